@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/integrity"
+)
+
+// defaultBaseline is the baseline file used without -baseline nor
+// configuration
+const defaultBaseline = "watch-fs.baseline"
+
+// baselineFlags registers the baseline file and signing key flags
+func baselineFlags(flags *flag.FlagSet) (*string, *string) {
+	path := flags.String("baseline", "", "Baseline file (default: integrity.baseline of the configuration, then "+defaultBaseline+")")
+	key := flags.String("key", "", "Signing key (default: integrity.key of the configuration, then "+integrity.DefaultKeyPath()+")")
+	return path, key
+}
+
+// baselinePaths returns the baseline and key files of the flags, falling
+// back to the configuration and the defaults
+func baselinePaths(settings config.Settings, path, key string) (string, string) {
+	if configured := settings.Integrity; configured != nil {
+		if path == "" {
+			path = configured.Baseline
+		}
+		if key == "" {
+			key = configured.Key
+		}
+	}
+	if path == "" {
+		path = defaultBaseline
+	}
+	if key == "" {
+		key = integrity.DefaultKeyPath()
+	}
+	return path, key
+}
+
+// runBaseline implements `watch-fs baseline [flags]`: it records the hash,
+// mode, owner and size of every path under the roots and returns the exit
+// code
+func runBaseline(args []string) int {
+	flags := newFlagSet("baseline")
+	var roots rootFlags
+	roots.register(flags, true, false)
+	path, keyPath := baselineFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs baseline [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Records the hash, mode, owner and size of every path under the roots,")
+		fmt.Fprintln(flags.Output(), "  signed with a local key generated on first use. The watching commands")
+		fmt.Fprintln(flags.Output(), "  flag the events breaking the baseline of integrity.baseline.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	rootPaths, err := roots.roots()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	ignore, err := settings.IgnoreRules(roots.defaults)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	filename, keyFile := baselinePaths(settings, *path, *keyPath)
+	key, err := integrity.LoadKey(keyFile, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	ignored := func(path string) bool {
+		for _, root := range rootPaths {
+			if ignore.Match(root, path) {
+				return true
+			}
+		}
+		return false
+	}
+	baseline, err := integrity.Create(filename, rootPaths, ignored, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	defer func() { _ = baseline.Close() }()
+	fmt.Fprintf(os.Stderr, "Recorded %d paths in %s\n", baseline.Len(), filename)
+	return 0
+}
+
+// runVerify implements `watch-fs verify [flags]`: it compares the roots
+// with the baseline, catching the changes made while nothing watched them.
+// It returns 1 when paths break the baseline, 2 on errors, such as a
+// tampered baseline.
+func runVerify(args []string) int {
+	flags := newFlagSet("verify")
+	var roots rootFlags
+	roots.register(flags, false, false)
+	path, keyPath := baselineFlags(flags)
+	asJSON := flags.Bool("json", false, "Print the violations as JSON instead of text")
+	accept := flags.Bool("accept", false, "Accept the changes found into the baseline")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs verify [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Checks the roots of the baseline, recorded by watch-fs baseline, for")
+		fmt.Fprintln(flags.Output(), "  added, removed and changed paths. Exits with 1 when some are found.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	ignore, err := settings.IgnoreRules(roots.defaults)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	filename, keyFile := baselinePaths(settings, *path, *keyPath)
+	key, err := integrity.LoadKey(keyFile, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	// The roots are those of the baseline, known once it is open
+	var baseline *integrity.Baseline
+	ignored := func(path string) bool {
+		for _, root := range baseline.Roots() {
+			if ignore.Match(root, path) {
+				return true
+			}
+		}
+		return false
+	}
+	baseline, err = integrity.Open(filename, ignored, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	defer func() { _ = baseline.Close() }()
+
+	violations, err := baseline.Verify()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if violations == nil {
+			violations = []integrity.Violation{}
+		}
+		err = encoder.Encode(violations)
+	} else {
+		for _, violation := range violations {
+			if _, err = fmt.Printf("%s %s\n", violation.Path, violation); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if len(violations) == 0 {
+		fmt.Fprintf(os.Stderr, "%d paths match the baseline of %s\n", baseline.Len(), baseline.Created().Local().Format("2006-01-02 15:04:05"))
+		return 0
+	}
+	if *accept {
+		paths := make([]string, len(violations))
+		for i, violation := range violations {
+			paths[i] = violation.Path
+		}
+		if err := baseline.Accept(paths...); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "Accepted %d changes into %s\n", len(violations), filename)
+		return 0
+	}
+	return 1
+}
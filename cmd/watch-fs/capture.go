@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/stats"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// runExport implements `watch-fs export [flags] -o FILE`: it records events
+// without the TUI until interrupted or the duration elapses, then writes
+// them to a capture
+func runExport(args []string) int {
+	flags := newFlagSet("export")
+	var roots rootFlags
+	roots.register(flags, false, false)
+	output := flags.String("o", "", "Capture to write (.db or .json, optionally .gz or .zst)")
+	duration := flags.Duration("duration", 0, "Stop recording after this long (default: until interrupted)")
+	aggregate := flags.Bool("aggregate", true, "Aggregate repeated events on a path, like the TUI")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs export [flags] -o FILE")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Records events until interrupted or the duration elapses, then writes a capture.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 || *output == "" {
+		flags.Usage()
+		return 1
+	}
+	format, err := captureFormat(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+
+	session := ui.NewUI(fileWatcher, fileWatcher.GetRoot())
+	session.GetState().MaxEvents = math.MaxInt
+	session.GetState().AggregateEvents = *aggregate
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	var expired <-chan time.Time
+	if *duration > 0 {
+		expired = time.After(*duration)
+	}
+
+	fmt.Fprintf(os.Stderr, "Recording to %s, press Ctrl+C to stop\n", *output)
+recording:
+	for {
+		select {
+		case event, ok := <-fileWatcher.Events():
+			if !ok {
+				break recording
+			}
+			if fileWatcher.Ignored(event.Name) {
+				continue
+			}
+			isDir := false
+			if info, err := os.Stat(event.Name); err == nil {
+				isDir = info.IsDir()
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create && isDir {
+				_ = fileWatcher.AddDirectory(event.Name)
+			}
+			session.RecordEvent(&ui.FileEvent{
+				Path:      event.Name,
+				Operation: event.Op,
+				Timestamp: time.Now(),
+				IsDir:     isDir,
+				Count:     1,
+				Attrs:     fileWatcher.AttrChange(event),
+			})
+		case err, ok := <-fileWatcher.Errors():
+			if ok {
+				logger.Error(err, "Watcher error")
+			}
+		case <-signals:
+			break recording
+		case <-expired:
+			break recording
+		}
+	}
+
+	if err := session.ExportEvents(*output, format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d events to %s\n", len(session.GetState().Events), *output)
+	return 0
+}
+
+// runImport implements `watch-fs import [flags] FILE`: it opens a capture
+// or a log of another watcher in the TUI, or converts it with -o
+func runImport(args []string) int {
+	flags := newFlagSet("import")
+	output := flags.String("o", "", "Convert to this capture (.db or .json, optionally .gz or .zst) instead of opening the TUI")
+	textImport := textImportFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs import [flags] FILE")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Opens a capture, or an inotifywait, fswatch or watchman log, in the TUI.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	filename := flags.Arg(0)
+	format, ok := ui.CaptureFormat(filename)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: cannot tell the format of '%s'\n", filename)
+		return 1
+	}
+
+	session := ui.NewUI(captureSource{filename: filename}, filename)
+	session.SetTextImportOptions(*textImport)
+	if *output == "" {
+		if err := session.ImportEvents(filename, format); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := session.Run(); err != nil {
+			logger.Error(err, "TUI exited with error")
+			return 1
+		}
+		return 0
+	}
+
+	outputFormat, err := captureFormat(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	events, meta, err := session.LoadCapture(filename, format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// The converted capture keeps the recorded roots
+	converted := ui.NewUI(captureSource{filename: filename, roots: meta.Roots}, filename)
+	converted.GetState().Events = events
+	if err := converted.ExportEvents(*output, outputFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Converted %d events to %s\n", len(events), *output)
+	return 0
+}
+
+// runStats implements `watch-fs stats [flags] FILE`: it summarizes a
+// capture and returns the exit code
+func runStats(args []string) int {
+	flags := newFlagSet("stats")
+	top := flags.Int("top", 10, "Number of busiest paths to list")
+	asJSON := flags.Bool("json", false, "Print the summary as JSON instead of text")
+	textImport := textImportFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs stats [flags] FILE")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Counts the events of a capture by operation and root, and lists the busiest paths.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() != 1 || *top < 0 {
+		flags.Usage()
+		return 1
+	}
+	filename := flags.Arg(0)
+	format, ok := ui.CaptureFormat(filename)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: cannot tell the format of '%s'\n", filename)
+		return 1
+	}
+
+	session := ui.NewUI(captureSource{filename: filename}, filename)
+	session.SetTextImportOptions(*textImport)
+	events, meta, err := session.LoadCapture(filename, format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	summary := stats.Summarize(events, meta.Roots, *top)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(summary)
+	} else {
+		err = summary.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pbouamriou/watch-fs/internal/collector"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// envToken is the environment variable holding the token shared by
+// forwarders and collectors, so that it stays out of process listings
+const envToken = "WATCH_FS_TOKEN"
+
+// runCollect implements `watch-fs collect [flags]`: it receives the events
+// of `watch-fs forward` instances and shows every host in the TUI
+func runCollect(args []string) int {
+	flags := newFlagSet("collect")
+	listen := flags.String("listen", ":7780", "Address to listen on for forwarders")
+	token := tokenFlag(flags)
+	certFile := flags.String("tls-cert", "", "PEM certificate to serve TLS with (requires -tls-key)")
+	keyFile := flags.String("tls-key", "", "PEM key of -tls-cert")
+	snapshotDir := snapshotDirFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs collect [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Shows the events of every 'watch-fs forward' in the TUI, with the host")
+		fmt.Fprintln(flags.Output(), "  each comes from. H cycles through the hosts and s sorts by host.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 1
+	}
+	secret, err := resolveToken(*token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	tlsConfig, err := serverTLS(*certFile, *keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	c := collector.New(secret, tlsConfig)
+	listener, err := c.Listen(*listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() { _ = c.Close() }()
+
+	tui := ui.NewUI(c, "")
+	c.OnNotice(func(message string) {
+		tui.RefreshRoots()
+		tui.SetStatusMessage(message)
+	})
+	go func() {
+		if err := c.Serve(listener); err != nil {
+			logger.Error(err, "Collector stopped")
+			tui.SetStatusMessage(fmt.Sprintf("Collector stopped: %v", err))
+		}
+	}()
+	tui.SetStatusMessage(fmt.Sprintf("Waiting for forwarders on %s", listener.Addr()))
+
+	signals, stopSignals := notifySignals()
+	defer stopSignals()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				switch actionOf(sig) {
+				case signalReload:
+					tui.SetStatusMessage("The collector has no configuration to reload")
+				case signalSnapshot:
+					filename, err := writeSnapshot(tui, *snapshotDir)
+					if err != nil {
+						tui.SetStatusMessage(fmt.Sprintf("Snapshot failed: %v", err))
+						continue
+					}
+					tui.SetStatusMessage(fmt.Sprintf("Snapshot written to %s", filename))
+				default:
+					tui.Quit()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err := tui.Run(); err != nil {
+		logger.Error(err, "TUI exited with error")
+		return 1
+	}
+	return 0
+}
+
+// tokenFlag registers the token shared by forwarders and collectors
+func tokenFlag(flags *flag.FlagSet) *string {
+	return flags.String("token", "", "Token shared by the collector and its forwarders (default: $"+envToken+")")
+}
+
+// resolveToken returns the token of the flag, or of the environment
+func resolveToken(token string) (string, error) {
+	if token == "" {
+		token = os.Getenv(envToken)
+	}
+	if token == "" {
+		return "", errors.New("a token is required: use -token or $" + envToken)
+	}
+	return token, nil
+}
+
+// serverTLS returns the TLS configuration of a collector, or nil for plain
+// TCP
+func serverTLS(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("-tls-cert and -tls-key go together")
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}, nil
+}
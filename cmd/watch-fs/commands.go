@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// command is a watch-fs subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands in the order help shows them. It is filled
+// in init because help refers to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"watch", "Print events to the console", runWatch},
+		{"tui", "Browse events in the terminal user interface", runTUI},
+		{"serve", "Serve events and a web UI over HTTP", runServe},
+		{"daemon", "Watch in the background for attached clients", runDaemon},
+		{"forward", "Send events to a collector on another host", runForward},
+		{"collect", "Browse the events of several hosts in the TUI", runCollect},
+		{"export", "Record events to a capture file", runExport},
+		{"import", "Open a capture in the TUI, or convert it", runImport},
+		{"stats", "Summarize a capture", runStats},
+		{"query", "Select and group the events of a capture", runQuery},
+		{"replay", "Replay a capture at its recorded pace", runReplay},
+		{"diff", "Compare two captures", runDiff},
+		{"baseline", "Record an integrity baseline of the roots", runBaseline},
+		{"verify", "Check the roots against the integrity baseline", runVerify},
+		{"exec", "Run a command whenever files change", runExec},
+		{"gotest", "Re-run the Go tests affected by changes", runGoTest},
+		{"run", "Report the files a command changed", runRun},
+		{"wait", "Wait for a matching event", runWait},
+		{"settle", "Wait until the directories are quiet", runSettle},
+		{"help", "Show help for a command", runHelp},
+		{"version", "Show version information", runVersion},
+	}
+}
+
+// dispatch runs the subcommand named by the first argument and returns the
+// exit code. Arguments starting with a flag are the legacy invocation.
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return 1
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return runLegacy(args)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Error: unknown command '%s'\n\n", args[0])
+	printUsage(os.Stderr)
+	return 1
+}
+
+// printUsage lists the subcommands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: watch-fs <command> [flags]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Run 'watch-fs help <command>' for the flags of a command.")
+	fmt.Fprintln(w, "'watch-fs -path DIR [-tui=false]' still works and is the same as 'watch-fs tui' or 'watch-fs watch'.")
+}
+
+// runHelp implements `watch-fs help [command]`
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return 0
+	}
+	for _, c := range commands {
+		if c.name == args[0] && c.name != "help" {
+			// Every command prints its usage and exits on -h
+			return c.run([]string{"-h"})
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Error: unknown command '%s'\n", args[0])
+	return 1
+}
+
+// runVersion implements `watch-fs version`
+func runVersion(args []string) int {
+	fmt.Printf("watch-fs version %s\n", version)
+	return 0
+}
+
+// rootFlags holds the root, ignore and configuration flags shared by the
+// commands that watch directories
+type rootFlags struct {
+	paths    pathsFlag
+	legacy   string // Comma-separated roots of the legacy -paths flag
+	ignore   pathsFlag
+	defaults bool
+	current  bool // Watch the current directory without roots
+	config   string
+	profile  string
+	audit    string
+	metrics  string
+
+	flags       *flag.FlagSet
+	file        *config.File     // Loaded by settings
+	profileName string           // Selected profile, empty without profiles
+	resolved    *config.Settings // Configuration, profile, environment and flags merged
+}
+
+// register registers the root, ignore and configuration flags on a flag
+// set. With current, the current directory is watched when no root is
+// given; defaultIgnores is the default of -default-ignores.
+func (r *rootFlags) register(flags *flag.FlagSet, current, defaultIgnores bool) {
+	r.flags = flags
+	r.current = current
+	usage := "Directory to watch (can be used multiple times)"
+	if current {
+		usage = "Directory to watch (can be used multiple times, default: current directory)"
+	}
+	flags.Var(&r.paths, "path", usage)
+	flags.StringVar(&r.legacy, "paths", "", "Comma-separated list of directories to watch (legacy)")
+	flags.Var(&r.ignore, "ignore", "Ignore paths matching this glob, relative to the watched directory (can be used multiple times, ** supported)")
+	flags.BoolVar(&r.defaults, "default-ignores", defaultIgnores, "Ignore hidden paths and names such as .git and node_modules")
+	flags.StringVar(&r.config, "config", "", "Configuration file (default: $XDG_CONFIG_HOME/watch-fs/config.yml)")
+	flags.StringVar(&r.audit, "audit-log", "", "Record every received file system event as JSON in this file, or - for stderr")
+	flags.StringVar(&r.profile, "profile", "", "Configuration profile to use (default: $WATCH_FS_PROFILE, then the profile of the file)")
+	flags.StringVar(&r.metrics, "metrics-addr", "", "Serve Prometheus metrics on /metrics at this address, e.g. 127.0.0.1:9477")
+}
+
+// settings returns the configuration, profile, environment and flags
+// merged, in that order
+func (r *rootFlags) settings() (config.Settings, error) {
+	if r.resolved != nil {
+		return *r.resolved, nil
+	}
+	file, err := config.Load(r.config)
+	if err != nil {
+		return config.Settings{}, err
+	}
+	name := r.profile
+	if name == "" {
+		name = os.Getenv(config.EnvProfile)
+	}
+	settings, err := file.Resolve(name)
+	if err != nil {
+		return config.Settings{}, err
+	}
+	env, err := config.FromEnv(os.Getenv)
+	if err != nil {
+		return config.Settings{}, err
+	}
+	settings = settings.Merge(env)
+
+	var flagged config.Settings
+	r.flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "path", "paths":
+			flagged.Roots = r.flagRoots()
+		case "ignore":
+			flagged.Ignore = r.ignore
+		case "default-ignores":
+			flagged.DefaultIgnores = &r.defaults
+		}
+	})
+	settings = settings.Merge(flagged)
+
+	if name == "" {
+		name = file.Profile
+	}
+	r.profileName = name
+	r.file = file
+	r.resolved = &settings
+	return settings, nil
+}
+
+// reload reads the configuration again, keeping the current one on error
+func (r *rootFlags) reload() (config.Settings, error) {
+	file, profileName, resolved := r.file, r.profileName, r.resolved
+	r.resolved = nil
+	settings, err := r.settings()
+	if err != nil {
+		r.file, r.profileName, r.resolved = file, profileName, resolved
+		return config.Settings{}, err
+	}
+	return settings, nil
+}
+
+// flagRoots returns the roots given on the command line; -path flags take
+// priority over -paths
+func (r *rootFlags) flagRoots() []string {
+	if len(r.paths) > 0 {
+		return append([]string(nil), r.paths...)
+	}
+	return strings.Split(r.legacy, ",")
+}
+
+// roots returns the validated roots
+func (r *rootFlags) roots() ([]string, error) {
+	settings, err := r.settings()
+	if err != nil {
+		return nil, err
+	}
+	rootPaths := append([]string(nil), settings.Roots...)
+	if len(rootPaths) == 0 {
+		if !r.current {
+			return nil, fmt.Errorf("at least one --path flag is required")
+		}
+		rootPaths = []string{"."}
+	}
+
+	for i, path := range rootPaths {
+		rootPaths[i] = strings.TrimSpace(path)
+		if err := utils.ValidateDirectory(rootPaths[i]); err != nil {
+			return nil, fmt.Errorf("invalid directory '%s': %w", rootPaths[i], err)
+		}
+	}
+	return rootPaths, nil
+}
+
+// newWatcher watches the roots recursively, leaving out ignored paths
+func (r *rootFlags) newWatcher() (*watcher.Watcher, error) {
+	rootPaths, err := r.roots()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := r.resolved.IgnoreRules(r.defaults)
+	if err != nil {
+		return nil, err
+	}
+	options := logging.options()
+	if err := logger.SetupAudit(r.audit, options.MaxSize, options.MaxBackups); err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	fileWatcher, err := watcher.NewWithIgnore(rootPaths, rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+	if r.metrics != "" {
+		if err := serveMetrics(r.metrics, fileWatcher); err != nil {
+			_ = fileWatcher.Close()
+			return nil, err
+		}
+	}
+	logger.Info("Watching " + strings.Join(rootPaths, ", "))
+	return fileWatcher, nil
+}
+
+// captureFormat returns the export format of a capture file name, which
+// must be a .db or .json file, optionally compressed
+func captureFormat(filename string) (ui.ExportFormat, error) {
+	format, _, ok := ui.DetectFormat(filename)
+	if !ok || (format != ui.FormatSQLite && format != ui.FormatJSON) {
+		return 0, fmt.Errorf("cannot export to '%s' (want .db or .json, optionally .gz or .zst)", filename)
+	}
+	return format, nil
+}
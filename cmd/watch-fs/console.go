@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// consoleFlags holds the flags of the console (-tui=false) output
+type consoleFlags struct {
+	format     string
+	template   string
+	pathFilter string
+	operations string
+	noDirs     bool
+	noFiles    bool
+	risk       string
+}
+
+// registerConsoleFlags registers the console output flags on a flag set
+func registerConsoleFlags(flags *flag.FlagSet) *consoleFlags {
+	c := &consoleFlags{}
+	flags.StringVar(&c.format, "format", "text", "Console output format: text, json, ndjson or template")
+	flags.StringVar(&c.template, "template", "", "Go text/template for each event, e.g. '{{.Time.Format \"15:04:05\"}} {{.Op}} {{.RelativePath}}' (implies -format template)")
+	flags.StringVar(&c.pathFilter, "filter", "", "Only print events whose path contains this text")
+	flags.StringVar(&c.operations, "op", "", "Only print these operations (comma-separated: create,write,remove,rename,chmod)")
+	flags.BoolVar(&c.noDirs, "no-dirs", false, "Do not print events on directories")
+	flags.BoolVar(&c.noFiles, "no-files", false, "Do not print events on files")
+	flags.StringVar(&c.risk, "risk", "", "Only print risky mode and owner changes: any, or text of the risk such as setuid or root")
+	return c
+}
+
+// applyConfig takes the filter of the configuration unless a filter flag
+// is given
+func (c *consoleFlags) applyConfig(filter *config.Filter) {
+	if filter == nil || c.pathFilter != "" || c.operations != "" || c.noDirs || c.noFiles || c.risk != "" {
+		return
+	}
+	c.pathFilter = filter.Path
+	c.operations = strings.ReplaceAll(filter.Op, "|", ",")
+	c.noDirs = filter.Dirs != nil && !*filter.Dirs
+	c.noFiles = filter.Files != nil && !*filter.Files
+	c.risk = filter.Risk
+}
+
+// newPrinter creates the console printer described by the flags
+func (c *consoleFlags) newPrinter(w io.Writer, roots []string) (*console.Printer, error) {
+	format, err := console.ParseFormat(c.format)
+	if err != nil {
+		return nil, err
+	}
+	if c.template != "" {
+		format = console.FormatTemplate
+	}
+	operations, err := console.ParseOperations(c.operations)
+	if err != nil {
+		return nil, err
+	}
+
+	return console.NewPrinter(w, console.Options{
+		Format:   format,
+		Template: c.template,
+		Roots:    roots,
+		Filter: ui.Filter{
+			PathFilter: c.pathFilter,
+			ShowDirs:   !c.noDirs,
+			ShowFiles:  !c.noFiles,
+			RiskFilter: c.risk,
+		},
+		Operations: operations,
+	})
+}
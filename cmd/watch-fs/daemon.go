@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/daemon"
+	"github.com/pbouamriou/watch-fs/internal/server"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// detachTimeout bounds how long `daemon -detach` waits for the daemon to
+// listen
+const detachTimeout = 5 * time.Second
+
+// runDaemon implements `watch-fs daemon [flags] [start|status|stop]`: it
+// watches in the background and answers the control API on a Unix socket
+func runDaemon(args []string) int {
+	flags := newFlagSet("daemon")
+	var roots rootFlags
+	roots.register(flags, true, false)
+	socket := socketFlag(flags)
+	listen := flags.String("listen", "", "Also serve events and the web UI over HTTP on this address")
+	detach := flags.Bool("detach", false, "Start the daemon in the background and return once it listens")
+	snapshotDir := snapshotDirFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs daemon [flags] [start|status|stop]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  start (default) watches until stopped and keeps the events for the")
+		fmt.Fprintln(flags.Output(), "  clients of the socket, such as 'watch-fs tui -attach'. status and stop")
+		fmt.Fprintln(flags.Output(), "  act on the daemon of the socket.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	// The action may come before the flags
+	action := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 1 || (action != "" && flags.NArg() > 0) {
+		flags.Usage()
+		return 1
+	}
+	if action == "" {
+		action = flags.Arg(0)
+	}
+
+	switch action {
+	case "", "start":
+		if *detach {
+			return startDetached(&roots, *socket, args)
+		}
+		return serveDaemon(&roots, *socket, *listen, *snapshotDir)
+	case "status":
+		return daemonStatus(*socket)
+	case "stop":
+		return daemonStop(*socket)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown action '%s' (want start, status or stop)\n", action)
+		return 1
+	}
+}
+
+// socketFlag registers the socket of the daemon
+func socketFlag(flags *flag.FlagSet) *string {
+	return flags.String("socket", daemon.DefaultSocket(), "Unix socket of the daemon")
+}
+
+// serveDaemon watches and answers the clients of the socket until stopped
+// by a client or SIGINT or SIGTERM. SIGHUP reloads the configuration and
+// SIGUSR1 writes a snapshot.
+func serveDaemon(roots *rootFlags, socket, listen, snapshotDir string) int {
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	view, err := settings.View()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	configHooks, err := newHooks(settings, fileWatcher.GetRoots(), fileWatcher.Ignored, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer configHooks.Close()
+	configHooks.onRuleFiring(reportRuleFailures(os.Stderr))
+	configHooks.onAlert(reportAlerts(os.Stderr))
+	configHooks.onDetection(reportDetections(os.Stderr))
+
+	srv := server.New(fileWatcher, view)
+	srv.OnEvent(configHooks.handle)
+	d := daemon.New(srv, fileWatcher)
+	listener, err := daemon.Listen(socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	go srv.Watch()
+	defer srv.Close()
+
+	served := make(chan error, 1)
+	go func() { served <- d.Serve(listener) }()
+	defer d.Stop()
+	fmt.Fprintf(os.Stderr, "Daemon listening on %s\n", socket)
+
+	var httpServer *http.Server
+	var httpServed <-chan error
+	if listen != "" {
+		if httpServer, httpServed, err = startHTTP(srv, listen); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer stopHTTP(srv, httpServer)
+	}
+
+	signals, stopSignals := notifySignals()
+	defer stopSignals()
+	for {
+		select {
+		case err := <-served:
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			return 0
+		case err := <-httpServed:
+			if !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		case <-d.Stopped():
+			fmt.Fprintln(os.Stderr, "Daemon stopped")
+			return 0
+		case sig := <-signals:
+			switch actionOf(sig) {
+			case signalReload:
+				settings, err := reloadConfig(fileWatcher, roots, configHooks, os.Stderr)
+				if err == nil {
+					view, err = settings.View()
+				}
+				if err != nil {
+					logger.Error(err, "Failed to reload configuration")
+					continue
+				}
+				srv.ApplySettings(view)
+				fmt.Fprintln(os.Stderr, "Configuration reloaded")
+			case signalSnapshot:
+				filename, err := writeSnapshot(srv, snapshotDir)
+				if err != nil {
+					logger.Error(err, "Failed to write snapshot")
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "Snapshot written to %s\n", filename)
+			default:
+				return 0
+			}
+		}
+	}
+}
+
+// startDetached starts the daemon again without -detach in a new session,
+// and returns once it listens on the socket
+func startDetached(roots *rootFlags, socket string, args []string) int {
+	// Report configuration errors here, the daemon has no terminal
+	if _, err := roots.settings(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if conn, err := net.Dial("unix", socket); err == nil {
+		_ = conn.Close()
+		fmt.Fprintf(os.Stderr, "Error: a daemon is already running on %s\n", socket)
+		return 1
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	daemonArgs := []string{"daemon"}
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if name == "detach" || strings.HasPrefix(name, "detach=") {
+			continue
+		}
+		daemonArgs = append(daemonArgs, arg)
+	}
+	cmd := exec.Command(executable, daemonArgs...)
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to start daemon: %v\n", err)
+		return 1
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(detachTimeout)
+	for {
+		select {
+		case err := <-exited:
+			fmt.Fprintf(os.Stderr, "Error: the daemon exited (%v), see the log for details\n", err)
+			return 1
+		case <-deadline:
+			fmt.Fprintf(os.Stderr, "Error: the daemon (pid %d) did not listen on %s in time\n", cmd.Process.Pid, socket)
+			return 1
+		case <-time.After(50 * time.Millisecond):
+			if conn, err := net.Dial("unix", socket); err == nil {
+				_ = conn.Close()
+				fmt.Printf("Daemon started (pid %d) on %s\n", cmd.Process.Pid, socket)
+				return 0
+			}
+		}
+	}
+}
+
+// daemonStatus prints the status of the daemon of a socket
+func daemonStatus(socket string) int {
+	client, err := daemon.Dial(socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() { _ = client.Close() }()
+	status, err := client.Status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Daemon running (pid %d) on %s\n", status.Pid, socket)
+	fmt.Printf("Started: %s (%s ago)\n", status.Started.Format("2006-01-02 15:04:05"), time.Since(status.Started).Round(time.Second))
+	fmt.Printf("Events: %d\n", status.Events)
+	fmt.Printf("Clients: %d\n", status.Clients-1)
+	fmt.Println("Watching:")
+	for _, root := range status.Roots {
+		fmt.Printf("  %s (%d dirs)\n", root.Path, root.Watched)
+	}
+	return 0
+}
+
+// daemonStop stops the daemon of a socket
+func daemonStop(socket string) int {
+	client, err := daemon.Dial(socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() { _ = client.Close() }()
+	if err := client.Stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Println("Daemon stopped")
+	return 0
+}
+
+// attachTUI shows the events of the daemon of a socket in the TUI, with
+// the view settings of the configuration. Quitting detaches; the daemon
+// keeps watching and its history is there on the next attach.
+func attachTUI(roots *rootFlags, socket string, textImport *ui.TextImportOptions, snapshotDir string) int {
+	if len(roots.paths) > 0 || roots.legacy != "" {
+		fmt.Fprintln(os.Stderr, "Error: -path cannot be used with -attach: the daemon's directories are shown")
+		return 1
+	}
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	view, err := settings.View()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	client, err := daemon.Dial(socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() { _ = client.Close() }()
+	if err := client.Attach(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	tui := ui.NewUI(client, client.GetRoot())
+	tui.SetTextImportOptions(*textImport)
+	tui.ApplySettings(view)
+
+	signals, stopSignals := notifySignals()
+	defer stopSignals()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		disconnected := client.Disconnected()
+		for {
+			select {
+			case sig := <-signals:
+				switch actionOf(sig) {
+				case signalReload:
+					tui.SetStatusMessage("Send SIGHUP to the daemon to reload its configuration")
+				case signalSnapshot:
+					filename, err := writeSnapshot(tui, snapshotDir)
+					if err != nil {
+						tui.SetStatusMessage(fmt.Sprintf("Snapshot failed: %v", err))
+						continue
+					}
+					tui.SetStatusMessage(fmt.Sprintf("Snapshot written to %s", filename))
+				default:
+					tui.Quit()
+				}
+			case <-disconnected:
+				disconnected = nil
+				tui.SetStatusMessage("Detached: the daemon stopped")
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err := tui.Run(); err != nil {
+		logger.Error(err, "TUI exited with error")
+		return 1
+	}
+	return 0
+}
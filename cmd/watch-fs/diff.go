@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// runDiff implements `watch-fs diff [flags] <a> <b>` and returns the exit
+// code: 0 when the captures match, 1 when they differ, 2 on error
+func runDiff(args []string) int {
+	flags := newFlagSet("diff")
+	output := flags.String("o", "", "Export the diff to a JSON file (.gz or .zst to compress)")
+	asJSON := flags.Bool("json", false, "Print the diff as JSON instead of text")
+	textImport := textImportFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs diff [flags] <a> <b>")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	fileA, fileB := flags.Arg(0), flags.Arg(1)
+
+	formats := make([]ui.ExportFormat, 2)
+	for i, filename := range []string{fileA, fileB} {
+		format, ok := ui.CaptureFormat(filename)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: cannot tell the format of '%s'\n", filename)
+			return 2
+		}
+		formats[i] = format
+	}
+
+	session := ui.NewUI(captureSource{filename: fileA}, fileA)
+	session.SetTextImportOptions(*textImport)
+	result, err := session.CompareCaptures(fileA, formats[0], fileB, formats[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if *output != "" {
+		if err := result.WriteFile(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(result)
+	} else {
+		err = result.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if len(result.Entries) > 0 {
+		return 1
+	}
+	return 0
+}
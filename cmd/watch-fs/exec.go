@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/runner"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// execTrigger decides which events make the command run
+type execTrigger struct {
+	ignored    func(path string) bool
+	filter     ui.Filter
+	operations fsnotify.Op
+}
+
+// matches reports whether an event should trigger the command; ignored
+// paths, by default hidden ones and names such as .git, never do
+func (t execTrigger) matches(event *ui.FileEvent) bool {
+	if t.ignored(event.Path) {
+		return false
+	}
+	return t.filter.Matches(event) && event.Operation&t.operations != 0
+}
+
+// runExec implements `watch-fs exec [flags] -- command [args...]` and
+// returns the exit code
+func runExec(args []string) int {
+	flags := newFlagSet("exec")
+	var roots rootFlags
+	roots.register(flags, true, true)
+	debounce := flags.Duration("debounce", runner.DefaultDebounce, "Quiet period to wait for before running the command")
+	onBusy := flags.String("on-busy", "restart", "Changes during a run: restart (kill and rerun), queue (rerun afterwards) or ignore")
+	postpone := flags.Bool("postpone", false, "Wait for a first change instead of running the command at start")
+	useTUI := flags.Bool("tui", false, "Show events and the command output in the terminal user interface")
+	pathFilter := flags.String("filter", "", "Only run for paths containing this text")
+	operationNames := flags.String("op", "create,write,remove,rename", "Operations that run the command (comma-separated: create,write,remove,rename,chmod)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs exec [flags] -- command [args...]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintf(flags.Output(), "  %s and %s in arguments are replaced by the changed paths,\n", runner.PlaceholderPath, runner.PlaceholderPaths)
+		fmt.Fprintf(flags.Output(), "  also available as $%s and $%s.\n\n", runner.EnvChangedPath, runner.EnvChangedPaths)
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+	policy, err := runner.ParsePolicy(*onBusy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operations, err := console.ParseOperations(*operationNames)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() {
+		if err := fileWatcher.Close(); err != nil {
+			logger.Error(err, "Failed to close watcher")
+		}
+	}()
+
+	config := runner.Config{
+		Command:  flags.Args(),
+		Debounce: *debounce,
+		Policy:   policy,
+	}
+	if !*useTUI {
+		// The TUI shows the output in its command pane instead
+		config.Stdout = os.Stdout
+		config.Stderr = os.Stderr
+	}
+	commandRunner, err := runner.New(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer commandRunner.Close()
+
+	trigger := execTrigger{
+		ignored:    fileWatcher.Ignored,
+		filter:     ui.Filter{PathFilter: *pathFilter, ShowDirs: true, ShowFiles: true},
+		operations: operations,
+	}
+	if !*postpone {
+		commandRunner.Trigger()
+	}
+
+	if *useTUI {
+		tui := ui.NewUI(fileWatcher, fileWatcher.GetRoot())
+		tui.SetCommandRunner(commandRunner)
+		tui.OnEvent(func(event *ui.FileEvent) {
+			if trigger.matches(event) {
+				commandRunner.Notify(event.Path)
+			}
+		})
+		if err := tui.Run(); err != nil {
+			logger.Error(err, "TUI exited with error")
+			return 1
+		}
+		return 0
+	}
+
+	return notifyChanges(fileWatcher, trigger, commandRunner)
+}
+
+// notifyChanges feeds matching events to the runner until interrupted
+func notifyChanges(fileWatcher *watcher.Watcher, trigger execTrigger, commandRunner *runner.Runner) int {
+	// The command runs in its own process group, so Ctrl+C has to be
+	// forwarded by closing the runner
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case event, ok := <-fileWatcher.Events():
+			if !ok {
+				return 0
+			}
+			isDir := false
+			if info, err := os.Stat(event.Name); err == nil {
+				isDir = info.IsDir()
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create && isDir {
+				_ = fileWatcher.AddDirectory(event.Name)
+			}
+			if trigger.matches(&ui.FileEvent{Path: event.Name, Operation: event.Op, Timestamp: time.Now(), IsDir: isDir, Count: 1}) {
+				commandRunner.Notify(event.Name)
+			}
+
+		case err, ok := <-fileWatcher.Errors():
+			if !ok {
+				return 0
+			}
+			logger.Error(err, "Watcher error")
+
+		case <-signals:
+			return 0
+		}
+	}
+}
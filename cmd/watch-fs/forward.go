@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/pbouamriou/watch-fs/internal/collector"
+	"github.com/pbouamriou/watch-fs/internal/server"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// runForward implements `watch-fs forward -to ADDRESS [flags]`: it watches
+// without the TUI and sends the events to a collector
+func runForward(args []string) int {
+	flags := newFlagSet("forward")
+	var roots rootFlags
+	roots.register(flags, true, false)
+	to := flags.String("to", "", "Address of the collector, host:port")
+	token := tokenFlag(flags)
+	hostname, _ := os.Hostname()
+	host := flags.String("host", hostname, "Name of this host on the collector")
+	useTLS := flags.Bool("tls", false, "Connect to the collector over TLS")
+	caFile := flags.String("tls-ca", "", "PEM certificates to verify the collector with (implies -tls, default: system roots)")
+	insecure := flags.Bool("tls-insecure", false, "Do not verify the certificate of the collector (implies -tls)")
+	buffer := flags.Int("buffer", collector.DefaultBuffer, "Events kept while the collector is unreachable; the oldest are dropped beyond")
+	snapshotDir := snapshotDirFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs forward -to ADDRESS [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Sends the events of this host to 'watch-fs collect'. Events are kept")
+		fmt.Fprintln(flags.Output(), "  until the collector acknowledges them and sent again after reconnecting.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 || *to == "" {
+		flags.Usage()
+		return 1
+	}
+	secret, err := resolveToken(*token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *host == "" {
+		fmt.Fprintln(os.Stderr, "Error: -host is required when the host name is unknown")
+		return 1
+	}
+	tlsConfig, err := clientTLS(*to, *useTLS, *caFile, *insecure)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	view, err := settings.View()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	configHooks, err := newHooks(settings, fileWatcher.GetRoots(), fileWatcher.Ignored, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer configHooks.Close()
+	configHooks.onRuleFiring(reportRuleFailures(os.Stderr))
+	configHooks.onAlert(reportAlerts(os.Stderr))
+	configHooks.onDetection(reportDetections(os.Stderr))
+
+	forwarder := collector.NewForwarder(collector.ForwarderOptions{
+		Address: *to,
+		Host:    *host,
+		Token:   secret,
+		TLS:     tlsConfig,
+		Buffer:  *buffer,
+		Roots:   fileWatcher.GetRoots(),
+	})
+	defer forwarder.Close()
+	srv := server.New(fileWatcher, view)
+	srv.OnEvent(configHooks.handle)
+	srv.OnEvent(forwarder.Send)
+	go srv.Watch()
+	defer srv.Close()
+
+	forwarded := make(chan error, 1)
+	go func() { forwarded <- forwarder.Run() }()
+	fmt.Fprintf(os.Stderr, "Forwarding the events of %s to %s\n", *host, *to)
+
+	signals, stopSignals := notifySignals()
+	defer stopSignals()
+	for {
+		select {
+		case err := <-forwarded:
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			return 0
+		case sig := <-signals:
+			switch actionOf(sig) {
+			case signalReload:
+				settings, err := reloadConfig(fileWatcher, &roots, configHooks, os.Stderr)
+				if err == nil {
+					view, err = settings.View()
+				}
+				if err != nil {
+					logger.Error(err, "Failed to reload configuration")
+					continue
+				}
+				srv.ApplySettings(view)
+				fmt.Fprintln(os.Stderr, "Configuration reloaded")
+			case signalSnapshot:
+				filename, err := writeSnapshot(srv, *snapshotDir)
+				if err != nil {
+					logger.Error(err, "Failed to write snapshot")
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "Snapshot written to %s\n", filename)
+			default:
+				if pending := forwarder.Pending(); pending > 0 {
+					fmt.Fprintf(os.Stderr, "%d events were not acknowledged by the collector\n", pending)
+				}
+				return 0
+			}
+		}
+	}
+}
+
+// clientTLS returns the TLS configuration to reach a collector, or nil for
+// plain TCP
+func clientTLS(address string, enabled bool, caFile string, insecure bool) (*tls.Config, error) {
+	if !enabled && caFile == "" && !insecure {
+		return nil, nil
+	}
+	serverName, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collector address '%s': %w", address, err)
+	}
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in '%s'", caFile)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/gotest"
+	"github.com/pbouamriou/watch-fs/internal/runner"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// runGoTest implements `watch-fs gotest [flags] [-- go test flags]` and
+// returns the exit code
+func runGoTest(args []string) int {
+	flags := newFlagSet("gotest")
+	var roots rootFlags
+	roots.register(flags, true, true)
+	debounce := flags.Duration("debounce", runner.DefaultDebounce, "Quiet period to wait for before running the tests")
+	onBusy := flags.String("on-busy", "restart", "Changes during a run: restart (kill and rerun), queue (rerun afterwards) or ignore")
+	postpone := flags.Bool("postpone", false, "Wait for a first change instead of testing every package at start")
+	useTUI := flags.Bool("tui", false, "Show events and per-package results in the terminal user interface")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs gotest [flags] [-- go test flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Runs go test for the packages of changed Go files and their reverse dependencies.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+
+	policy, err := runner.ParsePolicy(*onBusy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+
+	session, err := gotest.NewSession(fileWatcher.GetRoot(), flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	config := runner.Config{
+		Build:    session.Command,
+		Dir:      session.ModuleDir(),
+		Debounce: *debounce,
+		Policy:   policy,
+		Stdout:   session,
+	}
+	if !*useTUI {
+		config.Stderr = os.Stderr
+		config.Build = func(changed []string) ([]string, error) {
+			packages, err := session.Packages(changed)
+			if err != nil {
+				return nil, err
+			}
+			if len(packages) > 0 {
+				fmt.Fprintf(os.Stderr, "Testing %d packages\n", len(packages))
+			}
+			return session.TestCommand(packages), nil
+		}
+		session.OnResult(printTestResult)
+	}
+	testRunner, err := runner.New(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer testRunner.Close()
+
+	// Every file may matter to a test; files outside Go packages are skipped
+	// when the run is built
+	trigger := execTrigger{
+		ignored:    fileWatcher.Ignored,
+		filter:     ui.Filter{ShowDirs: true, ShowFiles: true},
+		operations: fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename,
+	}
+	if !*postpone {
+		testRunner.Trigger()
+	}
+
+	if *useTUI {
+		tui := ui.NewUI(fileWatcher, fileWatcher.GetRoot())
+		tui.SetTestSession(session, testRunner)
+		tui.OnEvent(func(event *ui.FileEvent) {
+			if trigger.matches(event) {
+				testRunner.Notify(event.Path)
+			}
+		})
+		if err := tui.Run(); err != nil {
+			logger.Error(err, "TUI exited with error")
+			return 1
+		}
+		return 0
+	}
+
+	return notifyChanges(fileWatcher, trigger, testRunner)
+}
+
+// printTestResult prints a finished package like `go test` does; the output
+// of a failure ends with its FAIL line
+func printTestResult(result gotest.Result) {
+	switch result.State {
+	case gotest.StatePassed:
+		fmt.Printf("ok  \t%s\t%.3fs\n", result.Package, result.Elapsed.Seconds())
+	case gotest.StateSkipped:
+		fmt.Printf("?   \t%s\t[no test files]\n", result.Package)
+	default:
+		for _, line := range result.Output {
+			fmt.Println(line)
+		}
+	}
+}
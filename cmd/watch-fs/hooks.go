@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/integrity"
+	"github.com/pbouamriou/watch-fs/internal/rules"
+	"github.com/pbouamriou/watch-fs/internal/runner"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/wait"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// hookAction is a configured action and the runner of its command
+type hookAction struct {
+	matcher wait.Matcher
+	runner  *runner.Runner
+}
+
+// hooks checks every event against the integrity baseline, then runs the
+// journal, actions and rules of the configuration on it
+type hooks struct {
+	mu        sync.Mutex
+	file      *os.File
+	journal   *console.Printer
+	actions   []hookAction
+	rules     *rules.Engine
+	integrity *integrity.Baseline // Nil without a configured baseline
+
+	firingListeners []func(ui.RuleFiring) // Kept across reloads
+	alertListeners  []func(ui.Alert)      // Kept across reloads
+
+	detectionListeners []func(ui.Detection) // Kept across reloads
+}
+
+// newHooks opens the integrity baseline and starts the journal, actions and
+// rules of settings. Command output goes to output, or is only kept by the
+// runners when output is nil.
+func newHooks(settings config.Settings, roots []string, ignored func(path string) bool, output io.Writer) (*hooks, error) {
+	h := &hooks{}
+	if journal := settings.Journal; journal != nil && journal.Path != "" {
+		name := journal.Format
+		if name == "" {
+			name = "ndjson"
+		}
+		format, err := console.ParseFormat(name)
+		if err != nil {
+			return nil, fmt.Errorf("journal: %w", err)
+		}
+		file, err := os.OpenFile(journal.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open journal: %w", err)
+		}
+		h.file = file
+		h.journal, err = console.NewPrinter(file, console.Options{
+			Format:   format,
+			Template: journal.Template,
+			Roots:    roots,
+			Filter:   ui.Filter{ShowDirs: true, ShowFiles: true},
+		})
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("journal: %w", err)
+		}
+	}
+
+	for _, action := range settings.Actions {
+		hook, err := newHookAction(action, roots, ignored, output)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("action %s: %w", action.Name, err)
+		}
+		h.actions = append(h.actions, hook)
+	}
+
+	engine, err := rules.New(settings, roots, ignored, output)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	h.rules = engine
+
+	if settings.Integrity != nil && settings.Integrity.Baseline != "" {
+		baseline, err := openBaseline(settings.Integrity.Baseline, settings.Integrity.Key, ignored)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("integrity: %w", err)
+		}
+		h.integrity = baseline
+	}
+	return h, nil
+}
+
+// openBaseline opens a baseline, checking its signature with the key of
+// keyPath, or the default key
+func openBaseline(path, keyPath string, ignored func(path string) bool) (*integrity.Baseline, error) {
+	if keyPath == "" {
+		keyPath = integrity.DefaultKeyPath()
+	}
+	key, err := integrity.LoadKey(keyPath, false)
+	if err != nil {
+		return nil, err
+	}
+	return integrity.Open(path, ignored, key)
+}
+
+// newHookAction creates the runner of an action
+func newHookAction(action config.Action, roots []string, ignored func(path string) bool, output io.Writer) (hookAction, error) {
+	matcher := wait.Matcher{Roots: roots, Ignore: ignored}
+	if action.Match != "" {
+		pattern, err := utils.NewGlob(action.Match)
+		if err != nil {
+			return hookAction{}, err
+		}
+		matcher.Pattern = pattern
+	}
+	matcher.Operations = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename
+	if action.Op != "" {
+		operations, err := console.ParseOperations(action.Op)
+		if err != nil {
+			return hookAction{}, err
+		}
+		matcher.Operations = operations
+	}
+	policy := runner.PolicyRestart
+	if action.OnBusy != "" {
+		var err error
+		if policy, err = runner.ParsePolicy(action.OnBusy); err != nil {
+			return hookAction{}, err
+		}
+	}
+
+	config := runner.Config{Command: action.Command, Debounce: action.Debounce, Policy: policy}
+	if output != nil {
+		config.Stdout = output
+		config.Stderr = output
+	}
+	commandRunner, err := runner.New(config)
+	if err != nil {
+		return hookAction{}, err
+	}
+	return hookAction{matcher: matcher, runner: commandRunner}, nil
+}
+
+// onRuleFiring registers a function called with the outcome of every rule
+// action, including those of the rules of later reloads
+func (h *hooks) onRuleFiring(listener func(ui.RuleFiring)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.firingListeners = append(h.firingListeners, listener)
+	h.rules.OnFiring(listener)
+}
+
+// onAlert registers a function called whenever an alert fires or
+// resolves, including the alerts of later reloads
+func (h *hooks) onAlert(listener func(ui.Alert)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.alertListeners = append(h.alertListeners, listener)
+	h.rules.OnAlert(listener)
+}
+
+// onDetection registers a function called with the evidence of every
+// ransomware-like detection, including those of later reloads
+func (h *hooks) onDetection(listener func(ui.Detection)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.detectionListeners = append(h.detectionListeners, listener)
+	h.rules.OnDetection(listener)
+}
+
+// acceptBaseline records the current state of a path in the baseline, for
+// the TUI
+func (h *hooks) acceptBaseline(path string) error {
+	h.mu.Lock()
+	baseline := h.integrity
+	h.mu.Unlock()
+	if baseline == nil {
+		return errors.New("no integrity baseline is configured")
+	}
+	return baseline.Accept(path)
+}
+
+// hasBaseline reports whether events are checked against a baseline
+func (h *hooks) hasBaseline() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.integrity != nil
+}
+
+// ruleCount returns the number of configured rules and alerts
+func (h *hooks) ruleCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rules.Len()
+}
+
+// handle checks an event against the baseline, journals it, notifies the
+// actions it matches and runs the rules. The file is hashed without holding
+// the mutex, so that reloads and the TUI do not wait for it.
+func (h *hooks) handle(event *ui.FileEvent) {
+	h.mu.Lock()
+	baseline := h.integrity
+	h.mu.Unlock()
+	if baseline != nil && event.Host == "" {
+		event.Violation = ""
+		if violation := baseline.Check(event.Path); violation != nil {
+			event.Violation = violation.String()
+			logger.Warn(fmt.Sprintf("Baseline violation: %s %s", event.Path, event.Violation))
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.journal != nil {
+		if err := h.journal.Print(event); err != nil {
+			logger.Error(err, "Failed to write journal")
+		}
+	}
+	for _, action := range h.actions {
+		if action.matcher.Matches(fsnotify.Event{Name: event.Path, Op: event.Operation}) {
+			action.runner.Notify(event.Path)
+		}
+	}
+	h.rules.Handle(event)
+}
+
+// replace takes over the baseline, journal, actions and rules of next, closing the
+// current ones, when the configuration is reloaded
+func (h *hooks) replace(next *hooks) {
+	h.mu.Lock()
+	previous := &hooks{file: h.file, journal: h.journal, actions: h.actions, rules: h.rules, integrity: h.integrity}
+	h.file, h.journal, h.actions, h.rules, h.integrity = next.file, next.journal, next.actions, next.rules, next.integrity
+	for _, listener := range h.firingListeners {
+		h.rules.OnFiring(listener)
+	}
+	for _, listener := range h.alertListeners {
+		h.rules.OnAlert(listener)
+	}
+	for _, listener := range h.detectionListeners {
+		h.rules.OnDetection(listener)
+	}
+	h.mu.Unlock()
+	previous.Close()
+}
+
+// Close stops the actions and rules and closes the baseline and journal
+func (h *hooks) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, action := range h.actions {
+		action.runner.Close()
+	}
+	if h.rules != nil {
+		h.rules.Close()
+	}
+	if h.integrity != nil {
+		if err := h.integrity.Close(); err != nil {
+			logger.Error(err, "Failed to close baseline")
+		}
+	}
+	if h.journal != nil {
+		if err := h.journal.Close(); err != nil {
+			logger.Error(err, "Failed to flush journal")
+		}
+	}
+	if h.file != nil {
+		if err := h.file.Close(); err != nil {
+			logger.Error(err, "Failed to close journal")
+		}
+	}
+}
+
+// reportRuleFailures returns a listener writing the failed rule actions
+// to w, for the modes without the rules pane of the TUI
+func reportRuleFailures(w io.Writer) func(ui.RuleFiring) {
+	return func(firing ui.RuleFiring) {
+		if firing.Err != nil {
+			fmt.Fprintf(w, "Rule %s: %s failed for %s: %v\n", firing.Rule, firing.Action, firing.Path, firing.Err)
+		}
+	}
+}
+
+// reportAlerts returns a listener writing alert changes to w, for the
+// modes without the status bar of the TUI
+func reportAlerts(w io.Writer) func(ui.Alert) {
+	return func(alert ui.Alert) {
+		state := "resolved"
+		if alert.Firing {
+			state = "firing"
+		}
+		fmt.Fprintf(w, "Alert %s %s: %s\n", alert.Name, state, alert.Reason)
+	}
+}
+
+// reportDetections returns a listener writing the evidence of ransomware-like
+// detections to w, for the modes without the detection popup of the TUI
+func reportDetections(w io.Writer) func(ui.Detection) {
+	return func(detection ui.Detection) {
+		fmt.Fprintf(w, "Ransomware-like activity at %s: %s\n",
+			detection.Time.Format("15:04:05"), strings.Join(detection.Signals, "; "))
+		if len(detection.Extensions) > 0 {
+			fmt.Fprintf(w, "  Unfamiliar extensions: %s\n", strings.Join(detection.Extensions, " "))
+		}
+		for _, path := range detection.Paths {
+			fmt.Fprintf(w, "  %s\n", path)
+		}
+		if detection.Containment != "" {
+			fmt.Fprintf(w, "  Containment: %s\n", detection.Containment)
+		}
+	}
+}
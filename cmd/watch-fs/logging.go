@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// logFlags holds the logging flags every command accepts
+type logFlags struct {
+	level      string
+	file       string
+	maxSize    int64 // Megabytes
+	maxBackups int
+}
+
+// logging is set by the flags of the running command
+var logging logFlags
+
+// newFlagSet creates the flag set of a command, with the logging flags
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&logging.level, "log-level", "info", "Diagnostic log level: trace, debug, info, warn, error or disabled")
+	flags.StringVar(&logging.file, "log-file", "", "Diagnostic log file, - for stderr or off (default: "+logger.DefaultPath()+")")
+	flags.Int64Var(&logging.maxSize, "log-max-size", logger.DefaultMaxSize>>20, "Rotate log files beyond this many megabytes (0: never)")
+	flags.IntVar(&logging.maxBackups, "log-max-backups", logger.DefaultMaxBackups, "Rotated log files to keep")
+	return flags
+}
+
+// parseFlags parses the flags of a command and sets up the diagnostic log.
+// A log that cannot be opened is reported and left disabled.
+func parseFlags(flags *flag.FlagSet, args []string) {
+	_ = flags.Parse(args)
+	if _, err := logger.ParseLevel(logging.level); err != nil {
+		fmt.Fprintf(flags.Output(), "invalid value %q for flag -log-level: %v\n", logging.level, err)
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := logger.Setup(logging.options()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: logging disabled: %v\n", err)
+	}
+}
+
+// options returns the logger options of the flags
+func (l logFlags) options() logger.Options {
+	options := logger.Options{Level: l.level, File: l.file, MaxSize: l.maxSize << 20, MaxBackups: l.maxBackups}
+	if l.maxSize == 0 {
+		options.MaxSize = -1
+	}
+	if l.maxBackups == 0 {
+		options.MaxBackups = -1
+	}
+	return options
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/pbouamriou/watch-fs/internal/metrics"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// serveMetrics serves the metrics of a watcher on /metrics at an address
+// until the process exits
+func serveMetrics(address string, watcher metrics.Watcher) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to serve metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler(watcher))
+	go func() {
+		if err := http.Serve(listener, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(err, "Metrics server stopped")
+		}
+	}()
+	logger.Info("Serving metrics on http://" + listener.Addr().String() + "/metrics")
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/query"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// queryEvent is a matching event in the JSON output of query
+type queryEvent struct {
+	Path         string    `json:"path"`
+	RelativePath string    `json:"relative_path"`
+	Op           string    `json:"op"`
+	IsDir        bool      `json:"is_dir"`
+	Count        int       `json:"count"`
+	Time         time.Time `json:"time"`
+}
+
+// runQuery implements `watch-fs query <file> [flags]`: it prints the
+// events of a capture matching conditions, optionally grouped
+func runQuery(args []string) int {
+	flags := newFlagSet("query")
+	var where pathsFlag
+	flags.Var(&where, "where", "Conditions such as 'path~internal/ and op=write and count>10' (can be used multiple times)")
+	groupBy := flags.String("group-by", "", "Group the matching events by dir, ext, op, root or host")
+	top := flags.Int("top", 0, "Only print the first N rows (default: all)")
+	since := flags.String("since", "", "Leave out events before this time (RFC 3339 or YYYY-MM-DD [HH:MM[:SS]])")
+	until := flags.String("until", "", "Leave out events after this time")
+	asJSON := flags.Bool("json", false, "Print JSON instead of a table")
+	textImport := textImportFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs query <file> [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Prints the events of a capture matching the conditions, busiest first.")
+		fmt.Fprintln(flags.Output(), "  Conditions: path~TEXT, op=NAME, type=file|dir and count with =, >, >=, < or <=,")
+		fmt.Fprintln(flags.Output(), "  filtering like the TUI event list.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+
+	// The file may come before the flags
+	var filename string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		filename, args = args[0], args[1:]
+	}
+	parseFlags(flags, args)
+	if filename == "" && flags.NArg() == 1 {
+		filename = flags.Arg(0)
+	} else if filename == "" || flags.NArg() > 0 || *top < 0 {
+		flags.Usage()
+		return 1
+	}
+
+	q := query.New()
+	for _, expr := range where {
+		if err := q.Where(expr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	by, err := query.ParseGroupBy(*groupBy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, bound := range []struct {
+		value string
+		time  *time.Time
+	}{{*since, &q.Since}, {*until, &q.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		if *bound.time, err = query.ParseTime(bound.value); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	format, ok := ui.CaptureFormat(filename)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: cannot tell the format of '%s'\n", filename)
+		return 1
+	}
+	session := ui.NewUI(captureSource{filename: filename}, filename)
+	session.SetTextImportOptions(*textImport)
+	events, meta, err := session.LoadCapture(filename, format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	session.GetState().Events = events
+	session.GetState().SortOption = ui.SortByCount
+	matching := q.Run(session)
+
+	if by != query.GroupNone {
+		groups := query.GroupEvents(matching, by, meta.Roots)
+		if *top > 0 && len(groups) > *top {
+			groups = groups[:*top]
+		}
+		err = writeGroups(os.Stdout, groups, *groupBy, *asJSON)
+	} else {
+		if *top > 0 && len(matching) > *top {
+			matching = matching[:*top]
+		}
+		err = writeQueryEvents(os.Stdout, matching, meta.Roots, *asJSON)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// writeGroups prints groups as a table or JSON
+func writeGroups(w io.Writer, groups []query.Group, by string, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(groups)
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(table, "EVENTS\tPATHS\tRECORDS\t  %s\n", strings.ToUpper(by))
+	for _, group := range groups {
+		fmt.Fprintf(table, "%d\t%d\t%d\t  %s\n", group.Events, group.Paths, group.Records, group.Key)
+	}
+	return table.Flush()
+}
+
+// writeQueryEvents prints events as a table or JSON
+func writeQueryEvents(w io.Writer, events []*ui.FileEvent, roots []string, asJSON bool) error {
+	if asJSON {
+		records := make([]queryEvent, 0, len(events))
+		for _, event := range events {
+			records = append(records, queryEvent{
+				Path:         event.Path,
+				RelativePath: utils.RelativePath(event.Path, roots),
+				Op:           event.Operation.String(),
+				IsDir:        event.IsDir,
+				Count:        max(event.Count, 1),
+				Time:         event.Timestamp,
+			})
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "COUNT\tOPERATION\tTYPE\tLAST SEEN\tPATH")
+	for _, event := range events {
+		kind := "file"
+		if event.IsDir {
+			kind = "dir"
+		}
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\n", max(event.Count, 1), event.Operation, kind,
+			event.Timestamp.Local().Format("2006-01-02 15:04:05"), utils.RelativePath(event.Path, roots))
+	}
+	return table.Flush()
+}
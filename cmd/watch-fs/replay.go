@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// captureSource stands in for the file watcher when working on a recorded
+// capture: it never produces live events and cannot watch new directories
+type captureSource struct {
+	filename string
+	roots    []string               // Roots recorded in the capture, the file name when empty
+	ignored  func(path string) bool // Paths left out of the replay, none when nil
+}
+
+func (s captureSource) Events() <-chan fsnotify.Event { return nil }
+func (s captureSource) Errors() <-chan error          { return nil }
+func (s captureSource) GetRoot() string               { return s.filename }
+
+func (s captureSource) GetRoots() []string {
+	if len(s.roots) > 0 {
+		return s.roots
+	}
+	return []string{s.filename}
+}
+
+func (s captureSource) Ignored(path string) bool {
+	return s.ignored != nil && s.ignored(path)
+}
+
+func (s captureSource) AddDirectory(path string) error {
+	return errors.New("directories cannot be watched from a capture")
+}
+
+// runReplay implements `watch-fs replay [flags] <file>` and returns the exit code
+func runReplay(args []string) int {
+	flags := newFlagSet("replay")
+	var roots rootFlags
+	roots.register(flags, false, false)
+	speed := flags.Float64("speed", 1, "Speed multiplier (1 is real time, 0 replays without delays)")
+	paused := flags.Bool("paused", false, "Start paused, stepping with 'n' (TUI only)")
+	useTUI := flags.Bool("tui", true, "Use terminal user interface (default: true)")
+	textImport := textImportFlags(flags)
+	consoleOutput := registerConsoleFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs replay [flags] <file>")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Replays a capture through the ignores, rules and alerts of -ignore and")
+		fmt.Fprintln(flags.Output(), "  the configuration; the roots are those of the capture, or of -path.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	filename := flags.Arg(0)
+	if *speed < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -speed must not be negative")
+		return 1
+	}
+
+	format, ok := ui.CaptureFormat(filename)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: cannot tell the format of '%s'\n", filename)
+		return 1
+	}
+
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	ignore, err := settings.IgnoreRules(roots.defaults)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// The roots are those of the capture, known once it is loaded, or
+	// those of -path for captures that did not record them
+	var replayRoots []string
+	ignored := func(path string) bool {
+		for _, root := range replayRoots {
+			if ignore.Match(root, path) {
+				return true
+			}
+		}
+		return false
+	}
+	replay := ui.NewUI(captureSource{filename: filename, ignored: ignored}, filename)
+	replay.SetTextImportOptions(*textImport)
+
+	// The replay starts paused until the hooks and printer know the roots
+	if err := replay.StartReplay(filename, format, *speed, true); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	replayRoots = replay.GetState().Replay.Roots
+	if len(replayRoots) == 0 {
+		replayRoots = settings.Roots
+	}
+	// Replayed events go through the rules, alerts and detection, but are
+	// not journaled again, do not run the live actions and are not checked
+	// against a baseline of the current files
+	settings.Journal = nil
+	settings.Actions = nil
+	settings.Integrity = nil
+	var output io.Writer = os.Stderr
+	if *useTUI {
+		output = nil
+	}
+	configHooks, err := newHooks(settings, replayRoots, ignored, output)
+	if err != nil {
+		replay.StopReplay()
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer configHooks.Close()
+	replay.OnEvent(configHooks.handle)
+
+	if !*useTUI {
+		// Print events as they are replayed, like the console watch mode
+		configHooks.onRuleFiring(reportRuleFailures(os.Stderr))
+		configHooks.onAlert(reportAlerts(os.Stderr))
+		configHooks.onDetection(reportDetections(os.Stderr))
+		printer, err := consoleOutput.newPrinter(os.Stdout, replayRoots)
+		if err != nil {
+			replay.StopReplay()
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		replay.OnEvent(func(event *ui.FileEvent) {
+			if err := printer.Print(event); err != nil {
+				logger.Error(err, "Failed to print event")
+			}
+		})
+		replay.ResumeReplay()
+		<-replay.ReplayDone()
+		if err := printer.Close(); err != nil {
+			logger.Error(err, "Failed to close console output")
+			return 1
+		}
+		return 0
+	}
+
+	replay.ShowRules(configHooks.ruleCount())
+	configHooks.onRuleFiring(replay.RecordRuleFiring)
+	configHooks.onAlert(replay.SetAlert)
+	configHooks.onDetection(replay.ShowDetection)
+	if !*paused {
+		replay.ResumeReplay()
+	}
+	if err := replay.Run(); err != nil {
+		logger.Error(err, "TUI exited with error")
+		return 1
+	}
+	return 0
+}
+
+// textImportFlags registers the flags describing watcher log formats
+func textImportFlags(flags *flag.FlagSet) *ui.TextImportOptions {
+	options := &ui.TextImportOptions{}
+	flags.StringVar(&options.InotifyFormat, "inotify-format", "", "inotifywait --format used by imported logs (default \"%w %e %f\")")
+	flags.StringVar(&options.InotifyTimeFmt, "inotify-timefmt", "", "inotifywait --timefmt used by imported logs with %T")
+	flags.StringVar(&options.FswatchTimeFmt, "fswatch-timefmt", "", "fswatch -f time format used by imported logs (default \"%c\")")
+	return options
+}
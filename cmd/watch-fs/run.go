@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/provenance"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// runRun implements `watch-fs run [flags] -- command [args...]`: it reports
+// the files the command changed and returns the exit code of the command
+func runRun(args []string) int {
+	flags := newFlagSet("run")
+	var roots rootFlags
+	roots.register(flags, true, false)
+	format := flags.String("format", "text", "Report format: text or json")
+	reportFile := flags.String("report", "", "Write the report to this file instead of the standard output")
+	output := flags.String("o", "", "Also export the changes as a capture (.db or .json, optionally .gz or .zst)")
+	quiet := flags.Duration("quiet", 500*time.Millisecond, "Quiet period to wait for after the command exits")
+	settleTimeout := flags.Duration("settle-timeout", 30*time.Second, "Longest wait for the quiet period (0 waits forever)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs run [flags] -- command [args...]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Runs a command and reports the files it created, modified or deleted.")
+		fmt.Fprintln(flags.Output(), "  Exits with the exit code of the command, or 128+N when signal N killed it.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+
+	if flags.NArg() == 0 || (*format != "text" && *format != "json") {
+		flags.Usage()
+		return 1
+	}
+	var exportFormat ui.ExportFormat
+	if *output != "" {
+		var err error
+		if exportFormat, err = captureFormat(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+
+	// Events go through the usual aggregation, without the TUI event limit
+	session := ui.NewUI(fileWatcher, fileWatcher.GetRoot())
+	session.GetState().MaxEvents = math.MaxInt
+
+	cmd := exec.Command(flags.Arg(0), flags.Args()[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Ctrl+C reaches the command, which shares the terminal; the report is
+	// still written once it exits
+	signal.Ignore(os.Interrupt)
+	report, err := provenance.Run(fileWatcher, session, cmd, provenance.Options{
+		Quiet:         *quiet,
+		SettleTimeout: *settleTimeout,
+	})
+	signal.Reset(os.Interrupt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *reportFile != "" {
+		file, err := os.Create(*reportFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to create report: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				logger.Error(err, "Failed to close report")
+			}
+		}()
+		w = file
+	}
+	if *format == "json" {
+		err = report.WriteJSON(w)
+	} else {
+		err = report.WriteText(w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to write report: %v\n", err)
+		return 1
+	}
+
+	if *output != "" {
+		session.GetState().Events = report.Events()
+		if err := session.ExportEvents(*output, exportFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	return report.ExitCode
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/server"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// shutdownTimeout bounds how long serve waits for requests in flight
+const shutdownTimeout = 5 * time.Second
+
+// runServe implements `watch-fs serve [flags]`: it watches without the TUI
+// and serves the events and the web UI over HTTP until interrupted
+func runServe(args []string) int {
+	flags := newFlagSet("serve")
+	var roots rootFlags
+	roots.register(flags, true, false)
+	listen := flags.String("listen", "127.0.0.1:7777", "Address to listen on, e.g. :7777 for every interface")
+	snapshotDir := snapshotDirFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs serve [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Serves a web UI on / and events over HTTP:")
+		fmt.Fprintln(flags.Output(), "    GET /api/events             stored events, filtered, sorted and paged")
+		fmt.Fprintln(flags.Output(), "    GET /api/stats              summary of the stored events")
+		fmt.Fprintln(flags.Output(), "    GET|POST|DELETE /api/roots  list, add or remove watched directories")
+		fmt.Fprintln(flags.Output(), "    GET /api/stream             live events as Server-Sent Events")
+		fmt.Fprintln(flags.Output(), "    GET /api/ws                 live events over WebSocket")
+		fmt.Fprintln(flags.Output(), "    GET /api/export             download the events as a capture")
+		fmt.Fprintln(flags.Output(), "    GET /metrics                Prometheus metrics")
+		fmt.Fprintln(flags.Output(), "  Filters: path, op, type, min_count, max_count, since, until and where.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 1
+	}
+
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	view, err := settings.View()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	configHooks, err := newHooks(settings, fileWatcher.GetRoots(), fileWatcher.Ignored, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer configHooks.Close()
+	configHooks.onRuleFiring(reportRuleFailures(os.Stderr))
+	configHooks.onAlert(reportAlerts(os.Stderr))
+	configHooks.onDetection(reportDetections(os.Stderr))
+
+	srv := server.New(fileWatcher, view)
+	srv.OnEvent(configHooks.handle)
+	go srv.Watch()
+
+	httpServer, served, err := startHTTP(srv, *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	signals, stopSignals := notifySignals()
+	defer stopSignals()
+	for {
+		select {
+		case err := <-served:
+			if !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			return 0
+		case sig := <-signals:
+			switch actionOf(sig) {
+			case signalReload:
+				settings, err := reloadConfig(fileWatcher, &roots, configHooks, os.Stderr)
+				if err == nil {
+					view, err = settings.View()
+				}
+				if err != nil {
+					logger.Error(err, "Failed to reload configuration")
+					continue
+				}
+				srv.ApplySettings(view)
+				fmt.Fprintln(os.Stderr, "Configuration reloaded")
+			case signalSnapshot:
+				filename, err := writeSnapshot(srv, *snapshotDir)
+				if err != nil {
+					logger.Error(err, "Failed to write snapshot")
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "Snapshot written to %s\n", filename)
+			default:
+				stopHTTP(srv, httpServer)
+				return 0
+			}
+		}
+	}
+}
+
+// startHTTP serves the API and web UI of a server on an address; the
+// channel receives the outcome of serving
+func startHTTP(srv *server.Server, address string) (*http.Server, <-chan error, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpServer := &http.Server{Handler: srv.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	served := make(chan error, 1)
+	go func() { served <- httpServer.Serve(listener) }()
+	fmt.Fprintf(os.Stderr, "Serving events on http://%s\n", listener.Addr())
+	return httpServer, served, nil
+}
+
+// stopHTTP closes the streams of a server, then waits a while for the
+// requests in flight
+func stopHTTP(srv *server.Server, httpServer *http.Server) {
+	// Streams only end when the server closes them
+	srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Error(err, "Failed to shut down the server")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+)
+
+// signalAction is what a signal asks a watching command to do
+type signalAction int
+
+const (
+	signalStop     signalAction = iota // SIGINT, SIGTERM: flush outputs and exit
+	signalReload                       // SIGHUP: reload the configuration
+	signalSnapshot                     // SIGUSR1: write the current events to a capture
+)
+
+// notifySignals relays the shutdown, reload and snapshot signals; the
+// returned function stops relaying
+func notifySignals() (<-chan os.Signal, func()) {
+	signals := make(chan os.Signal, 1)
+	handled := append([]os.Signal{os.Interrupt, syscall.SIGTERM}, reloadSignals...)
+	signal.Notify(signals, append(handled, snapshotSignals...)...)
+	return signals, func() { signal.Stop(signals) }
+}
+
+// actionOf returns what a relayed signal asks for
+func actionOf(sig os.Signal) signalAction {
+	switch {
+	case slices.Contains(reloadSignals, sig):
+		return signalReload
+	case slices.Contains(snapshotSignals, sig):
+		return signalSnapshot
+	default:
+		return signalStop
+	}
+}
+
+// reloadConfig reads the configuration again and applies its ignore rules,
+// journal, actions and event rules. The watched roots are kept, and so are the watches
+// of directories the new rules ignore.
+func reloadConfig(fileWatcher *watcher.Watcher, roots *rootFlags, configHooks *hooks, output io.Writer) (config.Settings, error) {
+	settings, err := roots.reload()
+	if err != nil {
+		return config.Settings{}, err
+	}
+	rules, err := settings.IgnoreRules(roots.defaults)
+	if err != nil {
+		return config.Settings{}, err
+	}
+	next, err := newHooks(settings, fileWatcher.GetRoots(), fileWatcher.Ignored, output)
+	if err != nil {
+		return config.Settings{}, err
+	}
+	if err := fileWatcher.ReloadIgnore(rules); err != nil {
+		next.Close()
+		return config.Settings{}, err
+	}
+	configHooks.replace(next)
+	return settings, nil
+}
+
+// snapshotter exports every event it holds, like ui.UI and server.Server
+type snapshotter interface {
+	Snapshot(filename string, format ui.ExportFormat) error
+}
+
+// writeSnapshot exports every event of a session to a timestamped capture
+// in dir and returns its name
+func writeSnapshot(session snapshotter, dir string) (string, error) {
+	filename := filepath.Join(dir, "watch-fs-"+time.Now().Format("20060102-150405")+".db")
+	if err := session.Snapshot(filename, ui.FormatSQLite); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
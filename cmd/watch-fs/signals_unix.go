@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// reloadSignals reload the configuration
+	reloadSignals = []os.Signal{syscall.SIGHUP}
+	// snapshotSignals write the current events to a capture
+	snapshotSignals = []os.Signal{syscall.SIGUSR1}
+)
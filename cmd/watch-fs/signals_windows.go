@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows has no SIGHUP or SIGUSR1; only shutdown signals are handled
+var (
+	reloadSignals   []os.Signal
+	snapshotSignals []os.Signal
+)
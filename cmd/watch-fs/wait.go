@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/wait"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// Exit codes of the wait and settle subcommands
+const (
+	exitMatched  = 0 // The event happened, or the directories settled
+	exitTimedOut = 1 // The timeout expired first
+	exitError    = 2 // Invalid arguments or watcher failure
+)
+
+// waitFlags holds the flags shared by wait and settle
+type waitFlags struct {
+	roots      rootFlags
+	match      string
+	operations string
+	timeout    time.Duration
+}
+
+// register registers the shared flags on a flag set
+func (w *waitFlags) register(flags *flag.FlagSet) {
+	w.roots.register(flags, true, true)
+	flags.StringVar(&w.match, "match", "", "Only consider paths matching this glob, relative to the watched directory (**, * and ? supported)")
+	flags.StringVar(&w.operations, "op", "create,write,remove,rename", "Only consider these operations (comma-separated: create,write,remove,rename,chmod)")
+	flags.DurationVar(&w.timeout, "timeout", 0, "Give up after this long (default: wait forever)")
+}
+
+// setup starts watching and builds the matcher described by the flags
+func (w *waitFlags) setup() (*watcher.Watcher, wait.Matcher, error) {
+	operations, err := console.ParseOperations(w.operations)
+	if err != nil {
+		return nil, wait.Matcher{}, err
+	}
+	var pattern *utils.Glob
+	if w.match != "" {
+		if pattern, err = utils.NewGlob(w.match); err != nil {
+			return nil, wait.Matcher{}, err
+		}
+	}
+	fileWatcher, err := w.roots.newWatcher()
+	if err != nil {
+		return nil, wait.Matcher{}, err
+	}
+	return fileWatcher, wait.Matcher{
+		Roots:      fileWatcher.GetRoots(),
+		Pattern:    pattern,
+		Operations: operations,
+		Ignore:     fileWatcher.Ignored,
+	}, nil
+}
+
+// closeWatcher closes a watcher, logging failures
+func closeWatcher(fileWatcher *watcher.Watcher) {
+	if err := fileWatcher.Close(); err != nil {
+		logger.Error(err, "Failed to close watcher")
+	}
+}
+
+// runWait implements `watch-fs wait [flags]`: it blocks until a matching
+// event and returns the exit code
+func runWait(args []string) int {
+	flags := newFlagSet("wait")
+	var options waitFlags
+	options.register(flags)
+	printJSON := flags.Bool("json", false, "Print the matching event as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs wait [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Waits for a matching event. Exits with 0 on a match, 1 on timeout and 2 on error.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	fileWatcher, matcher, err := options.setup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	defer closeWatcher(fileWatcher)
+
+	event, err := wait.ForEvent(fileWatcher, matcher, options.timeout)
+	if errors.Is(err, wait.ErrTimeout) {
+		fmt.Fprintf(os.Stderr, "No matching event after %s\n", options.timeout)
+		return exitTimedOut
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	if *printJSON {
+		printer, err := console.NewPrinter(os.Stdout, console.Options{
+			Format: console.FormatNDJSON,
+			Roots:  matcher.Roots,
+			Filter: ui.Filter{ShowDirs: true, ShowFiles: true},
+		})
+		if err == nil {
+			isDir := false
+			if info, statErr := os.Stat(event.Name); statErr == nil {
+				isDir = info.IsDir()
+			}
+			err = printer.Print(&ui.FileEvent{Path: event.Name, Operation: event.Op, Timestamp: time.Now(), IsDir: isDir, Count: 1})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+	}
+	return exitMatched
+}
+
+// runSettle implements `watch-fs settle [flags]`: it blocks until the
+// directories have been quiet for a while and returns the exit code
+func runSettle(args []string) int {
+	flags := newFlagSet("settle")
+	var options waitFlags
+	options.register(flags)
+	quiet := flags.Duration("quiet", 2*time.Second, "Quiet period without events to wait for")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs settle [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Waits until no matching event happened for the quiet period.")
+		fmt.Fprintln(flags.Output(), "  Exits with 0 once settled, 1 on timeout and 2 on error.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 || *quiet <= 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	fileWatcher, matcher, err := options.setup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	defer closeWatcher(fileWatcher)
+
+	count, err := wait.ForQuiet(fileWatcher, matcher, *quiet, options.timeout)
+	if errors.Is(err, wait.ErrTimeout) {
+		fmt.Fprintf(os.Stderr, "Still changing after %s (%d events)\n", options.timeout, count)
+		return exitTimedOut
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+	return exitMatched
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// runWatch implements `watch-fs watch [flags]`: it prints events to the
+// console and returns the exit code
+func runWatch(args []string) int {
+	flags := newFlagSet("watch")
+	var roots rootFlags
+	roots.register(flags, false, false)
+	snapshotDir := snapshotDirFlag(flags)
+	consoleOutput := registerConsoleFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs watch [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Prints events to the console as text, JSON or a template.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 1
+	}
+
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+	return watchConsole(fileWatcher, &roots, consoleOutput, *snapshotDir)
+}
+
+// runTUI implements `watch-fs tui [flags]` and returns the exit code
+func runTUI(args []string) int {
+	flags := newFlagSet("tui")
+	var roots rootFlags
+	roots.register(flags, false, false)
+	snapshotDir := snapshotDirFlag(flags)
+	textImport := textImportFlags(flags)
+	attach := flags.Bool("attach", false, "Show the events of the daemon of -socket instead of watching")
+	socket := socketFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: watch-fs tui [flags]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "  Browses events in the terminal user interface.")
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 1
+	}
+	if *attach {
+		return attachTUI(&roots, *socket, textImport, *snapshotDir)
+	}
+
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+	return watchTUI(fileWatcher, &roots, textImport, *snapshotDir)
+}
+
+// runLegacy implements the original `watch-fs -path DIR [-tui=false]`
+// invocation, which runs tui or watch
+func runLegacy(args []string) int {
+	flags := newFlagSet("watch-fs")
+	var roots rootFlags
+	roots.register(flags, false, false)
+	useTUI := flags.Bool("tui", true, "Use terminal user interface (default: true)")
+	snapshotDir := snapshotDirFlag(flags)
+	showVersion := flags.Bool("version", false, "Show version information")
+	textImport := textImportFlags(flags)
+	consoleOutput := registerConsoleFlags(flags)
+	parseFlags(flags, args)
+
+	if *showVersion {
+		return runVersion(nil)
+	}
+
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(settings.Roots) == 0 {
+		fmt.Println("Error: at least one --path flag is required")
+		fmt.Println("Usage:")
+		fmt.Println("  watch-fs --path /single/directory")
+		fmt.Println("  watch-fs --path /dir1 --path /dir2 --path /dir3")
+		fmt.Println("  watch-fs --paths '/dir1,/dir2,/dir3'  (legacy)")
+		flags.Usage()
+		return 1
+	}
+	fileWatcher, err := roots.newWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer closeWatcher(fileWatcher)
+
+	if *useTUI {
+		return watchTUI(fileWatcher, &roots, textImport, *snapshotDir)
+	}
+	return watchConsole(fileWatcher, &roots, consoleOutput, *snapshotDir)
+}
+
+// snapshotDirFlag registers the directory of the captures written on
+// SIGUSR1
+func snapshotDirFlag(flags *flag.FlagSet) *string {
+	return flags.String("snapshot-dir", ".", "Directory of the capture written on SIGUSR1")
+}
+
+// watchTUI shows the events of a watcher in the TUI, with the view
+// settings and profiles of the configuration. SIGINT and SIGTERM quit,
+// SIGHUP reloads the configuration and SIGUSR1 writes a snapshot.
+func watchTUI(fileWatcher *watcher.Watcher, roots *rootFlags, textImport *ui.TextImportOptions, snapshotDir string) int {
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	view, err := settings.View()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	profiles, err := roots.file.UIProfiles(roots.defaults)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// Action output would garble the TUI; the runners keep it
+	configHooks, err := newHooks(settings, fileWatcher.GetRoots(), fileWatcher.Ignored, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer configHooks.Close()
+
+	tui := ui.NewUI(fileWatcher, fileWatcher.GetRoot())
+	tui.SetTextImportOptions(*textImport)
+	tui.ApplySettings(view)
+	tui.SetProfiles(profiles, roots.profileName)
+	tui.OnEvent(configHooks.handle)
+	tui.ShowRules(configHooks.ruleCount())
+	configHooks.onRuleFiring(tui.RecordRuleFiring)
+	configHooks.onAlert(tui.SetAlert)
+	configHooks.onDetection(tui.ShowDetection)
+	if configHooks.hasBaseline() {
+		tui.SetBaselineAccept(configHooks.acceptBaseline)
+	}
+
+	signals, stopSignals := notifySignals()
+	defer stopSignals()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				handleTUISignal(tui, sig, fileWatcher, roots, configHooks, snapshotDir)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err := tui.Run(); err != nil {
+		logger.Error(err, "TUI exited with error")
+		return 1
+	}
+	return 0
+}
+
+// handleTUISignal acts on a signal received by the TUI, on the TUI
+// goroutine since it changes its state, reporting the outcome in the status
+// bar
+func handleTUISignal(tui *ui.UI, sig os.Signal, fileWatcher *watcher.Watcher, roots *rootFlags, configHooks *hooks, snapshotDir string) {
+	tui.Update(func() {
+		applyTUISignal(tui, sig, fileWatcher, roots, configHooks, snapshotDir)
+	})
+}
+
+// applyTUISignal acts on a signal received by the TUI
+func applyTUISignal(tui *ui.UI, sig os.Signal, fileWatcher *watcher.Watcher, roots *rootFlags, configHooks *hooks, snapshotDir string) {
+	switch actionOf(sig) {
+	case signalReload:
+		if _, err := reloadConfig(fileWatcher, roots, configHooks, nil); err != nil {
+			tui.SetStatusMessage(fmt.Sprintf("Reload failed: %v", err))
+			return
+		}
+		profiles, err := roots.file.UIProfiles(roots.defaults)
+		if err != nil {
+			tui.SetStatusMessage(fmt.Sprintf("Reload failed: %v", err))
+			return
+		}
+		tui.SetProfiles(profiles, roots.profileName)
+		tui.ShowRules(configHooks.ruleCount())
+		tui.SetStatusMessage("Configuration reloaded")
+	case signalSnapshot:
+		filename, err := writeSnapshot(tui, snapshotDir)
+		if err != nil {
+			tui.SetStatusMessage(fmt.Sprintf("Snapshot failed: %v", err))
+			return
+		}
+		tui.SetStatusMessage(fmt.Sprintf("Snapshot written to %s", filename))
+	default:
+		tui.Quit()
+	}
+}
+
+// watchConsole prints the events of a watcher until it closes or SIGINT or
+// SIGTERM arrives. The filter of the configuration applies when no filter
+// flag is given. SIGHUP reloads the configuration and SIGUSR1 writes the
+// events kept in memory to a snapshot.
+func watchConsole(fileWatcher *watcher.Watcher, roots *rootFlags, consoleOutput *consoleFlags, snapshotDir string) int {
+	settings, err := roots.settings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	view, err := settings.View()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	consoleOutput.applyConfig(settings.Filter)
+	printer, err := consoleOutput.newPrinter(os.Stdout, fileWatcher.GetRoots())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() {
+		if err := printer.Close(); err != nil {
+			logger.Error(err, "Failed to close console output")
+		}
+	}()
+	configHooks, err := newHooks(settings, fileWatcher.GetRoots(), fileWatcher.Ignored, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer configHooks.Close()
+	configHooks.onRuleFiring(reportRuleFailures(os.Stderr))
+	configHooks.onAlert(reportAlerts(os.Stderr))
+	configHooks.onDetection(reportDetections(os.Stderr))
+
+	// Events are kept, within the configured retention, for snapshots
+	session := ui.NewUI(fileWatcher, fileWatcher.GetRoot())
+	session.ApplySettings(view)
+
+	signals, stopSignals := notifySignals()
+	defer stopSignals()
+
+	for {
+		select {
+		case event, ok := <-fileWatcher.Events():
+			if !ok {
+				return 0
+			}
+			if fileWatcher.Ignored(event.Name) {
+				continue
+			}
+			isDir := false
+			if info, err := os.Stat(event.Name); err == nil {
+				isDir = info.IsDir()
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create && isDir {
+				_ = fileWatcher.AddDirectory(event.Name)
+			}
+
+			fileEvent := &ui.FileEvent{
+				Path:      event.Name,
+				Operation: event.Op,
+				Timestamp: time.Now(),
+				IsDir:     isDir,
+				Count:     1,
+				Attrs:     fileWatcher.AttrChange(event),
+			}
+			configHooks.handle(fileEvent)
+			// The session keeps its own copy, which aggregation changes
+			recorded := *fileEvent
+			session.RecordEvent(&recorded)
+			if err := printer.Print(fileEvent); err != nil {
+				logger.Error(err, "Failed to print event")
+			}
+
+		case err, ok := <-fileWatcher.Errors():
+			if !ok {
+				return 0
+			}
+			logger.Error(err, "Watcher error")
+
+		case sig := <-signals:
+			switch actionOf(sig) {
+			case signalReload:
+				settings, err := reloadConfig(fileWatcher, roots, configHooks, os.Stderr)
+				if err == nil {
+					view, err = settings.View()
+				}
+				if err != nil {
+					logger.Error(err, "Failed to reload configuration")
+					continue
+				}
+				session.ApplySettings(view)
+				fmt.Fprintln(os.Stderr, "Configuration reloaded")
+			case signalSnapshot:
+				filename, err := writeSnapshot(session, snapshotDir)
+				if err != nil {
+					logger.Error(err, "Failed to write snapshot")
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "Snapshot written to %s\n", filename)
+			default:
+				return 0
+			}
+		}
+	}
+}
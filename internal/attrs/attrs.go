@@ -0,0 +1,233 @@
+// Package attrs follows the mode and ownership of the watched paths, so
+// that CHMOD events tell what they changed, and flags the risky
+// transitions: world-writable, setuid or setgid added, and ownership
+// changed to or from root.
+package attrs
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Risky transitions
+const (
+	RiskWorldWritable = "world-writable"
+	RiskSetuid        = "setuid added"
+	RiskSetgid        = "setgid added"
+	RiskOwnerToRoot   = "owner changed to root"
+	RiskOwnerFromRoot = "owner changed from root"
+	RiskGroupToRoot   = "group changed to root"
+	RiskGroupFromRoot = "group changed from root"
+)
+
+const (
+	maxTracked      = 1 << 20 // Paths whose attributes are kept; further ones are not followed
+	maxPending      = 16      // Changes kept per path until they are taken
+	maxPendingPaths = 1024    // Paths with changes not taken, for consumers never taking them
+)
+
+// Attributes are the mode and ownership of a path
+type Attributes struct {
+	Mode fs.FileMode `json:"mode"`
+	UID  int         `json:"uid"` // -1 where owners are unknown, e.g. on Windows
+	GID  int         `json:"gid"`
+}
+
+// Of returns the attributes of a file
+func Of(info fs.FileInfo) Attributes {
+	uid, gid := Owner(info)
+	return Attributes{Mode: info.Mode(), UID: uid, GID: gid}
+}
+
+// Change is how an event changed the attributes of a path
+type Change struct {
+	Before Attributes `json:"before"`
+	After  Attributes `json:"after"`
+	Risks  []string   `json:"risks,omitempty"` // Risky transitions, e.g. "world-writable"
+}
+
+// Compare returns the change between two states of a path, nil when they
+// are equal
+func Compare(before, after Attributes) *Change {
+	if before == after {
+		return nil
+	}
+	return &Change{Before: before, After: after, Risks: risks(before, after)}
+}
+
+// risks lists the risky transitions between two states of a path
+func risks(before, after Attributes) []string {
+	var found []string
+	if after.Mode&fs.ModeSymlink == 0 && after.Mode&0o002 != 0 && before.Mode&0o002 == 0 {
+		found = append(found, RiskWorldWritable)
+	}
+	if after.Mode&fs.ModeSetuid != 0 && before.Mode&fs.ModeSetuid == 0 {
+		found = append(found, RiskSetuid)
+	}
+	if after.Mode&fs.ModeSetgid != 0 && before.Mode&fs.ModeSetgid == 0 {
+		found = append(found, RiskSetgid)
+	}
+	if risk := rootRisk(before.UID, after.UID, RiskOwnerToRoot, RiskOwnerFromRoot); risk != "" {
+		found = append(found, risk)
+	}
+	if risk := rootRisk(before.GID, after.GID, RiskGroupToRoot, RiskGroupFromRoot); risk != "" {
+		found = append(found, risk)
+	}
+	return found
+}
+
+// rootRisk returns the risk of an owner or group changing to or from root,
+// empty when it did neither or is unknown
+func rootRisk(before, after int, to, from string) string {
+	switch {
+	case before == after || before < 0 || after < 0:
+		return ""
+	case after == 0:
+		return to
+	case before == 0:
+		return from
+	default:
+		return ""
+	}
+}
+
+// Then returns the change c followed by next: from the state before c to
+// the state after next, with the risks of both, so that a risky state
+// reverted shortly after is still reported
+func (c *Change) Then(next *Change) *Change {
+	if c == nil {
+		return next
+	}
+	if next == nil {
+		return c
+	}
+	merged := &Change{Before: c.Before, After: next.After}
+	for _, risk := range append(append([]string{}, c.Risks...), next.Risks...) {
+		if !merged.HasRisk(risk) {
+			merged.Risks = append(merged.Risks, risk)
+		}
+	}
+	return merged
+}
+
+// HasRisk reports whether the change has a risk
+func (c *Change) HasRisk(risk string) bool {
+	if c == nil {
+		return false
+	}
+	for _, r := range c.Risks {
+		if r == risk {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes what changed, e.g. "mode -rw-r--r-- -> -rwxrwxrwx"
+func (c *Change) String() string {
+	var changes []string
+	if c.Before.Mode != c.After.Mode {
+		changes = append(changes, fmt.Sprintf("mode %s -> %s", c.Before.Mode, c.After.Mode))
+	}
+	if c.Before.UID != c.After.UID || c.Before.GID != c.After.GID {
+		changes = append(changes, fmt.Sprintf("owner %d:%d -> %d:%d", c.Before.UID, c.Before.GID, c.After.UID, c.After.GID))
+	}
+	if len(changes) == 0 {
+		return "reverted"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// Tracker keeps the last known attributes of paths, and the changes of the
+// CHMOD events it observed until they are taken
+type Tracker struct {
+	mu      sync.Mutex
+	known   map[string]Attributes
+	pending map[string][]*Change // Per path, one entry per CHMOD event, nil when nothing changed
+}
+
+// NewTracker creates a tracker knowing no path
+func NewTracker() *Tracker {
+	return &Tracker{
+		known:   make(map[string]Attributes),
+		pending: make(map[string][]*Change),
+	}
+}
+
+// Record sets the known attributes of a path, e.g. while walking a tree
+func (t *Tracker) Record(path string, info fs.FileInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recordUnsafe(path, Of(info))
+}
+
+// recordUnsafe sets the known attributes of a path; the caller must hold
+// the mutex
+func (t *Tracker) recordUnsafe(path string, attributes Attributes) {
+	if _, ok := t.known[path]; !ok && len(t.known) >= maxTracked {
+		return
+	}
+	t.known[path] = attributes
+}
+
+// Observe updates the attributes of the path of an event, as it happens.
+// Each CHMOD event leaves a change, nil when neither mode nor owner
+// changed, for Take. Changes are no longer queued for new paths once
+// maxPendingPaths paths have some, as when nobody takes them.
+func (t *Tracker) Observe(event fsnotify.Event) {
+	var current *Attributes
+	if event.Op&(fsnotify.Create|fsnotify.Chmod) != 0 {
+		if info, err := os.Lstat(event.Name); err == nil {
+			attributes := Of(info)
+			current = &attributes
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	before, known := t.known[event.Name]
+	switch {
+	case current != nil:
+		t.recordUnsafe(event.Name, *current)
+	case event.Op&(fsnotify.Create|fsnotify.Chmod|fsnotify.Remove|fsnotify.Rename) != 0:
+		delete(t.known, event.Name)
+	}
+	if !event.Has(fsnotify.Chmod) {
+		return
+	}
+
+	if _, queued := t.pending[event.Name]; !queued && len(t.pending) >= maxPendingPaths {
+		return
+	}
+	var change *Change
+	if known && current != nil {
+		change = Compare(before, *current)
+	}
+	pending := append(t.pending[event.Name], change)
+	if len(pending) > maxPending {
+		pending = pending[len(pending)-maxPending:]
+	}
+	t.pending[event.Name] = pending
+}
+
+// Take returns the change of the oldest CHMOD event of a path not taken
+// yet, nil when it changed nothing or none is left
+func (t *Tracker) Take(path string) *Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending[path]
+	if len(pending) == 0 {
+		return nil
+	}
+	if len(pending) == 1 {
+		delete(t.pending, path)
+	} else {
+		t.pending[path] = pending[1:]
+	}
+	return pending[0]
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package attrs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// Owner returns the user and group owning a file
+func Owner(info fs.FileInfo) (int, int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return -1, -1
+}
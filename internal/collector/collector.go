@@ -0,0 +1,328 @@
+package collector
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// ackEvery is the number of events after which a busy forwarder is
+// acknowledged even though more are waiting
+const ackEvery = 256
+
+// errNotLocal is returned by the watcher methods that manage directories,
+// which the hosts do themselves
+var errNotLocal = errors.New("the collector shows the directories of its hosts and cannot change them")
+
+// Host is a host forwarding to a collector
+type Host struct {
+	Name      string
+	Address   string   // Remote address of the last connection
+	Roots     []string // Roots watched on the host
+	Connected bool
+	Events    int       // Events received from the host
+	LastSeen  time.Time // Time of the last connection or event
+}
+
+// host is the state kept for a host across its connections
+type host struct {
+	Host
+	session string // Forwarder run the sequence numbers belong to
+	seq     uint64 // Last sequence number received
+	conn    *conn  // Current connection, nil when disconnected
+}
+
+// Collector receives the events of forwarders. It provides the watcher
+// methods the TUI uses, and delivers the events with their host on
+// FileEvents.
+type Collector struct {
+	token     string
+	tlsConfig *tls.Config
+
+	mu       sync.Mutex
+	hosts    map[string]*host
+	listener net.Listener
+	notices  []func(string)
+
+	events    chan *ui.FileEvent
+	fsEvents  chan fsnotify.Event // Never sent on, closed by Close
+	errors    chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a collector accepting forwarders with a token, over TLS
+// when tlsConfig is not nil
+func New(token string, tlsConfig *tls.Config) *Collector {
+	return &Collector{
+		token:     token,
+		tlsConfig: tlsConfig,
+		hosts:     make(map[string]*host),
+		events:    make(chan *ui.FileEvent, 4096),
+		fsEvents:  make(chan fsnotify.Event),
+		errors:    make(chan error, 16),
+		closed:    make(chan struct{}),
+	}
+}
+
+// OnNotice registers a function called when a host connects or
+// disconnects, with a message describing it; register it before Serve
+func (c *Collector) OnNotice(notice func(string)) {
+	c.notices = append(c.notices, notice)
+}
+
+// notify calls the notice functions
+func (c *Collector) notify(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	logger.Info(message)
+	for _, notice := range c.notices {
+		notice(message)
+	}
+}
+
+// Listen listens for forwarders on a TCP address
+func (c *Collector) Listen(address string) (net.Listener, error) {
+	var listener net.Listener
+	var err error
+	if c.tlsConfig != nil {
+		listener, err = tls.Listen("tcp", address, c.tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	return listener, nil
+}
+
+// Serve accepts forwarders until Close
+func (c *Collector) Serve(listener net.Listener) error {
+	c.mu.Lock()
+	c.listener = listener
+	c.mu.Unlock()
+	for {
+		raw, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-c.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+		go c.handle(newConn(raw))
+	}
+}
+
+// handle receives the events of a forwarder until it disconnects
+func (c *Collector) handle(fc *conn) {
+	defer func() { _ = fc.Close() }()
+	h, err := c.welcome(fc)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Refused forwarder %s: %v", fc.RemoteAddr(), err))
+		return
+	}
+	defer c.disconnect(h, fc)
+
+	unacknowledged := 0
+	for {
+		m, err := fc.receive()
+		if err != nil {
+			return
+		}
+		if m.Type != typeEvent || m.Event == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		duplicate := m.Seq <= h.seq
+		if !duplicate {
+			h.seq = m.Seq
+			h.Events++
+			h.LastSeen = time.Now()
+		}
+		seq := h.seq
+		c.mu.Unlock()
+
+		if !duplicate {
+			select {
+			case c.events <- m.Event.FileEvent(h.Name):
+			case <-c.closed:
+				return
+			}
+		}
+		// Acknowledge once every received event is delivered
+		unacknowledged++
+		if fc.idle() || unacknowledged >= ackEvery {
+			if err := fc.send(&message{Type: typeAck, Seq: seq}, true); err != nil {
+				return
+			}
+			unacknowledged = 0
+		}
+	}
+}
+
+// welcome checks the hello of a forwarder and answers with the last
+// sequence number received from it
+func (c *Collector) welcome(fc *conn) (*host, error) {
+	_ = fc.SetDeadline(time.Now().Add(handshakeTimeout))
+	hello, err := fc.receive()
+	if err != nil {
+		return nil, err
+	}
+	refuse := func(reason string) error {
+		_ = fc.send(&message{Type: typeError, Error: reason}, true)
+		return errors.New(reason)
+	}
+	if hello.Type != typeHello {
+		return nil, refuse(fmt.Sprintf("expected hello, got %q", hello.Type))
+	}
+	if subtle.ConstantTimeCompare([]byte(hello.Token), []byte(c.token)) != 1 {
+		return nil, refuse("invalid token")
+	}
+	if hello.Version != protocolVersion {
+		return nil, refuse(fmt.Sprintf("unsupported protocol version %d", hello.Version))
+	}
+	if hello.Host == "" {
+		return nil, refuse("missing host name")
+	}
+
+	c.mu.Lock()
+	h, ok := c.hosts[hello.Host]
+	if !ok {
+		h = &host{Host: Host{Name: hello.Host}}
+		c.hosts[hello.Host] = h
+	}
+	if h.conn != nil {
+		// The previous connection of a reconnecting host is dead
+		_ = h.conn.Close()
+	}
+	if h.session != hello.Session {
+		h.session, h.seq = hello.Session, 0
+	}
+	h.conn = fc
+	h.Address = fc.RemoteAddr().String()
+	h.Roots = hello.Roots
+	h.Connected = true
+	h.LastSeen = time.Now()
+	seq := h.seq
+	c.mu.Unlock()
+
+	if err := fc.send(&message{Type: typeWelcome, Seq: seq}, true); err != nil {
+		return nil, err
+	}
+	_ = fc.SetDeadline(time.Time{})
+	c.notify("Host %s connected from %s", hello.Host, h.Address)
+	return h, nil
+}
+
+// disconnect records the end of a connection of a host
+func (c *Collector) disconnect(h *host, fc *conn) {
+	c.mu.Lock()
+	current := h.conn == fc
+	if current {
+		h.conn = nil
+		h.Connected = false
+	}
+	c.mu.Unlock()
+	if current {
+		c.notify("Host %s disconnected", h.Name)
+	}
+}
+
+// Hosts returns the hosts that connected, sorted by name
+func (c *Collector) Hosts() []Host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hosts := make([]Host, 0, len(c.hosts))
+	for _, h := range c.hosts {
+		copied := h.Host
+		copied.Roots = append([]string(nil), h.Roots...)
+		hosts = append(hosts, copied)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts
+}
+
+// FileEvents returns the events received from the hosts
+func (c *Collector) FileEvents() <-chan *ui.FileEvent {
+	return c.events
+}
+
+// Events returns no local events; it closes with the collector
+func (c *Collector) Events() <-chan fsnotify.Event {
+	return c.fsEvents
+}
+
+// Errors returns no errors; it closes with the collector
+func (c *Collector) Errors() <-chan error {
+	return c.errors
+}
+
+// GetRoots returns the roots of every host, as host:root
+func (c *Collector) GetRoots() []string {
+	var roots []string
+	for _, h := range c.Hosts() {
+		for _, root := range h.Roots {
+			roots = append(roots, h.Name+":"+root)
+		}
+	}
+	return roots
+}
+
+// GetRoot returns the first root
+func (c *Collector) GetRoot() string {
+	if roots := c.GetRoots(); len(roots) > 0 {
+		return roots[0]
+	}
+	return ""
+}
+
+// AddDirectory is not supported; the hosts watch their directories
+func (c *Collector) AddDirectory(string) error {
+	return errNotLocal
+}
+
+// AddRoot is not supported; roots are given to the forwarders
+func (c *Collector) AddRoot(string) error {
+	return errNotLocal
+}
+
+// RemoveRoot is not supported; roots are given to the forwarders
+func (c *Collector) RemoveRoot(string) error {
+	return errNotLocal
+}
+
+// IsWatching reports false: the collector watches no local directory
+func (c *Collector) IsWatching(string) bool {
+	return false
+}
+
+// Close stops accepting forwarders and disconnects them
+func (c *Collector) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		if c.listener != nil {
+			_ = c.listener.Close()
+		}
+		for _, h := range c.hosts {
+			if h.conn != nil {
+				_ = h.conn.Close()
+			}
+		}
+		c.mu.Unlock()
+		close(c.fsEvents)
+		close(c.errors)
+	})
+	return nil
+}
@@ -0,0 +1,308 @@
+package collector
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/metrics"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+const (
+	// DefaultBuffer is the default number of events kept while the
+	// collector is unreachable
+	DefaultBuffer = 10000
+	// minRetry and maxRetry bound the delay between reconnections, which
+	// doubles after each failure
+	minRetry = time.Second
+	maxRetry = 30 * time.Second
+	// dialTimeout bounds each connection attempt
+	dialTimeout = 10 * time.Second
+	// writeTimeout bounds sending a batch of events to a stalled collector
+	writeTimeout = 30 * time.Second
+)
+
+// ForwarderOptions configures a Forwarder
+type ForwarderOptions struct {
+	Address string      // Address of the collector, host:port
+	Host    string      // Name of this host, as the collector shows it
+	Token   string      // Token the collector expects
+	TLS     *tls.Config // TLS configuration, nil for plain TCP
+	Buffer  int         // Events kept until acknowledged, DefaultBuffer when zero
+	Roots   []string    // Roots watched on this host, for display
+}
+
+// pending is an event waiting for its acknowledgement
+type pending struct {
+	seq   uint64
+	event Event
+}
+
+// Forwarder sends events to a collector. Events are buffered until the
+// collector acknowledges them, and sent again after a reconnect; once the
+// buffer is full, the oldest events are dropped.
+type Forwarder struct {
+	options ForwarderOptions
+	session string
+
+	mu        sync.Mutex
+	pending   []pending // Unacknowledged events, oldest first
+	seq       uint64    // Sequence number of the last buffered event
+	sent      uint64    // Sequence number of the last event sent on the connection
+	dropped   int       // Events dropped since the last warning
+	connected bool
+
+	wake      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewForwarder creates a forwarder; Run connects it
+func NewForwarder(options ForwarderOptions) *Forwarder {
+	if options.Buffer <= 0 {
+		options.Buffer = DefaultBuffer
+	}
+	return &Forwarder{
+		options: options,
+		session: newSession(),
+		wake:    make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+}
+
+// newSession returns an identifier telling the runs of a forwarder apart,
+// whose sequence numbers all start at one
+func newSession() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// Send buffers an event for the collector
+func (f *Forwarder) Send(event *ui.FileEvent) {
+	f.mu.Lock()
+	f.seq++
+	f.pending = append(f.pending, pending{seq: f.seq, event: newEvent(event)})
+	if overflow := len(f.pending) - f.options.Buffer; overflow > 0 {
+		f.pending = append(f.pending[:0], f.pending[overflow:]...)
+		if f.dropped == 0 {
+			logger.Warn(fmt.Sprintf("Forward buffer full (%d events), dropping the oldest events", f.options.Buffer))
+		}
+		f.dropped += overflow
+		metrics.Dropped("forward_buffer", overflow)
+	}
+	f.mu.Unlock()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns the number of events not acknowledged by the collector
+func (f *Forwarder) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pending)
+}
+
+// Connected reports whether the forwarder is connected to the collector
+func (f *Forwarder) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connected
+}
+
+// Run connects to the collector and sends the events, reconnecting after
+// failures, until Close. Errors returned by the collector, such as a
+// wrong token, end Run.
+func (f *Forwarder) Run() error {
+	retry := minRetry
+	for {
+		c, err := f.connect()
+		if err == nil {
+			retry = minRetry
+			logger.Info("Connected to collector " + f.options.Address)
+			err = f.forward(c)
+			_ = c.Close()
+			f.setConnected(false)
+		}
+		var refused *refusedError
+		if errors.As(err, &refused) {
+			return err
+		}
+		select {
+		case <-f.closed:
+			return nil
+		default:
+		}
+		logger.Warn(fmt.Sprintf("Collector %s unreachable, retrying in %s: %v", f.options.Address, retry, err))
+
+		select {
+		case <-time.After(retry):
+		case <-f.closed:
+			return nil
+		}
+		retry = min(retry*2, maxRetry)
+	}
+}
+
+// refusedError is an error message of the collector
+type refusedError struct {
+	message string
+}
+
+func (e *refusedError) Error() string {
+	return "collector refused the connection: " + e.message
+}
+
+// connect dials the collector and says hello; the events acknowledged in
+// the welcome are dropped from the buffer
+func (f *Forwarder) connect() (*conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var raw net.Conn
+	var err error
+	if f.options.TLS != nil {
+		raw, err = tls.DialWithDialer(dialer, "tcp", f.options.Address, f.options.TLS)
+	} else {
+		raw, err = dialer.Dial("tcp", f.options.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(raw)
+
+	_ = c.SetDeadline(time.Now().Add(handshakeTimeout))
+	hello := &message{
+		Type:    typeHello,
+		Version: protocolVersion,
+		Host:    f.options.Host,
+		Token:   f.options.Token,
+		Session: f.session,
+		Roots:   f.options.Roots,
+	}
+	if err := c.send(hello, true); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	reply, err := c.receive()
+	if err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("no welcome: %w", err)
+	}
+	switch reply.Type {
+	case typeWelcome:
+	case typeError:
+		_ = c.Close()
+		return nil, &refusedError{message: reply.Error}
+	default:
+		_ = c.Close()
+		return nil, fmt.Errorf("unexpected %q message", reply.Type)
+	}
+	_ = c.SetDeadline(time.Time{})
+
+	f.mu.Lock()
+	f.acknowledge(reply.Seq)
+	f.sent = reply.Seq
+	f.connected = true
+	f.mu.Unlock()
+	return c, nil
+}
+
+// setConnected records the state of the connection
+func (f *Forwarder) setConnected(connected bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connected = connected
+}
+
+// acknowledge drops the events up to a sequence number; f.mu is held
+func (f *Forwarder) acknowledge(seq uint64) {
+	i := 0
+	for i < len(f.pending) && f.pending[i].seq <= seq {
+		i++
+	}
+	f.pending = append(f.pending[:0], f.pending[i:]...)
+}
+
+// forward sends the buffered events as they come and reads the
+// acknowledgements, until the connection fails or the forwarder is closed
+func (f *Forwarder) forward(c *conn) error {
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			m, err := c.receive()
+			if err != nil {
+				failed <- err
+				return
+			}
+			switch m.Type {
+			case typeAck:
+				f.mu.Lock()
+				f.acknowledge(m.Seq)
+				f.mu.Unlock()
+			case typeError:
+				failed <- &refusedError{message: m.Error}
+				return
+			}
+		}
+	}()
+
+	for {
+		if err := f.sendPending(c); err != nil {
+			return err
+		}
+		select {
+		case <-f.wake:
+		case err := <-failed:
+			return err
+		case <-f.closed:
+			return nil
+		}
+	}
+}
+
+// sendPending sends the buffered events not sent on the connection yet
+func (f *Forwarder) sendPending(c *conn) error {
+	f.mu.Lock()
+	var batch []pending
+	for _, p := range f.pending {
+		if p.seq > f.sent {
+			batch = append(batch, p)
+		}
+	}
+	if f.dropped > 0 {
+		logger.Warn(fmt.Sprintf("Dropped %d events, the forward buffer was full", f.dropped))
+		f.dropped = 0
+	}
+	f.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	_ = c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	for i, p := range batch {
+		event := p.event
+		if err := c.send(&message{Type: typeEvent, Seq: p.seq, Event: &event}, i == len(batch)-1); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	f.sent = max(f.sent, batch[len(batch)-1].seq)
+	f.mu.Unlock()
+	return nil
+}
+
+// Close stops Run; events still buffered are lost
+func (f *Forwarder) Close() {
+	f.closeOnce.Do(func() { close(f.closed) })
+}
@@ -0,0 +1,156 @@
+// Package collector gathers the events of several hosts. A Forwarder sends
+// the events of a host to a Collector over TCP, optionally with TLS,
+// buffering them while disconnected; the Collector stands in for a local
+// watcher so that the TUI shows every host together.
+//
+// The protocol is one JSON message per line. The forwarder opens with a
+// hello carrying the token; the collector answers welcome, with the last
+// sequence number it received from that forwarder, or error. Then the
+// forwarder sends numbered events and the collector acknowledges them, so
+// that unacknowledged events are sent again after a reconnect.
+package collector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+const (
+	// protocolVersion is the version of the protocol sent in hellos
+	protocolVersion = 1
+	// handshakeTimeout bounds the hello and welcome exchange
+	handshakeTimeout = 10 * time.Second
+	// maxMessage bounds the size of a message line
+	maxMessage = 1 << 20
+)
+
+// Message types
+const (
+	typeHello   = "hello"
+	typeWelcome = "welcome"
+	typeError   = "error"
+	typeEvent   = "event"
+	typeAck     = "ack"
+)
+
+// message is a line of the protocol
+type message struct {
+	Type    string   `json:"type"`
+	Version int      `json:"version,omitempty"` // hello
+	Host    string   `json:"host,omitempty"`    // hello
+	Token   string   `json:"token,omitempty"`   // hello
+	Session string   `json:"session,omitempty"` // hello, unique to a forwarder run
+	Roots   []string `json:"roots,omitempty"`   // hello
+	Seq     uint64   `json:"seq,omitempty"`     // welcome, event and ack
+	Event   *Event   `json:"event,omitempty"`   // event
+	Error   string   `json:"error,omitempty"`   // error
+}
+
+// Event is an event as forwarded
+type Event struct {
+	Path  string        `json:"path"`
+	Op    string        `json:"op"` // e.g. CREATE or CREATE|WRITE
+	IsDir bool          `json:"is_dir"`
+	Count int           `json:"count"`
+	Time  time.Time     `json:"time"`
+	Attrs *attrs.Change `json:"attrs,omitempty"` // Mode and owner before and after a CHMOD event
+}
+
+// newEvent converts a recorded event
+func newEvent(event *ui.FileEvent) Event {
+	return Event{
+		Path:  event.Path,
+		Op:    event.Operation.String(),
+		IsDir: event.IsDir,
+		Count: max(event.Count, 1),
+		Time:  event.Timestamp,
+		Attrs: event.Attrs,
+	}
+}
+
+// FileEvent converts the event back, recording the host it comes from
+func (e Event) FileEvent(host string) *ui.FileEvent {
+	operation, err := console.ParseOperations(strings.ReplaceAll(e.Op, "|", ","))
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Unknown operation %q from %s", e.Op, host))
+		operation = fsnotify.Op(0)
+	}
+	return &ui.FileEvent{
+		Path:      e.Path,
+		Operation: operation,
+		Timestamp: e.Time,
+		IsDir:     e.IsDir,
+		Count:     max(e.Count, 1),
+		Host:      host,
+		Attrs:     e.Attrs,
+	}
+}
+
+// conn reads and writes the messages of a connection
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// newConn wraps a network connection
+func newConn(c net.Conn) *conn {
+	return &conn{
+		Conn:   c,
+		reader: bufio.NewReader(c),
+		writer: bufio.NewWriter(c),
+	}
+}
+
+// send writes a message; flush sends it and the previous ones
+func (c *conn) send(m *message, flush bool) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := c.writer.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if flush {
+		return c.writer.Flush()
+	}
+	return nil
+}
+
+// receive reads the next message
+func (c *conn) receive() (*message, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := c.reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxMessage {
+			return nil, fmt.Errorf("message longer than %d bytes", maxMessage)
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	var m message
+	if err := json.Unmarshal(line, &m); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return &m, nil
+}
+
+// idle reports whether every received byte was read
+func (c *conn) idle() bool {
+	return c.reader.Buffered() == 0
+}
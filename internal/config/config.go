@@ -0,0 +1,448 @@
+// Package config reads the YAML configuration file and its named profiles.
+//
+// Settings are merged in this order, later ones winning: the top level of
+// the file, the selected profile, the environment, then command-line flags.
+// A list such as roots or ignore replaces the earlier one as a whole.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables read by FromEnv
+const (
+	EnvConfig    = "WATCH_FS_CONFIG"     // Configuration file
+	EnvProfile   = "WATCH_FS_PROFILE"    // Profile name
+	EnvPaths     = "WATCH_FS_PATHS"      // Roots, separated like PATH
+	EnvIgnore    = "WATCH_FS_IGNORE"     // Comma-separated ignore patterns
+	EnvSort      = "WATCH_FS_SORT"       // Initial sort: time, path, operation, count or host
+	EnvMaxEvents = "WATCH_FS_MAX_EVENTS" // Events kept in memory
+)
+
+// Filter is the initial event filter
+type Filter struct {
+	Path  string `yaml:"path"`  // Case-insensitive substring of the path
+	Op    string `yaml:"op"`    // Exact operation, e.g. write or create|write
+	Dirs  *bool  `yaml:"dirs"`  // Show directories, true when unset
+	Files *bool  `yaml:"files"` // Show files, true when unset
+	Risk  string `yaml:"risk"`  // Risky mode and owner changes only: any, or text of the risk
+}
+
+// Retention bounds the events kept in memory
+type Retention struct {
+	MaxEvents int           `yaml:"max_events"`
+	MaxAge    time.Duration `yaml:"max_age"` // Events last seen earlier are dropped
+}
+
+// Journal appends every event to a file, in a console output format
+type Journal struct {
+	Path     string `yaml:"path"`
+	Format   string `yaml:"format"`   // text, ndjson or template; ndjson when empty
+	Template string `yaml:"template"` // Go text/template with the template format
+}
+
+// Action runs a command when matching events happen, like `watch-fs exec`
+type Action struct {
+	Name     string        `yaml:"name"`
+	Match    string        `yaml:"match"` // Glob over root-relative paths, any path when empty
+	Op       string        `yaml:"op"`    // Comma-separated operations, everything but chmod when empty
+	Command  []string      `yaml:"command"`
+	Debounce time.Duration `yaml:"debounce"`
+	OnBusy   string        `yaml:"on_busy"` // restart, queue or ignore; restart when empty
+}
+
+// Rule runs actions on the events matching every condition it sets
+type Rule struct {
+	Name    string       `yaml:"name"`
+	Root    string       `yaml:"root"`     // Events under this root only
+	Match   string       `yaml:"match"`    // Glob over root-relative paths
+	Regex   string       `yaml:"regex"`    // Regular expression over full paths
+	Op      string       `yaml:"op"`       // Comma-separated operations, any when empty
+	Type    string       `yaml:"type"`     // file or dir, both when empty
+	MinSize Size         `yaml:"min_size"` // Files of at least this size, e.g. 10MB
+	MaxSize Size         `yaml:"max_size"` // Files of at most this size
+	Rate    *Rate        `yaml:"rate"`     // Fire only once events come this fast
+	Actions []RuleAction `yaml:"actions"`
+}
+
+// Rate makes a rule fire on the count-th matching event within window,
+// after which counting starts again
+type Rate struct {
+	Count  int           `yaml:"count"`
+	Window time.Duration `yaml:"window"`
+}
+
+// RuleAction is one thing a rule does; exactly one field is set, besides
+// the timeout of a command
+type RuleAction struct {
+	Command   []string      `yaml:"command"`   // Program and arguments, Go text/templates over the event
+	Timeout   time.Duration `yaml:"timeout"`   // Command timeout, one minute when zero
+	Webhook   *Webhook      `yaml:"webhook"`   // POST the event as JSON
+	Append    *Append       `yaml:"append"`    // Append the event to a file
+	Highlight string        `yaml:"highlight"` // Color of the event in the TUI
+}
+
+// Webhook posts events as JSON, retrying failed deliveries
+type Webhook struct {
+	URL     string        `yaml:"url"`
+	Secret  string        `yaml:"secret"`  // Signs the body with HMAC-SHA256 when set
+	Retries *int          `yaml:"retries"` // Attempts after the first one, 3 when unset
+	Backoff time.Duration `yaml:"backoff"` // First delay between attempts, doubled each time; 1s when zero
+	Timeout time.Duration `yaml:"timeout"` // Per attempt, 10s when zero
+}
+
+// Append writes a line per event to a file
+type Append struct {
+	Path     string `yaml:"path"`
+	Template string `yaml:"template"` // Go text/template over the event, JSON when empty
+}
+
+// Alert fires while a rate condition holds on the events under a path,
+// and resolves once it no longer does. Exactly one of Above, Silence and
+// Spike is set.
+type Alert struct {
+	Name    string        `yaml:"name"`
+	Path    string        `yaml:"path"`    // File, or directory and everything below; every event when empty
+	Match   string        `yaml:"match"`   // Glob over root-relative paths
+	Op      string        `yaml:"op"`      // Comma-separated operations, any when empty
+	Above   *Rate         `yaml:"above"`   // More than count events within window
+	Silence time.Duration `yaml:"silence"` // No event for this long, like a missed heartbeat
+	Spike   *Spike        `yaml:"spike"`   // Rate well above its learned baseline
+	Actions []RuleAction  `yaml:"actions"` // Run when the alert fires and when it resolves
+}
+
+// Spike compares the events within a window to a baseline learned as a
+// moving average of the same count
+type Spike struct {
+	Factor float64       `yaml:"factor"` // Times the baseline, 3 when zero
+	Window time.Duration `yaml:"window"` // One minute when zero
+	Learn  time.Duration `yaml:"learn"`  // Baseline period, one hour when zero; no alert before it passed
+	Min    int           `yaml:"min"`    // Fewest events within the window to fire, 10 when zero
+}
+
+// Ransomware detects mass encryption or deletion. Thresholds count events
+// within Window; 0 takes the value of the sensitivity and -1 turns the
+// signal off.
+type Ransomware struct {
+	Sensitivity  string        `yaml:"sensitivity"`   // low, medium or high; medium when empty
+	Window       time.Duration `yaml:"window"`        // 10s when zero
+	Renames      int           `yaml:"renames"`       // Renames to unfamiliar extensions
+	Writes       int           `yaml:"writes"`        // Distinct files written
+	Removes      int           `yaml:"removes"`       // Files and directories removed
+	Entropy      bool          `yaml:"entropy"`       // Sample rewritten files for an entropy jump
+	EntropyFiles int           `yaml:"entropy_files"` // Rewritten files whose entropy jumped
+	Cooldown     time.Duration `yaml:"cooldown"`      // No new detection for this long, 5m when zero
+	Containment  []string      `yaml:"containment"`   // Command run on detection, with templated arguments
+}
+
+// Integrity flags the events breaking a baseline written by
+// `watch-fs baseline`
+type Integrity struct {
+	Baseline string `yaml:"baseline"` // Baseline file
+	Key      string `yaml:"key"`      // Signing key, next to the configuration file when empty
+}
+
+// Size is a number of bytes, written as 512, 64k or 10MB; k, m and g are
+// powers of 1024
+type Size int64
+
+// UnmarshalYAML parses a size
+func (s *Size) UnmarshalYAML(node *yaml.Node) error {
+	size, err := ParseSize(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*s = size
+	return nil
+}
+
+// ParseSize parses a size such as 512, 64k, 10MB or 1.5GiB
+func ParseSize(value string) (Size, error) {
+	text := strings.ToLower(strings.TrimSpace(value))
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "b"), "i")
+	multiplier := 1.0
+	if text != "" {
+		switch text[len(text)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			text = text[:len(text)-1]
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return Size(number * multiplier), nil
+}
+
+// Settings are the options the top level of the file and each profile can
+// set; unset fields keep the earlier value
+type Settings struct {
+	Roots          []string    `yaml:"roots"`
+	Ignore         []string    `yaml:"ignore"`
+	DefaultIgnores *bool       `yaml:"default_ignores"`
+	Filter         *Filter     `yaml:"filter"`
+	Sort           string      `yaml:"sort"`
+	Aggregate      *bool       `yaml:"aggregate"`
+	Retention      *Retention  `yaml:"retention"`
+	Journal        *Journal    `yaml:"journal"`
+	Actions        []Action    `yaml:"actions"`
+	Rules          []Rule      `yaml:"rules"`
+	Alerts         []Alert     `yaml:"alerts"`
+	Ransomware     *Ransomware `yaml:"ransomware"`
+	Integrity      *Integrity  `yaml:"integrity"`
+}
+
+// File is a configuration file
+type File struct {
+	Settings `yaml:",inline"`
+	Profile  string              `yaml:"profile"` // Profile used when none is selected
+	Profiles map[string]Settings `yaml:"profiles"`
+
+	Path string `yaml:"-"` // Where the file was read from, empty without a file
+}
+
+// DefaultPath returns $XDG_CONFIG_HOME/watch-fs/config.yml, falling back
+// to ~/.config when XDG_CONFIG_HOME is unset
+func DefaultPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "watch-fs", "config.yml")
+}
+
+// Load reads a configuration file. Without a path, the one named by
+// WATCH_FS_CONFIG or the default one is read if it exists, and an empty
+// configuration is returned otherwise.
+func Load(path string) (*File, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv(EnvConfig)
+		explicit = path != ""
+	}
+	if !explicit {
+		path = DefaultPath()
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return &File{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return Parse(data, path)
+}
+
+// Parse decodes a configuration read from path
+func Parse(data []byte, path string) (*File, error) {
+	file := &File{Path: path}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// An empty file is a valid, empty configuration
+	if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	if file.Profile != "" {
+		if _, ok := file.Profiles[file.Profile]; !ok {
+			return nil, fmt.Errorf("invalid config %s: default profile %q is not defined", path, file.Profile)
+		}
+	}
+	return file, nil
+}
+
+// ProfileNames returns the names of the profiles, sorted
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve merges the top level of the file with a profile, the default one
+// when name is empty
+func (f *File) Resolve(name string) (Settings, error) {
+	if name == "" {
+		name = f.Profile
+	}
+	if name == "" {
+		return f.Settings, nil
+	}
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return Settings{}, fmt.Errorf("unknown profile %q", name)
+	}
+	return f.Settings.Merge(profile), nil
+}
+
+// Merge returns s with the fields set in over replacing its own; the
+// retention limits are merged one by one
+func (s Settings) Merge(over Settings) Settings {
+	if over.Roots != nil {
+		s.Roots = over.Roots
+	}
+	if over.Ignore != nil {
+		s.Ignore = over.Ignore
+	}
+	if over.DefaultIgnores != nil {
+		s.DefaultIgnores = over.DefaultIgnores
+	}
+	if over.Filter != nil {
+		s.Filter = over.Filter
+	}
+	if over.Sort != "" {
+		s.Sort = over.Sort
+	}
+	if over.Aggregate != nil {
+		s.Aggregate = over.Aggregate
+	}
+	if over.Retention != nil {
+		// Each limit is kept unless over sets it, e.g. the max_age of the
+		// file with WATCH_FS_MAX_EVENTS
+		retention := Retention{}
+		if s.Retention != nil {
+			retention = *s.Retention
+		}
+		if over.Retention.MaxEvents != 0 {
+			retention.MaxEvents = over.Retention.MaxEvents
+		}
+		if over.Retention.MaxAge != 0 {
+			retention.MaxAge = over.Retention.MaxAge
+		}
+		s.Retention = &retention
+	}
+	if over.Journal != nil {
+		s.Journal = over.Journal
+	}
+	if over.Actions != nil {
+		s.Actions = over.Actions
+	}
+	if over.Rules != nil {
+		s.Rules = over.Rules
+	}
+	if over.Alerts != nil {
+		s.Alerts = over.Alerts
+	}
+	if over.Ransomware != nil {
+		s.Ransomware = over.Ransomware
+	}
+	if over.Integrity != nil {
+		s.Integrity = over.Integrity
+	}
+	return s
+}
+
+// FromEnv returns the settings given by environment variables
+func FromEnv(getenv func(string) string) (Settings, error) {
+	var s Settings
+	if paths := getenv(EnvPaths); paths != "" {
+		s.Roots = filepath.SplitList(paths)
+	}
+	if ignore := getenv(EnvIgnore); ignore != "" {
+		s.Ignore = strings.Split(ignore, ",")
+	}
+	s.Sort = getenv(EnvSort)
+	if value := getenv(EnvMaxEvents); value != "" {
+		maxEvents, err := strconv.Atoi(value)
+		if err != nil || maxEvents <= 0 {
+			return Settings{}, fmt.Errorf("invalid %s %q", EnvMaxEvents, value)
+		}
+		s.Retention = &Retention{MaxEvents: maxEvents}
+	}
+	return s, nil
+}
+
+// IgnoreRules compiles the ignore patterns; defaultIgnores applies when the
+// settings do not choose
+func (s Settings) IgnoreRules(defaultIgnores bool) (*utils.IgnoreRules, error) {
+	if s.DefaultIgnores != nil {
+		defaultIgnores = *s.DefaultIgnores
+	}
+	return utils.NewIgnoreRules(s.Ignore, defaultIgnores)
+}
+
+// View returns the TUI view defaults of the settings
+func (s Settings) View() (ui.Settings, error) {
+	var view ui.Settings
+	if s.Filter != nil {
+		filter := ui.Filter{PathFilter: s.Filter.Path, ShowDirs: true, ShowFiles: true, RiskFilter: s.Filter.Risk}
+		if s.Filter.Op != "" {
+			op, err := console.ParseOperations(strings.ReplaceAll(s.Filter.Op, "|", ","))
+			if err != nil {
+				return ui.Settings{}, err
+			}
+			filter.OperationFilter = op
+		}
+		if s.Filter.Dirs != nil {
+			filter.ShowDirs = *s.Filter.Dirs
+		}
+		if s.Filter.Files != nil {
+			filter.ShowFiles = *s.Filter.Files
+		}
+		view.Filter = &filter
+	}
+	if s.Sort != "" {
+		option, ok := ui.ParseSortOption(s.Sort)
+		if !ok {
+			return ui.Settings{}, fmt.Errorf("unknown sort %q (want time, path, operation, count or host)", s.Sort)
+		}
+		view.SortOption = &option
+	}
+	view.AggregateEvents = s.Aggregate
+	if s.Retention != nil {
+		view.MaxEvents = s.Retention.MaxEvents
+		view.MaxAge = s.Retention.MaxAge
+	}
+	return view, nil
+}
+
+// UIProfiles returns the profiles of the file for switching in the TUI;
+// defaultIgnores applies to profiles that do not choose
+func (f *File) UIProfiles(defaultIgnores bool) ([]ui.Profile, error) {
+	var profiles []ui.Profile
+	for _, name := range f.ProfileNames() {
+		settings, err := f.Resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		view, err := settings.View()
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		ignore, err := settings.IgnoreRules(defaultIgnores)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		profiles = append(profiles, ui.Profile{Name: name, Roots: settings.Roots, Ignore: ignore, Settings: view})
+	}
+	return profiles, nil
+}
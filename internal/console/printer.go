@@ -0,0 +1,190 @@
+// Package console writes file system events for the non-interactive mode,
+// as colored text, JSON or a user-supplied template
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// Format selects how events are written
+type Format int
+
+const (
+	FormatText     Format = iota // Colored, human-readable lines
+	FormatJSON                   // A single JSON array
+	FormatNDJSON                 // One JSON object per line
+	FormatTemplate               // A text/template per event
+)
+
+// ParseFormat parses the name of an output format
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text", "":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	case "ndjson", "jsonl":
+		return FormatNDJSON, nil
+	case "template":
+		return FormatTemplate, nil
+	default:
+		return FormatText, fmt.Errorf("unknown output format %q (want text, json, ndjson or template)", name)
+	}
+}
+
+// Record is an event as seen by JSON output and templates
+type Record struct {
+	Path         string        `json:"path"`
+	RelativePath string        `json:"relative_path"` // Slash-separated, relative to Root
+	Root         string        `json:"root"`
+	Op           string        `json:"op"`
+	IsDir        bool          `json:"is_dir"`
+	Time         time.Time     `json:"time"`
+	Violation    string        `json:"violation,omitempty"` // How the path breaks the integrity baseline
+	Attrs        *attrs.Change `json:"attrs,omitempty"`     // Mode and owner before and after a CHMOD event
+}
+
+// Options configures a Printer
+type Options struct {
+	Format     Format
+	Template   string      // text/template used with FormatTemplate
+	Roots      []string    // Watched roots, for relative paths
+	Filter     ui.Filter   // Same filter as the TUI
+	Operations fsnotify.Op // When set, only events with one of these operations are written
+}
+
+// Printer writes events to a writer in the chosen format
+type Printer struct {
+	w       io.Writer
+	options Options
+	tmpl    *template.Template
+	count   int // Number of events written
+}
+
+// NewPrinter creates a printer; the template is parsed up front so that
+// errors are reported before any event is written
+func NewPrinter(w io.Writer, options Options) (*Printer, error) {
+	p := &Printer{w: w, options: options}
+	if options.Format == FormatTemplate {
+		if options.Template == "" {
+			return nil, fmt.Errorf("the template format needs a template")
+		}
+		text := options.Template
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		tmpl, err := template.New("event").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		p.tmpl = tmpl
+	}
+	return p, nil
+}
+
+// Print writes an event if it passes the filters
+func (p *Printer) Print(event *ui.FileEvent) error {
+	if !p.options.Filter.Matches(event) {
+		return nil
+	}
+	if p.options.Operations != 0 && event.Operation&p.options.Operations == 0 {
+		return nil
+	}
+
+	record := p.record(event)
+	var err error
+	switch p.options.Format {
+	case FormatJSON:
+		separator := ",\n"
+		if p.count == 0 {
+			separator = "[\n"
+		}
+		var data []byte
+		if data, err = json.Marshal(record); err == nil {
+			_, err = fmt.Fprintf(p.w, "%s%s", separator, data)
+		}
+	case FormatNDJSON:
+		err = json.NewEncoder(p.w).Encode(record)
+	case FormatTemplate:
+		err = p.tmpl.Execute(p.w, record)
+	default:
+		typeIndicator := "F"
+		if event.IsDir {
+			typeIndicator = "D"
+		}
+		violation := ""
+		if event.Violation != "" {
+			violation = color.New(color.FgRed, color.Bold).Sprintf(" [baseline %s]", event.Violation)
+		}
+		changed := ""
+		if event.Attrs != nil {
+			changed = " " + event.Attrs.String()
+			if len(event.Attrs.Risks) > 0 {
+				changed += color.New(color.FgRed, color.Bold).Sprintf(" [%s]", strings.Join(event.Attrs.Risks, ", "))
+			}
+		}
+		_, err = fmt.Fprintf(p.w, "[%s] %s %s %s%s%s\n",
+			event.Timestamp.Format("15:04:05.000"), ui.OperationLabel(event.Operation), typeIndicator, event.Path, changed, violation)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	p.count++
+	return nil
+}
+
+// Close terminates the output; it closes the JSON array
+func (p *Printer) Close() error {
+	if p.options.Format != FormatJSON {
+		return nil
+	}
+	closing := "\n]\n"
+	if p.count == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(p.w, closing)
+	return err
+}
+
+// record builds the JSON and template view of an event
+func (p *Printer) record(event *ui.FileEvent) Record {
+	return Record{
+		Path:         event.Path,
+		RelativePath: utils.RelativePath(event.Path, p.options.Roots),
+		Root:         utils.RootOf(event.Path, p.options.Roots),
+		Op:           event.Operation.String(),
+		IsDir:        event.IsDir,
+		Time:         event.Timestamp,
+		Violation:    event.Violation,
+		Attrs:        event.Attrs,
+	}
+}
+
+// ParseOperations parses a comma-separated list of operation names such as
+// "create,write"
+func ParseOperations(value string) (fsnotify.Op, error) {
+	var operations fsnotify.Op
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		op, ok := ui.ParseOperationName(name)
+		if !ok {
+			return 0, fmt.Errorf("unknown operation %q (want create, write, remove, rename or chmod)", name)
+		}
+		operations |= op
+	}
+	return operations, nil
+}
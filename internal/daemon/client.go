@@ -0,0 +1,259 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/server"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// pollWait is how long each poll of a client waits for events
+const pollWait = 30 * time.Second
+
+// Client is connected to a daemon. Once attached, it provides the watcher
+// methods the TUI uses, so that the TUI shows the daemon's events and
+// manages its roots.
+type Client struct {
+	rpc *rpc.Client
+
+	mu    sync.Mutex
+	roots []server.Root // Roots of the last successful request
+
+	history      []*ui.FileEvent
+	events       chan fsnotify.Event
+	errors       chan error
+	disconnected chan struct{}
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// Dial connects to the daemon listening on a socket
+func Dial(socket string) (*Client, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("no daemon is running on %s: %w", socket, err)
+	}
+	c := &Client{
+		rpc:          jsonrpc.NewClient(conn),
+		events:       make(chan fsnotify.Event, 1024),
+		errors:       make(chan error, 16),
+		disconnected: make(chan struct{}),
+		closed:       make(chan struct{}),
+	}
+	if _, err := c.fetchRoots(); err != nil {
+		_ = c.rpc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Attach reads the events stored by the daemon, then relays the following
+// ones on Events
+func (c *Client) Attach() error {
+	var history History
+	if err := c.rpc.Call(ServiceName+".History", &Empty{}, &history); err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	c.history = make([]*ui.FileEvent, len(history.Events))
+	for i, event := range history.Events {
+		c.history[i] = event.FileEvent()
+	}
+	go c.relay(history.Next)
+	return nil
+}
+
+// relay polls the events following a sequence number until the client is
+// closed or the daemon goes away
+func (c *Client) relay(next uint64) {
+	defer close(c.disconnected)
+	defer close(c.events)
+	for {
+		var reply PollReply
+		args := &PollArgs{After: next, WaitMs: int(pollWait / time.Millisecond)}
+		if err := c.rpc.Call(ServiceName+".Poll", args, &reply); err != nil {
+			select {
+			case <-c.closed:
+			default:
+				c.reportError(fmt.Errorf("lost the daemon: %w", err))
+			}
+			return
+		}
+		if reply.Missed > 0 {
+			c.reportError(fmt.Errorf("missed %d events", reply.Missed))
+		}
+		for _, event := range reply.Events {
+			select {
+			case c.events <- fsnotify.Event{Name: event.Path, Op: parseOp(event.Op)}:
+			case <-c.closed:
+				return
+			}
+		}
+		next = reply.Next
+	}
+}
+
+// reportError sends an error on Errors, dropping it when nobody reads them
+func (c *Client) reportError(err error) {
+	select {
+	case c.errors <- err:
+	default:
+	}
+}
+
+// History returns the events the daemon had stored when the client
+// attached, oldest first
+func (c *Client) History() []*ui.FileEvent {
+	return c.history
+}
+
+// Events returns the events received by the daemon since the client
+// attached; it closes when the client is detached
+func (c *Client) Events() <-chan fsnotify.Event {
+	return c.events
+}
+
+// Errors returns the errors of the connection to the daemon
+func (c *Client) Errors() <-chan error {
+	return c.errors
+}
+
+// Disconnected is closed when an attached client stops receiving events
+func (c *Client) Disconnected() <-chan struct{} {
+	return c.disconnected
+}
+
+// fetchRoots reads the roots of the daemon
+func (c *Client) fetchRoots() ([]server.Root, error) {
+	var roots []server.Root
+	if err := c.rpc.Call(ServiceName+".Roots", &Empty{}, &roots); err != nil {
+		return nil, fmt.Errorf("failed to list roots: %w", err)
+	}
+	c.setRoots(roots)
+	return roots, nil
+}
+
+// setRoots remembers the roots of the last request
+func (c *Client) setRoots(roots []server.Root) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roots = roots
+}
+
+// cachedRoots returns the roots of the last request, without asking the
+// daemon
+func (c *Client) cachedRoots() []server.Root {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.roots
+}
+
+// Roots returns the roots of the daemon and the number of directories
+// watched under each
+func (c *Client) Roots() []server.Root {
+	roots, err := c.fetchRoots()
+	if err != nil {
+		return c.cachedRoots()
+	}
+	return roots
+}
+
+// GetRoots returns the watched roots
+func (c *Client) GetRoots() []string {
+	roots := c.Roots()
+	paths := make([]string, len(roots))
+	for i, root := range roots {
+		paths[i] = root.Path
+	}
+	return paths
+}
+
+// GetRoot returns the first root
+func (c *Client) GetRoot() string {
+	if roots := c.GetRoots(); len(roots) > 0 {
+		return roots[0]
+	}
+	return ""
+}
+
+// AddRoot makes the daemon watch a directory
+func (c *Client) AddRoot(root string) error {
+	var roots []server.Root
+	if err := c.rpc.Call(ServiceName+".AddRoot", &PathArgs{Path: root}, &roots); err != nil {
+		return err
+	}
+	c.setRoots(roots)
+	return nil
+}
+
+// RemoveRoot makes the daemon stop watching a root
+func (c *Client) RemoveRoot(root string) error {
+	var roots []server.Root
+	if err := c.rpc.Call(ServiceName+".RemoveRoot", &PathArgs{Path: root}, &roots); err != nil {
+		return err
+	}
+	c.setRoots(roots)
+	return nil
+}
+
+// AddDirectory makes the daemon watch a directory under a root
+func (c *Client) AddDirectory(path string) error {
+	return c.rpc.Call(ServiceName+".AddDirectory", &PathArgs{Path: path}, &Empty{})
+}
+
+// IsWatching reports whether the daemon watches a directory
+func (c *Client) IsWatching(path string) bool {
+	var watching bool
+	if err := c.rpc.Call(ServiceName+".IsWatching", &PathArgs{Path: path}, &watching); err != nil {
+		return false
+	}
+	return watching
+}
+
+// GetWatchedCount returns the number of directories the daemon watches
+func (c *Client) GetWatchedCount() int {
+	total := 0
+	for _, root := range c.cachedRoots() {
+		total += root.Watched
+	}
+	return total
+}
+
+// GetWatchedCountForRoot returns the number of directories watched under
+// a root
+func (c *Client) GetWatchedCountForRoot(path string) int {
+	for _, root := range c.cachedRoots() {
+		if root.Path == path {
+			return root.Watched
+		}
+	}
+	return 0
+}
+
+// Status describes the daemon
+func (c *Client) Status() (Status, error) {
+	var status Status
+	err := c.rpc.Call(ServiceName+".Status", &Empty{}, &status)
+	return status, err
+}
+
+// Snapshot makes the daemon write every stored event to a capture
+func (c *Client) Snapshot(filename string) error {
+	return c.rpc.Call(ServiceName+".Snapshot", &SnapshotArgs{Filename: filename}, &Empty{})
+}
+
+// Stop stops the daemon
+func (c *Client) Stop() error {
+	return c.rpc.Call(ServiceName+".Stop", &Empty{}, &Empty{})
+}
+
+// Close detaches from the daemon, which keeps running
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.rpc.Close()
+}
@@ -0,0 +1,242 @@
+// Package daemon keeps watching in the background and exposes a JSON-RPC
+// control API on a Unix socket. Client attaches to a daemon and stands in
+// for a local watcher, so that the TUI renders the daemon's events.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/metrics"
+	"github.com/pbouamriou/watch-fs/internal/server"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+const (
+	// ServiceName is the prefix of the JSON-RPC methods, e.g. Daemon.Status
+	ServiceName = "Daemon"
+	// recentEvents is the number of received events kept for the clients
+	// polling them; slower clients miss events
+	recentEvents = 4096
+	// maxPollWait bounds how long Poll waits for events
+	maxPollWait = time.Minute
+)
+
+// DefaultSocket returns the socket of the daemon of the current user:
+// watch-fs.sock in $XDG_RUNTIME_DIR, or in the temporary directory
+func DefaultSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "watch-fs.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("watch-fs-%d.sock", os.Getuid()))
+}
+
+// Event is an event as sent to clients
+type Event struct {
+	Path  string        `json:"path"`
+	Op    string        `json:"op"` // e.g. CREATE or CREATE|WRITE
+	IsDir bool          `json:"is_dir"`
+	Count int           `json:"count"`
+	Time  time.Time     `json:"time"`
+	Attrs *attrs.Change `json:"attrs,omitempty"` // Mode and owner before and after a CHMOD event
+}
+
+// newEvent converts a stored event
+func newEvent(event *ui.FileEvent) Event {
+	return Event{
+		Path:  event.Path,
+		Op:    event.Operation.String(),
+		IsDir: event.IsDir,
+		Count: max(event.Count, 1),
+		Time:  event.Timestamp,
+		Attrs: event.Attrs,
+	}
+}
+
+// FileEvent converts the event back
+func (e Event) FileEvent() *ui.FileEvent {
+	return &ui.FileEvent{
+		Path:      e.Path,
+		Operation: parseOp(e.Op),
+		Timestamp: e.Time,
+		IsDir:     e.IsDir,
+		Count:     e.Count,
+		Attrs:     e.Attrs,
+	}
+}
+
+// parseOp parses an operation as written by fsnotify.Op.String
+func parseOp(op string) fsnotify.Op {
+	operation, err := console.ParseOperations(strings.ReplaceAll(op, "|", ","))
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Unknown operation %q", op))
+	}
+	return operation
+}
+
+// Daemon serves the events stored by a server to the clients of a socket
+type Daemon struct {
+	server  *server.Server
+	watcher *watcher.Watcher
+	started time.Time
+
+	mu      sync.Mutex
+	recent  []Event       // The latest received events
+	next    uint64        // Sequence number of the next received event
+	arrived chan struct{} // Closed, then replaced, when events arrive
+	conns   map[net.Conn]bool
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a daemon over a server and its watcher. It registers an
+// event listener, so it must be created before the server's Watch runs.
+func New(srv *server.Server, fileWatcher *watcher.Watcher) *Daemon {
+	d := &Daemon{
+		server:  srv,
+		watcher: fileWatcher,
+		started: time.Now(),
+		arrived: make(chan struct{}),
+		conns:   make(map[net.Conn]bool),
+		stopped: make(chan struct{}),
+	}
+	srv.OnEvent(d.record)
+	return d
+}
+
+// record keeps a received event for the polling clients
+func (d *Daemon) record(event *ui.FileEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recent = append(d.recent, newEvent(event))
+	if len(d.recent) > recentEvents {
+		d.recent = d.recent[len(d.recent)-recentEvents:]
+	}
+	d.next++
+	close(d.arrived)
+	d.arrived = make(chan struct{})
+}
+
+// Listen creates the socket, replacing the socket of a daemon that is no
+// longer running. Only the current user can connect.
+func Listen(socket string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("a daemon is already running on %s", socket)
+	}
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	// The socket is created with its final mode, so that no other user can
+	// connect between its creation and a chmod
+	restore := restrictUmask()
+	listener, err := net.Listen("unix", socket)
+	restore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	return listener, nil
+}
+
+// Serve answers the JSON-RPC requests of the clients of a listener until
+// the daemon stops, then closes the listener and the connections
+func (d *Daemon) Serve(listener net.Listener) error {
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName(ServiceName, &Service{daemon: d}); err != nil {
+		return fmt.Errorf("failed to register service: %w", err)
+	}
+	go func() {
+		<-d.stopped
+		_ = listener.Close()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for conn := range d.conns {
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-d.stopped:
+				return nil
+			default:
+				return fmt.Errorf("failed to accept client: %w", err)
+			}
+		}
+		d.mu.Lock()
+		d.conns[conn] = true
+		d.mu.Unlock()
+		go func() {
+			rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+			d.mu.Lock()
+			delete(d.conns, conn)
+			d.mu.Unlock()
+		}()
+	}
+}
+
+// Stop makes Serve return and ends the pending polls
+func (d *Daemon) Stop() {
+	d.stopOnce.Do(func() { close(d.stopped) })
+}
+
+// Stopped is closed when the daemon stops, e.g. on a Stop request
+func (d *Daemon) Stopped() <-chan struct{} {
+	return d.stopped
+}
+
+// clients returns the number of connected clients
+func (d *Daemon) clients() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+// poll returns the events received after the after sequence number,
+// waiting up to wait for some, and the number of events missed because
+// they are no longer kept
+func (d *Daemon) poll(after uint64, wait time.Duration) ([]Event, uint64, int) {
+	timer := time.NewTimer(min(wait, maxPollWait))
+	defer timer.Stop()
+	for {
+		d.mu.Lock()
+		next, arrived := d.next, d.arrived
+		if after < next {
+			first := next - uint64(len(d.recent))
+			missed := 0
+			if after < first {
+				missed = int(first - after)
+				after = first
+				metrics.Dropped("daemon_client", missed)
+			}
+			events := append([]Event(nil), d.recent[after-first:]...)
+			d.mu.Unlock()
+			return events, next, missed
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-timer.C:
+			return nil, next, 0
+		case <-d.stopped:
+			return nil, next, 0
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/server"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// Service holds the JSON-RPC methods of a daemon. Each request is a JSON
+// object {"method": "Daemon.<Name>", "params": [args], "id": n}.
+type Service struct {
+	daemon *Daemon
+}
+
+// Empty is the argument or reply of the methods that take or return none
+type Empty struct{}
+
+// PathArgs are the arguments of the methods acting on a directory
+type PathArgs struct {
+	Path string `json:"path"`
+}
+
+// Status describes a running daemon
+type Status struct {
+	Pid     int           `json:"pid"`
+	Started time.Time     `json:"started"`
+	Roots   []server.Root `json:"roots"`
+	Events  int           `json:"events"`  // Stored events
+	Clients int           `json:"clients"` // Connected clients, the caller included
+}
+
+// History is the reply of History
+type History struct {
+	Events []Event `json:"events"` // Stored events, oldest first
+	Next   uint64  `json:"next"`   // Sequence number to poll from
+}
+
+// PollArgs are the arguments of Poll
+type PollArgs struct {
+	After  uint64 `json:"after"`   // Sequence number of the first wanted event
+	WaitMs int    `json:"wait_ms"` // How long to wait for events, at most a minute
+}
+
+// PollReply is the reply of Poll
+type PollReply struct {
+	Events []Event `json:"events"`
+	Next   uint64  `json:"next"`   // Sequence number of the next poll
+	Missed int     `json:"missed"` // Events received since After but no longer kept
+}
+
+// SnapshotArgs are the arguments of Snapshot
+type SnapshotArgs struct {
+	Filename string `json:"filename"` // A .db or .json capture, optionally compressed
+}
+
+// Status describes the daemon
+func (s *Service) Status(_ *Empty, reply *Status) error {
+	*reply = Status{
+		Pid:     os.Getpid(),
+		Started: s.daemon.started,
+		Roots:   s.daemon.server.Roots(),
+		Events:  len(s.daemon.server.History()),
+		Clients: s.daemon.clients(),
+	}
+	return nil
+}
+
+// Roots lists the watched roots
+func (s *Service) Roots(_ *Empty, reply *[]server.Root) error {
+	*reply = s.daemon.server.Roots()
+	return nil
+}
+
+// AddRoot starts watching a directory and lists the roots
+func (s *Service) AddRoot(args *PathArgs, reply *[]server.Root) error {
+	if err := s.daemon.server.AddRoot(args.Path); err != nil {
+		return err
+	}
+	*reply = s.daemon.server.Roots()
+	return nil
+}
+
+// RemoveRoot stops watching a root and lists the roots
+func (s *Service) RemoveRoot(args *PathArgs, reply *[]server.Root) error {
+	if err := s.daemon.server.RemoveRoot(args.Path); err != nil {
+		return err
+	}
+	*reply = s.daemon.server.Roots()
+	return nil
+}
+
+// AddDirectory watches a directory under a root
+func (s *Service) AddDirectory(args *PathArgs, _ *Empty) error {
+	return s.daemon.watcher.AddDirectory(args.Path)
+}
+
+// IsWatching reports whether a directory is watched
+func (s *Service) IsWatching(args *PathArgs, reply *bool) error {
+	*reply = s.daemon.watcher.IsWatching(args.Path)
+	return nil
+}
+
+// History returns the stored events and the sequence number to poll the
+// following ones from
+func (s *Service) History(_ *Empty, reply *History) error {
+	// Read the sequence number first: events received in between are both
+	// stored and polled, which is better than missing them
+	s.daemon.mu.Lock()
+	next := s.daemon.next
+	s.daemon.mu.Unlock()
+
+	stored := s.daemon.server.History()
+	events := make([]Event, len(stored))
+	for i, event := range stored {
+		events[i] = newEvent(event)
+	}
+	*reply = History{Events: events, Next: next}
+	return nil
+}
+
+// Poll returns the events received from a sequence number on, waiting for
+// some when there are none yet
+func (s *Service) Poll(args *PollArgs, reply *PollReply) error {
+	events, next, missed := s.daemon.poll(args.After, time.Duration(args.WaitMs)*time.Millisecond)
+	*reply = PollReply{Events: events, Next: next, Missed: missed}
+	return nil
+}
+
+// Snapshot writes every stored event to a capture on the daemon's side
+func (s *Service) Snapshot(args *SnapshotArgs, _ *Empty) error {
+	format, _, ok := ui.DetectFormat(args.Filename)
+	if !ok || (format != ui.FormatSQLite && format != ui.FormatJSON) {
+		return fmt.Errorf("unknown capture format for %s (want .db or .json)", args.Filename)
+	}
+	return s.daemon.server.Snapshot(args.Filename, format)
+}
+
+// Stop stops the daemon once the reply is sent
+func (s *Service) Stop(_ *Empty, _ *Empty) error {
+	go func() {
+		// Let the reply go out before the connections close
+		time.Sleep(100 * time.Millisecond)
+		s.daemon.Stop()
+	}()
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package daemon
+
+import "syscall"
+
+// restrictUmask makes the files created until restore is called readable
+// and writable by the current user only
+func restrictUmask() (restore func()) {
+	previous := syscall.Umask(0o177)
+	return func() { syscall.Umask(previous) }
+}
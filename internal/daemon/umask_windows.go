@@ -0,0 +1,9 @@
+//go:build windows
+
+package daemon
+
+// restrictUmask does nothing on Windows, where sockets follow the ACL of
+// their directory
+func restrictUmask() (restore func()) {
+	return func() {}
+}
@@ -0,0 +1,152 @@
+// Package gotest maps changed Go files to the packages whose tests they
+// affect, and follows `go test -json` runs package by package
+package gotest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// listedPackage is the part of `go list -json` output the graph needs
+type listedPackage struct {
+	ImportPath     string
+	Dir            string
+	GoFiles        []string
+	CgoFiles       []string
+	TestGoFiles    []string
+	XTestGoFiles   []string
+	IgnoredGoFiles []string
+	Imports        []string
+	TestImports    []string
+	XTestImports   []string
+}
+
+// Graph is the package graph of a Go module
+type Graph struct {
+	ModuleDir string              // Directory holding go.mod
+	Packages  []string            // Import paths, sorted
+	byDir     map[string]string   // Package directory to import path
+	files     map[string]bool     // Known Go files
+	importers map[string][]string // Import path to the packages importing it, tests included
+}
+
+// FindModule returns the directory of the go.mod governing a directory
+func FindModule(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("no go.mod found")
+		}
+		dir = parent
+	}
+}
+
+// LoadGraph lists the packages of the module in moduleDir with `go list`
+func LoadGraph(moduleDir string) (*Graph, error) {
+	cmd := exec.Command("go", "list", "-e", "-json", "./...")
+	cmd.Dir = moduleDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	g := &Graph{
+		ModuleDir: moduleDir,
+		byDir:     make(map[string]string),
+		files:     make(map[string]bool),
+		importers: make(map[string][]string),
+	}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var pkg listedPackage
+		if err := decoder.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse go list output: %w", err)
+		}
+
+		g.Packages = append(g.Packages, pkg.ImportPath)
+		g.byDir[pkg.Dir] = pkg.ImportPath
+		for _, files := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles, pkg.XTestGoFiles, pkg.IgnoredGoFiles} {
+			for _, file := range files {
+				g.files[filepath.Join(pkg.Dir, file)] = true
+			}
+		}
+
+		imports := make(map[string]bool)
+		for _, list := range [][]string{pkg.Imports, pkg.TestImports, pkg.XTestImports} {
+			for _, path := range list {
+				if path != pkg.ImportPath && !imports[path] {
+					imports[path] = true
+					g.importers[path] = append(g.importers[path], pkg.ImportPath)
+				}
+			}
+		}
+	}
+	sort.Strings(g.Packages)
+	return g, nil
+}
+
+// Affected returns the packages to test after changes to paths: the
+// packages of changed Go files and everything depending on them. A change
+// to go.mod or go.sum affects every package. stale reports a Go file added
+// or removed since the graph was loaded, telling the caller to load it again.
+func (g *Graph) Affected(paths []string) (packages []string, stale bool) {
+	changed := make(map[string]bool)
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		switch base := filepath.Base(path); {
+		case base == "go.mod" || base == "go.sum":
+			return append([]string(nil), g.Packages...), base == "go.mod"
+		case strings.HasSuffix(base, ".go"):
+			if _, err := os.Stat(path); !g.files[path] || err != nil {
+				stale = true
+			}
+			if pkg, ok := g.byDir[filepath.Dir(path)]; ok {
+				changed[pkg] = true
+			}
+		}
+	}
+
+	// Reverse dependencies, transitively
+	queue := make([]string, 0, len(changed))
+	for pkg := range changed {
+		queue = append(queue, pkg)
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, importer := range g.importers[pkg] {
+			if !changed[importer] {
+				changed[importer] = true
+				queue = append(queue, importer)
+			}
+		}
+	}
+
+	for pkg := range changed {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	return packages, stale
+}
@@ -0,0 +1,286 @@
+package gotest
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State is the test state of a package
+type State int
+
+const (
+	StateRunning     State = iota // Tests running now
+	StatePassed                   // Last run passed
+	StateFailed                   // Last run failed, or did not build
+	StateSkipped                  // No test files
+	StateInterrupted              // Last run was killed before the package finished
+)
+
+// StateName returns the name of a package state
+func StateName(state State) string {
+	switch state {
+	case StatePassed:
+		return "ok"
+	case StateFailed:
+		return "FAIL"
+	case StateSkipped:
+		return "no tests"
+	case StateInterrupted:
+		return "interrupted"
+	default:
+		return "running"
+	}
+}
+
+// maxPackageOutput is the number of output lines kept per package
+const maxPackageOutput = 200
+
+// Result is the outcome of the last run of a package's tests
+type Result struct {
+	Package string
+	State   State
+	Elapsed time.Duration
+	Output  []string  // Test output, kept for failures
+	Updated time.Time // When the state last changed
+}
+
+// testEvent is a line of `go test -json` output, see `go doc test2json`
+type testEvent struct {
+	Action     string
+	Package    string
+	ImportPath string // Build output
+	Test       string
+	Elapsed    float64
+	Output     string
+}
+
+// Session runs the tests of the packages affected by changes and keeps
+// their results. It writes `go test -json` command lines for a
+// runner.Runner and reads their output as an io.Writer.
+type Session struct {
+	moduleDir string
+	args      []string // Extra `go test` flags
+
+	mu       sync.Mutex
+	graph    *Graph
+	results  map[string]*Result
+	partial  []byte // Incomplete output line
+	onResult []func(Result)
+	onChange []func()
+}
+
+// NewSession loads the package graph of the module governing dir
+func NewSession(dir string, args []string) (*Session, error) {
+	moduleDir, err := FindModule(dir)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := LoadGraph(moduleDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		moduleDir: moduleDir,
+		args:      args,
+		graph:     graph,
+		results:   make(map[string]*Result),
+	}, nil
+}
+
+// ModuleDir returns the directory of the module under test
+func (s *Session) ModuleDir() string {
+	return s.moduleDir
+}
+
+// OnResult registers a function called when a package finishes
+func (s *Session) OnResult(listener func(Result)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onResult = append(s.onResult, listener)
+}
+
+// OnChange registers a function called whenever a result changes
+func (s *Session) OnChange(listener func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, listener)
+}
+
+// Packages returns the packages to test for changed paths; no paths means
+// every package. The graph is loaded again without holding s.mu, so that
+// the test output is still read meanwhile.
+func (s *Session) Packages(changed []string) ([]string, error) {
+	s.mu.Lock()
+	if len(changed) == 0 {
+		defer s.mu.Unlock()
+		return append([]string(nil), s.graph.Packages...), nil
+	}
+	packages, stale := s.graph.Affected(changed)
+	s.mu.Unlock()
+	if !stale {
+		return packages, nil
+	}
+
+	// New files or packages: load the graph again
+	graph, err := LoadGraph(s.moduleDir)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.graph = graph
+	s.mu.Unlock()
+	packages, _ = graph.Affected(changed)
+	return packages, nil
+}
+
+// Command returns the `go test -json` command line for changed paths, or
+// nothing when no package is affected. It is meant for runner.Config.Build.
+func (s *Session) Command(changed []string) ([]string, error) {
+	packages, err := s.Packages(changed)
+	if err != nil {
+		return nil, err
+	}
+	return s.TestCommand(packages), nil
+}
+
+// TestCommand returns the `go test -json` command line for packages, or
+// nothing without packages, and marks them as running
+func (s *Session) TestCommand(packages []string) []string {
+	if len(packages) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	for _, result := range s.results {
+		if result.State == StateRunning {
+			result.State = StateInterrupted
+			result.Updated = now
+		}
+	}
+	for _, pkg := range packages {
+		s.results[pkg] = &Result{Package: pkg, State: StateRunning, Updated: now}
+	}
+	s.partial = nil
+	s.mu.Unlock()
+	s.changed()
+
+	argv := append([]string{"go", "test", "-json"}, s.args...)
+	return append(argv, packages...)
+}
+
+// Write reads `go test -json` output
+func (s *Session) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.partial = append(s.partial, p...)
+	var finished []Result
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if result, ok := s.handleLine(s.partial[:i]); ok {
+			finished = append(finished, result)
+		}
+		s.partial = s.partial[i+1:]
+	}
+	listeners := append([]func(Result){}, s.onResult...)
+	s.mu.Unlock()
+
+	for _, result := range finished {
+		for _, listener := range listeners {
+			listener(result)
+		}
+	}
+	s.changed()
+	return len(p), nil
+}
+
+// handleLine applies an output line; it returns the result of a package
+// that just finished. s.mu must be held.
+func (s *Session) handleLine(line []byte) (Result, bool) {
+	var event testEvent
+	if err := json.Unmarshal(line, &event); err != nil || event.Action == "" {
+		return Result{}, false
+	}
+
+	pkg := event.Package
+	if pkg == "" {
+		// Build output names the package as "path [path.test]"
+		pkg, _, _ = strings.Cut(event.ImportPath, " ")
+	}
+	result, ok := s.results[pkg]
+	if !ok {
+		result = &Result{Package: pkg, State: StateRunning}
+		s.results[pkg] = result
+	}
+
+	switch event.Action {
+	case "output", "build-output":
+		result.Output = append(result.Output, strings.TrimRight(event.Output, "\n"))
+		if extra := len(result.Output) - maxPackageOutput; extra > 0 {
+			result.Output = result.Output[extra:]
+		}
+		return Result{}, false
+	case "pass", "fail", "skip":
+		if event.Test != "" {
+			return Result{}, false
+		}
+	default:
+		return Result{}, false
+	}
+
+	switch event.Action {
+	case "pass":
+		result.State = StatePassed
+		result.Output = nil
+	case "skip":
+		result.State = StateSkipped
+		result.Output = nil
+	default:
+		result.State = StateFailed
+	}
+	result.Elapsed = time.Duration(event.Elapsed * float64(time.Second))
+	result.Updated = time.Now()
+	return s.copy(result), true
+}
+
+// Results returns the results of every package tested so far, failures
+// first
+func (s *Session) Results() []Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]Result, 0, len(s.results))
+	for _, result := range s.results {
+		results = append(results, s.copy(result))
+	}
+	sort.Slice(results, func(i, j int) bool {
+		failedI, failedJ := results[i].State == StateFailed, results[j].State == StateFailed
+		if failedI != failedJ {
+			return failedI
+		}
+		return results[i].Package < results[j].Package
+	})
+	return results
+}
+
+// copy returns a snapshot of a result
+func (s *Session) copy(result *Result) Result {
+	snapshot := *result
+	snapshot.Output = append([]string(nil), result.Output...)
+	return snapshot
+}
+
+// changed calls the change listeners
+func (s *Session) changed() {
+	s.mu.Lock()
+	listeners := append([]func(){}, s.onChange...)
+	s.mu.Unlock()
+	for _, listener := range listeners {
+		listener()
+	}
+}
@@ -0,0 +1,437 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// ErrTampered is returned when the signature of a baseline does not match
+// its content
+var ErrTampered = errors.New("baseline signature does not match its content")
+
+// schema is the layout of a baseline file
+const schema = `
+CREATE TABLE entries (
+	path TEXT PRIMARY KEY,
+	mode INTEGER NOT NULL,
+	uid INTEGER NOT NULL,
+	gid INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	hash TEXT NOT NULL,
+	link TEXT NOT NULL
+);
+CREATE TABLE meta (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// DefaultKeyPath returns the signing key next to the default configuration
+// file
+func DefaultKeyPath() string {
+	path := config.DefaultPath()
+	if path == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), "baseline.key")
+}
+
+// LoadKey reads the hex ed25519 seed of a key file. With create, a missing
+// key is generated and written readable only by its owner.
+func LoadKey(path string, create bool) (ed25519.PrivateKey, error) {
+	if path == "" {
+		return nil, errors.New("no signing key path")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && create {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create key directory: %w", err)
+		}
+		seed := hex.EncodeToString(key.Seed()) + "\n"
+		if err := os.WriteFile(path, []byte(seed), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write signing key: %w", err)
+		}
+		logger.Info("Generated baseline signing key " + path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key %s", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Baseline is an open baseline file
+type Baseline struct {
+	path    string
+	roots   []string
+	ignored func(path string) bool
+	key     ed25519.PrivateKey
+
+	mu      sync.Mutex
+	db      *sql.DB
+	created time.Time
+	entries map[string]Entry
+}
+
+// Create records the state of every path under the roots but the ignored
+// ones, and writes it to a new baseline file, replacing any previous one
+func Create(filename string, roots []string, ignored func(path string) bool, key ed25519.PrivateKey) (*Baseline, error) {
+	roots, err := absRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scan(roots, ignored)
+	if err != nil {
+		return nil, err
+	}
+
+	// Written aside, then renamed, so that a failure keeps the previous one
+	temp := filename + ".tmp"
+	_ = os.Remove(temp)
+	db, err := sql.Open("sqlite3", temp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create baseline: %w", err)
+	}
+	b := &Baseline{path: filename, roots: roots, ignored: ignored, key: key, db: db,
+		created: time.Now().UTC(), entries: entries}
+	if err := b.write(); err != nil {
+		_ = db.Close()
+		_ = os.Remove(temp)
+		return nil, err
+	}
+	if err := db.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write baseline: %w", err)
+	}
+	if err := os.Rename(temp, filename); err != nil {
+		return nil, fmt.Errorf("failed to write baseline: %w", err)
+	}
+	if b.db, err = sql.Open("sqlite3", filename); err != nil {
+		return nil, fmt.Errorf("failed to open baseline: %w", err)
+	}
+	return b, nil
+}
+
+// write stores the schema, the entries and the signature in a new file
+func (b *Baseline) write() error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to write baseline: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
+	}
+	for _, entry := range b.entries {
+		if err := upsert(tx, entry); err != nil {
+			return err
+		}
+	}
+	roots, err := json.Marshal(b.roots)
+	if err != nil {
+		return fmt.Errorf("failed to encode roots: %w", err)
+	}
+	for key, value := range map[string]string{
+		"version": "1",
+		"roots":   string(roots),
+		"created": b.created.Format(time.RFC3339Nano),
+		"key":     hex.EncodeToString(b.key.Public().(ed25519.PublicKey)),
+	} {
+		if err := setMeta(tx, key, value); err != nil {
+			return err
+		}
+	}
+	if err := b.sign(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write baseline: %w", err)
+	}
+	return nil
+}
+
+// Open reads a baseline file and checks its signature with the public half
+// of key. ignored leaves out paths from later checks, like at creation.
+func Open(filename string, ignored func(path string) bool, key ed25519.PrivateKey) (*Baseline, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, fmt.Errorf("failed to open baseline: %w", err)
+	}
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open baseline: %w", err)
+	}
+	b := &Baseline{path: filename, ignored: ignored, key: key, db: db, entries: make(map[string]Entry)}
+	if err := b.read(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// read loads the entries and metadata, and checks the signature
+func (b *Baseline) read() error {
+	meta := make(map[string]string)
+	rows, err := b.db.Query(`SELECT key, value FROM meta`)
+	if err != nil {
+		return fmt.Errorf("failed to read baseline %s: %w", b.path, err)
+	}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to read baseline: %w", err)
+		}
+		meta[key] = value
+	}
+	_ = rows.Close()
+	if meta["version"] != "1" {
+		return fmt.Errorf("%s is not a baseline", b.path)
+	}
+	if err := json.Unmarshal([]byte(meta["roots"]), &b.roots); err != nil {
+		return fmt.Errorf("invalid baseline roots: %w", err)
+	}
+	if b.created, err = time.Parse(time.RFC3339Nano, meta["created"]); err != nil {
+		return fmt.Errorf("invalid baseline creation time: %w", err)
+	}
+	if meta["key"] != hex.EncodeToString(b.key.Public().(ed25519.PublicKey)) {
+		return fmt.Errorf("baseline %s was signed with another key", b.path)
+	}
+
+	rows, err = b.db.Query(`SELECT path, mode, uid, gid, size, hash, link FROM entries`)
+	if err != nil {
+		return fmt.Errorf("failed to read baseline entries: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var entry Entry
+		var mode uint32
+		if err := rows.Scan(&entry.Path, &mode, &entry.UID, &entry.GID, &entry.Size, &entry.Hash, &entry.Link); err != nil {
+			return fmt.Errorf("failed to read baseline entry: %w", err)
+		}
+		entry.Mode = fs.FileMode(mode)
+		b.entries[entry.Path] = entry
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read baseline entries: %w", err)
+	}
+
+	signature, err := hex.DecodeString(meta["signature"])
+	if err != nil || !ed25519.Verify(b.key.Public().(ed25519.PublicKey), b.digest(), signature) {
+		return fmt.Errorf("%s: %w", b.path, ErrTampered)
+	}
+	return nil
+}
+
+// digest hashes the roots, creation time and entries, in path order
+func (b *Baseline) digest() []byte {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "watch-fs baseline 1\n%s\n%s\n", strings.Join(b.roots, "\x00"), b.created.Format(time.RFC3339Nano))
+	paths := make([]string, 0, len(b.entries))
+	for path := range b.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		e := b.entries[path]
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00%d\x00%d\x00%s\x00%s\n", e.Path, uint32(e.Mode), e.UID, e.GID, e.Size, e.Hash, e.Link)
+	}
+	return hash.Sum(nil)
+}
+
+// sign stores the signature of the entries
+func (b *Baseline) sign(tx *sql.Tx) error {
+	signature := ed25519.Sign(b.key, b.digest())
+	return setMeta(tx, "signature", hex.EncodeToString(signature))
+}
+
+// upsert stores an entry
+func upsert(tx *sql.Tx, e Entry) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO entries (path, mode, uid, gid, size, hash, link) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.Path, uint32(e.Mode), e.UID, e.GID, e.Size, e.Hash, e.Link)
+	if err != nil {
+		return fmt.Errorf("failed to write baseline entry: %w", err)
+	}
+	return nil
+}
+
+// setMeta stores a metadata value
+func setMeta(tx *sql.Tx, key, value string) error {
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)`, key, value); err != nil {
+		return fmt.Errorf("failed to write baseline metadata: %w", err)
+	}
+	return nil
+}
+
+// Roots returns the absolute roots the baseline covers
+func (b *Baseline) Roots() []string {
+	return append([]string(nil), b.roots...)
+}
+
+// Created returns when the baseline was first recorded
+func (b *Baseline) Created() time.Time {
+	return b.created
+}
+
+// Len returns the number of recorded paths
+func (b *Baseline) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// covers reports whether a path is under a root and not ignored
+func (b *Baseline) covers(path string) bool {
+	for _, root := range b.roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return path == root || b.ignored == nil || !b.ignored(path)
+		}
+	}
+	return false
+}
+
+// Check compares the current state of a path with the baseline. It returns
+// nil when they match, or when the baseline does not cover the path.
+func (b *Baseline) Check(path string) *Violation {
+	path, err := filepath.Abs(path)
+	if err != nil || !b.covers(path) {
+		return nil
+	}
+	current, err := stat(path)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Debug(fmt.Sprintf("Failed to check %s against the baseline: %v", path, err))
+		return nil
+	}
+	b.mu.Lock()
+	recorded, known := b.entries[path]
+	b.mu.Unlock()
+
+	switch {
+	case !known && !exists:
+		return nil
+	case !known:
+		return &Violation{Path: path, Kind: Added}
+	case !exists:
+		return &Violation{Path: path, Kind: Removed}
+	}
+	if changes := compare(recorded, current); len(changes) > 0 {
+		return &Violation{Path: path, Kind: Changed, Changes: changes}
+	}
+	return nil
+}
+
+// Verify scans the roots and returns every path not matching the baseline,
+// in path order
+func (b *Baseline) Verify() ([]Violation, error) {
+	current, err := scan(b.roots, b.ignored)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var violations []Violation
+	for path, entry := range current {
+		recorded, known := b.entries[path]
+		if !known {
+			violations = append(violations, Violation{Path: path, Kind: Added})
+		} else if changes := compare(recorded, entry); len(changes) > 0 {
+			violations = append(violations, Violation{Path: path, Kind: Changed, Changes: changes})
+		}
+	}
+	for path := range b.entries {
+		if _, ok := current[path]; !ok {
+			violations = append(violations, Violation{Path: path, Kind: Removed})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Path < violations[j].Path })
+	return violations, nil
+}
+
+// Accept records the current state of paths in the baseline, dropping those
+// that no longer exist, and signs it again
+func (b *Baseline) Accept(paths ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update baseline: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	previous := make(map[string]*Entry)
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if !b.covers(path) {
+			return fmt.Errorf("%s is not covered by the baseline", path)
+		}
+		if _, seen := previous[path]; !seen {
+			previous[path] = nil
+			if recorded, ok := b.entries[path]; ok {
+				previous[path] = &recorded
+			}
+		}
+		entry, err := stat(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			delete(b.entries, path)
+			if _, err := tx.Exec(`DELETE FROM entries WHERE path = ?`, path); err != nil {
+				return fmt.Errorf("failed to update baseline: %w", err)
+			}
+		case err != nil:
+			return err
+		default:
+			b.entries[path] = entry
+			if err := upsert(tx, entry); err != nil {
+				return err
+			}
+		}
+	}
+	err = b.sign(tx)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		// Keep the entries in line with the file
+		for path, recorded := range previous {
+			if recorded == nil {
+				delete(b.entries, path)
+			} else {
+				b.entries[path] = *recorded
+			}
+		}
+		return fmt.Errorf("failed to update baseline: %w", err)
+	}
+	return nil
+}
+
+// Close closes the baseline file
+func (b *Baseline) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,179 @@
+// Package integrity records a baseline of the files under the roots, with
+// their hashes, modes, owners and sizes, and reports the paths that no
+// longer match it, in the manner of tripwire or AIDE. Baselines are SQLite
+// files signed with a local ed25519 key, so that tampering is detected.
+package integrity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+)
+
+// Entry is the recorded state of a path
+type Entry struct {
+	Path string      `json:"path"`
+	Mode fs.FileMode `json:"mode"`
+	UID  int         `json:"uid"` // -1 where owners are unknown, e.g. on Windows
+	GID  int         `json:"gid"`
+	Size int64       `json:"size"`           // Regular files and links only
+	Hash string      `json:"hash,omitempty"` // Hex SHA-256 of a regular file
+	Link string      `json:"link,omitempty"` // Target of a symbolic link
+}
+
+// Kind is how a path breaks the baseline
+type Kind int
+
+const (
+	Changed Kind = iota // Recorded and present, with differences
+	Added               // Present but not recorded
+	Removed             // Recorded but absent
+)
+
+// MarshalText encodes a kind as changed, added or removed
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// String returns changed, added or removed
+func (k Kind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// Violation is a path that does not match the baseline
+type Violation struct {
+	Path    string   `json:"path"`
+	Kind    Kind     `json:"kind"`
+	Changes []string `json:"changes,omitempty"` // Changed: what differs, e.g. "mode -rw-r--r-- -> -rwxrwxrwx"
+}
+
+// String describes a violation without its path
+func (v Violation) String() string {
+	if len(v.Changes) == 0 {
+		return v.Kind.String()
+	}
+	return v.Kind.String() + ": " + strings.Join(v.Changes, ", ")
+}
+
+// stat records the current state of a path, hashing regular files
+func stat(path string) (Entry, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := Entry{Path: path, Mode: info.Mode()}
+	entry.UID, entry.GID = attrs.Owner(info)
+	switch {
+	case info.Mode().IsRegular():
+		entry.Size = info.Size()
+		if entry.Hash, err = hashFile(path); err != nil {
+			return Entry{}, err
+		}
+	case info.Mode()&fs.ModeSymlink != 0:
+		entry.Size = info.Size()
+		if entry.Link, err = os.Readlink(path); err != nil {
+			return Entry{}, err
+		}
+	}
+	return entry, nil
+}
+
+// hashFile returns the hex SHA-256 of a file
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// compare lists what differs between the recorded and current state of a
+// path
+func compare(recorded, current Entry) []string {
+	var changes []string
+	if recorded.Mode != current.Mode {
+		changes = append(changes, fmt.Sprintf("mode %s -> %s", recorded.Mode, current.Mode))
+	}
+	if recorded.UID != current.UID || recorded.GID != current.GID {
+		changes = append(changes, fmt.Sprintf("owner %d:%d -> %d:%d", recorded.UID, recorded.GID, current.UID, current.GID))
+	}
+	if recorded.Size != current.Size {
+		changes = append(changes, fmt.Sprintf("size %d -> %d", recorded.Size, current.Size))
+	}
+	if recorded.Hash != current.Hash && recorded.Hash != "" && current.Hash != "" {
+		changes = append(changes, "content")
+	}
+	if recorded.Link != current.Link {
+		changes = append(changes, fmt.Sprintf("link %s -> %s", recorded.Link, current.Link))
+	}
+	return changes
+}
+
+// scan records every path under the roots but the ignored ones
+func scan(roots []string, ignored func(path string) bool) (map[string]Entry, error) {
+	entries := make(map[string]Entry)
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) && path != root {
+					return nil
+				}
+				return err
+			}
+			if path != root && ignored != nil && ignored(path) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			entry, err := stat(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			entries[path] = entry
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+		}
+	}
+	return entries, nil
+}
+
+// absRoots returns the roots as cleaned absolute paths
+func absRoots(roots []string) ([]string, error) {
+	abs := make([]string, len(roots))
+	for i, root := range roots {
+		path, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root %s: %w", root, err)
+		}
+		abs[i] = path
+	}
+	sort.Strings(abs)
+	return abs, nil
+}
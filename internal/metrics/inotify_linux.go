@@ -0,0 +1,19 @@
+//go:build linux
+
+package metrics
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// inotifyWatchLimit reads the number of inotify watches a user may hold
+func inotifyWatchLimit() (int, bool) {
+	data, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0, false
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return limit, err == nil
+}
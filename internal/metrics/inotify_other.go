@@ -0,0 +1,8 @@
+//go:build !linux
+
+package metrics
+
+// inotifyWatchLimit reports no limit: only Linux has inotify
+func inotifyWatchLimit() (int, bool) {
+	return 0, false
+}
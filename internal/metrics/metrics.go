@@ -0,0 +1,248 @@
+// Package metrics counts what the watchers do and exposes it in the
+// Prometheus text format, or OpenMetrics when the scraper asks for it.
+// Counters are process-wide, like the log; the gauges of watched
+// directories are read from a watcher at each scrape.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the event
+// processing latency histogram
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// operations are the operations events are counted by, with their label
+var operations = []struct {
+	op    fsnotify.Op
+	label string
+}{
+	{fsnotify.Create, "create"},
+	{fsnotify.Write, "write"},
+	{fsnotify.Remove, "remove"},
+	{fsnotify.Rename, "rename"},
+	{fsnotify.Chmod, "chmod"},
+}
+
+// eventKey identifies an event counter
+type eventKey struct {
+	root string
+	op   string
+}
+
+// registry holds the counters of the process
+type registry struct {
+	mu        sync.Mutex
+	started   time.Time
+	events    map[eventKey]uint64
+	ignored   uint64
+	errors    uint64
+	overflows uint64
+	dropped   map[string]uint64 // By reason
+
+	latencyCounts []uint64 // Per bucket, the last one is +Inf
+	latencySum    float64
+	latencyCount  uint64
+}
+
+var std = &registry{
+	started:       time.Now(),
+	events:        make(map[eventKey]uint64),
+	dropped:       make(map[string]uint64),
+	latencyCounts: make([]uint64, len(latencyBuckets)+1),
+}
+
+// ObserveEvent counts an event received under root, and the time it
+// waited before being taken for processing
+func ObserveEvent(root string, op fsnotify.Op, ignored bool, wait time.Duration) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if ignored {
+		std.ignored++
+		return
+	}
+	for _, operation := range operations {
+		if op.Has(operation.op) {
+			std.events[eventKey{root: root, op: operation.label}]++
+		}
+	}
+
+	seconds := wait.Seconds()
+	bucket := sort.SearchFloat64s(latencyBuckets, seconds)
+	std.latencyCounts[bucket]++
+	std.latencySum += seconds
+	std.latencyCount++
+}
+
+// ObserveError counts an error of a watcher; queue overflows, where the
+// kernel dropped events, are also counted apart
+func ObserveError(err error) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.errors++
+	if errors.Is(err, fsnotify.ErrEventOverflow) {
+		std.overflows++
+	}
+}
+
+// Dropped counts events dropped by watch-fs itself, such as those of a
+// stream lagging behind
+func Dropped(reason string, count int) {
+	if count <= 0 {
+		return
+	}
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.dropped[reason] += uint64(count)
+}
+
+// Watcher is the part of a watcher the gauges are read from
+type Watcher interface {
+	GetRoots() []string
+	GetWatchedCount() int
+	GetWatchedCountForRoot(root string) int
+}
+
+// Handler serves the metrics, with the gauges of a watcher
+func Handler(watcher Watcher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		}
+		Write(w, watcher, openMetrics)
+	})
+}
+
+// Write writes the metrics in the Prometheus text format, or OpenMetrics
+func Write(w io.Writer, watcher Watcher, openMetrics bool) {
+	e := &exposition{w: w, openMetrics: openMetrics}
+
+	std.mu.Lock()
+	e.family("watch_fs_events", "counter", "File system events by root and operation")
+	keys := make([]eventKey, 0, len(std.events))
+	for key := range std.events {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].root != keys[j].root {
+			return keys[i].root < keys[j].root
+		}
+		return keys[i].op < keys[j].op
+	})
+	for _, key := range keys {
+		e.sample("watch_fs_events_total", labels("root", key.root, "op", key.op), float64(std.events[key]))
+	}
+	e.counter("watch_fs_ignored_events", "Events under ignored paths", std.ignored)
+	e.counter("watch_fs_errors", "Errors reported by the watcher", std.errors)
+	e.counter("watch_fs_overflows", "Kernel event queue overflows, where events were lost", std.overflows)
+
+	e.family("watch_fs_dropped_events", "counter", "Events dropped by watch-fs, by reason")
+	reasons := make([]string, 0, len(std.dropped))
+	for reason := range std.dropped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		e.sample("watch_fs_dropped_events_total", labels("reason", reason), float64(std.dropped[reason]))
+	}
+
+	e.family("watch_fs_event_processing_seconds", "histogram", "Time events waited for the previous ones to be processed")
+	cumulative := uint64(0)
+	for i, bound := range latencyBuckets {
+		cumulative += std.latencyCounts[i]
+		e.sample("watch_fs_event_processing_seconds_bucket", labels("le", formatFloat(bound)), float64(cumulative))
+	}
+	e.sample("watch_fs_event_processing_seconds_bucket", labels("le", "+Inf"), float64(std.latencyCount))
+	e.sample("watch_fs_event_processing_seconds_sum", "", std.latencySum)
+	e.sample("watch_fs_event_processing_seconds_count", "", float64(std.latencyCount))
+	started := std.started
+	std.mu.Unlock()
+
+	if watcher != nil {
+		e.family("watch_fs_watched_directories", "gauge", "Directories watched under each root")
+		for _, root := range watcher.GetRoots() {
+			e.sample("watch_fs_watched_directories", labels("root", root), float64(watcher.GetWatchedCountForRoot(root)))
+		}
+		watches := watcher.GetWatchedCount()
+		e.gauge("watch_fs_watches", "Directories watched in total", float64(watches))
+		if limit, ok := inotifyWatchLimit(); ok && limit > 0 {
+			e.gauge("watch_fs_inotify_max_user_watches", "Inotify watches allowed per user (fs.inotify.max_user_watches)", float64(limit))
+			e.gauge("watch_fs_inotify_watches_ratio", "Share of the inotify watch budget used by this process", float64(watches)/float64(limit))
+		}
+	}
+	e.gauge("watch_fs_start_time_seconds", "Start time of the process since the Unix epoch", float64(started.UnixNano())/1e9)
+
+	if openMetrics {
+		_, _ = fmt.Fprintln(w, "# EOF")
+	}
+}
+
+// exposition writes metric families
+type exposition struct {
+	w           io.Writer
+	openMetrics bool
+}
+
+// family writes the help and type of a family. OpenMetrics names counter
+// families without their _total suffix, the Prometheus format with it.
+func (e *exposition) family(name, kind, help string) {
+	if kind == "counter" && !e.openMetrics {
+		name += "_total"
+	}
+	_, _ = fmt.Fprintf(e.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample
+func (e *exposition) sample(name, labels string, value float64) {
+	_, _ = fmt.Fprintf(e.w, "%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// counter writes a counter family with a single sample
+func (e *exposition) counter(name, help string, value uint64) {
+	e.family(name, "counter", help)
+	e.sample(name+"_total", "", float64(value))
+}
+
+// gauge writes a gauge family with a single sample
+func (e *exposition) gauge(name, help string, value float64) {
+	e.family(name, "gauge", help)
+	e.sample(name, "", value)
+}
+
+// labels formats label pairs as {name="value",...}
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// labelEscaper escapes label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatFloat formats a sample value
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
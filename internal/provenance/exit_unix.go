@@ -0,0 +1,17 @@
+//go:build !windows
+
+package provenance
+
+import (
+	"os"
+	"syscall"
+)
+
+// exitStatus returns the exit code of a command, 128+N when signal N
+// killed it as shells report it, and the name of that signal
+func exitStatus(state *os.ProcessState) (int, string) {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), status.Signal().String()
+	}
+	return state.ExitCode(), ""
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package provenance
+
+import "os"
+
+// exitStatus returns the exit code of a command; Windows has no signals
+func exitStatus(state *os.ProcessState) (int, string) {
+	return state.ExitCode(), ""
+}
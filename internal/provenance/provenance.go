@@ -0,0 +1,326 @@
+// Package provenance records the files a command creates, modifies or
+// deletes, netting out intermediate steps such as temporary files
+package provenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/wait"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// Kind is the net effect of a command on a path
+type Kind int
+
+const (
+	Created  Kind = iota // Absent before, present after
+	Modified             // Present before and after, with content changes
+	Deleted              // Present before, absent after
+)
+
+// MarshalText encodes a kind as created, modified or deleted
+func (k Kind) MarshalText() ([]byte, error) {
+	switch k {
+	case Created:
+		return []byte("created"), nil
+	case Deleted:
+		return []byte("deleted"), nil
+	default:
+		return []byte("modified"), nil
+	}
+}
+
+// Change is the net change of one path
+type Change struct {
+	Path         string      `json:"path"`
+	RelativePath string      `json:"relative_path"` // Slash-separated, relative to its root
+	Kind         Kind        `json:"kind"`
+	IsDir        bool        `json:"is_dir"`
+	Operations   fsnotify.Op `json:"-"` // Every operation seen on the path
+	Events       int         `json:"events"`
+}
+
+// MarshalJSON adds the operation names to a change
+func (c Change) MarshalJSON() ([]byte, error) {
+	type plain Change
+	return json.Marshal(struct {
+		plain
+		Operations string `json:"operations"`
+	}{plain(c), c.Operations.String()})
+}
+
+// Report describes what a command changed
+type Report struct {
+	Command   []string  `json:"command"`
+	Roots     []string  `json:"roots"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`         // When the tree settled
+	ExitCode  int       `json:"exit_code"`        // 128+N when signal N killed the command
+	Signal    string    `json:"signal,omitempty"` // Signal that killed the command
+	Changes   []Change  `json:"changes"`
+	Temporary int       `json:"temporary"` // Paths created and removed again
+}
+
+// Options configures a recording
+type Options struct {
+	Quiet         time.Duration // Quiet period after the command exits
+	SettleTimeout time.Duration // Longest wait for the quiet period, none when zero
+}
+
+// Run runs cmd while the session records the events of source, waits for
+// the tree to settle once it exits, and reports the net changes. The exit
+// code of the command is part of the report; an error means it could not
+// be run or watched.
+func Run(source wait.Source, session *ui.UI, cmd *exec.Cmd, options Options) (*Report, error) {
+	report := &Report{Command: cmd.Args, Roots: session.GetRootPaths(), Started: time.Now()}
+	ignored := func(string) bool { return false }
+	if ignorer, ok := source.(interface{ Ignored(string) bool }); ok {
+		ignored = ignorer.Ignored
+	}
+	before := Existing(report.Roots, ignored)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	// Events are recorded until the command exits, then until the quiet
+	// period or the settle timeout
+	var settled, expired <-chan time.Time
+	var quiet *time.Timer
+recording:
+	for {
+		select {
+		case event, ok := <-source.Events():
+			if !ok {
+				<-exited
+				return nil, fmt.Errorf("watcher closed")
+			}
+			if ignored(event.Name) {
+				continue
+			}
+			record(source, session, event)
+			if quiet != nil {
+				quiet.Reset(options.Quiet)
+			}
+		case err, ok := <-source.Errors():
+			if ok {
+				logger.Error(err, "Watcher error")
+			}
+		case <-exited:
+			exited = nil
+			quiet = time.NewTimer(options.Quiet)
+			defer quiet.Stop()
+			settled = quiet.C
+			if options.SettleTimeout > 0 {
+				expired = time.After(options.SettleTimeout)
+			}
+		case <-settled:
+			break recording
+		case <-expired:
+			logger.Warn(fmt.Sprintf("The tree did not settle within %s, reporting the changes so far", options.SettleTimeout))
+			break recording
+		}
+	}
+
+	report.Finished = time.Now()
+	report.ExitCode, report.Signal = exitStatus(cmd.ProcessState)
+	report.Changes, report.Temporary = Net(session.GetState().Events, report.Roots, before, os.Lstat)
+	return report, nil
+}
+
+// record adds an event to the session. The content of new directories is
+// recorded too, since files may be written before the directory is watched.
+func record(source wait.Source, session *ui.UI, event fsnotify.Event) {
+	info, err := os.Lstat(event.Name)
+	isDir := err == nil && info.IsDir()
+	session.AddEvent(event.Name, event.Op, isDir)
+	if !isDir || event.Op&fsnotify.Create == 0 {
+		return
+	}
+
+	_ = filepath.WalkDir(event.Name, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == event.Name {
+			return nil
+		}
+		if ignorer, ok := source.(interface{ Ignored(string) bool }); ok && ignorer.Ignored(path) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			_ = source.AddDirectory(path)
+		}
+		session.AddEvent(path, fsnotify.Create, entry.IsDir())
+		return nil
+	})
+	_ = source.AddDirectory(event.Name)
+}
+
+// Existing returns the paths present under the roots, skipping the ignored
+// ones, so that Net knows what existed before a command ran
+func Existing(roots []string, ignored func(path string) bool) map[string]bool {
+	existing := make(map[string]bool)
+	for _, root := range roots {
+		_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if path != root && ignored(path) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			existing[path] = true
+			return nil
+		})
+	}
+	return existing
+}
+
+// Net reduces recorded events to one change per path. existedBefore holds
+// the paths present before the command ran, see Existing; stat tells
+// whether a path exists after, so a file replaced by the command is
+// modified rather than created. Paths created then removed are temporary
+// and only counted, and paths with permission changes only are left out.
+func Net(events []*ui.FileEvent, roots []string, existedBefore map[string]bool,
+	stat func(string) (os.FileInfo, error)) ([]Change, int) {
+	byPath := make(map[string]*Change)
+	var order []string
+	for _, event := range events {
+		change, ok := byPath[event.Path]
+		if !ok {
+			change = &Change{Path: event.Path, IsDir: event.IsDir}
+			byPath[event.Path] = change
+			order = append(order, event.Path)
+		}
+		change.Operations |= event.Operation
+		change.Events += max(event.Count, 1)
+	}
+
+	changes := make([]Change, 0, len(order))
+	temporary := 0
+	for _, path := range order {
+		change := byPath[path]
+		info, err := stat(path)
+		existsAfter := err == nil
+		if existsAfter {
+			change.IsDir = info.IsDir()
+		}
+
+		switch {
+		case !existedBefore[path] && !existsAfter:
+			temporary++
+			continue
+		case !existedBefore[path]:
+			change.Kind = Created
+		case !existsAfter:
+			change.Kind = Deleted
+		case change.IsDir || change.Operations&^fsnotify.Chmod == 0:
+			// Directory listings and permissions are not content
+			continue
+		default:
+			change.Kind = Modified
+		}
+
+		change.RelativePath = utils.RelativePath(change.Path, roots)
+		changes = append(changes, *change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].RelativePath < changes[j].RelativePath
+	})
+	return changes, temporary
+}
+
+// Count returns the number of changes of a kind
+func (r *Report) Count(kind Kind) int {
+	count := 0
+	for _, change := range r.Changes {
+		if change.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// Events returns one event per change, for the export formats: creations,
+// writes and removals at the time the tree settled
+func (r *Report) Events() []*ui.FileEvent {
+	events := make([]*ui.FileEvent, 0, len(r.Changes))
+	for _, change := range r.Changes {
+		op := fsnotify.Write
+		switch change.Kind {
+		case Created:
+			op = fsnotify.Create
+		case Deleted:
+			op = fsnotify.Remove
+		}
+		events = append(events, &ui.FileEvent{
+			Path:      change.Path,
+			Operation: op,
+			Timestamp: r.Finished,
+			IsDir:     change.IsDir,
+			Count:     change.Events,
+		})
+	}
+	return events
+}
+
+// WriteText writes a human-readable report
+func (r *Report) WriteText(w io.Writer) error {
+	status := fmt.Sprintf("exited with %d", r.ExitCode)
+	if r.Signal != "" {
+		status = fmt.Sprintf("killed by %s (%d)", r.Signal, r.ExitCode)
+	}
+	if _, err := fmt.Fprintf(w, "%s %s in %s\n", strings.Join(r.Command, " "), status,
+		r.Finished.Sub(r.Started).Round(time.Millisecond)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Created: %d | Modified: %d | Deleted: %d | Temporary: %d\n",
+		r.Count(Created), r.Count(Modified), r.Count(Deleted), r.Temporary); err != nil {
+		return err
+	}
+
+	for _, change := range r.Changes {
+		marker := "~"
+		switch change.Kind {
+		case Created:
+			marker = "+"
+		case Deleted:
+			marker = "-"
+		}
+		path := change.RelativePath
+		if change.IsDir {
+			path += "/"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", marker, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
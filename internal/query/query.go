@@ -0,0 +1,289 @@
+// Package query selects and groups the events of a capture without the
+// TUI, with the filter semantics of the event list
+package query
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// GroupBy selects how matching events are grouped
+type GroupBy int
+
+const (
+	GroupNone GroupBy = iota // One row per event
+	GroupDir                 // Root-relative parent directory
+	GroupExt                 // File extension
+	GroupOp                  // Operation, as the TUI shows it
+	GroupRoot                // Watched root
+	GroupHost                // Host of a collector
+)
+
+// ParseGroupBy parses the name of a grouping
+func ParseGroupBy(name string) (GroupBy, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return GroupNone, nil
+	case "dir":
+		return GroupDir, nil
+	case "ext":
+		return GroupExt, nil
+	case "op":
+		return GroupOp, nil
+	case "root":
+		return GroupRoot, nil
+	case "host":
+		return GroupHost, nil
+	default:
+		return GroupNone, fmt.Errorf("unknown grouping %q (want dir, ext, op, root or host)", name)
+	}
+}
+
+// Query selects events. Filter is applied exactly as by the TUI event
+// list; the counts and time bounds narrow the result further.
+type Query struct {
+	Filter   ui.Filter
+	MinCount int       // Events with a lower count are left out, none when zero
+	MaxCount int       // Events with a higher count are left out, none when zero
+	Since    time.Time // Events before are left out, none when zero
+	Until    time.Time // Events after are left out, none when zero
+}
+
+// New returns a query matching every event
+func New() *Query {
+	return &Query{Filter: ui.Filter{ShowDirs: true, ShowFiles: true}}
+}
+
+// Where adds the conditions of an expression such as
+// "path~internal/ and op=write and count>10" to the query. Conditions are
+// path~TEXT (case-insensitive substring), op=NAME (exact operation, like
+// the TUI filter), type=file|dir, host=NAME, risk=any or risk~TEXT (risky
+// mode and owner changes) and count with =, >, >=, < or <=.
+func (q *Query) Where(expr string) error {
+	for _, condition := range splitConditions(expr) {
+		if err := q.where(condition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitConditions splits an expression on "and" and commas
+func splitConditions(expr string) []string {
+	var conditions []string
+	for _, part := range strings.Split(expr, ",") {
+		words := strings.Fields(part)
+		start := 0
+		for i, word := range words {
+			if strings.EqualFold(word, "and") {
+				conditions = append(conditions, strings.Join(words[start:i], " "))
+				start = i + 1
+			}
+		}
+		conditions = append(conditions, strings.Join(words[start:], " "))
+	}
+
+	nonEmpty := conditions[:0]
+	for _, condition := range conditions {
+		if condition != "" {
+			nonEmpty = append(nonEmpty, condition)
+		}
+	}
+	return nonEmpty
+}
+
+// where adds a single condition
+func (q *Query) where(condition string) error {
+	i := strings.IndexAny(condition, "~=<>")
+	if i <= 0 {
+		return fmt.Errorf("invalid condition %q (want e.g. path~src, op=write, type=file or count>10)", condition)
+	}
+	field := strings.ToLower(strings.TrimSpace(condition[:i]))
+	operator := condition[i : i+1]
+	value := condition[i+1:]
+	if strings.HasPrefix(value, "=") && (operator == "<" || operator == ">") {
+		operator += "="
+		value = value[1:]
+	}
+	value = strings.TrimSpace(value)
+
+	switch {
+	case field == "path" && operator == "~":
+		if q.Filter.PathFilter != "" {
+			return fmt.Errorf("only one path condition is supported")
+		}
+		q.Filter.PathFilter = value
+	case field == "op" && operator == "=":
+		op, err := console.ParseOperations(strings.ReplaceAll(value, "|", ","))
+		if err != nil {
+			return err
+		}
+		q.Filter.OperationFilter = op
+	case field == "type" && operator == "=":
+		switch strings.ToLower(value) {
+		case "file":
+			q.Filter.ShowDirs = false
+		case "dir":
+			q.Filter.ShowFiles = false
+		default:
+			return fmt.Errorf("unknown type %q (want file or dir)", value)
+		}
+	case field == "host" && operator == "=":
+		q.Filter.HostFilter = value
+	case field == "risk" && (operator == "~" || (operator == "=" && strings.EqualFold(value, ui.RiskAny))):
+		q.Filter.RiskFilter = value
+	case field == "count":
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid count %q", value)
+		}
+		switch operator {
+		case ">":
+			q.MinCount = max(q.MinCount, count+1)
+		case ">=":
+			q.MinCount = max(q.MinCount, count)
+		case "<":
+			q.MaxCount = minCount(q.MaxCount, count-1)
+		case "<=":
+			q.MaxCount = minCount(q.MaxCount, count)
+		case "=":
+			q.MinCount = max(q.MinCount, count)
+			q.MaxCount = minCount(q.MaxCount, count)
+		default:
+			return fmt.Errorf("invalid condition %q", condition)
+		}
+	default:
+		return fmt.Errorf("invalid condition %q (want e.g. path~src, op=write, type=file or count>10)", condition)
+	}
+	return nil
+}
+
+// minCount lowers an upper bound, where zero means none
+func minCount(bound, count int) int {
+	if bound == 0 {
+		return max(count, 0)
+	}
+	return min(bound, count)
+}
+
+// Run returns the events of a session matching the query, in the order of
+// the session's event list
+func (q *Query) Run(session *ui.UI) []*ui.FileEvent {
+	session.GetState().Filter = q.Filter
+	var matching []*ui.FileEvent
+	for _, event := range session.GetFilteredEvents() {
+		if q.matchesBounds(event) {
+			matching = append(matching, event)
+		}
+	}
+	return matching
+}
+
+// Matches reports whether a single event matches the query, for filtering
+// live events
+func (q *Query) Matches(event *ui.FileEvent) bool {
+	return q.Filter.Matches(event) && q.matchesBounds(event)
+}
+
+// matchesBounds reports whether an event is within the count and time
+// bounds
+func (q *Query) matchesBounds(event *ui.FileEvent) bool {
+	count := max(event.Count, 1)
+	if (q.MinCount > 0 && count < q.MinCount) || (q.MaxCount > 0 && count > q.MaxCount) {
+		return false
+	}
+	if (!q.Since.IsZero() && event.Timestamp.Before(q.Since)) || (!q.Until.IsZero() && event.Timestamp.After(q.Until)) {
+		return false
+	}
+	return true
+}
+
+// ParseTime parses a --since or --until bound: RFC 3339, or a local date
+// with an optional time
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (want RFC 3339 or YYYY-MM-DD [HH:MM[:SS]])", value)
+}
+
+// Group is a group of matching events
+type Group struct {
+	Key     string `json:"key"`
+	Events  int    `json:"events"`  // Occurrences, aggregated events counting as many
+	Paths   int    `json:"paths"`   // Distinct paths
+	Records int    `json:"records"` // Events as stored
+}
+
+// GroupEvents groups events recorded under roots, by decreasing number of
+// occurrences
+func GroupEvents(events []*ui.FileEvent, by GroupBy, roots []string) []Group {
+	groups := make(map[string]*Group)
+	paths := make(map[string]map[string]bool)
+	for _, event := range events {
+		key := groupKey(event, by, roots)
+		group, ok := groups[key]
+		if !ok {
+			group = &Group{Key: key}
+			groups[key] = group
+			paths[key] = make(map[string]bool)
+		}
+		group.Events += max(event.Count, 1)
+		group.Records++
+		paths[key][event.Path] = true
+	}
+
+	sorted := make([]Group, 0, len(groups))
+	for key, group := range groups {
+		group.Paths = len(paths[key])
+		sorted = append(sorted, *group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Events != sorted[j].Events {
+			return sorted[i].Events > sorted[j].Events
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+// groupKey returns the group of an event
+func groupKey(event *ui.FileEvent, by GroupBy, roots []string) string {
+	switch by {
+	case GroupExt:
+		if event.IsDir {
+			return "(dir)"
+		}
+		if ext := strings.ToLower(filepath.Ext(event.Path)); ext != "" {
+			return ext
+		}
+		return "(none)"
+	case GroupOp:
+		return event.Operation.String()
+	case GroupHost:
+		if event.Host != "" {
+			return event.Host
+		}
+		return "(local)"
+	case GroupRoot:
+		if root := utils.RootOf(event.Path, roots); root != "" {
+			return root
+		}
+		return "(outside roots)"
+	default:
+		return filepath.ToSlash(filepath.Dir(utils.RelativePath(event.Path, roots)))
+	}
+}
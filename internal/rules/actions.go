@@ -0,0 +1,255 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/runner"
+)
+
+const (
+	// defaultCommandTimeout bounds a command run by a rule
+	defaultCommandTimeout = time.Minute
+	// defaultRetries is the number of webhook attempts after the first one
+	defaultRetries = 3
+	// defaultBackoff is the first delay between webhook attempts
+	defaultBackoff = time.Second
+	// maxBackoff caps the delay between webhook attempts
+	maxBackoff = time.Minute
+	// defaultWebhookTimeout bounds a webhook attempt
+	defaultWebhookTimeout = 10 * time.Second
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a webhook body, keyed
+// with the secret of the webhook and prefixed with "sha256="
+const SignatureHeader = "X-Watch-FS-Signature-256"
+
+// newAction builds a command, webhook or append action
+func newAction(configured config.RuleAction, output io.Writer) (*action, error) {
+	set := 0
+	for _, isSet := range []bool{len(configured.Command) > 0, configured.Webhook != nil, configured.Append != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, errors.New("want exactly one of command, webhook, append or highlight")
+	}
+
+	a := &action{jobs: make(chan Data, queueSize)}
+	var err error
+	switch {
+	case len(configured.Command) > 0:
+		a.kind = "command"
+		a.run, err = commandAction(configured.Command, configured.Timeout, output)
+	case configured.Webhook != nil:
+		a.kind = "webhook"
+		a.run, err = webhookAction(*configured.Webhook)
+	default:
+		a.kind = "append"
+		a.run, err = appendAction(*configured.Append)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// commandAction runs a program with templated arguments, without a shell;
+// a shell such as [sh, -c, "..."] can be given as the program
+func commandAction(command []string, timeout time.Duration, output io.Writer) (func(context.Context, Data) error, error) {
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	args := make([]*template.Template, len(command))
+	for i, arg := range command {
+		tmpl, err := template.New("arg").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command template %q: %w", arg, err)
+		}
+		args[i] = tmpl
+	}
+
+	return func(ctx context.Context, data Data) error {
+		argv := make([]string, len(args))
+		for i, tmpl := range args {
+			var b strings.Builder
+			if err := tmpl.Execute(&b, data); err != nil {
+				return fmt.Errorf("failed to expand command: %w", err)
+			}
+			argv[i] = b.String()
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		cmd.Env = append(os.Environ(), runner.ChangeEnv([]string{data.Path})...)
+		var captured bytes.Buffer
+		cmd.Stdout = &captured
+		cmd.Stderr = &captured
+		if output != nil {
+			cmd.Stdout = io.MultiWriter(&captured, output)
+			cmd.Stderr = io.MultiWriter(&captured, output)
+		}
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("%s timed out after %s", argv[0], timeout)
+			}
+			if last := lastLine(captured.String()); last != "" {
+				return fmt.Errorf("%s: %w: %s", argv[0], err, last)
+			}
+			return fmt.Errorf("%s: %w", argv[0], err)
+		}
+		return nil
+	}, nil
+}
+
+// lastLine returns the last non-empty line of an output
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// permanentError is a webhook failure retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// webhookAction posts events as JSON, retrying with an exponential backoff
+// on network errors, 429 and 5xx responses
+func webhookAction(webhook config.Webhook) (func(context.Context, Data) error, error) {
+	if webhook.URL == "" {
+		return nil, errors.New("webhook needs a url")
+	}
+	if !strings.HasPrefix(webhook.URL, "http://") && !strings.HasPrefix(webhook.URL, "https://") {
+		return nil, fmt.Errorf("invalid webhook url %q", webhook.URL)
+	}
+	retries := defaultRetries
+	if webhook.Retries != nil {
+		retries = max(*webhook.Retries, 0)
+	}
+	backoff := webhook.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	timeout := webhook.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	return func(ctx context.Context, data Data) error {
+		body, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		delay := backoff
+		for attempt := 0; ; attempt++ {
+			err = post(ctx, client, webhook, data.Rule, body)
+			var permanent *permanentError
+			if err == nil || errors.As(err, &permanent) {
+				return err
+			}
+			if attempt >= retries {
+				return fmt.Errorf("gave up after %d attempts: %w", attempt+1, err)
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			delay = min(delay*2, maxBackoff)
+		}
+	}, nil
+}
+
+// post makes one webhook attempt
+func post(ctx context.Context, client *http.Client, webhook config.Webhook, rule string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "watch-fs")
+	req.Header.Set("X-Watch-FS-Rule", rule)
+	if webhook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	default:
+		return &permanentError{err: fmt.Errorf("webhook answered %s", resp.Status)}
+	}
+}
+
+// Sign returns the value of SignatureHeader for a body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// appendAction appends a line per event to a file, opened at each firing
+// so that the file can be rotated
+func appendAction(appended config.Append) (func(context.Context, Data) error, error) {
+	if appended.Path == "" {
+		return nil, errors.New("append needs a path")
+	}
+	var tmpl *template.Template
+	if appended.Template != "" {
+		text := appended.Template
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		var err error
+		if tmpl, err = template.New("append").Option("missingkey=error").Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+	}
+
+	return func(ctx context.Context, data Data) error {
+		var line bytes.Buffer
+		if tmpl != nil {
+			if err := tmpl.Execute(&line, data); err != nil {
+				return fmt.Errorf("failed to expand template: %w", err)
+			}
+		} else if err := json.NewEncoder(&line).Encode(data); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		file, err := os.OpenFile(appended.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", appended.Path, err)
+		}
+		if _, err := file.Write(line.Bytes()); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("failed to write %s: %w", appended.Path, err)
+		}
+		return file.Close()
+	}, nil
+}
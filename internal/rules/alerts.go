@@ -0,0 +1,302 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/wait"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+const (
+	// evaluateEvery is how often time-based alert conditions are checked
+	evaluateEvery = time.Second
+	// Spike defaults
+	defaultSpikeFactor = 3
+	defaultSpikeWindow = time.Minute
+	defaultSpikeLearn  = time.Hour
+	defaultSpikeMin    = 10
+)
+
+// Alert states, as given to actions
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// alert is a compiled configuration alert
+type alert struct {
+	name    string
+	path    string
+	matcher wait.Matcher
+	above   *config.Rate
+	silence time.Duration
+	spike   *config.Spike // With defaults applied
+	actions []*action
+
+	hits      []time.Time // Events within the window of above or spike
+	last      time.Time   // Last event, or the start, for silence
+	baseline  float64     // Spike: average of the events within the window
+	samples   int         // Spike: evaluations averaged while learning
+	learned   time.Time   // Spike: end of the learning period
+	evaluated time.Time   // Last evaluation
+	state     ui.Alert
+}
+
+// compileAlert checks an alert and builds its matcher and actions
+func compileAlert(name string, a config.Alert, roots []string, ignored func(path string) bool, output io.Writer, start time.Time) (*alert, error) {
+	compiled := &alert{
+		name:      name,
+		matcher:   wait.Matcher{Roots: roots, Ignore: ignored},
+		last:      start,
+		evaluated: start,
+		state:     ui.Alert{Name: name, Since: start},
+	}
+	if a.Path != "" {
+		path, err := filepath.Abs(a.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+		compiled.path = path
+	}
+	if a.Match != "" {
+		pattern, err := utils.NewGlob(a.Match)
+		if err != nil {
+			return nil, err
+		}
+		compiled.matcher.Pattern = pattern
+	}
+	if a.Op != "" {
+		operations, err := console.ParseOperations(a.Op)
+		if err != nil {
+			return nil, err
+		}
+		compiled.matcher.Operations = operations
+	}
+
+	conditions := 0
+	if a.Above != nil {
+		if a.Above.Count < 0 || a.Above.Window <= 0 {
+			return nil, errors.New("above needs a count and a positive window")
+		}
+		compiled.above = a.Above
+		conditions++
+	}
+	if a.Silence != 0 {
+		if a.Silence < 0 {
+			return nil, errors.New("silence must be positive")
+		}
+		compiled.silence = a.Silence
+		conditions++
+	}
+	if a.Spike != nil {
+		spike := *a.Spike
+		if spike.Factor == 0 {
+			spike.Factor = defaultSpikeFactor
+		}
+		if spike.Window == 0 {
+			spike.Window = defaultSpikeWindow
+		}
+		if spike.Learn == 0 {
+			spike.Learn = defaultSpikeLearn
+		}
+		if spike.Min == 0 {
+			spike.Min = defaultSpikeMin
+		}
+		if spike.Factor <= 1 || spike.Window < 0 || spike.Learn < 0 || spike.Min < 0 {
+			return nil, errors.New("spike needs a factor above 1 and positive durations")
+		}
+		compiled.spike = &spike
+		compiled.learned = start.Add(spike.Learn)
+		conditions++
+	}
+	if conditions != 1 {
+		return nil, errors.New("want exactly one of above, silence or spike")
+	}
+
+	for i, configured := range a.Actions {
+		if configured.Highlight != "" {
+			return nil, fmt.Errorf("action %d: alerts cannot highlight events", i+1)
+		}
+		action, err := newAction(configured, output)
+		if err != nil {
+			return nil, fmt.Errorf("action %d: %w", i+1, err)
+		}
+		compiled.actions = append(compiled.actions, action)
+	}
+	return compiled, nil
+}
+
+// window returns the period events are counted over
+func (a *alert) window() time.Duration {
+	switch {
+	case a.above != nil:
+		return a.above.Window
+	case a.spike != nil:
+		return a.spike.Window
+	default:
+		return 0
+	}
+}
+
+// observe counts an event the alert considers
+func (a *alert) observe(event *ui.FileEvent) bool {
+	if a.path != "" && utils.RootOf(event.Path, []string{a.path}) == "" {
+		return false
+	}
+	if !a.matcher.Matches(fsnotify.Event{Name: event.Path, Op: event.Operation}) {
+		return false
+	}
+	a.last = event.Timestamp
+	if a.window() > 0 {
+		a.hits = append(a.hits, event.Timestamp)
+		// Above only needs the latest count+1 events to tell whether more
+		// than count happened within the window
+		if a.above != nil && len(a.hits) > a.above.Count+1 {
+			a.hits = a.hits[len(a.hits)-a.above.Count-1:]
+		}
+	}
+	return true
+}
+
+// count drops the events older than the window and counts the others
+func (a *alert) count(now time.Time) int {
+	since := now.Add(-a.window())
+	drop := 0
+	for drop < len(a.hits) && !a.hits[drop].After(since) {
+		drop++
+	}
+	a.hits = a.hits[drop:]
+	return len(a.hits)
+}
+
+// learn updates the spike baseline with the events within the window: an
+// average while learning, then a moving average over the learning period
+func (a *alert) learn(now time.Time, count int) {
+	elapsed := now.Sub(a.evaluated)
+	a.evaluated = now
+	if a.spike == nil || a.state.Firing {
+		return
+	}
+	if now.Before(a.learned) {
+		a.samples++
+		a.baseline += (float64(count) - a.baseline) / float64(a.samples)
+		return
+	}
+	weight := min(elapsed.Seconds()/a.spike.Learn.Seconds(), 1)
+	a.baseline += (float64(count) - a.baseline) * weight
+}
+
+// check tells whether the condition of the alert holds, and why
+func (a *alert) check(now time.Time) (bool, string) {
+	switch {
+	case a.above != nil:
+		count := a.count(now)
+		if count > a.above.Count {
+			return true, fmt.Sprintf("%d events in %s, above %d", count, a.above.Window, a.above.Count)
+		}
+		return false, fmt.Sprintf("%d events in %s", count, a.above.Window)
+	case a.silence > 0:
+		quiet := now.Sub(a.last)
+		if quiet >= a.silence {
+			return true, fmt.Sprintf("no events for %s", quiet.Round(time.Second))
+		}
+		return false, "events resumed"
+	default:
+		count := a.count(now)
+		if now.Before(a.learned) {
+			return false, "learning the baseline"
+		}
+		limit := a.spike.Factor * a.baseline
+		if count >= a.spike.Min && float64(count) > limit {
+			return true, fmt.Sprintf("%d events in %s, baseline %.1f", count, a.spike.Window, a.baseline)
+		}
+		return false, fmt.Sprintf("%d events in %s, baseline %.1f", count, a.spike.Window, a.baseline)
+	}
+}
+
+// update applies the outcome of a check, notifying the listeners and
+// queuing the actions when the state changes; e.mu must be held
+func (e *Engine) update(a *alert, now time.Time) {
+	firing, reason := a.check(now)
+	if firing == a.state.Firing {
+		return
+	}
+	a.state = ui.Alert{Name: a.name, Firing: firing, Reason: reason, Since: now}
+	state := StateResolved
+	if firing {
+		state = StateFiring
+		logger.Warn(fmt.Sprintf("Alert %s firing: %s", a.name, reason))
+	} else {
+		logger.Info(fmt.Sprintf("Alert %s resolved: %s", a.name, reason))
+	}
+	for _, listener := range e.alertListeners {
+		listener(a.state)
+	}
+
+	data := Data{
+		Record: console.Record{Path: a.path, Root: utils.RootOf(a.path, e.roots), Time: now},
+		Rule:   a.name,
+		Count:  len(a.hits),
+		State:  state,
+		Reason: reason,
+	}
+	e.queue(a.name, a.path, a.actions, data)
+}
+
+// Evaluate checks the time-based conditions of the alerts, such as
+// silences, as of now. The engine calls it every second.
+func (e *Engine) Evaluate(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	for _, a := range e.alerts {
+		if a.spike != nil {
+			a.learn(now, a.count(now))
+		}
+		e.update(a, now)
+	}
+}
+
+// evaluate runs Evaluate every second until the engine is closed
+func (e *Engine) evaluate() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(evaluateEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			e.Evaluate(now)
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// OnAlert registers a function called whenever an alert fires or resolves
+func (e *Engine) OnAlert(listener func(ui.Alert)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.alertListeners = append(e.alertListeners, listener)
+}
+
+// Alerts returns the state of every alert
+func (e *Engine) Alerts() []ui.Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	states := make([]ui.Alert, len(e.alerts))
+	for i, a := range e.alerts {
+		states[i] = a.state
+	}
+	return states
+}
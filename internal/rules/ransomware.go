@@ -0,0 +1,402 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+const (
+	// RansomwareRule names the containment firings of the detector
+	RansomwareRule = "ransomware"
+	// Detector defaults
+	defaultDetectionWindow   = 10 * time.Second
+	defaultDetectionCooldown = 5 * time.Minute
+	// renamePairing is how long after a rename a create in the same
+	// directory is taken as its new name
+	renamePairing = 2 * time.Second
+	// entropySample is the number of bytes read to estimate entropy
+	entropySample = 8 * 1024
+	// Entropy jump, in bits per byte: plain content below low rewritten
+	// above high, as compression or encryption does
+	entropyLow  = 6.0
+	entropyHigh = 7.5
+	// maxEntropyFiles bounds the files whose entropy is remembered
+	maxEntropyFiles = 50000
+	// Bounds of the initial walk learning extensions and entropies
+	maxWalkEntries = 200000
+	maxWalkSamples = 5000
+	// maxEvidence is the number of paths and extensions a detection shows
+	maxEvidence = 10
+)
+
+// sensitivities are the thresholds of each sensitivity: renames, written
+// files, removes and entropy jumps within the window
+var sensitivities = map[string][4]int{
+	"low":    {50, 500, 300, 25},
+	"medium": {20, 200, 100, 10},
+	"high":   {10, 100, 50, 5},
+}
+
+// hit is an event counted by the detector
+type hit struct {
+	time time.Time
+	path string
+	ext  string // Renames: the unfamiliar extension
+}
+
+// signal counts hits within the window against a threshold
+type signal struct {
+	threshold int // Off when -1
+	format    string
+	hits      []hit
+	distinct  map[string]int // Written files: hits per path
+}
+
+// add counts a hit
+func (s *signal) add(h hit) {
+	if s.threshold < 0 {
+		return
+	}
+	s.hits = append(s.hits, h)
+	if s.distinct != nil {
+		s.distinct[h.path]++
+	}
+}
+
+// count drops the hits older than the window and counts the others, or the
+// distinct paths among them
+func (s *signal) count(since time.Time) int {
+	drop := 0
+	for drop < len(s.hits) && !s.hits[drop].time.After(since) {
+		if s.distinct != nil {
+			if s.distinct[s.hits[drop].path]--; s.distinct[s.hits[drop].path] == 0 {
+				delete(s.distinct, s.hits[drop].path)
+			}
+		}
+		drop++
+	}
+	s.hits = s.hits[drop:]
+	if s.distinct != nil {
+		return len(s.distinct)
+	}
+	return len(s.hits)
+}
+
+// detector looks for the mass renames, rewrites and removals of
+// ransomware, from the events alone
+type detector struct {
+	window   time.Duration
+	cooldown time.Duration
+	entropy  bool
+
+	renames signal
+	writes  signal
+	removes signal
+	jumps   signal
+
+	known     map[string]bool      // Extensions seen in the roots
+	renamed   map[string]time.Time // Directories by time of their last rename
+	entropies map[string]float64   // Bits per byte of the files sampled
+	last      time.Time            // Last detection
+
+	containment *action
+	command     string
+}
+
+// compileDetector checks the ransomware settings and applies the thresholds
+// of the sensitivity
+func compileDetector(r config.Ransomware, output io.Writer) (*detector, error) {
+	sensitivity := strings.ToLower(r.Sensitivity)
+	if sensitivity == "" {
+		sensitivity = "medium"
+	}
+	preset, ok := sensitivities[sensitivity]
+	if !ok {
+		return nil, fmt.Errorf("unknown sensitivity %q (want low, medium or high)", r.Sensitivity)
+	}
+	if r.Window < 0 || r.Cooldown < 0 {
+		return nil, errors.New("window and cooldown must be positive")
+	}
+	d := &detector{
+		window:    r.Window,
+		cooldown:  r.Cooldown,
+		entropy:   r.Entropy,
+		known:     make(map[string]bool),
+		renamed:   make(map[string]time.Time),
+		entropies: make(map[string]float64),
+	}
+	if d.window == 0 {
+		d.window = defaultDetectionWindow
+	}
+	if d.cooldown == 0 {
+		d.cooldown = defaultDetectionCooldown
+	}
+	thresholds := []int{r.Renames, r.Writes, r.Removes, r.EntropyFiles}
+	for i, threshold := range thresholds {
+		switch {
+		case threshold == 0:
+			thresholds[i] = preset[i]
+		case threshold < -1:
+			return nil, errors.New("thresholds must be positive, or -1 to turn a signal off")
+		}
+	}
+	if !d.entropy {
+		thresholds[3] = -1
+	}
+	d.renames = signal{threshold: thresholds[0], format: "%d renames to unfamiliar extensions in %s"}
+	d.writes = signal{threshold: thresholds[1], format: "%d files written in %s", distinct: make(map[string]int)}
+	d.removes = signal{threshold: thresholds[2], format: "%d removals in %s"}
+	d.jumps = signal{threshold: thresholds[3], format: "%d files rewritten with high entropy in %s"}
+
+	if len(r.Containment) > 0 {
+		action, err := newAction(config.RuleAction{Command: r.Containment}, output)
+		if err != nil {
+			return nil, fmt.Errorf("containment: %w", err)
+		}
+		d.containment = action
+		d.command = strings.Join(r.Containment, " ")
+	}
+	return d, nil
+}
+
+// signals returns the signals in the order detections list them
+func (d *detector) signals() []*signal {
+	return []*signal{&d.renames, &d.writes, &d.removes, &d.jumps}
+}
+
+// observe counts an event and returns a detection when a threshold is
+// crossed outside the cooldown
+func (d *detector) observe(event *ui.FileEvent) *ui.Detection {
+	if event.Host != "" {
+		// Remote files cannot be sampled nor learned from
+		return nil
+	}
+	now := event.Timestamp
+	dir := filepath.Dir(event.Path)
+	ext := strings.ToLower(filepath.Ext(event.Path))
+	switch {
+	case event.Operation.Has(fsnotify.Rename):
+		d.renamed[dir] = now
+		delete(d.entropies, event.Path)
+	case event.Operation.Has(fsnotify.Remove):
+		d.removes.add(hit{time: now, path: event.Path})
+		delete(d.entropies, event.Path)
+	case event.Operation.Has(fsnotify.Create):
+		if renamed, ok := d.renamed[dir]; ok && now.Sub(renamed) <= renamePairing && !event.IsDir {
+			if ext != "" && !d.known[ext] {
+				d.renames.add(hit{time: now, path: event.Path, ext: ext})
+			}
+		} else if ext != "" {
+			d.known[ext] = true
+		}
+	case event.Operation.Has(fsnotify.Write) && !event.IsDir:
+		d.writes.add(hit{time: now, path: event.Path})
+		if d.entropy {
+			d.sample(event.Path, now)
+		}
+	}
+	d.forget(now)
+	return d.detect(now)
+}
+
+// sample reads the entropy of a written file, counting a jump from plain
+// to random-looking content
+func (d *detector) sample(path string, now time.Time) {
+	entropy, ok := entropyOf(path)
+	if !ok {
+		return
+	}
+	if previous, ok := d.entropies[path]; ok && previous < entropyLow && entropy >= entropyHigh {
+		d.jumps.add(hit{time: now, path: path})
+	}
+	if len(d.entropies) >= maxEntropyFiles {
+		clear(d.entropies)
+	}
+	d.entropies[path] = entropy
+}
+
+// forget drops the renames too old to pair with a create
+func (d *detector) forget(now time.Time) {
+	for dir, renamed := range d.renamed {
+		if now.Sub(renamed) > renamePairing {
+			delete(d.renamed, dir)
+		}
+	}
+}
+
+// detect checks the thresholds, building the evidence of a detection
+func (d *detector) detect(now time.Time) *ui.Detection {
+	since := now.Add(-d.window)
+	var crossed []*signal
+	var reasons []string
+	for _, s := range d.signals() {
+		count := s.count(since)
+		if s.threshold >= 0 && count >= s.threshold {
+			crossed = append(crossed, s)
+			reasons = append(reasons, fmt.Sprintf(s.format, count, d.window))
+		}
+	}
+	if len(crossed) == 0 || (!d.last.IsZero() && now.Sub(d.last) < d.cooldown) {
+		return nil
+	}
+	d.last = now
+
+	detection := &ui.Detection{Time: now, Signals: reasons}
+	for _, s := range crossed {
+		for i := len(s.hits) - 1; i >= 0 && len(detection.Paths) < maxEvidence; i-- {
+			if !slices.Contains(detection.Paths, s.hits[i].path) {
+				detection.Paths = append(detection.Paths, s.hits[i].path)
+			}
+		}
+	}
+	for _, h := range d.renames.hits {
+		if len(detection.Extensions) < maxEvidence && !slices.Contains(detection.Extensions, h.ext) {
+			detection.Extensions = append(detection.Extensions, h.ext)
+		}
+	}
+	slices.Sort(detection.Extensions)
+	if d.containment != nil {
+		detection.Containment = d.command
+	}
+	return detection
+}
+
+// learn walks the roots for the extensions they hold and, with entropy on,
+// the entropy of their files, until done
+func (d *detector) learn(roots []string, ignored func(path string) bool, done <-chan struct{}) (map[string]bool, map[string]float64) {
+	known := make(map[string]bool)
+	entropies := make(map[string]float64)
+	entries := 0
+	for _, root := range roots {
+		_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			select {
+			case <-done:
+				return filepath.SkipAll
+			default:
+			}
+			if entries++; entries > maxWalkEntries {
+				return filepath.SkipAll
+			}
+			if err != nil || (ignored != nil && ignored(path)) {
+				if entry != nil && entry.IsDir() && path != root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() || !entry.Type().IsRegular() {
+				return nil
+			}
+			if ext := strings.ToLower(filepath.Ext(path)); ext != "" {
+				known[ext] = true
+			}
+			if d.entropy && len(entropies) < maxWalkSamples {
+				if entropy, ok := entropyOf(path); ok {
+					entropies[path] = entropy
+				}
+			}
+			return nil
+		})
+	}
+	return known, entropies
+}
+
+// entropyOf returns the Shannon entropy, in bits per byte, of the start of
+// a file
+func entropyOf(path string) (float64, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer func() { _ = file.Close() }()
+	buf := make([]byte, entropySample)
+	n, err := io.ReadFull(file, buf)
+	if n == 0 || (err != nil && !errors.Is(err, io.ErrUnexpectedEOF)) {
+		return 0, false
+	}
+	var counts [256]int
+	for _, b := range buf[:n] {
+		counts[b]++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(n)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy, true
+}
+
+// detect counts an event for the ransomware detector; e.mu must be held
+func (e *Engine) detect(event *ui.FileEvent) {
+	detection := e.detector.observe(event)
+	if detection == nil {
+		return
+	}
+	reason := strings.Join(detection.Signals, "; ")
+	logger.Warn(fmt.Sprintf("Ransomware-like activity: %s", reason))
+	for _, listener := range e.detectionListeners {
+		listener(*detection)
+	}
+	if e.detector.containment == nil {
+		return
+	}
+	path := detection.Paths[0]
+	data := Data{
+		Record: console.Record{Path: path, Root: utils.RootOf(path, e.roots), Time: detection.Time},
+		Rule:   RansomwareRule,
+		Count:  len(detection.Paths),
+		State:  StateFiring,
+		Reason: reason,
+	}
+	e.queue(RansomwareRule, path, []*action{e.detector.containment}, data)
+}
+
+// learnRoots merges what a walk of the roots learned into the detector
+func (e *Engine) learnRoots(ignored func(path string) bool) {
+	defer e.wg.Done()
+	defer close(e.learning)
+	known, entropies := e.detector.learn(e.roots, ignored, e.ctx.Done())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ext := range known {
+		e.detector.known[ext] = true
+	}
+	for path, entropy := range entropies {
+		if _, ok := e.detector.entropies[path]; !ok {
+			e.detector.entropies[path] = entropy
+		}
+	}
+	logger.Debug(fmt.Sprintf("Ransomware detector learned %d extensions", len(known)))
+}
+
+// OnDetection registers a function called with the evidence of every
+// ransomware-like detection
+func (e *Engine) OnDetection(listener func(ui.Detection)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.detectionListeners = append(e.detectionListeners, listener)
+}
+
+// Learned waits for the walk learning the extensions and entropies of the
+// roots to end
+func (e *Engine) Learned() {
+	if e.learning != nil {
+		<-e.learning
+	}
+}
@@ -0,0 +1,432 @@
+// Package rules runs the actions of the configured rules on the events
+// they match: running a command, posting a webhook, appending to a file or
+// highlighting the event in the TUI. Alerts run the same actions when a
+// rate condition starts or stops holding, and the ransomware detector runs
+// its containment command on mass renames, rewrites or removals.
+//
+// Matching happens on the goroutine handling events, so that rates count
+// events in order. Each action then runs on its own goroutine, so that a
+// slow webhook does not hold back a command or the event stream.
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/metrics"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/wait"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// queueSize is the number of firings an action can fall behind by; later
+// ones are dropped and reported as failures
+const queueSize = 256
+
+// Data is an event as seen by action templates, webhooks and appended
+// JSON lines
+type Data struct {
+	console.Record
+	Rule   string `json:"rule"` // Name of the rule or alert
+	Host   string `json:"host,omitempty"`
+	Size   int64  `json:"size"` // Bytes, -1 when unknown, e.g. after a removal
+	Count  int    `json:"count"`
+	State  string `json:"state,omitempty"`  // Alerts: firing or resolved
+	Reason string `json:"reason,omitempty"` // Alerts: why the state changed
+}
+
+// Engine matches events against rules and alerts and runs their actions
+type Engine struct {
+	roots    []string
+	rules    []*rule
+	alerts   []*alert
+	detector *detector     // Nil unless configured
+	learning chan struct{} // Closed once the detector learned the roots
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu             sync.Mutex
+	closed         bool
+	listeners      []func(ui.RuleFiring)
+	alertListeners []func(ui.Alert)
+
+	detectionListeners []func(ui.Detection)
+}
+
+// rule is a compiled configuration rule
+type rule struct {
+	name      string
+	root      string
+	matcher   wait.Matcher
+	regex     *regexp.Regexp
+	files     bool
+	dirs      bool
+	minSize   int64
+	maxSize   int64 // No limit when zero
+	rate      *config.Rate
+	hits      []time.Time // Matching events within the rate window
+	highlight string
+	actions   []*action
+}
+
+// action is a command, webhook or append action and its queue
+type action struct {
+	kind string
+	run  func(ctx context.Context, data Data) error
+	jobs chan Data
+}
+
+// New compiles the rules and alerts of settings. Commands write their
+// output to output, or only report it on failure when output is nil.
+func New(settings config.Settings, roots []string, ignored func(path string) bool, output io.Writer) (*Engine, error) {
+	e := &Engine{roots: roots}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	for i, r := range settings.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		compiled, err := compile(name, r, roots, ignored, output)
+		if err != nil {
+			e.cancel()
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	start := time.Now()
+	for i, a := range settings.Alerts {
+		name := a.Name
+		if name == "" {
+			name = fmt.Sprintf("alert %d", i+1)
+		}
+		compiled, err := compileAlert(name, a, roots, ignored, output, start)
+		if err != nil {
+			e.cancel()
+			return nil, fmt.Errorf("alert %s: %w", name, err)
+		}
+		e.alerts = append(e.alerts, compiled)
+	}
+	if settings.Ransomware != nil {
+		detector, err := compileDetector(*settings.Ransomware, output)
+		if err != nil {
+			e.cancel()
+			return nil, fmt.Errorf("ransomware: %w", err)
+		}
+		e.detector = detector
+	}
+
+	for _, r := range e.rules {
+		for _, a := range r.actions {
+			e.wg.Add(1)
+			go e.work(r.name, a)
+		}
+	}
+	for _, a := range e.alerts {
+		for _, action := range a.actions {
+			e.wg.Add(1)
+			go e.work(a.name, action)
+		}
+	}
+	if len(e.alerts) > 0 {
+		e.wg.Add(1)
+		go e.evaluate()
+	}
+	if e.detector != nil {
+		if e.detector.containment != nil {
+			e.wg.Add(1)
+			go e.work(RansomwareRule, e.detector.containment)
+		}
+		e.learning = make(chan struct{})
+		e.wg.Add(1)
+		go e.learnRoots(ignored)
+	}
+	return e, nil
+}
+
+// compile checks a rule and builds its matcher and actions
+func compile(name string, r config.Rule, roots []string, ignored func(path string) bool, output io.Writer) (*rule, error) {
+	compiled := &rule{
+		name:    name,
+		files:   true,
+		dirs:    true,
+		minSize: int64(r.MinSize),
+		maxSize: int64(r.MaxSize),
+		matcher: wait.Matcher{Roots: roots, Ignore: ignored},
+	}
+	if r.Root != "" {
+		root, err := filepath.Abs(r.Root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root: %w", err)
+		}
+		compiled.root = root
+		compiled.matcher.Roots = []string{root}
+	}
+	if r.Match != "" {
+		pattern, err := utils.NewGlob(r.Match)
+		if err != nil {
+			return nil, err
+		}
+		compiled.matcher.Pattern = pattern
+	}
+	if r.Regex != "" {
+		regex, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		compiled.regex = regex
+	}
+	if r.Op != "" {
+		operations, err := console.ParseOperations(r.Op)
+		if err != nil {
+			return nil, err
+		}
+		compiled.matcher.Operations = operations
+	}
+	switch strings.ToLower(r.Type) {
+	case "":
+	case "file":
+		compiled.dirs = false
+	case "dir", "directory":
+		compiled.files = false
+	default:
+		return nil, fmt.Errorf("unknown type %q (want file or dir)", r.Type)
+	}
+	if r.MaxSize > 0 && r.MinSize > r.MaxSize {
+		return nil, errors.New("min_size is above max_size")
+	}
+	if r.Rate != nil {
+		if r.Rate.Count <= 0 || r.Rate.Window <= 0 {
+			return nil, errors.New("rate needs a positive count and window")
+		}
+		compiled.rate = r.Rate
+	}
+	if len(r.Actions) == 0 {
+		return nil, errors.New("no actions")
+	}
+	for i, configured := range r.Actions {
+		if configured.Highlight != "" {
+			if len(configured.Command) > 0 || configured.Webhook != nil || configured.Append != nil {
+				return nil, fmt.Errorf("action %d: want exactly one of command, webhook, append or highlight", i+1)
+			}
+			if !ui.IsHighlightColor(configured.Highlight) {
+				return nil, fmt.Errorf("action %d: unknown highlight color %q", i+1, configured.Highlight)
+			}
+			compiled.highlight = configured.Highlight
+			continue
+		}
+		a, err := newAction(configured, output)
+		if err != nil {
+			return nil, fmt.Errorf("action %d: %w", i+1, err)
+		}
+		compiled.actions = append(compiled.actions, a)
+	}
+	return compiled, nil
+}
+
+// OnFiring registers a function called with the outcome of every action,
+// from the goroutine of the action
+func (e *Engine) OnFiring(listener func(ui.RuleFiring)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, listener)
+}
+
+// Len returns the number of rules and alerts, counting the ransomware
+// detector as one
+func (e *Engine) Len() int {
+	n := len(e.rules) + len(e.alerts)
+	if e.detector != nil {
+		n++
+	}
+	return n
+}
+
+// Handle runs the rules matching an event and counts it for the alerts.
+// Highlighting happens before it returns; the other actions are queued.
+func (e *Engine) Handle(event *ui.FileEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	size := int64(-2) // Not read yet
+	for _, r := range e.rules {
+		if !r.matches(event, &size) {
+			continue
+		}
+		data := e.data(r.name, event, &size)
+		if r.highlight != "" {
+			event.Highlight = r.highlight
+			e.fire(ui.RuleFiring{Rule: r.name, Action: "highlight", Path: event.Path, Time: time.Now()})
+		}
+		e.queue(r.name, event.Path, r.actions, data)
+	}
+	for _, a := range e.alerts {
+		if a.observe(event) {
+			e.update(a, event.Timestamp)
+		}
+	}
+	if e.detector != nil {
+		e.detect(event)
+	}
+}
+
+// queue hands a firing to actions, reporting those too far behind; e.mu
+// must be held
+func (e *Engine) queue(name, path string, actions []*action, data Data) {
+	for _, a := range actions {
+		select {
+		case a.jobs <- data:
+		default:
+			metrics.Dropped("rules", 1)
+			e.fire(ui.RuleFiring{Rule: name, Action: a.kind, Path: path, Time: time.Now(),
+				Err: errors.New("too many firings queued, dropped")})
+		}
+	}
+}
+
+// matches reports whether an event fulfils every condition of the rule,
+// then counts it against the rate. size caches the size of the file
+// across rules.
+func (r *rule) matches(event *ui.FileEvent, size *int64) bool {
+	if (event.IsDir && !r.dirs) || (!event.IsDir && !r.files) {
+		return false
+	}
+	if r.root != "" && utils.RootOf(event.Path, []string{r.root}) == "" {
+		return false
+	}
+	if !r.matcher.Matches(fsnotify.Event{Name: event.Path, Op: event.Operation}) {
+		return false
+	}
+	if r.regex != nil && !r.regex.MatchString(event.Path) {
+		return false
+	}
+	if r.minSize > 0 || r.maxSize > 0 {
+		if *size == -2 {
+			*size = sizeOf(event)
+		}
+		if *size < 0 || *size < r.minSize || (r.maxSize > 0 && *size > r.maxSize) {
+			return false
+		}
+	}
+	if r.rate == nil {
+		return true
+	}
+
+	// Keep the hits within the window, firing on the count-th one
+	since := event.Timestamp.Add(-r.rate.Window)
+	kept := r.hits[:0]
+	for _, hit := range r.hits {
+		if hit.After(since) {
+			kept = append(kept, hit)
+		}
+	}
+	r.hits = append(kept, event.Timestamp)
+	if len(r.hits) < r.rate.Count {
+		return false
+	}
+	r.hits = r.hits[:0]
+	return true
+}
+
+// sizeOf returns the size of the file of a local event, or -1
+func sizeOf(event *ui.FileEvent) int64 {
+	if event.Host != "" || event.Operation.Has(fsnotify.Remove) || event.Operation.Has(fsnotify.Rename) {
+		return -1
+	}
+	info, err := os.Lstat(event.Path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// data builds the template and JSON view of an event
+func (e *Engine) data(name string, event *ui.FileEvent, size *int64) Data {
+	if *size == -2 {
+		*size = sizeOf(event)
+	}
+	return Data{
+		Record: console.Record{
+			Path:         event.Path,
+			RelativePath: utils.RelativePath(event.Path, e.roots),
+			Root:         utils.RootOf(event.Path, e.roots),
+			Op:           event.Operation.String(),
+			IsDir:        event.IsDir,
+			Time:         event.Timestamp,
+			Violation:    event.Violation,
+			Attrs:        event.Attrs,
+		},
+		Rule:  name,
+		Host:  event.Host,
+		Size:  *size,
+		Count: max(event.Count, 1),
+	}
+}
+
+// work runs the queued firings of an action until the engine is closed
+func (e *Engine) work(name string, a *action) {
+	defer e.wg.Done()
+	for data := range a.jobs {
+		if e.ctx.Err() != nil {
+			continue
+		}
+		err := a.run(e.ctx, data)
+		if err != nil {
+			logger.Error(err, fmt.Sprintf("Rule %s: %s action failed for %s", name, a.kind, data.Path))
+		} else {
+			logger.Debug(fmt.Sprintf("Rule %s: %s action ran for %s", name, a.kind, data.Path))
+		}
+		e.mu.Lock()
+		e.fire(ui.RuleFiring{Rule: name, Action: a.kind, Path: data.Path, Time: time.Now(), Err: err})
+		e.mu.Unlock()
+	}
+}
+
+// fire notifies the listeners of a firing; e.mu must be held
+func (e *Engine) fire(firing ui.RuleFiring) {
+	for _, listener := range e.listeners {
+		listener(firing)
+	}
+}
+
+// Close stops the actions, interrupting running commands and webhook
+// retries, and drops the queued firings
+func (e *Engine) Close() {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+	e.closed = true
+	e.cancel()
+	for _, r := range e.rules {
+		for _, a := range r.actions {
+			close(a.jobs)
+		}
+	}
+	for _, a := range e.alerts {
+		for _, action := range a.actions {
+			close(action.jobs)
+		}
+	}
+	if e.detector != nil && e.detector.containment != nil {
+		close(e.detector.containment.jobs)
+	}
+	e.mu.Unlock()
+	e.wg.Wait()
+}
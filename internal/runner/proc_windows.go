@@ -0,0 +1,20 @@
+//go:build windows
+
+package runner
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows, where processes have no group
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills the command; Windows has no SIGTERM
+func terminateProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
+
+// killProcessGroup kills the command
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,476 @@
+// Package runner re-runs a command when watched files change, in the
+// manner of entr or watchexec
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy decides what happens to changes arriving while the command runs
+type Policy int
+
+const (
+	PolicyRestart Policy = iota // Kill the running command and start it again
+	PolicyQueue                 // Run once more after the current run
+	PolicyIgnore                // Drop the changes
+)
+
+// ParsePolicy parses the name of a policy
+func ParsePolicy(name string) (Policy, error) {
+	switch strings.ToLower(name) {
+	case "restart":
+		return PolicyRestart, nil
+	case "queue":
+		return PolicyQueue, nil
+	case "ignore":
+		return PolicyIgnore, nil
+	default:
+		return PolicyRestart, fmt.Errorf("unknown policy %q (want restart, queue or ignore)", name)
+	}
+}
+
+// PolicyName returns the name of a policy
+func PolicyName(policy Policy) string {
+	switch policy {
+	case PolicyQueue:
+		return "queue"
+	case PolicyIgnore:
+		return "ignore"
+	default:
+		return "restart"
+	}
+}
+
+// State is the state of the command
+type State int
+
+const (
+	StateIdle      State = iota // Never run
+	StateRunning                // Running now
+	StateSucceeded              // Last run exited with 0
+	StateFailed                 // Last run exited with an error
+	StateKilled                 // Last run was killed for a restart or on close
+)
+
+// StateName returns the name of a state
+func StateName(state State) string {
+	switch state {
+	case StateRunning:
+		return "running"
+	case StateSucceeded:
+		return "succeeded"
+	case StateFailed:
+		return "failed"
+	case StateKilled:
+		return "killed"
+	default:
+		return "idle"
+	}
+}
+
+// Environment variables describing the changes that triggered a run
+const (
+	EnvChangedPath  = "WATCH_FS_CHANGED_PATH"  // First changed path
+	EnvChangedPaths = "WATCH_FS_CHANGED_PATHS" // Every changed path, one per line
+)
+
+// Argument placeholders replaced by the changed paths
+const (
+	PlaceholderPath  = "{path}"  // First changed path
+	PlaceholderPaths = "{paths}" // Every changed path, as separate arguments when alone
+)
+
+const (
+	// DefaultDebounce is the quiet period waited for before running
+	DefaultDebounce = 200 * time.Millisecond
+	// DefaultOutputLines is the number of output lines kept for display
+	DefaultOutputLines = 200
+	// killGrace is how long a command has to exit after SIGTERM
+	killGrace = 2 * time.Second
+)
+
+// Config configures a Runner
+type Config struct {
+	Command     []string      // Program and arguments, with optional placeholders
+	Dir         string        // Working directory, the current one when empty
+	Debounce    time.Duration // Quiet period before running, DefaultDebounce when zero
+	Policy      Policy        // What to do with changes during a run
+	Stdout      io.Writer     // Receives the command output besides the kept lines
+	Stderr      io.Writer     // Receives the command errors besides the kept lines
+	OutputLines int           // Output lines kept for display, DefaultOutputLines when zero
+
+	// Build returns the command line of a run from the changed paths, in
+	// place of Command and its placeholders. An empty command line skips
+	// the run.
+	Build func(changed []string) ([]string, error)
+}
+
+// Status is a snapshot of the runner
+type Status struct {
+	State    State
+	Runs     int           // Number of runs started
+	ExitCode int           // Exit code of the last finished run
+	Started  time.Time     // Start of the current or last run
+	Duration time.Duration // Duration of the last finished run
+	Changed  []string      // Paths that triggered the current or last run
+	Pending  int           // Paths queued for the next run
+	Output   []string      // Last output lines
+	Err      error         // Error starting the last run
+}
+
+// Runner runs a command on file changes
+type Runner struct {
+	config Config
+
+	mu       sync.Mutex
+	status   Status
+	pending  map[string]bool // Changes waiting for the debounce or the current run
+	timer    *time.Timer
+	cmd      *exec.Cmd
+	done     chan struct{} // Closed when the current run ends
+	queued   bool          // A run is due once the current one ends
+	closed   bool
+	onChange []func()
+}
+
+// New creates a runner
+func New(config Config) (*Runner, error) {
+	if len(config.Command) == 0 && config.Build == nil {
+		return nil, errors.New("no command to run")
+	}
+	if config.Debounce <= 0 {
+		config.Debounce = DefaultDebounce
+	}
+	if config.OutputLines <= 0 {
+		config.OutputLines = DefaultOutputLines
+	}
+	return &Runner{config: config, pending: make(map[string]bool)}, nil
+}
+
+// OnChange registers a function called whenever the status changes
+func (r *Runner) OnChange(listener func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onChange = append(r.onChange, listener)
+}
+
+// Command returns the configured command line
+func (r *Runner) Command() []string {
+	return r.config.Command
+}
+
+// Policy returns the configured policy
+func (r *Runner) Policy() Policy {
+	return r.config.Policy
+}
+
+// Status returns a snapshot of the runner
+func (r *Runner) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status
+	status.Changed = append([]string(nil), r.status.Changed...)
+	status.Output = append([]string(nil), r.status.Output...)
+	status.Pending = len(r.pending)
+	return status
+}
+
+// Notify records a changed path; the command runs once no change arrived
+// for the debounce period
+func (r *Runner) Notify(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if r.cmd != nil && r.config.Policy == PolicyIgnore {
+		return
+	}
+	r.pending[path] = true
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.config.Debounce, r.trigger)
+	} else {
+		r.timer.Reset(r.config.Debounce)
+	}
+}
+
+// Trigger runs the command now with the pending changes, applying the policy
+// if it is already running
+func (r *Runner) Trigger() {
+	r.mu.Lock()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mu.Unlock()
+	r.trigger()
+}
+
+// trigger applies the policy once the debounce period is over. The command
+// line is built without holding r.mu, since Build may take a while, e.g.
+// to run go list, and Notify must not wait for it.
+func (r *Runner) trigger() {
+	r.mu.Lock()
+	for {
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+		for r.cmd != nil {
+			switch r.config.Policy {
+			case PolicyIgnore:
+				clear(r.pending)
+				r.mu.Unlock()
+				return
+			case PolicyQueue:
+				r.queued = true
+				r.mu.Unlock()
+				r.notify()
+				return
+			default:
+				// Restart: stop the current run, which another trigger may
+				// have replaced by the time the lock is taken again
+				r.mu.Unlock()
+				r.stop()
+				r.mu.Lock()
+				if r.closed {
+					r.mu.Unlock()
+					return
+				}
+			}
+		}
+
+		changed := make([]string, 0, len(r.pending))
+		for path := range r.pending {
+			changed = append(changed, path)
+		}
+		sort.Strings(changed)
+		clear(r.pending)
+		r.queued = false
+		r.mu.Unlock()
+
+		argv, err := r.commandLine(changed)
+
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+		if r.cmd == nil {
+			r.startLocked(changed, argv, err)
+			break
+		}
+		// Another trigger started a run meanwhile: apply the policy to
+		// these changes too
+		for _, path := range changed {
+			r.pending[path] = true
+		}
+	}
+	r.mu.Unlock()
+	r.notify()
+}
+
+// commandLine returns the command line of a run for changed paths
+func (r *Runner) commandLine(changed []string) ([]string, error) {
+	if r.config.Build != nil {
+		return r.config.Build(changed)
+	}
+	argv := r.config.Command
+	return append(argv[:1:1], ExpandArgs(argv[1:], changed)...), nil
+}
+
+// startLocked starts a run of argv for changed paths, or records why it
+// could not be built; r.mu must be held
+func (r *Runner) startLocked(changed, argv []string, err error) {
+	if err != nil {
+		r.status.Runs++
+		r.status.Started = time.Now()
+		r.status.Changed = changed
+		r.status.State = StateFailed
+		r.status.ExitCode = -1
+		r.status.Err = err
+		return
+	}
+	if len(argv) == 0 {
+		return
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = r.config.Dir
+	cmd.Env = append(os.Environ(), ChangeEnv(changed)...)
+	setProcessGroup(cmd)
+
+	output, writer := io.Pipe()
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	if r.config.Stdout != nil {
+		cmd.Stdout = io.MultiWriter(writer, r.config.Stdout)
+	}
+	if r.config.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(writer, r.config.Stderr)
+	}
+
+	r.status.Runs++
+	r.status.Started = time.Now()
+	r.status.Changed = changed
+	r.status.Output = nil
+	r.status.Err = nil
+
+	if err := cmd.Start(); err != nil {
+		_ = writer.Close()
+		_ = output.Close()
+		r.status.State = StateFailed
+		r.status.ExitCode = -1
+		r.status.Err = err
+		return
+	}
+
+	r.status.State = StateRunning
+	r.cmd = cmd
+	done := make(chan struct{})
+	r.done = done
+
+	lines := make(chan struct{})
+	go r.collect(output, lines)
+	go r.wait(cmd, writer, lines, done)
+}
+
+// collect keeps the last output lines of a run
+func (r *Runner) collect(output io.ReadCloser, finished chan<- struct{}) {
+	defer close(finished)
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		r.mu.Lock()
+		r.status.Output = append(r.status.Output, scanner.Text())
+		if extra := len(r.status.Output) - r.config.OutputLines; extra > 0 {
+			r.status.Output = r.status.Output[extra:]
+		}
+		r.mu.Unlock()
+		r.notify()
+	}
+	// Drain the pipe if the scanner gave up on a huge line
+	_, _ = io.Copy(io.Discard, output)
+	_ = output.Close()
+}
+
+// wait records the end of a run and starts the queued one
+func (r *Runner) wait(cmd *exec.Cmd, writer *io.PipeWriter, lines <-chan struct{}, done chan struct{}) {
+	err := cmd.Wait()
+	_ = writer.Close()
+	<-lines
+
+	r.mu.Lock()
+	killed := r.status.State == StateKilled
+	r.status.Duration = time.Since(r.status.Started)
+	r.status.ExitCode = cmd.ProcessState.ExitCode()
+	switch {
+	case killed:
+	case err != nil:
+		r.status.State = StateFailed
+	default:
+		r.status.State = StateSucceeded
+	}
+	r.cmd = nil
+	close(done)
+	queued := r.queued && !r.closed
+	r.mu.Unlock()
+	r.notify()
+
+	if queued {
+		r.trigger()
+	}
+}
+
+// stop kills the running command and its children, and waits for it
+func (r *Runner) stop() {
+	r.mu.Lock()
+	cmd, done := r.cmd, r.done
+	if cmd == nil {
+		r.mu.Unlock()
+		return
+	}
+	r.status.State = StateKilled
+	r.queued = false
+	r.mu.Unlock()
+
+	terminateProcessGroup(cmd)
+	select {
+	case <-done:
+	case <-time.After(killGrace):
+		killProcessGroup(cmd)
+		<-done
+	}
+}
+
+// Close stops the runner and kills the running command
+func (r *Runner) Close() {
+	r.mu.Lock()
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mu.Unlock()
+	r.stop()
+}
+
+// Wait blocks until the current run, if any, has finished
+func (r *Runner) Wait() {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// notify calls the change listeners
+func (r *Runner) notify() {
+	r.mu.Lock()
+	listeners := append([]func(){}, r.onChange...)
+	r.mu.Unlock()
+	for _, listener := range listeners {
+		listener()
+	}
+}
+
+// ExpandArgs replaces the path placeholders in command arguments. An
+// argument that is exactly {paths} becomes one argument per path.
+func ExpandArgs(args []string, changed []string) []string {
+	first := ""
+	if len(changed) > 0 {
+		first = changed[0]
+	}
+
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == PlaceholderPaths {
+			expanded = append(expanded, changed...)
+			continue
+		}
+		arg = strings.ReplaceAll(arg, PlaceholderPath, first)
+		arg = strings.ReplaceAll(arg, PlaceholderPaths, strings.Join(changed, " "))
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// ChangeEnv returns the environment variables describing changed paths
+func ChangeEnv(changed []string) []string {
+	first := ""
+	if len(changed) > 0 {
+		first = changed[0]
+	}
+	return []string{
+		EnvChangedPath + "=" + first,
+		EnvChangedPaths + "=" + strings.Join(changed, "\n"),
+	}
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/query"
+	"github.com/pbouamriou/watch-fs/internal/stats"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+const (
+	// DefaultPageSize is the number of events /api/events returns by default
+	DefaultPageSize = 100
+	// MaxPageSize is the largest page /api/events returns
+	MaxPageSize = 5000
+)
+
+// EventPage is a page of /api/events
+type EventPage struct {
+	Total  int     `json:"total"` // Matching events
+	Offset int     `json:"offset"`
+	Limit  int     `json:"limit"`
+	Events []Event `json:"events"`
+}
+
+// ParseQuery builds a query from the filters of a query string:
+// path (substring), op (e.g. create|write), type (file or dir), host,
+// risk (any, or text of a risk as in the risk filter of the
+// configuration), min_count, max_count, since, until and where (a query
+// expression)
+func ParseQuery(values url.Values) (*query.Query, error) {
+	q := query.New()
+	if path := values.Get("path"); path != "" {
+		q.Filter.PathFilter = path
+	}
+	if op := values.Get("op"); op != "" {
+		operation, err := console.ParseOperations(strings.ReplaceAll(op, "|", ","))
+		if err != nil {
+			return nil, err
+		}
+		q.Filter.OperationFilter = operation
+	}
+	if host := values.Get("host"); host != "" {
+		q.Filter.HostFilter = host
+	}
+	if risk := values.Get("risk"); risk != "" {
+		q.Filter.RiskFilter = risk
+	}
+	switch kind := values.Get("type"); kind {
+	case "":
+	case "file":
+		q.Filter.ShowDirs = false
+	case "dir":
+		q.Filter.ShowFiles = false
+	default:
+		return nil, fmt.Errorf("unknown type %q (want file or dir)", kind)
+	}
+	for _, bound := range []struct {
+		name  string
+		count *int
+	}{{"min_count", &q.MinCount}, {"max_count", &q.MaxCount}} {
+		if value := values.Get(bound.name); value != "" {
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid %s %q", bound.name, value)
+			}
+			*bound.count = count
+		}
+	}
+	var err error
+	if since := values.Get("since"); since != "" {
+		if q.Since, err = query.ParseTime(since); err != nil {
+			return nil, err
+		}
+	}
+	if until := values.Get("until"); until != "" {
+		if q.Until, err = query.ParseTime(until); err != nil {
+			return nil, err
+		}
+	}
+	for _, expr := range values["where"] {
+		if err := q.Where(expr); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// handleEvents implements GET /api/events: the stored events matching the
+// filters, sorted by sort (time, path, operation or count) and paged with
+// offset and limit
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	q, err := ParseQuery(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	sortOption, err := parseSort(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, err := intParam(values, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := intParam(values, "limit", DefaultPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit = min(limit, MaxPageSize)
+
+	roots := s.watcher.GetRoots()
+	var page EventPage
+	s.withView(q, sortOption, func(matching []*ui.FileEvent) {
+		page = EventPage{Total: len(matching), Offset: offset, Limit: limit, Events: []Event{}}
+		for i := offset; i < len(matching) && i < offset+limit; i++ {
+			page.Events = append(page.Events, NewEvent(matching[i], roots))
+		}
+	})
+	writeJSON(w, http.StatusOK, page)
+}
+
+// withView calls fn with the stored events matching q in a sort. The
+// session shows that view during the call, then its own again.
+func (s *Server) withView(q *query.Query, sortOption ui.SortOption, fn func([]*ui.FileEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.session.GetState()
+	filter, previousSort := state.Filter, state.SortOption
+	defer func() { state.Filter, state.SortOption = filter, previousSort }()
+	state.SortOption = sortOption
+	fn(q.Run(s.session))
+}
+
+// parseSort parses the sort parameter, by time when missing
+func parseSort(values url.Values) (ui.SortOption, error) {
+	name := values.Get("sort")
+	if name == "" {
+		return ui.SortByTime, nil
+	}
+	sortOption, ok := ui.ParseSortOption(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown sort %q (want time, path, operation or count)", name)
+	}
+	return sortOption, nil
+}
+
+// handleStats implements GET /api/stats: a summary of the stored events,
+// with the top busiest paths
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	top, err := intParam(r.URL.Query(), "top", 10)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	roots := s.watcher.GetRoots()
+	s.mu.Lock()
+	summary := stats.Summarize(s.session.GetState().Events, roots, top)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// handleRoots implements GET /api/roots
+func (s *Server) handleRoots(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Roots())
+}
+
+// rootRequest is the body of POST /api/roots
+type rootRequest struct {
+	Path string `json:"path"`
+}
+
+// handleAddRoot implements POST /api/roots with a {"path": ...} body or a
+// path parameter, and returns the roots
+func (s *Server) handleAddRoot(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		var request rootRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		path = request.Path
+	}
+	if path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing path"))
+		return
+	}
+	if err := s.AddRoot(path); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.Roots())
+}
+
+// handleRemoveRoot implements DELETE /api/roots?path=... and returns the
+// roots
+func (s *Server) handleRemoveRoot(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing path"))
+		return
+	}
+	if s.findRoot(path) == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not watched", path))
+		return
+	}
+	if err := s.RemoveRoot(path); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Roots())
+}
+
+// intParam parses a non-negative integer parameter
+func intParam(values url.Values, name string, fallback int) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Error(err, "Failed to write response")
+	}
+}
+
+// writeError writes an error as {"error": message}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,294 @@
+// Package server serves the events of a watcher over HTTP: a REST API to
+// list events and manage roots, live streams over Server-Sent Events and
+// WebSocket, Prometheus metrics and a web UI
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+	"github.com/pbouamriou/watch-fs/internal/metrics"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// Event is an event as served by the API and the streams
+type Event struct {
+	Path         string        `json:"path"`
+	RelativePath string        `json:"relative_path"` // Slash-separated, relative to Root
+	Root         string        `json:"root"`
+	Host         string        `json:"host,omitempty"` // Host of a collector, empty for local events
+	Op           string        `json:"op"`
+	IsDir        bool          `json:"is_dir"`
+	Count        int           `json:"count"`
+	Time         time.Time     `json:"time"`
+	Attrs        *attrs.Change `json:"attrs,omitempty"` // Mode and owner before and after a CHMOD event
+}
+
+// NewEvent converts an event recorded under roots
+func NewEvent(event *ui.FileEvent, roots []string) Event {
+	return Event{
+		Path:         event.Path,
+		RelativePath: utils.RelativePath(event.Path, roots),
+		Root:         utils.RootOf(event.Path, roots),
+		Host:         event.Host,
+		Op:           event.Operation.String(),
+		IsDir:        event.IsDir,
+		Count:        max(event.Count, 1),
+		Time:         event.Timestamp,
+		Attrs:        event.Attrs,
+	}
+}
+
+// Root is a watched root
+type Root struct {
+	Path    string `json:"path"`
+	Watched int    `json:"watched"` // Watched directories under the root
+}
+
+// Server keeps the events of a watcher in a headless UI session and serves
+// them over HTTP
+type Server struct {
+	watcher *watcher.Watcher
+
+	mu      sync.Mutex // Protects the session, which is not safe for concurrent use
+	session *ui.UI
+
+	subMu       sync.Mutex
+	subscribers map[*subscriber]bool
+
+	listeners []func(*ui.FileEvent) // Called for every received event
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a server over a watcher, with the view defaults of settings
+func New(fileWatcher *watcher.Watcher, settings ui.Settings) *Server {
+	session := ui.NewUI(fileWatcher, fileWatcher.GetRoot())
+	session.ApplySettings(settings)
+	return &Server{
+		watcher:     fileWatcher,
+		session:     session,
+		subscribers: make(map[*subscriber]bool),
+		closed:      make(chan struct{}),
+	}
+}
+
+// OnEvent registers a function called for every received event; register
+// listeners before calling Watch
+func (s *Server) OnEvent(listener func(*ui.FileEvent)) {
+	s.listeners = append(s.listeners, listener)
+}
+
+// Watch records and streams the events of the watcher until it closes or
+// the server is closed
+func (s *Server) Watch() {
+	for {
+		select {
+		case event, ok := <-s.watcher.Events():
+			if !ok {
+				return
+			}
+			if s.watcher.Ignored(event.Name) {
+				continue
+			}
+			isDir := false
+			if info, err := os.Stat(event.Name); err == nil {
+				isDir = info.IsDir()
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create && isDir {
+				_ = s.watcher.AddDirectory(event.Name)
+			}
+			s.record(&ui.FileEvent{
+				Path:      event.Name,
+				Operation: event.Op,
+				Timestamp: time.Now(),
+				IsDir:     isDir,
+				Count:     1,
+				Attrs:     s.watcher.AttrChange(event),
+			})
+		case err, ok := <-s.watcher.Errors():
+			if !ok {
+				return
+			}
+			logger.Error(err, "Watcher error")
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// record stores a copy of an event, with every field, then notifies the
+// listeners and the streams
+func (s *Server) record(event *ui.FileEvent) {
+	recorded := *event
+	s.mu.Lock()
+	s.session.RecordEvent(&recorded)
+	s.mu.Unlock()
+
+	for _, listener := range s.listeners {
+		listener(event)
+	}
+	s.publish(event)
+}
+
+// Handler returns the HTTP handler of the API and the web UI
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/events", s.handleEvents)
+	mux.HandleFunc("GET /api/stats", s.handleStats)
+	mux.HandleFunc("GET /api/roots", s.handleRoots)
+	mux.HandleFunc("POST /api/roots", s.handleAddRoot)
+	mux.HandleFunc("DELETE /api/roots", s.handleRemoveRoot)
+	mux.HandleFunc("GET /api/stream", s.handleSSE)
+	mux.HandleFunc("GET /api/ws", s.handleWebSocket)
+	mux.HandleFunc("GET /api/settings", s.handleSettings)
+	mux.HandleFunc("PUT /api/settings", s.handleUpdateSettings)
+	mux.HandleFunc("GET /api/info", s.handleInfo)
+	mux.HandleFunc("GET /api/browse", s.handleBrowse)
+	mux.HandleFunc("GET /api/export", s.handleExport)
+	mux.Handle("GET /metrics", metrics.Handler(s.watcher))
+	mux.Handle("GET /", webHandler())
+	return sameSite(mux)
+}
+
+// sameSite refuses the requests other websites could make from a browser:
+// WebSocket upgrades and requests changing state whose Origin is not the
+// server, and requests changing state that are not JSON, which cross-site
+// forms send without a preflight
+func sameSite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		changes := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+		if (changes || headerContains(r.Header, "Upgrade", "websocket")) && !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin request from %s refused", r.Header.Get("Origin")))
+			return
+		}
+		if changes {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("requests changing state must be sent as application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether the Origin of a request, when it has one as
+// requests from browsers do, is the host the request was sent to
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+// Close ends the streams and stops Watch; the watcher stays open
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// Snapshot exports every stored event to a file
+func (s *Server) Snapshot(filename string, format ui.ExportFormat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session.Snapshot(filename, format)
+}
+
+// History returns a copy of the stored events, oldest first
+func (s *Server) History() []*ui.FileEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.session.GetState().Events
+	history := make([]*ui.FileEvent, len(events))
+	for i, event := range events {
+		copied := *event
+		history[i] = &copied
+	}
+	return history
+}
+
+// ApplySettings applies new view defaults, such as the retention, to the
+// stored events
+func (s *Server) ApplySettings(settings ui.Settings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session.ApplySettings(settings)
+}
+
+// Roots returns the watched roots
+func (s *Server) Roots() []Root {
+	roots := s.watcher.GetRoots()
+	result := make([]Root, 0, len(roots))
+	for _, root := range roots {
+		result = append(result, Root{Path: root, Watched: s.watcher.GetWatchedCountForRoot(root)})
+	}
+	return result
+}
+
+// AddRoot starts watching a directory
+func (s *Server) AddRoot(path string) error {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("invalid directory '%s': %w", path, err)
+	}
+	if err := utils.ValidateDirectory(absolute); err != nil {
+		return fmt.Errorf("invalid directory '%s': %w", path, err)
+	}
+	if s.findRoot(absolute) != "" {
+		return nil
+	}
+	if err := s.watcher.AddRoot(absolute); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", absolute, err)
+	}
+	s.refreshRoots()
+	return nil
+}
+
+// RemoveRoot stops watching a root; the events recorded under it are kept
+func (s *Server) RemoveRoot(path string) error {
+	root := s.findRoot(path)
+	if root == "" {
+		return fmt.Errorf("%s is not watched", path)
+	}
+	if err := s.watcher.RemoveRoot(root); err != nil {
+		return fmt.Errorf("failed to stop watching %s: %w", root, err)
+	}
+	s.refreshRoots()
+	return nil
+}
+
+// findRoot returns the watched root designating the same directory as
+// path, empty when there is none
+func (s *Server) findRoot(path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	for _, root := range s.watcher.GetRoots() {
+		if rootAbs, err := filepath.Abs(root); err == nil && rootAbs == absolute {
+			return root
+		}
+	}
+	return ""
+}
+
+// refreshRoots updates the roots recorded in exports
+func (s *Server) refreshRoots() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session.RefreshRoots()
+}
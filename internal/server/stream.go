@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/metrics"
+	"github.com/pbouamriou/watch-fs/internal/query"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+const (
+	// streamBuffer is the number of events a slow stream may lag behind
+	// before events are dropped for it
+	streamBuffer = 256
+	// keepAlive is the interval of SSE comments and WebSocket pings keeping
+	// idle connections open through proxies
+	keepAlive = 15 * time.Second
+)
+
+// subscriber is a live stream and its filters
+type subscriber struct {
+	query  *query.Query
+	events chan []byte // JSON-encoded events
+}
+
+// subscribe starts relaying the events matching q
+func (s *Server) subscribe(q *query.Query) *subscriber {
+	sub := &subscriber{query: q, events: make(chan []byte, streamBuffer)}
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	s.subscribers[sub] = true
+	return sub
+}
+
+// unsubscribe stops relaying events to a stream
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	delete(s.subscribers, sub)
+}
+
+// publish sends an event to the streams it matches, dropping it for the
+// streams that lag behind
+func (s *Server) publish(event *ui.FileEvent) {
+	var data []byte
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for sub := range s.subscribers {
+		if !sub.query.Matches(event) {
+			continue
+		}
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(NewEvent(event, s.watcher.GetRoots())); err != nil {
+				logger.Error(err, "Failed to encode event")
+				return
+			}
+		}
+		select {
+		case sub.events <- data:
+		default:
+			metrics.Dropped("stream", 1)
+		}
+	}
+}
+
+// handleSSE implements GET /api/stream: matching events as Server-Sent
+// Events, with the filters of /api/events
+func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	sub := s.subscribe(q)
+	defer s.unsubscribe(sub)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-sub.events:
+			if _, err := fmt.Fprintf(w, "event: event\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// handleWebSocket implements GET /api/ws: matching events as WebSocket
+// text messages, with the filters of /api/events
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logger.Error(err, "Failed to close WebSocket")
+		}
+	}()
+
+	sub := s.subscribe(q)
+	defer s.unsubscribe(sub)
+	// The client only sends pings and the closing handshake
+	disconnected := make(chan struct{})
+	go conn.readLoop(disconnected)
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-sub.events:
+			if err := conn.writeFrame(opText, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		case <-disconnected:
+			return
+		case <-s.closed:
+			_ = conn.writeFrame(opClose, closePayload(closeGoingAway))
+			return
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// webFiles is the web UI, served from /
+//
+//go:embed web
+var webFiles embed.FS
+
+// ExportFormats are the values of the format parameter of /api/export, as
+// export file extensions
+var ExportFormats = []string{"db", "db.gz", "db.zst", "json", "json.gz", "json.zst"}
+
+// webHandler serves the embedded web UI
+func webHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err) // The directory is embedded at build time
+	}
+	return http.FileServerFS(files)
+}
+
+// Settings are the session settings the web UI changes
+type Settings struct {
+	Aggregate bool `json:"aggregate"` // Merge repeated events on a path within a second
+}
+
+// handleSettings implements GET /api/settings
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	settings := Settings{Aggregate: s.session.GetState().AggregateEvents}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// handleUpdateSettings implements PUT /api/settings; like the TUI, changing
+// the aggregation only affects the events received afterwards
+func (s *Server) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var settings Settings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	s.ApplySettings(ui.Settings{AggregateEvents: &settings.Aggregate})
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// FileInfo describes the current state of the file of an event
+type FileInfo struct {
+	Path     string    `json:"path"`
+	Exists   bool      `json:"exists"`
+	IsDir    bool      `json:"is_dir,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Mode     string    `json:"mode,omitempty"`
+	Modified time.Time `json:"modified,omitzero"`
+}
+
+// handleInfo implements GET /api/info?path=...: the size, permissions and
+// modification time shown in the event details, for paths under a root
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing path"))
+		return
+	}
+	path = filepath.Clean(path)
+	if utils.RootOf(path, s.watcher.GetRoots()) == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not under a watched directory", path))
+		return
+	}
+	info := FileInfo{Path: path}
+	if stat, err := os.Stat(path); err == nil {
+		info.Exists = true
+		info.IsDir = stat.IsDir()
+		info.Size = stat.Size()
+		info.Mode = stat.Mode().String()
+		info.Modified = stat.ModTime()
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// Directory is a directory listed by the folder browser
+type Directory struct {
+	Path   string   `json:"path"`
+	Parent string   `json:"parent,omitempty"` // Empty at the top of the file system
+	Dirs   []Subdir `json:"dirs"`
+}
+
+// Subdir is a subdirectory listed by the folder browser
+type Subdir struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Watching bool   `json:"watching"`
+}
+
+// handleBrowse implements GET /api/browse?path=...: the subdirectories the
+// folder manager can add, not ignored, starting from the working directory.
+// Only the working directory, the watched directories and their
+// subdirectories are listed.
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "."
+	}
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid directory '%s': %w", path, err))
+		return
+	}
+	if !s.browsable(absolute) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s is outside the working directory and the watched directories", absolute))
+		return
+	}
+	entries, err := os.ReadDir(absolute)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("failed to read directory: %w", err))
+		return
+	}
+
+	directory := Directory{Path: absolute, Dirs: []Subdir{}}
+	if parent := filepath.Dir(absolute); parent != absolute && s.browsable(parent) {
+		directory.Parent = parent
+	}
+	for _, entry := range entries {
+		subdir := filepath.Join(absolute, entry.Name())
+		if !entry.IsDir() || s.watcher.Ignored(subdir) {
+			continue
+		}
+		directory.Dirs = append(directory.Dirs, Subdir{
+			Name:     entry.Name(),
+			Path:     subdir,
+			Watching: s.watcher.IsWatching(subdir),
+		})
+	}
+	sort.Slice(directory.Dirs, func(i, j int) bool { return directory.Dirs[i].Name < directory.Dirs[j].Name })
+	writeJSON(w, http.StatusOK, directory)
+}
+
+// browsable reports whether the folder browser may list a directory: the
+// working directory, a watched directory or one of their subdirectories,
+// once symbolic links are resolved
+func (s *Server) browsable(path string) bool {
+	bases := append([]string(nil), s.watcher.GetRoots()...)
+	if wd, err := os.Getwd(); err == nil {
+		bases = append(bases, wd)
+	}
+	for i, base := range bases {
+		if resolved, err := filepath.EvalSymlinks(base); err == nil {
+			bases[i] = resolved
+		}
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return utils.RootOf(path, bases) != ""
+}
+
+// handleExport implements GET /api/export?format=...: downloads the events
+// as a capture, in a format of ExportFormats. With scope=view, only the
+// events matching the filters of /api/events are exported, in its sort.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	extension := values.Get("format")
+	if extension == "" {
+		extension = "db"
+	}
+	filename := fmt.Sprintf("watch-fs-events_%s.%s", time.Now().Format("20060102_150405"), extension)
+	format, _, ok := ui.DetectFormat(filename)
+	if !ok || (format != ui.FormatSQLite && format != ui.FormatJSON) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q (want one of %v)", extension, ExportFormats))
+		return
+	}
+	scope := values.Get("scope")
+	if scope != "" && scope != "all" && scope != "view" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown scope %q (want all or view)", scope))
+		return
+	}
+	q, err := ParseQuery(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	sortOption, err := parseSort(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "watch-fs-export-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to create export: %w", err))
+		return
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Error(err, "Failed to remove export")
+		}
+	}()
+	path := filepath.Join(dir, filename)
+
+	if scope == "view" {
+		// The export metadata records the filter and sort of the view
+		s.withView(q, sortOption, func(matching []*ui.FileEvent) {
+			err = s.session.ExportView(path, format, matching)
+		})
+	} else {
+		err = s.Snapshot(path, format)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to export events: %w", err))
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to read export: %w", err))
+		return
+	}
+	defer func() { _ = file.Close() }()
+	stat, err := file.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to read export: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	http.ServeContent(w, r, filename, stat.ModTime(), file)
+}
@@ -0,0 +1,399 @@
+// watch-fs web UI: the event list, filters, sort and details of the TUI,
+// a folder manager and exports, over the serve API
+"use strict";
+
+// Events shown at most; the list is refreshed as events arrive
+const PAGE_SIZE = 1000;
+// Delay grouping the refreshes of bursts of events, in milliseconds
+const REFRESH_DELAY = 200;
+
+// Operations in the order the TUI picks the main one of combined events
+const OPERATIONS = ["CREATE", "WRITE", "REMOVE", "RENAME", "CHMOD"];
+const SORTS = ["time", "path", "operation", "count"];
+const EXPORTS = [
+  ["db", "SQLite"], ["db.gz", "SQLite (gzip)"], ["db.zst", "SQLite (zstd)"],
+  ["json", "JSON"], ["json.gz", "JSON (gzip)"], ["json.zst", "JSON (zstd)"],
+];
+
+const state = {
+  events: [],
+  selected: 0,
+  refreshTimer: null,
+  browsePath: "",
+};
+
+const $ = (id) => document.getElementById(id);
+
+// api fetches a JSON endpoint, throwing the {"error": ...} message on
+// failure. Requests are sent as JSON, which the server requires of requests
+// changing state.
+async function api(path, options = {}) {
+  options.headers = { "Content-Type": "application/json", ...options.headers };
+  const response = await fetch(path, options);
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+  return body;
+}
+
+// el creates an element with a class and text
+function el(tag, className, text) {
+  const node = document.createElement(tag);
+  if (className) node.className = className;
+  if (text !== undefined) node.textContent = text;
+  return node;
+}
+
+// mainOperation returns the operation the TUI shows for an event
+function mainOperation(op) {
+  const parts = op.split("|");
+  return OPERATIONS.find((name) => parts.includes(name)) || "UNKNOWN";
+}
+
+function pad(n, width = 2) {
+  return String(n).padStart(width, "0");
+}
+
+function clock(date) {
+  return `${pad(date.getHours())}:${pad(date.getMinutes())}:${pad(date.getSeconds())}`;
+}
+
+function timestamp(date) {
+  return `${date.getFullYear()}-${pad(date.getMonth() + 1)}-${pad(date.getDate())} ${clock(date)}.${pad(date.getMilliseconds(), 3)}`;
+}
+
+// viewParams returns the filters and sort of the event list, or null when
+// both files and directories are hidden
+function viewParams() {
+  const params = new URLSearchParams();
+  const files = $("show-files").checked;
+  const dirs = $("show-dirs").checked;
+  if (!files && !dirs) return null;
+  if (!files) params.set("type", "dir");
+  if (!dirs) params.set("type", "file");
+  const path = $("path-filter").value.trim();
+  if (path) params.set("path", path);
+  const op = $("op-filter").value;
+  if (op) params.set("op", op);
+  params.set("sort", $("sort").value);
+  return params;
+}
+
+// Events page
+
+async function refresh() {
+  state.refreshTimer = null;
+  const params = viewParams();
+  try {
+    const [stored, roots] = await Promise.all([api("api/events?limit=0"), api("api/roots")]);
+    let page = { total: 0, events: [] };
+    if (params) {
+      params.set("limit", PAGE_SIZE);
+      page = await api("api/events?" + params);
+    }
+    state.events = page.events;
+    renderStatus(roots, stored.total, page.total);
+    renderEvents();
+  } catch (err) {
+    renderStatusError(err);
+  }
+}
+
+function scheduleRefresh() {
+  if (state.refreshTimer === null) {
+    state.refreshTimer = setTimeout(refresh, REFRESH_DELAY);
+  }
+}
+
+function renderStatus(roots, stored, matching) {
+  const status = $("status");
+  status.replaceChildren();
+  const watching = roots.map((root) => root.path).join(", ") || "nothing";
+  const dirs = roots.length > 1 ? ` (${roots.length} dirs)` : "";
+  status.append("Watching: ", el("span", "value", watching + dirs));
+  status.append(" | Events: ", el("span", "count", stored));
+  if (matching !== stored) {
+    status.append(" | Shown: ", el("span", "count", matching > PAGE_SIZE ? `${PAGE_SIZE} of ${matching}` : matching));
+  }
+  status.append(" | Sort: ", el("span", "value", $("sort").selectedOptions[0].text));
+}
+
+function renderStatusError(err) {
+  const status = $("status");
+  status.replaceChildren(el("span", "offline", err.message));
+}
+
+function renderEvents() {
+  const list = $("events");
+  list.replaceChildren();
+  if (state.events.length === 0) {
+    list.append(el("li", "empty", "No events to display"));
+    return;
+  }
+  state.selected = Math.min(state.selected, state.events.length - 1);
+  state.events.forEach((event, index) => {
+    const operation = mainOperation(event.op);
+    const item = el("li", index === state.selected ? "selected" : "");
+    item.append(`[${clock(new Date(event.time))}] `);
+    item.append(el("span", "op-" + operation.toLowerCase(), operation));
+    item.append(` ${event.is_dir ? "D" : "F"} ${event.path}`);
+    if (event.count > 1) item.append(` (${event.count})`);
+    item.addEventListener("click", () => {
+      select(index);
+      showDetails();
+    });
+    list.append(item);
+  });
+}
+
+function select(index) {
+  if (state.events.length === 0) return;
+  state.selected = Math.max(0, Math.min(index, state.events.length - 1));
+  const items = $("events").children;
+  for (let i = 0; i < items.length; i++) {
+    items[i].classList.toggle("selected", i === state.selected);
+  }
+  items[state.selected].scrollIntoView({ block: "nearest" });
+}
+
+async function showDetails() {
+  const event = state.events[state.selected];
+  if (!event) return;
+  const operation = mainOperation(event.op);
+  const rows = [
+    ["Operation", el("span", "op-" + operation.toLowerCase(), operation)],
+    ["Path", event.path],
+    ["Type", el("span", "count", event.is_dir ? "Directory" : "File")],
+    ["Timestamp", timestamp(new Date(event.time))],
+    ["Count", String(event.count)],
+  ];
+  try {
+    const info = await api("api/info?" + new URLSearchParams({ path: event.path }));
+    if (info.exists) {
+      rows.push(["Size", `${info.size || 0} bytes`]);
+      rows.push(["Permissions", info.mode]);
+      rows.push(["Modified", timestamp(new Date(info.modified)).slice(0, 19)]);
+    }
+  } catch (err) {
+    // The root of the event may have been removed; show what is known
+  }
+
+  const body = $("details-body");
+  body.replaceChildren();
+  for (const [name, value] of rows) {
+    const dd = el("dd");
+    dd.append(value);
+    body.append(el("dt", "", name), dd);
+  }
+  const dialog = $("details");
+  if (!dialog.open) dialog.showModal();
+}
+
+async function toggleAggregate() {
+  const aggregate = $("aggregate").checked;
+  try {
+    await api("api/settings", { method: "PUT", body: JSON.stringify({ aggregate }) });
+  } catch (err) {
+    $("aggregate").checked = !aggregate;
+    renderStatusError(err);
+  }
+}
+
+function renderExports() {
+  const buttons = $("export-buttons");
+  for (const [format, label] of EXPORTS) {
+    const button = el("button", "", label);
+    button.type = "button";
+    button.addEventListener("click", () => exportEvents(format));
+    buttons.append(button, " ");
+  }
+}
+
+function exportEvents(format) {
+  const scope = $("export-scope").value;
+  const params = scope === "view" ? viewParams() : new URLSearchParams();
+  if (params === null) {
+    renderStatusError(new Error("The current view is empty: show files or directories to export it"));
+    return;
+  }
+  params.set("format", format);
+  params.set("scope", scope);
+  window.location.href = "api/export?" + params;
+}
+
+// Folders page
+
+async function refreshFolders() {
+  try {
+    const [roots, directory] = await Promise.all([
+      api("api/roots"),
+      api("api/browse?" + new URLSearchParams({ path: state.browsePath })),
+    ]);
+    state.browsePath = directory.path;
+    renderRoots(roots);
+    renderBrowse(directory);
+  } catch (err) {
+    folderMessage(err.message, true);
+  }
+}
+
+function renderRoots(roots) {
+  const list = $("roots");
+  list.replaceChildren();
+  if (roots.length === 0) {
+    list.append(el("li", "watching", "No directories watched"));
+  }
+  for (const root of roots) {
+    const item = el("li");
+    item.append(el("span", "name", root.path), el("span", "count", `${root.watched} dirs`));
+    const remove = el("button", "", "Remove");
+    remove.addEventListener("click", () => removeRoot(root.path));
+    item.append(remove);
+    list.append(item);
+  }
+}
+
+function renderBrowse(directory) {
+  $("browse-path").textContent = directory.path;
+  const list = $("browse");
+  list.replaceChildren();
+  const entries = directory.parent ? [{ name: "..", path: directory.parent, parent: true }] : [];
+  for (const entry of entries.concat(directory.dirs)) {
+    const item = el("li");
+    const link = el("a", "name", entry.name);
+    link.href = "#folders";
+    link.addEventListener("click", (e) => {
+      e.preventDefault();
+      state.browsePath = entry.path;
+      refreshFolders();
+    });
+    item.append(link);
+    if (entry.watching) {
+      item.append(el("span", "watching", "[WATCHING]"));
+    } else if (!entry.parent) {
+      const add = el("button", "", "Add");
+      add.addEventListener("click", () => addRoot(entry.path));
+      item.append(add);
+    }
+    list.append(item);
+  }
+}
+
+async function addRoot(path) {
+  try {
+    await api("api/roots", { method: "POST", body: JSON.stringify({ path }) });
+    folderMessage(`Watching ${path}`, false);
+  } catch (err) {
+    folderMessage(err.message, true);
+  }
+  refreshFolders();
+}
+
+async function removeRoot(path) {
+  try {
+    await api("api/roots?" + new URLSearchParams({ path }), { method: "DELETE" });
+    folderMessage(`Stopped watching ${path}`, false);
+  } catch (err) {
+    folderMessage(err.message, true);
+  }
+  refreshFolders();
+}
+
+function folderMessage(text, error) {
+  const message = $("folder-message");
+  message.textContent = text;
+  message.classList.toggle("error", error);
+}
+
+// Navigation and live updates
+
+function showPage() {
+  const folders = window.location.hash === "#folders";
+  $("page-events").hidden = folders;
+  $("page-folders").hidden = !folders;
+  $("tab-events").classList.toggle("active", !folders);
+  $("tab-folders").classList.toggle("active", folders);
+  if (folders) {
+    refreshFolders();
+  } else {
+    refresh();
+  }
+}
+
+function connect() {
+  const stream = new EventSource("api/stream");
+  const connection = $("connection");
+  stream.onopen = () => {
+    connection.textContent = "live";
+    connection.className = "online";
+    scheduleRefresh();
+  };
+  stream.onerror = () => {
+    connection.textContent = "offline";
+    connection.className = "offline";
+  };
+  stream.addEventListener("event", () => {
+    if (!$("page-events").hidden) scheduleRefresh();
+  });
+}
+
+// toggle flips a checkbox as its TUI key does
+function toggle(id) {
+  const box = $(id);
+  box.checked = !box.checked;
+  box.dispatchEvent(new Event("change"));
+}
+
+function onKey(e) {
+  if ($("page-events").hidden || e.ctrlKey || e.metaKey || e.altKey) return;
+  if (e.target.matches("input, select")) {
+    if (e.key === "Escape") e.target.blur();
+    return;
+  }
+  if ($("details").open) return; // The dialog closes itself on Escape
+  const sort = $("sort");
+  switch (e.key) {
+    case "f": toggle("show-files"); break;
+    case "d": toggle("show-dirs"); break;
+    case "a": toggle("aggregate"); break;
+    case "s":
+      sort.value = SORTS[(SORTS.indexOf(sort.value) + 1) % SORTS.length];
+      sort.dispatchEvent(new Event("change"));
+      break;
+    case "/": $("path-filter").focus(); break;
+    case "ArrowDown": case "j": select(state.selected + 1); break;
+    case "ArrowUp": case "k": select(state.selected - 1); break;
+    case "Home": case "g": select(0); break;
+    case "End": case "G": select(state.events.length - 1); break;
+    case "Enter": showDetails(); break;
+    default: return;
+  }
+  e.preventDefault();
+}
+
+async function init() {
+  for (const id of ["show-files", "show-dirs", "op-filter", "sort"]) {
+    $(id).addEventListener("change", refresh);
+  }
+  $("path-filter").addEventListener("input", scheduleRefresh);
+  $("aggregate").addEventListener("change", toggleAggregate);
+  $("add-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    const path = $("add-path").value.trim();
+    if (path) addRoot(path);
+  });
+  document.addEventListener("keydown", onKey);
+  window.addEventListener("hashchange", showPage);
+  renderExports();
+
+  try {
+    $("aggregate").checked = (await api("api/settings")).aggregate;
+  } catch (err) {
+    renderStatusError(err);
+  }
+  showPage();
+  connect();
+}
+
+init();
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The server side of RFC 6455, enough to push text messages and answer the
+// pings and closing handshake of clients
+
+// websocketGUID is appended to the client key to compute the accept key
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// Close status codes
+const (
+	closeNormal        = 1000
+	closeGoingAway     = 1001
+	closeProtocolError = 1002
+)
+
+// errUnmasked is returned for client frames without a mask, which RFC 6455
+// section 5.1 requires
+var errUnmasked = errors.New("unmasked WebSocket frame from client")
+
+// maxClientFrame bounds the frames read from clients, which only send
+// control frames
+const maxClientFrame = 64 << 10
+
+// websocketConn is an upgraded connection
+type websocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex // Serializes writes
+}
+
+// upgradeWebSocket answers the opening handshake and takes over the
+// connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("not a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported WebSocket version (want 13)")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("the connection cannot be upgraded")
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to upgrade connection: %w", err)
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := buffered.WriteString(response); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to upgrade connection: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to upgrade connection: %w", err)
+	}
+	return &websocketConn{conn: conn, reader: buffered.Reader}, nil
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value answering a client key
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// headerContains reports whether a comma-separated header has a token,
+// ignoring case
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame writes an unfragmented, unmasked frame
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return fmt.Errorf("failed to write WebSocket frame: %w", err)
+	}
+	return nil
+}
+
+// readFrame reads a frame from the client and unmasks its payload; clients
+// must mask every frame
+func (c *websocketConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errUnmasked
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > maxClientFrame {
+		return 0, nil, fmt.Errorf("WebSocket frame too large (%d bytes)", length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop answers pings and the closing handshake until the client leaves,
+// then closes disconnected. Data frames are ignored.
+func (c *websocketConn) readLoop(disconnected chan<- struct{}) {
+	defer close(disconnected)
+	for {
+		opcode, payload, err := c.readFrame()
+		if errors.Is(err, errUnmasked) {
+			_ = c.writeFrame(opClose, closePayload(closeProtocolError))
+			return
+		}
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return
+			}
+		case opClose:
+			_ = c.writeFrame(opClose, closePayload(closeNormal))
+			return
+		}
+	}
+}
+
+// closePayload returns the payload of a close frame with a status code
+func closePayload(code uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, code)
+}
+
+// Close closes the connection
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
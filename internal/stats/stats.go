@@ -0,0 +1,131 @@
+// Package stats summarizes recorded events: totals, operations, roots and
+// the busiest paths
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// operations are the operations counted separately, in display order
+var operations = []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Remove, fsnotify.Rename, fsnotify.Chmod}
+
+// Count is the number of events of a key
+type Count struct {
+	Key    string `json:"key"`
+	Events int    `json:"events"`
+}
+
+// Summary describes a set of events. Aggregated events count as many times
+// as they happened.
+type Summary struct {
+	Records    int       `json:"records"` // Events as stored, aggregated or not
+	Events     int       `json:"events"`
+	Paths      int       `json:"paths"`
+	First      time.Time `json:"first,omitzero"`
+	Last       time.Time `json:"last,omitzero"`
+	Operations []Count   `json:"operations"`
+	Roots      []Count   `json:"roots,omitempty"`
+	TopPaths   []Count   `json:"top_paths"`
+}
+
+// Summarize summarizes events recorded under roots, keeping the top busiest
+// paths
+func Summarize(events []*ui.FileEvent, roots []string, top int) Summary {
+	summary := Summary{Records: len(events)}
+	byOperation := make(map[fsnotify.Op]int)
+	byRoot := make(map[string]int)
+	byPath := make(map[string]int)
+	for _, event := range events {
+		count := max(event.Count, 1)
+		summary.Events += count
+		byPath[event.Path] += count
+		for _, op := range operations {
+			if event.Operation&op != 0 {
+				byOperation[op] += count
+			}
+		}
+		if len(roots) > 0 {
+			root := utils.RootOf(event.Path, roots)
+			if root == "" {
+				root = "(outside roots)"
+			}
+			byRoot[root] += count
+		}
+		if summary.First.IsZero() || event.Timestamp.Before(summary.First) {
+			summary.First = event.Timestamp
+		}
+		if event.Timestamp.After(summary.Last) {
+			summary.Last = event.Timestamp
+		}
+	}
+	summary.Paths = len(byPath)
+
+	for _, op := range operations {
+		summary.Operations = append(summary.Operations, Count{Key: op.String(), Events: byOperation[op]})
+	}
+	summary.Roots = sortCounts(byRoot, 0)
+	summary.TopPaths = sortCounts(byPath, top)
+	return summary
+}
+
+// sortCounts returns counts by decreasing number of events, at most limit
+// of them unless limit is zero
+func sortCounts(counts map[string]int, limit int) []Count {
+	sorted := make([]Count, 0, len(counts))
+	for key, events := range counts {
+		sorted = append(sorted, Count{Key: key, Events: events})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Events != sorted[j].Events {
+			return sorted[i].Events > sorted[j].Events
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// WriteText writes a human-readable summary
+func (s Summary) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Events: %d (%d records, %d paths)\n", s.Events, s.Records, s.Paths); err != nil {
+		return err
+	}
+	if !s.First.IsZero() {
+		if _, err := fmt.Fprintf(w, "From %s to %s (%s)\n", s.First.Format(time.RFC3339), s.Last.Format(time.RFC3339),
+			s.Last.Sub(s.First).Round(time.Second)); err != nil {
+			return err
+		}
+	}
+
+	sections := []struct {
+		title  string
+		counts []Count
+	}{
+		{"Operations", s.Operations},
+		{"Roots", s.Roots},
+		{"Top paths", s.TopPaths},
+	}
+	for _, section := range sections {
+		if len(section.counts) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\n%s:\n", section.title); err != nil {
+			return err
+		}
+		for _, count := range section.counts {
+			if _, err := fmt.Fprintf(w, "  %8d  %s\n", count.Events, count.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
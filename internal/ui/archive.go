@@ -0,0 +1,345 @@
+package ui
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+const (
+	manifestSuffix  = ".manifest.json"
+	manifestVersion = 1
+
+	// exportFileFilter lists the files shown by the export dialog
+	exportFileFilter = "*.db,*.json,*.gz,*.zst"
+	// importFileFilter also lists the watcher logs the import dialog can read
+	importFileFilter = exportFileFilter + ",*.inotify,*.fswatch,*.watchman,*.log"
+)
+
+// ErrManifestMismatch is returned when an export does not match its manifest
+var ErrManifestMismatch = errors.New("export does not match its manifest")
+
+// ErrCorruptExport is returned when an export file cannot be fully decoded
+var ErrCorruptExport = errors.New("export file is damaged")
+
+// ManifestError details why an export does not match its manifest
+type ManifestError struct {
+	File   string
+	Reason string
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("%s: %s", filepath.Base(e.File), e.Reason)
+}
+
+func (e *ManifestError) Unwrap() error {
+	return ErrManifestMismatch
+}
+
+// Manifest describes an export file so that damage can be detected on import.
+// It is written next to the export as <file>.manifest.json
+type Manifest struct {
+	Version     int       `json:"version"`
+	File        string    `json:"file"`
+	Format      string    `json:"format"`
+	Compression string    `json:"compression"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	EventCount  int       `json:"event_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// exportExtensions maps file extensions to export formats
+var exportExtensions = map[string]ExportFormat{
+	".db":       FormatSQLite,
+	".json":     FormatJSON,
+	".inotify":  FormatInotifywait,
+	".fswatch":  FormatFswatch,
+	".watchman": FormatWatchman,
+}
+
+// compressionExtensions maps file extensions to compressions
+var compressionExtensions = map[string]Compression{
+	".gz":  CompressionGzip,
+	".zst": CompressionZstd,
+}
+
+// DetectFormat returns the export format and compression implied by a
+// filename such as events.db, events.json.gz or events.db.zst. The last
+// result is false when the extension is not recognised.
+func DetectFormat(filename string) (ExportFormat, Compression, bool) {
+	name := strings.ToLower(filename)
+
+	compression := CompressionNone
+	if c, ok := compressionExtensions[filepath.Ext(name)]; ok {
+		compression = c
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	format, ok := exportExtensions[filepath.Ext(name)]
+	return format, compression, ok
+}
+
+// CaptureFormat returns the format of a capture from its extension, or from
+// its content when the extension does not tell (e.g. .log)
+func CaptureFormat(filename string) (ExportFormat, bool) {
+	if format, _, ok := DetectFormat(filename); ok {
+		return format, true
+	}
+	return SniffFormat(filename)
+}
+
+// formatName returns the manifest name of an export format
+func formatName(format ExportFormat) string {
+	switch format {
+	case FormatJSON:
+		return "json"
+	case FormatInotifywait, FormatFswatch, FormatWatchman:
+		return textFormatName(format)
+	default:
+		return "sqlite"
+	}
+}
+
+// compressionName returns the manifest name of a compression
+func compressionName(compression Compression) string {
+	switch compression {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// manifestPath returns the path of the manifest for an export file
+func manifestPath(filename string) string {
+	return filename + manifestSuffix
+}
+
+// fileSHA256 returns the hex encoded SHA-256 checksum and size of a file
+func fileSHA256(filename string) (string, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// writeManifest writes the manifest describing a finished export
+func writeManifest(filename string, format ExportFormat, compression Compression, eventCount int) error {
+	checksum, size, err := fileSHA256(filename)
+	if err != nil {
+		return fmt.Errorf("failed to checksum export: %w", err)
+	}
+
+	manifest := Manifest{
+		Version:     manifestVersion,
+		File:        filepath.Base(filename),
+		Format:      formatName(format),
+		Compression: compressionName(compression),
+		Size:        size,
+		SHA256:      checksum,
+		EventCount:  eventCount,
+		CreatedAt:   time.Now(),
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(manifestPath(filename), data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// readManifest loads the manifest of an export file. It returns nil without
+// error for exports that have no manifest.
+func readManifest(filename string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, &ManifestError{File: filename, Reason: fmt.Sprintf("unreadable manifest: %v", err)}
+	}
+	return &manifest, nil
+}
+
+// verifyChecksum checks an export file against the size and checksum of its manifest
+func verifyChecksum(filename string, manifest *Manifest) error {
+	checksum, size, err := fileSHA256(filename)
+	if err != nil {
+		return fmt.Errorf("failed to checksum export: %w", err)
+	}
+	if size != manifest.Size {
+		return &ManifestError{File: filename, Reason: fmt.Sprintf("size is %d bytes, manifest expects %d (truncated?)", size, manifest.Size)}
+	}
+	if checksum != manifest.SHA256 {
+		return &ManifestError{File: filename, Reason: "SHA-256 checksum does not match the manifest"}
+	}
+	return nil
+}
+
+// newCompressedWriter wraps w so that everything written to it is compressed
+func newCompressedWriter(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// newDecompressedReader wraps r so that reads return decompressed data
+func newDecompressedReader(r io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// nopWriteCloser turns an io.Writer into an io.WriteCloser
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// writeCompressedFile writes data to a file with the given compression
+func writeCompressedFile(filename string, data []byte, compression Compression) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	writer, err := newCompressedWriter(file, compression)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to compress file: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		_ = file.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to compress file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// compressFile writes a compressed copy of src to dst
+func compressFile(src, dst string, compression Compression) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := in.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	writer, err := newCompressedWriter(out, compression)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err := io.Copy(writer, in); err != nil {
+		_ = writer.Close()
+		_ = out.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// decompressFile writes a decompressed copy of src to dst. When the input is
+// damaged, dst keeps whatever could be decompressed and ErrCorruptExport is
+// returned.
+func decompressFile(src, dst string, compression Compression) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := in.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
+
+	reader, err := newDecompressedReader(in, compression)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptExport, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(out, reader)
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptExport, copyErr)
+	}
+	return nil
+}
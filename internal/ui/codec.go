@@ -0,0 +1,94 @@
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// operationNames maps operation names found in captures to fsnotify
+// operations. Besides the names written by fsnotify itself, it knows the
+// event names of inotifywait and the flags of fswatch -x.
+var operationNames = map[string]fsnotify.Op{
+	// fsnotify (SQLite and JSON exports)
+	"CREATE": fsnotify.Create,
+	"WRITE":  fsnotify.Write,
+	"REMOVE": fsnotify.Remove,
+	"RENAME": fsnotify.Rename,
+	"CHMOD":  fsnotify.Chmod,
+
+	// inotifywait
+	"MODIFY":      fsnotify.Write,
+	"CLOSE_WRITE": fsnotify.Write,
+	"DELETE":      fsnotify.Remove,
+	"DELETE_SELF": fsnotify.Remove,
+	"MOVED_FROM":  fsnotify.Rename,
+	"MOVED_TO":    fsnotify.Create,
+	"MOVE_SELF":   fsnotify.Rename,
+	"ATTRIB":      fsnotify.Chmod,
+
+	// fswatch -x
+	"Created":           fsnotify.Create,
+	"Updated":           fsnotify.Write,
+	"Removed":           fsnotify.Remove,
+	"Renamed":           fsnotify.Rename,
+	"MovedFrom":         fsnotify.Rename,
+	"MovedTo":           fsnotify.Create,
+	"OwnerModified":     fsnotify.Chmod,
+	"AttributeModified": fsnotify.Chmod,
+}
+
+// ParseOperationName returns the operation of a name as fsnotify writes
+// it, matched case-insensitively, e.g. "create" or "WRITE"
+func ParseOperationName(name string) (fsnotify.Op, bool) {
+	name = strings.ToUpper(name)
+	op, ok := operationNames[name]
+	return op, ok && op.String() == name
+}
+
+// directoryFlags are the flags marking an event on a directory
+var directoryFlags = map[string]bool{
+	"ISDIR": true, // inotifywait
+	"IsDir": true, // fswatch -x
+}
+
+// parseOperation decodes an operation written by fsnotify, inotifywait or
+// fswatch. Several names separated by '|', ',' or spaces are combined, and
+// the result tells whether a directory flag was present. ok is false when
+// no known operation was found.
+func parseOperation(value string) (operation fsnotify.Op, isDir bool, ok bool) {
+	names := strings.FieldsFunc(value, func(r rune) bool {
+		return r == '|' || r == ',' || r == ' '
+	})
+	for _, name := range names {
+		if op, known := operationNames[name]; known {
+			operation |= op
+			ok = true
+		} else if directoryFlags[name] {
+			isDir = true
+		}
+	}
+	return operation, isDir, ok
+}
+
+// decodeEvent builds an event from the fields stored in a capture. It is
+// shared by every importer so that all formats decode operations the same
+// way; ok is false when the operation is unknown and the record should be
+// skipped.
+func decodeEvent(path, operation string, timestamp time.Time, isDir bool, count int) (*FileEvent, bool) {
+	op, dirFlag, ok := parseOperation(operation)
+	if !ok {
+		return nil, false
+	}
+	if count < 1 {
+		count = 1
+	}
+	return &FileEvent{
+		Path:      path,
+		Operation: op,
+		Timestamp: timestamp,
+		IsDir:     isDir || dirFlag,
+		Count:     count,
+	}, true
+}
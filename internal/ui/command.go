@@ -0,0 +1,106 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jesseduffield/gocui"
+	"github.com/pbouamriou/watch-fs/internal/runner"
+)
+
+// Command shows the status and last output of the command run by
+// `watch-fs exec` below the events
+type Command struct {
+	ui *UI
+}
+
+// NewCommand creates a new Command instance
+func NewCommand(ui *UI) *Command {
+	return &Command{ui: ui}
+}
+
+// Attach shows the pane for a runner and redraws it when its status changes
+func (c *Command) Attach(r *runner.Runner) {
+	c.ui.runner = r
+	r.OnChange(c.refresh)
+}
+
+// Rerun runs the command again, applying the runner policy if it is running
+func (c *Command) Rerun() {
+	if c.ui.runner != nil {
+		c.ui.runner.Trigger()
+	}
+}
+
+// UpdateView renders the command status and as many output lines as fit
+func (c *Command) UpdateView(v *gocui.View) {
+	v.Clear()
+	r := c.ui.runner
+	if r == nil {
+		return
+	}
+	status := r.Status()
+	cyan := color.New(color.FgCyan).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	state := runner.StateName(status.State)
+	switch status.State {
+	case runner.StateRunning:
+		state = yellow(fmt.Sprintf("%s %s", state, time.Since(status.Started).Round(time.Second)))
+	case runner.StateSucceeded:
+		state = green(fmt.Sprintf("%s in %s", state, status.Duration.Round(time.Millisecond)))
+	case runner.StateFailed:
+		state = red(fmt.Sprintf("%s (exit %d)", state, status.ExitCode))
+	case runner.StateKilled:
+		state = red(state)
+	}
+
+	pending := ""
+	if status.Pending > 0 {
+		pending = fmt.Sprintf(" | Pending: %s", yellow(status.Pending))
+	}
+	_, _ = fmt.Fprintf(v, "%s | %s | Runs: %d | On change: %s%s\n",
+		cyan(strings.Join(r.Command(), " ")), state, status.Runs, runner.PolicyName(r.Policy()), pending)
+
+	if status.Err != nil {
+		_, _ = fmt.Fprintf(v, "%s\n", red(status.Err))
+		return
+	}
+
+	// Keep the tail of the output in view
+	_, height := v.Size()
+	output := status.Output
+	if room := height - 1; room >= 0 && len(output) > room {
+		output = output[len(output)-room:]
+	}
+	for _, line := range output {
+		_, _ = fmt.Fprintln(v, line)
+	}
+}
+
+// refresh redraws the command pane
+func (c *Command) refresh() {
+	if c.ui.gui != nil {
+		c.ui.gui.Update(func(g *gocui.Gui) error {
+			if v, err := g.View(CommandView); err == nil {
+				c.UpdateView(v)
+			}
+			return nil
+		})
+	}
+}
+
+// Keybinding handlers
+
+// rerun runs the command again
+func (c *Command) rerun(g *gocui.Gui, v *gocui.View) error {
+	if c.ui.runner != nil {
+		c.Rerun()
+		c.ui.setStatusMessage("Command restarted")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/jesseduffield/gocui"
+)
+
+// Confirm manages the yes/no confirmation prompt
+type Confirm struct {
+	ui *UI
+}
+
+// NewConfirm creates a new Confirm instance
+func NewConfirm(ui *UI) *Confirm {
+	return &Confirm{ui: ui}
+}
+
+// Show opens the prompt; onConfirm runs if the user answers yes
+func (c *Confirm) Show(message string, onConfirm func() error) {
+	c.ui.state.Confirm = ConfirmState{
+		Message:   message,
+		OnConfirm: onConfirm,
+		Previous:  c.ui.state.CurrentFocus,
+	}
+	c.ui.state.ShowConfirm = true
+	c.ui.state.CurrentFocus = FocusConfirm
+}
+
+// Hide closes the prompt and restores the previous focus
+func (c *Confirm) Hide() {
+	c.ui.state.ShowConfirm = false
+	c.ui.state.CurrentFocus = c.ui.state.Confirm.Previous
+	c.ui.state.Confirm = ConfirmState{}
+}
+
+// Accept answers yes to the prompt
+func (c *Confirm) Accept(g *gocui.Gui, v *gocui.View) error {
+	onConfirm := c.ui.state.Confirm.OnConfirm
+	c.Hide()
+	if onConfirm != nil {
+		if err := onConfirm(); err != nil {
+			c.ui.setStatusMessage(fmt.Sprintf("Error: %v", err))
+		}
+	}
+	return c.ui.layout.Layout(g)
+}
+
+// Reject answers no to the prompt
+func (c *Confirm) Reject(g *gocui.Gui, v *gocui.View) error {
+	c.Hide()
+	return c.ui.layout.Layout(g)
+}
+
+// UpdateView updates the confirmation prompt view
+func (c *Confirm) UpdateView(v *gocui.View) {
+	v.Clear()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	_, _ = fmt.Fprintf(v, "%s\n\n", c.ui.state.Confirm.Message)
+	_, _ = fmt.Fprintf(v, "%s", yellow("y/Enter: Yes | n/ESC: No"))
+}
@@ -0,0 +1,74 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/jesseduffield/gocui"
+)
+
+// DetectionPopup shows the evidence of a ransomware-like detection until
+// the user dismisses it
+type DetectionPopup struct {
+	ui *UI
+}
+
+// NewDetectionPopup creates a new DetectionPopup instance
+func NewDetectionPopup(ui *UI) *DetectionPopup {
+	return &DetectionPopup{ui: ui}
+}
+
+// Show opens the popup, or replaces the detection it shows. The detector
+// calls it from its own goroutine.
+func (d *DetectionPopup) Show(detection Detection) {
+	show := func() {
+		if !d.ui.state.ShowDetection {
+			d.ui.state.Detection.Previous = d.ui.state.CurrentFocus
+		}
+		d.ui.state.Detection.Detection = detection
+		d.ui.state.ShowDetection = true
+		d.ui.state.CurrentFocus = FocusDetection
+		d.ui.setStatusMessage(fmt.Sprintf("Ransomware-like activity detected at %s", detection.Time.Format("15:04:05")))
+	}
+	if d.ui.gui == nil {
+		show()
+		return
+	}
+	d.ui.gui.Update(func(g *gocui.Gui) error {
+		show()
+		return d.ui.layout.Layout(g)
+	})
+}
+
+// Hide closes the popup and restores the previous focus
+func (d *DetectionPopup) Hide(g *gocui.Gui, v *gocui.View) error {
+	d.ui.state.ShowDetection = false
+	d.ui.state.CurrentFocus = d.ui.state.Detection.Previous
+	d.ui.state.Detection = DetectionState{}
+	return d.ui.layout.Layout(g)
+}
+
+// UpdateView renders the signals, then the evidence of the detection
+func (d *DetectionPopup) UpdateView(v *gocui.View) {
+	v.Clear()
+	red := color.New(color.FgRed, color.Bold).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	cyan := color.New(color.FgCyan).SprintFunc()
+	detection := d.ui.state.Detection.Detection
+
+	_, _ = fmt.Fprintf(v, "%s at %s\n", red("Ransomware-like activity"), detection.Time.Format("2006-01-02 15:04:05"))
+	for _, signal := range detection.Signals {
+		_, _ = fmt.Fprintf(v, "  %s\n", red(signal))
+	}
+	if len(detection.Extensions) > 0 {
+		_, _ = fmt.Fprintf(v, "\n%s %v\n", cyan("Unfamiliar extensions:"), detection.Extensions)
+	}
+	_, _ = fmt.Fprintf(v, "\n%s\n", cyan("Recent paths:"))
+	for _, path := range detection.Paths {
+		_, _ = fmt.Fprintf(v, "  %s\n", path)
+	}
+	if detection.Containment != "" {
+		_, _ = fmt.Fprintf(v, "\n%s %s (see the rules pane)\n", cyan("Containment started:"), detection.Containment)
+	}
+	_, _ = fmt.Fprintf(v, "\n%s", yellow("Enter/ESC/q: Dismiss"))
+}
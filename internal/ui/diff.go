@@ -0,0 +1,298 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jesseduffield/gocui"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// Diff manages the comparison of two captures and the session diff view
+type Diff struct {
+	ui *UI
+}
+
+// NewDiff creates a new Diff instance
+func NewDiff(ui *UI) *Diff {
+	return &Diff{ui: ui}
+}
+
+// Compare loads two captures and compares them by root-relative path,
+// without changing the current events
+func (d *Diff) Compare(fileA string, formatA ExportFormat, fileB string, formatB ExportFormat) (*SessionDiff, error) {
+	a, err := d.ui.exportImport.loadCapture(fileA, formatA, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filepath.Base(fileA), err)
+	}
+	b, err := d.ui.exportImport.loadCapture(fileB, formatB, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filepath.Base(fileB), err)
+	}
+
+	result := diffCaptures(summarizeCapture(a), summarizeCapture(b))
+	result.A = fileA
+	result.B = fileB
+	return result, nil
+}
+
+// summarizeCapture merges the events of a capture by root-relative path
+func summarizeCapture(c *capture) map[string]*DiffSide {
+	roots := c.meta.Roots
+	if len(roots) == 0 {
+		roots = []string{commonDir(c.events)}
+	}
+
+	sides := make(map[string]*DiffSide)
+	for _, event := range c.events {
+		path := relativePath(event.Path, roots)
+		side, ok := sides[path]
+		if !ok {
+			side = &DiffSide{}
+			sides[path] = side
+		}
+		side.Operations |= event.Operation
+		side.Count += max(event.Count, 1)
+		side.IsDir = side.IsDir || event.IsDir
+	}
+	return sides
+}
+
+// diffCaptures compares two capture summaries
+func diffCaptures(a, b map[string]*DiffSide) *SessionDiff {
+	result := &SessionDiff{Entries: make([]PathDiff, 0)}
+	for path, sideA := range a {
+		sideB, ok := b[path]
+		switch {
+		case !ok:
+			result.Entries = append(result.Entries, PathDiff{Path: path, Kind: DiffOnlyA, A: sideA})
+		case sideA.Count != sideB.Count || sideA.Operations != sideB.Operations:
+			result.Entries = append(result.Entries, PathDiff{Path: path, Kind: DiffChanged, A: sideA, B: sideB})
+		default:
+			result.Unchanged++
+		}
+	}
+	for path, sideB := range b {
+		if _, ok := a[path]; !ok {
+			result.Entries = append(result.Entries, PathDiff{Path: path, Kind: DiffOnlyB, B: sideB})
+		}
+	}
+
+	sort.Slice(result.Entries, func(i, j int) bool {
+		if result.Entries[i].Kind != result.Entries[j].Kind {
+			return result.Entries[i].Kind < result.Entries[j].Kind
+		}
+		return result.Entries[i].Path < result.Entries[j].Path
+	})
+	return result
+}
+
+// relativePath returns a path relative to the deepest root containing it.
+// With several roots, the root name is kept to tell them apart.
+func relativePath(path string, roots []string) string {
+	root := utils.RootOf(path, roots)
+	if root == "" {
+		return filepath.ToSlash(path)
+	}
+	rel := utils.RelativePath(path, roots)
+	if len(roots) > 1 {
+		rel = filepath.ToSlash(filepath.Join(filepath.Base(root), rel))
+	}
+	return rel
+}
+
+// commonDir returns the deepest directory containing every event, used as
+// the root of captures that do not record one
+func commonDir(events []*FileEvent) string {
+	if len(events) == 0 {
+		return ""
+	}
+	common := filepath.Dir(events[0].Path)
+	for _, event := range events[1:] {
+		for !containsPath(common, event.Path) && common != filepath.Dir(common) {
+			common = filepath.Dir(common)
+		}
+	}
+	return common
+}
+
+// containsPath reports whether path is inside dir, which may end with a
+// separator, as / and C:\ do
+func containsPath(dir, path string) bool {
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
+// Count returns the number of entries of a kind
+func (sd *SessionDiff) Count(kind DiffKind) int {
+	count := 0
+	for _, entry := range sd.Entries {
+		if entry.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteFile exports the diff as JSON, compressed according to the extension
+func (sd *SessionDiff) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(sd, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal diff: %w", err)
+	}
+	_, compression, _ := DetectFormat(filename)
+	return writeCompressedFile(filename, data, compression)
+}
+
+// WriteText prints the diff as a plain text report
+func (sd *SessionDiff) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "A: %s\nB: %s\n", sd.A, sd.B); err != nil {
+		return err
+	}
+	for _, entry := range sd.Entries {
+		var line string
+		switch entry.Kind {
+		case DiffOnlyA:
+			line = fmt.Sprintf("- %s  %s", entry.Path, formatDiffSide(entry.A))
+		case DiffOnlyB:
+			line = fmt.Sprintf("+ %s  %s", entry.Path, formatDiffSide(entry.B))
+		default:
+			line = fmt.Sprintf("~ %s  %s -> %s", entry.Path, formatDiffSide(entry.A), formatDiffSide(entry.B))
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "Only in A: %d | Only in B: %d | Changed: %d | Unchanged: %d\n",
+		sd.Count(DiffOnlyA), sd.Count(DiffOnlyB), sd.Count(DiffChanged), sd.Unchanged)
+	return err
+}
+
+// diffKindName returns the export name of a diff kind
+func diffKindName(kind DiffKind) string {
+	switch kind {
+	case DiffOnlyA:
+		return "only_a"
+	case DiffOnlyB:
+		return "only_b"
+	default:
+		return "changed"
+	}
+}
+
+// MarshalText encodes a diff kind by name
+func (k DiffKind) MarshalText() ([]byte, error) {
+	return []byte(diffKindName(k)), nil
+}
+
+// MarshalJSON encodes a diff side with its operations by name
+func (s DiffSide) MarshalJSON() ([]byte, error) {
+	type side DiffSide
+	return json.Marshal(struct {
+		Operations string `json:"operations"`
+		side
+	}{s.Operations.String(), side(s)})
+}
+
+// Show opens the diff view with a result
+func (d *Diff) Show(result *SessionDiff) {
+	d.ui.state.Diff = DiffState{Result: result}
+	d.ui.state.ShowDiff = true
+	d.ui.state.CurrentFocus = FocusDiff
+}
+
+// Hide closes the diff view
+func (d *Diff) Hide() {
+	d.ui.state.ShowDiff = false
+	d.ui.state.Diff = DiffState{}
+	d.ui.state.CurrentFocus = FocusMain
+}
+
+// Close closes the diff view
+func (d *Diff) Close(g *gocui.Gui, v *gocui.View) error {
+	d.Hide()
+	return d.ui.layout.Layout(g)
+}
+
+// Up scrolls the diff view up
+func (d *Diff) Up(g *gocui.Gui, v *gocui.View) error {
+	if d.ui.state.Diff.ScrollOffset > 0 {
+		d.ui.state.Diff.ScrollOffset--
+	}
+	return d.ui.layout.Layout(g)
+}
+
+// Down scrolls the diff view down
+func (d *Diff) Down(g *gocui.Gui, v *gocui.View) error {
+	if result := d.ui.state.Diff.Result; result != nil && d.ui.state.Diff.ScrollOffset < len(result.Entries)-1 {
+		d.ui.state.Diff.ScrollOffset++
+	}
+	return d.ui.layout.Layout(g)
+}
+
+// Export writes the displayed diff next to the exports
+func (d *Diff) Export(g *gocui.Gui, v *gocui.View) error {
+	if d.ui.state.Diff.Result == nil {
+		return nil
+	}
+	filename := fmt.Sprintf("watch-fs-diff_%s.json", time.Now().Format("2006-01-02_15-04-05"))
+	if err := d.ui.state.Diff.Result.WriteFile(filename); err != nil {
+		d.ui.setStatusMessage(fmt.Sprintf("Diff export failed: %v", err))
+	} else {
+		d.ui.setStatusMessage(fmt.Sprintf("Diff exported to %s", filename))
+	}
+	return nil
+}
+
+// UpdateView updates the session diff view
+func (d *Diff) UpdateView(v *gocui.View) {
+	v.Clear()
+	result := d.ui.state.Diff.Result
+	if result == nil {
+		return
+	}
+	cyan := color.New(color.FgCyan).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	_, _ = fmt.Fprintf(v, "A: %s\nB: %s\n", cyan(result.A), cyan(result.B))
+	_, _ = fmt.Fprintf(v, "Only in A: %s | Only in B: %s | Changed: %s | Unchanged: %d\n\n",
+		red(result.Count(DiffOnlyA)), green(result.Count(DiffOnlyB)),
+		yellow(result.Count(DiffChanged)), result.Unchanged)
+
+	if len(result.Entries) == 0 {
+		_, _ = fmt.Fprintf(v, "The captures touch the same paths the same way")
+		return
+	}
+
+	_, height := v.Size()
+	for i := d.ui.state.Diff.ScrollOffset; i < len(result.Entries) && i-d.ui.state.Diff.ScrollOffset < height-4; i++ {
+		entry := result.Entries[i]
+		switch entry.Kind {
+		case DiffOnlyA:
+			_, _ = fmt.Fprintf(v, "%s %s  %s\n", red("-"), entry.Path, formatDiffSide(entry.A))
+		case DiffOnlyB:
+			_, _ = fmt.Fprintf(v, "%s %s  %s\n", green("+"), entry.Path, formatDiffSide(entry.B))
+		default:
+			_, _ = fmt.Fprintf(v, "%s %s  %s → %s\n", yellow("~"), entry.Path,
+				formatDiffSide(entry.A), formatDiffSide(entry.B))
+		}
+	}
+}
+
+// formatDiffSide formats the operations and count of one side of a diff
+func formatDiffSide(side *DiffSide) string {
+	if side == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s x%d", side.Operations, side.Count)
+}
@@ -3,16 +3,19 @@ package ui
 import (
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/jesseduffield/gocui"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
 )
 
 // Events manages the logic of watcher events and their processing
 // (adding, filtering, sorting, aggregation)
 type Events struct {
 	ui *UI
+	mu sync.Mutex // Held while the events change, for exports from other goroutines
 }
 
 func NewEvents(ui *UI) *Events {
@@ -21,33 +24,46 @@ func NewEvents(ui *UI) *Events {
 
 // addEvent adds a new event to the state
 func (e *Events) addEvent(path string, operation fsnotify.Op, isDir bool) {
-	if e.ui.state.AggregateEvents {
-		// Check if a similar event exists in the last second
-		now := time.Now()
-		for _, event := range e.ui.state.Events {
-			if event.Path == path && event.Operation == operation &&
-				now.Sub(event.Timestamp) < time.Second {
-				event.Count++
-				event.Timestamp = now
-				return
-			}
-		}
-	}
+	e.recordEvent(path, operation, isDir, time.Now())
+}
 
-	// Add a new event
-	event := &FileEvent{
+// recordEvent adds an event that happened at the given time to the state.
+// Live events use the current time, replayed events their recorded one.
+func (e *Events) recordEvent(path string, operation fsnotify.Op, isDir bool, timestamp time.Time) {
+	e.record(&FileEvent{
 		Path:      path,
 		Operation: operation,
-		Timestamp: time.Now(),
+		Timestamp: timestamp,
 		IsDir:     isDir,
 		Count:     1,
+	})
+}
+
+// record adds an event to the state, merging it into a similar one when
+// aggregating
+func (e *Events) record(received *FileEvent) {
+	e.mu.Lock()
+	event := e.aggregate(received)
+	if event == nil {
+		// Add a new event
+		event = received
+		e.ui.state.Events = append(e.ui.state.Events, event)
 	}
-	e.ui.state.Events = append(e.ui.state.Events, event)
 
 	// Limit the number of events
 	if len(e.ui.state.Events) > e.ui.state.MaxEvents {
+		delete(e.ui.state.SelectedEvents, e.ui.state.Events[0])
 		e.ui.state.Events = e.ui.state.Events[1:]
 	}
+	if e.ui.state.MaxAge > 0 {
+		e.dropOlderThan(received.Timestamp.Add(-e.ui.state.MaxAge))
+	}
+	e.mu.Unlock()
+
+	// Notify listeners
+	for _, listener := range e.ui.eventListeners {
+		listener(event)
+	}
 
 	// Update the UI if initialized
 	if e.ui.gui != nil {
@@ -66,20 +82,73 @@ func (e *Events) addEvent(path string, operation fsnotify.Op, isDir bool) {
 	}
 }
 
+// aggregate merges an event into a similar one from the last second and
+// returns it, or returns nil when aggregation is off or nothing matches
+func (e *Events) aggregate(received *FileEvent) *FileEvent {
+	if !e.ui.state.AggregateEvents {
+		return nil
+	}
+	for _, event := range e.ui.state.Events {
+		if event.Path == received.Path && event.Operation == received.Operation &&
+			event.Host == received.Host &&
+			received.Timestamp.Sub(event.Timestamp) < time.Second {
+			event.Count += max(received.Count, 1)
+			event.Timestamp = received.Timestamp
+			event.Attrs = event.Attrs.Then(received.Attrs)
+			return event
+		}
+	}
+	return nil
+}
+
+// dropOlderThan drops the events last seen before a time
+func (e *Events) dropOlderThan(cutoff time.Time) {
+	kept := e.ui.state.Events[:0]
+	for _, event := range e.ui.state.Events {
+		if event.Timestamp.Before(cutoff) {
+			delete(e.ui.state.SelectedEvents, event)
+			continue
+		}
+		kept = append(kept, event)
+	}
+	clear(e.ui.state.Events[len(kept):])
+	e.ui.state.Events = kept
+}
+
 // watchEvents listens to watcher events
 func (e *Events) watchEvents() {
+	// Collectors deliver complete events, with the host they come from
+	var remote <-chan *FileEvent
+	if source, ok := e.ui.watcher.(interface{ FileEvents() <-chan *FileEvent }); ok {
+		remote = source.FileEvents()
+	}
 	for {
 		select {
+		case event, ok := <-remote:
+			if !ok {
+				return
+			}
+			e.record(event)
 		case event, ok := <-e.ui.watcher.Events():
 			if !ok {
 				return
 			}
+			if ignorer, ok := e.ui.watcher.(interface{ Ignored(string) bool }); ok && ignorer.Ignored(event.Name) {
+				continue
+			}
 			info, err := e.ui.getFileInfo(event.Name)
 			isDir := false
 			if err == nil {
 				isDir = info.IsDir()
 			}
-			e.addEvent(event.Name, event.Op, isDir)
+			fileEvent := &FileEvent{Path: event.Name, Operation: event.Op, Timestamp: time.Now(), IsDir: isDir, Count: 1}
+			// The watcher knows how CHMOD events changed the mode and owner
+			if tracker, ok := e.ui.watcher.(interface {
+				AttrChange(fsnotify.Event) *attrs.Change
+			}); ok {
+				fileEvent.Attrs = tracker.AttrChange(event)
+			}
+			e.record(fileEvent)
 		case err, ok := <-e.ui.watcher.Errors():
 			if !ok {
 				return
@@ -94,28 +163,60 @@ func (e *Events) watchEvents() {
 func (e *Events) getFilteredEvents() []*FileEvent {
 	filtered := make([]*FileEvent, 0)
 	for _, event := range e.ui.state.Events {
-		// Filter path
-		if e.ui.state.Filter.PathFilter != "" &&
-			!strings.Contains(strings.ToLower(event.Path), strings.ToLower(e.ui.state.Filter.PathFilter)) {
-			continue
-		}
-		// Filter operation
-		if e.ui.state.Filter.OperationFilter != 0 && event.Operation != e.ui.state.Filter.OperationFilter {
-			continue
-		}
-		// Filter type
-		if event.IsDir && !e.ui.state.Filter.ShowDirs {
-			continue
-		}
-		if !event.IsDir && !e.ui.state.Filter.ShowFiles {
-			continue
+		if e.ui.state.Filter.Matches(event) {
+			filtered = append(filtered, event)
 		}
-		filtered = append(filtered, event)
 	}
 	e.sortEvents(filtered)
 	return filtered
 }
 
+// Matches reports whether an event passes the filter
+func (f Filter) Matches(event *FileEvent) bool {
+	// Filter path
+	if f.PathFilter != "" &&
+		!strings.Contains(strings.ToLower(event.Path), strings.ToLower(f.PathFilter)) {
+		return false
+	}
+	// Filter operation
+	if f.OperationFilter != 0 && event.Operation != f.OperationFilter {
+		return false
+	}
+	// Filter type
+	if event.IsDir && !f.ShowDirs {
+		return false
+	}
+	if !event.IsDir && !f.ShowFiles {
+		return false
+	}
+	// Filter host
+	if f.HostFilter != "" && event.Host != f.HostFilter {
+		return false
+	}
+	// Filter risky attribute changes
+	if f.RiskFilter != "" && !matchesRisk(event.Attrs, f.RiskFilter) {
+		return false
+	}
+	return true
+}
+
+// RiskAny is the risk filter matching every risky attribute change
+const RiskAny = "any"
+
+// matchesRisk reports whether a change has a risk containing a text,
+// case-insensitively, or any risk for RiskAny
+func matchesRisk(change *attrs.Change, text string) bool {
+	if change == nil {
+		return false
+	}
+	for _, risk := range change.Risks {
+		if strings.EqualFold(text, RiskAny) || strings.Contains(strings.ToLower(risk), strings.ToLower(text)) {
+			return true
+		}
+	}
+	return false
+}
+
 // sortEvents sorts events according to the current option
 func (e *Events) sortEvents(events []*FileEvent) {
 	switch e.ui.state.SortOption {
@@ -135,12 +236,111 @@ func (e *Events) sortEvents(events []*FileEvent) {
 		sort.Slice(events, func(i, j int) bool {
 			return events[i].Count > events[j].Count
 		})
+	case SortByHost:
+		sort.Slice(events, func(i, j int) bool {
+			if events[i].Host != events[j].Host {
+				return events[i].Host < events[j].Host
+			}
+			return events[i].Timestamp.After(events[j].Timestamp)
+		})
+	}
+}
+
+// getScopedEvents returns the events covered by an export scope
+func (e *Events) getScopedEvents(scope ExportScope) []*FileEvent {
+	switch scope {
+	case ScopeFiltered:
+		return e.getFilteredEvents()
+	case ScopeSelected:
+		selected := make([]*FileEvent, 0, len(e.ui.state.SelectedEvents))
+		for _, event := range e.ui.state.Events {
+			if e.ui.state.SelectedEvents[event] {
+				selected = append(selected, event)
+			}
+		}
+		return selected
+	default:
+		return e.ui.state.Events
+	}
+}
+
+// copyScopedEvents returns copies of the events covered by a scope, taken
+// while no event is being recorded, so that they can be exported meanwhile
+func (e *Events) copyScopedEvents(scope ExportScope) []*FileEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	events := e.getScopedEvents(scope)
+	copies := make([]*FileEvent, len(events))
+	for i, event := range events {
+		copied := *event
+		copies[i] = &copied
+	}
+	return copies
+}
+
+// toggleSelection marks or unmarks an event for a selection export
+func (e *Events) toggleSelection(event *FileEvent) {
+	if e.ui.state.SelectedEvents[event] {
+		delete(e.ui.state.SelectedEvents, event)
+		return
 	}
+	e.ui.state.SelectedEvents[event] = true
+}
+
+// clearSelection unmarks every selected event
+func (e *Events) clearSelection() {
+	e.ui.state.SelectedEvents = make(map[*FileEvent]bool)
 }
 
 // getSortOptionName returns the name of the current sort option
 func (e *Events) getSortOptionName() string {
-	switch e.ui.state.SortOption {
+	return sortOptionName(e.ui.state.SortOption)
+}
+
+// sortOptions lists the sort options in the order the TUI cycles them
+var sortOptions = []SortOption{SortByTime, SortByPath, SortByOperation, SortByCount, SortByHost}
+
+// nextSortOption returns the sort option following another
+func nextSortOption(option SortOption) SortOption {
+	return (option + 1) % SortOption(len(sortOptions))
+}
+
+// hosts returns the hosts of the stored events, sorted
+func (e *Events) hosts() []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, event := range e.ui.state.Events {
+		if event.Host != "" && !seen[event.Host] {
+			seen[event.Host] = true
+			hosts = append(hosts, event.Host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// nextHostFilter returns the host filter following the current one: every
+// host, then each known host in turn
+func (e *Events) nextHostFilter() string {
+	hosts := e.hosts()
+	current := e.ui.state.Filter.HostFilter
+	for i, host := range hosts {
+		if host == current {
+			if i+1 < len(hosts) {
+				return hosts[i+1]
+			}
+			return ""
+		}
+	}
+	if current == "" && len(hosts) > 0 {
+		return hosts[0]
+	}
+	return ""
+}
+
+// sortOptionName returns the display name of a sort option
+func sortOptionName(option SortOption) string {
+	switch option {
 	case SortByTime:
 		return "Time"
 	case SortByPath:
@@ -149,7 +349,37 @@ func (e *Events) getSortOptionName() string {
 		return "Operation"
 	case SortByCount:
 		return "Count"
+	case SortByHost:
+		return "Host"
 	default:
 		return "Unknown"
 	}
 }
+
+// parseSortOption returns the sort option matching a name from sortOptionName
+func parseSortOption(name string) (SortOption, bool) {
+	for _, option := range sortOptions {
+		if strings.EqualFold(sortOptionName(option), name) {
+			return option, true
+		}
+	}
+	return SortByTime, false
+}
+
+// ParseSortOption parses a sort option name: time, path, operation, count
+// or host
+func ParseSortOption(name string) (SortOption, bool) {
+	return parseSortOption(name)
+}
+
+// exportScopeName returns the name of an export scope
+func exportScopeName(scope ExportScope) string {
+	switch scope {
+	case ScopeFiltered:
+		return "filtered"
+	case ScopeSelected:
+		return "selected"
+	default:
+		return "all"
+	}
+}
@@ -3,12 +3,17 @@ package ui
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
 	"github.com/pbouamriou/watch-fs/pkg/logger"
 )
 
@@ -22,32 +27,193 @@ func NewExportImport(ui *UI) *ExportImport {
 	return &ExportImport{ui: ui}
 }
 
-// ExportEvents exports events to a file
+// capture holds the events and metadata decoded from an export file
+type capture struct {
+	events  []*FileEvent
+	meta    ExportMeta
+	records int // Records found in the file, including skipped ones
+}
+
+// ExportEvents exports the events covered by the current export scope to a
+// file. A .gz or .zst suffix compresses the export, and a manifest with its
+// checksum is written next to it.
 func (ei *ExportImport) ExportEvents(filename string, format ExportFormat) error {
+	return ei.exportScope(filename, format, ei.ui.state.ExportScope)
+}
+
+// Snapshot exports every event, whatever the export scope
+func (ei *ExportImport) Snapshot(filename string, format ExportFormat) error {
+	return ei.exportScope(filename, format, ScopeAll)
+}
+
+// ExportView exports events picked by the caller as the filtered view
+func (ei *ExportImport) ExportView(filename string, format ExportFormat, events []*FileEvent) error {
+	return ei.exportEvents(filename, format, events, ScopeFiltered)
+}
+
+// exportScope exports the events covered by a scope, copied since events
+// may keep arriving during the export
+func (ei *ExportImport) exportScope(filename string, format ExportFormat, scope ExportScope) error {
+	return ei.exportEvents(filename, format, ei.ui.events.copyScopedEvents(scope), scope)
+}
+
+// exportEvents exports events, recording the scope they were taken from
+func (ei *ExportImport) exportEvents(filename string, format ExportFormat, events []*FileEvent, scope ExportScope) error {
+	meta := ei.buildMeta(events, scope)
+	_, compression, _ := DetectFormat(filename)
+
+	var err error
 	switch format {
 	case FormatSQLite:
-		return ei.exportToSQLite(filename)
+		err = ei.exportToSQLite(filename, events, meta, compression)
 	case FormatJSON:
-		return ei.exportToJSON(filename)
+		err = ei.exportToJSON(filename, events, meta, compression)
 	default:
 		return fmt.Errorf("unsupported export format")
 	}
+	if err != nil {
+		return err
+	}
+
+	return writeManifest(filename, format, compression, len(events))
+}
+
+// buildMeta records the scope, filter and sort used for an export
+func (ei *ExportImport) buildMeta(events []*FileEvent, scope ExportScope) ExportMeta {
+	filter := ei.ui.state.Filter
+	return ExportMeta{
+		ExportTime: time.Now(),
+		TotalCount: len(events),
+		Scope:      exportScopeName(scope),
+		Filter:     &filter,
+		SortOption: sortOptionName(ei.ui.state.SortOption),
+		Roots:      ei.ui.rootPaths,
+	}
+}
+
+// applyMeta restores the filter and sort recorded in an export
+func (ei *ExportImport) applyMeta(meta ExportMeta) {
+	if meta.Filter != nil {
+		ei.ui.state.Filter = *meta.Filter
+	}
+	if option, ok := parseSortOption(meta.SortOption); ok {
+		ei.ui.state.SortOption = option
+	}
 }
 
-// ImportEvents imports events from a file
+// ImportEvents imports events from a file. When the file has a manifest,
+// the import fails with a *ManifestError if the file does not match it.
 func (ei *ExportImport) ImportEvents(filename string, format ExportFormat) error {
+	return ei.importEvents(filename, format, false)
+}
+
+// ImportEventsPartial imports whatever events can be recovered from a file,
+// without checking its manifest
+func (ei *ExportImport) ImportEventsPartial(filename string, format ExportFormat) error {
+	return ei.importEvents(filename, format, true)
+}
+
+// importEvents reads an export file and replaces the current events with it
+func (ei *ExportImport) importEvents(filename string, format ExportFormat, partial bool) error {
+	c, err := ei.loadCapture(filename, format, partial)
+	if err != nil {
+		return err
+	}
+
+	// Replace current events
+	ei.ui.state.Events = c.events
+	ei.ui.events.clearSelection()
+	ei.applyMeta(c.meta)
+
+	return nil
+}
+
+// loadCapture reads an export file or watcher log without changing the state.
+// Unless partial is set, the file must match its manifest.
+func (ei *ExportImport) loadCapture(filename string, format ExportFormat, partial bool) (*capture, error) {
+	// SQLite would otherwise create a missing database
+	if _, err := os.Stat(filename); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var manifest *Manifest
+	if !partial {
+		var err error
+		if manifest, err = readManifest(filename); err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			if err := verifyChecksum(filename, manifest); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, compression, _ := DetectFormat(filename)
+
+	var c *capture
+	var err error
 	switch format {
 	case FormatSQLite:
-		return ei.importFromSQLite(filename)
+		c, err = ei.readSQLite(filename, compression)
 	case FormatJSON:
-		return ei.importFromJSON(filename)
+		c, err = ei.readJSON(filename, compression)
+	case FormatInotifywait, FormatFswatch, FormatWatchman:
+		c, err = ei.readText(filename, format, compression)
 	default:
-		return fmt.Errorf("unsupported import format")
+		return nil, fmt.Errorf("unsupported import format")
+	}
+	if err != nil && (!partial || c == nil || len(c.events) == 0) {
+		return nil, err
+	}
+
+	if manifest != nil && c.records != manifest.EventCount {
+		return nil, &ManifestError{
+			File:   filename,
+			Reason: fmt.Sprintf("found %d events, manifest expects %d", c.records, manifest.EventCount),
+		}
 	}
+
+	return c, nil
 }
 
 // exportToSQLite exports events to SQLite database
-func (ei *ExportImport) exportToSQLite(filename string) error {
+func (ei *ExportImport) exportToSQLite(filename string, events []*FileEvent, meta ExportMeta, compression Compression) error {
+	// Compressed databases are built in a temporary file first
+	dbFile := filename
+	if compression != CompressionNone {
+		tmp, err := os.CreateTemp(filepath.Dir(filename), ".watch-fs-export-*.db")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary database: %w", err)
+		}
+		dbFile = tmp.Name()
+		_ = tmp.Close()
+		defer func() {
+			if err := os.Remove(dbFile); err != nil {
+				logger.Error(err, "Failed to remove temporary database")
+			}
+		}()
+	}
+
+	// Start from an empty database so that the export matches its manifest
+	if err := os.Remove(dbFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+
+	if err := writeSQLite(dbFile, events, meta); err != nil {
+		return err
+	}
+
+	if compression != CompressionNone {
+		if err := compressFile(dbFile, filename, compression); err != nil {
+			return fmt.Errorf("failed to compress database: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeSQLite writes events and metadata to a new SQLite database
+func writeSQLite(filename string, events []*FileEvent, meta ExportMeta) error {
 	// Create database
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
@@ -68,11 +234,23 @@ func (ei *ExportImport) exportToSQLite(filename string) error {
 		timestamp DATETIME NOT NULL,
 		is_dir BOOLEAN NOT NULL,
 		count INTEGER NOT NULL,
+		host TEXT NOT NULL DEFAULT '',
+		mode_before INTEGER,
+		mode_after INTEGER,
+		uid_before INTEGER,
+		gid_before INTEGER,
+		uid_after INTEGER,
+		gid_after INTEGER,
+		risks TEXT NOT NULL DEFAULT '',
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
 	CREATE INDEX IF NOT EXISTS idx_events_path ON events(path);
 	CREATE INDEX IF NOT EXISTS idx_events_operation ON events(operation);
+	CREATE TABLE IF NOT EXISTS meta (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
 	`
 
 	_, err = db.Exec(createTableSQL)
@@ -81,7 +259,9 @@ func (ei *ExportImport) exportToSQLite(filename string) error {
 	}
 
 	// Insert events
-	insertSQL := `INSERT INTO events (path, operation, timestamp, is_dir, count) VALUES (?, ?, ?, ?, ?)`
+	insertSQL := `INSERT INTO events (path, operation, timestamp, is_dir, count, host,
+		mode_before, mode_after, uid_before, gid_before, uid_after, gid_after, risks)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	stmt, err := db.Prepare(insertSQL)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
@@ -92,22 +272,144 @@ func (ei *ExportImport) exportToSQLite(filename string) error {
 		}
 	}()
 
-	for _, event := range ei.ui.state.Events {
-		_, err = stmt.Exec(event.Path, event.Operation.String(), event.Timestamp, event.IsDir, event.Count)
+	for _, event := range events {
+		values := []any{event.Path, event.Operation.String(), event.Timestamp, event.IsDir, event.Count, event.Host}
+		_, err = stmt.Exec(append(values, attrValues(event.Attrs)...)...)
 		if err != nil {
 			return fmt.Errorf("failed to insert event: %w", err)
 		}
 	}
 
+	return writeSQLiteMeta(db, meta)
+}
+
+// attrValues returns the attribute columns of an event, NULL but the risks
+// for the events that changed no attribute
+func attrValues(change *attrs.Change) []any {
+	if change == nil {
+		return []any{nil, nil, nil, nil, nil, nil, ""}
+	}
+	return []any{uint32(change.Before.Mode), uint32(change.After.Mode),
+		change.Before.UID, change.Before.GID, change.After.UID, change.After.GID,
+		strings.Join(change.Risks, ",")}
+}
+
+// writeSQLiteMeta stores export metadata in the meta table
+func writeSQLiteMeta(db *sql.DB, meta ExportMeta) error {
+	filter, err := json.Marshal(meta.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal filter: %w", err)
+	}
+
+	roots, err := json.Marshal(meta.Roots)
+	if err != nil {
+		return fmt.Errorf("failed to marshal roots: %w", err)
+	}
+
+	values := map[string]string{
+		"export_time": meta.ExportTime.Format(time.RFC3339Nano),
+		"total_count": strconv.Itoa(meta.TotalCount),
+		"scope":       meta.Scope,
+		"filter":      string(filter),
+		"sort":        meta.SortOption,
+		"roots":       string(roots),
+	}
+	for key, value := range values {
+		if _, err := db.Exec(`INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)`, key, value); err != nil {
+			return fmt.Errorf("failed to write metadata: %w", err)
+		}
+	}
 	return nil
 }
 
-// importFromSQLite imports events from SQLite database
-func (ei *ExportImport) importFromSQLite(filename string) error {
+// hasColumn reports whether a table has a column
+func hasColumn(db *sql.DB, table, column string) bool {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	return err == nil && count > 0
+}
+
+// readSQLiteMeta loads export metadata, if the database has any
+func readSQLiteMeta(db *sql.DB) (ExportMeta, error) {
+	var meta ExportMeta
+
+	rows, err := db.Query(`SELECT key, value FROM meta`)
+	if err != nil {
+		// Databases exported before metadata was recorded have no meta table
+		return meta, nil
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logger.Error(err, "rows close error")
+		}
+	}()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return meta, fmt.Errorf("failed to scan metadata: %w", err)
+		}
+		switch key {
+		case "export_time":
+			meta.ExportTime, _ = time.Parse(time.RFC3339Nano, value)
+		case "total_count":
+			meta.TotalCount, _ = strconv.Atoi(value)
+		case "scope":
+			meta.Scope = value
+		case "filter":
+			var filter Filter
+			if err := json.Unmarshal([]byte(value), &filter); err == nil {
+				meta.Filter = &filter
+			}
+		case "sort":
+			meta.SortOption = value
+		case "roots":
+			_ = json.Unmarshal([]byte(value), &meta.Roots)
+		}
+	}
+	return meta, rows.Err()
+}
+
+// readSQLite reads events from a SQLite database, decompressing it first if needed
+func (ei *ExportImport) readSQLite(filename string, compression Compression) (*capture, error) {
+	dbFile := filename
+	var decompressErr error
+	if compression != CompressionNone {
+		tmp, err := os.CreateTemp("", "watch-fs-import-*.db")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary database: %w", err)
+		}
+		dbFile = tmp.Name()
+		_ = tmp.Close()
+		defer func() {
+			if err := os.Remove(dbFile); err != nil {
+				logger.Error(err, "Failed to remove temporary database")
+			}
+		}()
+
+		// Keep going on damaged input: SQLite may still read the pages that survived
+		decompressErr = decompressFile(filename, dbFile, compression)
+		if decompressErr != nil && !errors.Is(decompressErr, ErrCorruptExport) {
+			return nil, decompressErr
+		}
+	}
+
+	c, err := readSQLiteFile(dbFile)
+	if err != nil {
+		if decompressErr != nil {
+			return c, decompressErr
+		}
+		return c, fmt.Errorf("%w: %v", ErrCorruptExport, err)
+	}
+	return c, decompressErr
+}
+
+// readSQLiteFile reads events from an uncompressed SQLite database
+func readSQLiteFile(filename string) (*capture, error) {
 	// Open database
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -116,9 +418,20 @@ func (ei *ExportImport) importFromSQLite(filename string) error {
 	}()
 
 	// Query events
-	rows, err := db.Query(`SELECT path, operation, timestamp, is_dir, count FROM events ORDER BY timestamp DESC`)
+	// Databases exported before hosts were recorded have no host column
+	hostColumn := "'' AS host"
+	if hasColumn(db, "events", "host") {
+		hostColumn = "host"
+	}
+	// Those exported before attribute changes were recorded have none of
+	// their columns
+	attrColumns := "NULL, NULL, NULL, NULL, NULL, NULL, ''"
+	if hasColumn(db, "events", "mode_after") {
+		attrColumns = "mode_before, mode_after, uid_before, gid_before, uid_after, gid_after, risks"
+	}
+	rows, err := db.Query(`SELECT path, operation, timestamp, is_dir, count, ` + hostColumn + `, ` + attrColumns + ` FROM events ORDER BY timestamp DESC`)
 	if err != nil {
-		return fmt.Errorf("failed to query events: %w", err)
+		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -126,66 +439,57 @@ func (ei *ExportImport) importFromSQLite(filename string) error {
 		}
 	}()
 
-	var events []*FileEvent
+	c := &capture{}
 	for rows.Next() {
-		var path, operationStr string
+		var path, operationStr, host string
 		var timestamp time.Time
 		var isDir bool
 		var count int
+		var modeBefore, modeAfter, uidBefore, gidBefore, uidAfter, gidAfter sql.NullInt64
+		var risks string
 
-		err := rows.Scan(&path, &operationStr, &timestamp, &isDir, &count)
+		err := rows.Scan(&path, &operationStr, &timestamp, &isDir, &count, &host,
+			&modeBefore, &modeAfter, &uidBefore, &gidBefore, &uidAfter, &gidAfter, &risks)
 		if err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
-		}
-
-		// Parse operation
-		var operation fsnotify.Op
-		switch operationStr {
-		case "CREATE":
-			operation = fsnotify.Create
-		case "WRITE":
-			operation = fsnotify.Write
-		case "REMOVE":
-			operation = fsnotify.Remove
-		case "RENAME":
-			operation = fsnotify.Rename
-		case "CHMOD":
-			operation = fsnotify.Chmod
-		default:
+			return c, fmt.Errorf("failed to scan row: %w", err)
+		}
+		c.records++
+
+		event, ok := decodeEvent(path, operationStr, timestamp, isDir, count)
+		if !ok {
 			// Skip unknown operations
 			continue
 		}
-
-		event := &FileEvent{
-			Path:      path,
-			Operation: operation,
-			Timestamp: timestamp,
-			IsDir:     isDir,
-			Count:     count,
+		event.Host = host
+		if modeAfter.Valid {
+			event.Attrs = &attrs.Change{
+				Before: attrs.Attributes{Mode: fs.FileMode(modeBefore.Int64), UID: int(uidBefore.Int64), GID: int(gidBefore.Int64)},
+				After:  attrs.Attributes{Mode: fs.FileMode(modeAfter.Int64), UID: int(uidAfter.Int64), GID: int(gidAfter.Int64)},
+			}
+			if risks != "" {
+				event.Attrs.Risks = strings.Split(risks, ",")
+			}
 		}
-		events = append(events, event)
+		c.events = append(c.events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return c, fmt.Errorf("failed to read events: %w", err)
 	}
 
-	// Replace current events
-	ei.ui.state.Events = events
-
-	return nil
+	c.meta, err = readSQLiteMeta(db)
+	return c, err
 }
 
 // exportToJSON exports events to JSON file
-func (ei *ExportImport) exportToJSON(filename string) error {
+func (ei *ExportImport) exportToJSON(filename string, events []*FileEvent, meta ExportMeta, compression Compression) error {
 	// Create export data structure
 	exportData := struct {
 		Events []*FileEvent `json:"events"`
-		Meta   struct {
-			ExportTime time.Time `json:"export_time"`
-			TotalCount int       `json:"total_count"`
-		} `json:"meta"`
+		Meta   ExportMeta   `json:"meta"`
 	}{
-		Events: ei.ui.state.Events,
+		Events: events,
+		Meta:   meta,
 	}
-	exportData.Meta.ExportTime = time.Now()
-	exportData.Meta.TotalCount = len(ei.ui.state.Events)
 
 	// Marshal to JSON
 	data, err := json.MarshalIndent(exportData, "", "  ")
@@ -194,39 +498,106 @@ func (ei *ExportImport) exportToJSON(filename string) error {
 	}
 
 	// Write to file
-	err = os.WriteFile(filename, data, 0644)
+	return writeCompressedFile(filename, data, compression)
+}
+
+// readJSON reads events from a JSON export, decompressing it if needed
+func (ei *ExportImport) readJSON(filename string, compression Compression) (*capture, error) {
+	// Read file
+	file, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
 
-	return nil
-}
+	reader, err := newDecompressedReader(file, compression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptExport, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
 
-// importFromJSON imports events from JSON file
-func (ei *ExportImport) importFromJSON(filename string) error {
-	// Read file
-	data, err := os.ReadFile(filename)
+	c, err := decodeJSONCapture(reader)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return c, fmt.Errorf("%w: failed to unmarshal JSON: %v", ErrCorruptExport, err)
 	}
+	return c, nil
+}
 
-	// Unmarshal JSON
-	var importData struct {
-		Events []*FileEvent `json:"events"`
-		Meta   struct {
-			ExportTime time.Time `json:"export_time"`
-			TotalCount int       `json:"total_count"`
-		} `json:"meta"`
+// decodeJSONCapture decodes a JSON export one event at a time, so that the
+// events read before a decoding error are still returned
+func decodeJSONCapture(r io.Reader) (*capture, error) {
+	c := &capture{}
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return c, err
 	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return c, err
+		}
 
-	err = json.Unmarshal(data, &importData)
+		switch token {
+		case "events":
+			if err := decodeJSONEvents(dec, c); err != nil {
+				return c, err
+			}
+		case "meta":
+			if err := dec.Decode(&c.meta); err != nil {
+				return c, err
+			}
+		default:
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return c, err
+			}
+		}
+	}
+	return c, expectDelim(dec, '}')
+}
+
+// decodeJSONEvents decodes the events array of a JSON export
+func decodeJSONEvents(dec *json.Decoder, c *capture) error {
+	token, err := dec.Token()
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal JSON: %w", err)
+		return err
+	}
+	if token == nil {
+		return nil // "events": null
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected events array, got %v", token)
 	}
 
-	// Replace current events
-	ei.ui.state.Events = importData.Events
+	for dec.More() {
+		var event FileEvent
+		if err := dec.Decode(&event); err != nil {
+			return err
+		}
+		c.records++
+		c.events = append(c.events, &event)
+	}
+	return expectDelim(dec, ']')
+}
 
+// expectDelim reads the next JSON token and checks that it is the given delimiter
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("expected %q, got %v", want, token)
+	}
 	return nil
 }
 
@@ -245,3 +616,13 @@ func (ei *ExportImport) GetRecentExportFiles() (string, string) {
 
 	return sqliteFile, jsonFile
 }
+
+// LoadCapture reads the events and metadata of an export file or watcher log
+// without changing the state
+func (ei *ExportImport) LoadCapture(filename string, format ExportFormat) ([]*FileEvent, ExportMeta, error) {
+	c, err := ei.loadCapture(filename, format, false)
+	if err != nil {
+		return nil, ExportMeta{}, err
+	}
+	return c.events, c.meta, nil
+}
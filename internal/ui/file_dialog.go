@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -102,14 +103,10 @@ func (fd *FileDialog) loadDirectory(path string) error {
 			continue
 		}
 
-		// Apply filter for files
-		if !entry.IsDir() {
-			if fd.ui.state.FileDialog.Filter != "" && fd.ui.state.FileDialog.Filter != "*" {
-				matched, _ := filepath.Match(fd.ui.state.FileDialog.Filter, entry.Name())
-				if !matched {
-					continue
-				}
-			}
+		// Apply filter for files, hiding export manifests
+		if !entry.IsDir() && (!matchesFilter(fd.ui.state.FileDialog.Filter, entry.Name()) ||
+			strings.HasSuffix(entry.Name(), manifestSuffix)) {
+			continue
 		}
 
 		fullPath := filepath.Join(path, entry.Name())
@@ -191,26 +188,57 @@ func (fd *FileDialog) Enter(g *gocui.Gui, v *gocui.View) error {
 		}
 	} else {
 		// File selected
-		if fd.ui.state.FileDialog.Mode == ModeOpen {
-			// Import mode - load the file
-			format := FormatSQLite
-			if strings.HasSuffix(selected.Path, ".json") {
-				format = FormatJSON
+		if fd.ui.state.FileDialog.Mode == ModeDiff {
+			// Diff mode - the first file is A, the second one B
+			if fd.ui.state.Diff.FirstFile == "" {
+				fd.ui.state.Diff.FirstFile = selected.Path
+				fd.ui.setStatusMessage(fmt.Sprintf("Comparing %s with...", selected.Name))
+				return fd.ui.layout.Layout(g)
+			}
+
+			first := fd.ui.state.Diff.FirstFile
+			formatA, _ := CaptureFormat(first)
+			formatB, _ := CaptureFormat(selected.Path)
+			result, err := fd.ui.CompareCaptures(first, formatA, selected.Path, formatB)
+			fd.Hide()
+			if err != nil {
+				fd.ui.state.Diff.FirstFile = ""
+				fd.ui.setStatusMessage(fmt.Sprintf("Diff failed: %v", err))
+			} else {
+				fd.ui.setStatusMessage("")
+				fd.ui.ShowDiff(result)
+			}
+			return fd.ui.layout.Layout(g)
+		} else if fd.ui.state.FileDialog.Mode == ModeReplay {
+			// Replay mode - replay the file in real time
+			format, _ := CaptureFormat(selected.Path)
+
+			err := fd.ui.StartReplay(selected.Path, format, 1, false)
+			fd.Hide()
+			if err != nil {
+				fd.ui.setStatusMessage(fmt.Sprintf("Replay failed: %v", err))
+			} else {
+				fd.ui.setStatusMessage(fmt.Sprintf("Replaying %s", selected.Name))
 			}
+			return fd.ui.layout.Layout(g)
+		} else if fd.ui.state.FileDialog.Mode == ModeOpen {
+			// Import mode - load the file, looking at its content when the
+			// extension does not tell the format (e.g. .log)
+			format, _ := CaptureFormat(selected.Path)
 
 			err := fd.ui.ImportEvents(selected.Path, format)
+			fd.Hide()
 			if err != nil {
-				// Could show error, but for now just hide dialog
-				fd.Hide()
+				fd.reportImportError(selected.Path, format, err)
 			} else {
-				fd.Hide()
-				// Update UI
-				if v, err := g.View(StatusView); err == nil {
-					fd.ui.views.UpdateStatusView(v)
-				}
-				if v, err := g.View(EventsView); err == nil {
-					fd.ui.views.UpdateEventsView(v)
-				}
+				fd.ui.setStatusMessage(fmt.Sprintf("Imported %s", selected.Name))
+			}
+			// Update UI
+			if v, err := g.View(StatusView); err == nil {
+				fd.ui.views.UpdateStatusView(v)
+			}
+			if v, err := g.View(EventsView); err == nil {
+				fd.ui.views.UpdateEventsView(v)
 			}
 		} else {
 			// Save mode - offer to edit filename or use selected file
@@ -227,21 +255,14 @@ func (fd *FileDialog) Enter(g *gocui.Gui, v *gocui.View) error {
 			} else {
 				// Ask user if they want to edit the filename
 				// For now, just use the selected file
-				format := FormatSQLite
-				if strings.HasSuffix(selected.Path, ".json") {
-					format = FormatJSON
-				}
+				format, _, _ := DetectFormat(selected.Path)
 
 				err := fd.ui.ExportEvents(selected.Path, format)
-				if err != nil {
-					// Could show error, but for now just hide dialog
-					fd.Hide()
-				} else {
-					fd.Hide()
-					// Update UI
-					if v, err := g.View(StatusView); err == nil {
-						fd.ui.views.UpdateStatusView(v)
-					}
+				fd.Hide()
+				fd.reportExportResult(selected.Path, err)
+				// Update UI
+				if v, err := g.View(StatusView); err == nil {
+					fd.ui.views.UpdateStatusView(v)
 				}
 			}
 		}
@@ -250,6 +271,48 @@ func (fd *FileDialog) Enter(g *gocui.Gui, v *gocui.View) error {
 	return fd.ui.layout.Layout(g)
 }
 
+// reportExportResult shows the outcome of an export in the status bar
+func (fd *FileDialog) reportExportResult(path string, err error) {
+	if err != nil {
+		logger.Error(err, "Export failed")
+		fd.ui.setStatusMessage(fmt.Sprintf("Export failed: %v", err))
+		return
+	}
+	fd.ui.setStatusMessage(fmt.Sprintf("Exported %s", filepath.Base(path)))
+}
+
+// reportImportError shows an import failure. Damaged files get a prompt
+// offering to import whatever events can be recovered.
+func (fd *FileDialog) reportImportError(path string, format ExportFormat, err error) {
+	logger.Error(err, "Import failed")
+	fd.ui.setStatusMessage(fmt.Sprintf("Import failed: %v", err))
+
+	if !errors.Is(err, ErrManifestMismatch) && !errors.Is(err, ErrCorruptExport) {
+		return
+	}
+	message := fmt.Sprintf("%s looks damaged:\n%v\nImport the events that can be recovered?", filepath.Base(path), err)
+	fd.ui.confirm.Show(message, func() error {
+		if err := fd.ui.ImportEventsPartial(path, format); err != nil {
+			return err
+		}
+		fd.ui.setStatusMessage(fmt.Sprintf("Partially imported %s (%d events)", filepath.Base(path), len(fd.ui.state.Events)))
+		return nil
+	})
+}
+
+// matchesFilter reports whether a filename matches a comma-separated list of glob patterns
+func matchesFilter(filter, name string) bool {
+	if filter == "" || filter == "*" {
+		return true
+	}
+	for _, pattern := range strings.Split(filter, ",") {
+		if matched, _ := filepath.Match(strings.TrimSpace(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // EditFilename switches to filename editing mode
 func (fd *FileDialog) EditFilename(g *gocui.Gui, v *gocui.View) error {
 	if fd.ui.state.FileDialog.Mode == ModeSave {
@@ -263,6 +326,15 @@ func (fd *FileDialog) EditFilename(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
+// CycleScope switches the export scope (save mode only)
+func (fd *FileDialog) CycleScope(g *gocui.Gui, v *gocui.View) error {
+	if fd.ui.state.FileDialog.Mode != ModeSave {
+		return nil
+	}
+	fd.ui.CycleExportScope()
+	return fd.ui.layout.Layout(g)
+}
+
 // Cancel cancels the file dialog
 func (fd *FileDialog) Cancel(g *gocui.Gui, v *gocui.View) error {
 	fd.Hide()
@@ -282,12 +354,27 @@ func (fd *FileDialog) UpdatePathView(v *gocui.View) {
 	yellow := color.New(color.FgYellow).SprintFunc()
 
 	mode := "Save"
-	if fd.ui.state.FileDialog.Mode == ModeOpen {
+	switch fd.ui.state.FileDialog.Mode {
+	case ModeOpen:
 		mode = "Open"
+	case ModeReplay:
+		mode = "Replay"
+	case ModeDiff:
+		mode = "Compare A"
+		if fd.ui.state.Diff.FirstFile != "" {
+			mode = fmt.Sprintf("Compare %s with B", filepath.Base(fd.ui.state.Diff.FirstFile))
+		}
 	}
 
 	_, _ = fmt.Fprintf(v, "%s: %s\n", yellow(mode), cyan(fd.ui.state.FileDialog.CurrentPath))
-	_, _ = fmt.Fprintf(v, "Filter: %s\n", yellow(fd.ui.state.FileDialog.Filter))
+	if fd.ui.state.FileDialog.Mode == ModeSave {
+		_, _ = fmt.Fprintf(v, "Filter: %s | Scope: %s (%d events)\n",
+			yellow(fd.ui.state.FileDialog.Filter),
+			cyan(exportScopeName(fd.ui.state.ExportScope)),
+			len(fd.ui.GetScopedEvents()))
+	} else {
+		_, _ = fmt.Fprintf(v, "Filter: %s\n", yellow(fd.ui.state.FileDialog.Filter))
+	}
 }
 
 // UpdateFileListView updates the file list view
@@ -429,34 +516,26 @@ func (fd *FileDialog) FilenameEditor(v *gocui.View, key gocui.Key, ch rune, mod
 		// Build full path
 		fullPath := filepath.Join(fd.ui.state.FileDialog.CurrentPath, filename)
 
-		// Determine format based on extension
-		var format ExportFormat
-		if strings.HasSuffix(filename, ".json") {
-			format = FormatJSON
-		} else {
-			format = FormatSQLite
+		// Determine format based on extension (.db, .json, optionally .gz or .zst)
+		format, _, ok := DetectFormat(filename)
+		if !ok {
 			// Ensure .db extension
-			if !strings.HasSuffix(filename, ".db") {
-				fullPath += ".db"
-			}
+			format = FormatSQLite
+			fullPath += ".db"
 		}
 
 		// Perform export
 		err := fd.ui.ExportEvents(fullPath, format)
-		if err != nil {
-			// Could show error in status, but for now just hide dialog
-			fd.Hide()
-		} else {
-			fd.Hide()
-			// Update UI
-			if fd.ui.gui != nil {
-				fd.ui.gui.Update(func(g *gocui.Gui) error {
-					if v, err := g.View(StatusView); err == nil {
-						fd.ui.views.UpdateStatusView(v)
-					}
-					return fd.ui.layout.Layout(g)
-				})
-			}
+		fd.Hide()
+		fd.reportExportResult(fullPath, err)
+		// Update UI
+		if fd.ui.gui != nil {
+			fd.ui.gui.Update(func(g *gocui.Gui) error {
+				if v, err := g.View(StatusView); err == nil {
+					fd.ui.views.UpdateStatusView(v)
+				}
+				return fd.ui.layout.Layout(g)
+			})
 		}
 	case key == gocui.KeyEsc:
 		// Cancel editing, return to file list
@@ -87,6 +87,18 @@ func (kb *Keybindings) Setup(g *gocui.Gui) error {
 	if err := g.SetKeybinding(EventsView, 's', gocui.ModNone, kb.cycleSort); err != nil {
 		return err
 	}
+	if err := g.SetKeybinding(EventsView, 'H', gocui.ModNone, kb.cycleHost); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, 'R', gocui.ModNone, kb.toggleRisky); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, gocui.KeySpace, gocui.ModNone, kb.toggleSelection); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, 'c', gocui.ModNone, kb.clearSelection); err != nil {
+		return err
+	}
 	if err := g.SetKeybinding(EventsView, gocui.KeyCtrlE, gocui.ModNone, kb.exportEventsHandler); err != nil {
 		return err
 	}
@@ -96,6 +108,40 @@ func (kb *Keybindings) Setup(g *gocui.Gui) error {
 	if err := g.SetKeybinding(EventsView, gocui.KeyCtrlF, gocui.ModNone, kb.showFolderManager); err != nil {
 		return err
 	}
+	if err := g.SetKeybinding(EventsView, gocui.KeyCtrlR, gocui.ModNone, kb.replayEventsHandler); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, gocui.KeyCtrlD, gocui.ModNone, kb.diffEventsHandler); err != nil {
+		return err
+	}
+
+	// Command and profile controls
+	if err := g.SetKeybinding(EventsView, 'r', gocui.ModNone, kb.commandRerun); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, 'P', gocui.ModNone, kb.profileNext); err != nil {
+		return err
+	}
+
+	// Replay controls
+	if err := g.SetKeybinding(EventsView, 'p', gocui.ModNone, kb.replayTogglePause); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, 'n', gocui.ModNone, kb.replayStep); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, '+', gocui.ModNone, kb.replayFaster); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, '-', gocui.ModNone, kb.replaySlower); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, 'x', gocui.ModNone, kb.replayStop); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(EventsView, 'B', gocui.ModNone, kb.acceptViolation); err != nil {
+		return err
+	}
 
 	// Global escape key for closing popups
 	if err := g.SetKeybinding("", gocui.KeyEsc, gocui.ModNone, kb.debugEscape); err != nil {
@@ -113,6 +159,28 @@ func (kb *Keybindings) Setup(g *gocui.Gui) error {
 		return err
 	}
 
+	// Confirmation prompt keybindings
+	if err := g.SetKeybinding(ConfirmView, 'y', gocui.ModNone, kb.confirmAccept); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(ConfirmView, gocui.KeyEnter, gocui.ModNone, kb.confirmAccept); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(ConfirmView, 'n', gocui.ModNone, kb.confirmReject); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(ConfirmView, 'q', gocui.ModNone, kb.confirmReject); err != nil {
+		return err
+	}
+
+	// Detection popup keybindings
+	if err := g.SetKeybinding(DetectionView, gocui.KeyEnter, gocui.ModNone, kb.detectionDismiss); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(DetectionView, 'q', gocui.ModNone, kb.detectionDismiss); err != nil {
+		return err
+	}
+
 	// File dialog keybindings
 	if err := g.SetKeybinding(FileListView, gocui.KeyArrowUp, gocui.ModNone, kb.fileDialogUp); err != nil {
 		return err
@@ -138,6 +206,29 @@ func (kb *Keybindings) Setup(g *gocui.Gui) error {
 	if err := g.SetKeybinding(FileListView, 'e', gocui.ModNone, kb.fileDialogEditFilename); err != nil {
 		return err
 	}
+	if err := g.SetKeybinding(FileListView, 'v', gocui.ModNone, kb.fileDialogCycleScope); err != nil {
+		return err
+	}
+
+	// Session diff keybindings
+	if err := g.SetKeybinding(DiffView, gocui.KeyArrowUp, gocui.ModNone, kb.diffUp); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(DiffView, gocui.KeyArrowDown, gocui.ModNone, kb.diffDown); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(DiffView, 'k', gocui.ModNone, kb.diffUp); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(DiffView, 'j', gocui.ModNone, kb.diffDown); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(DiffView, 'e', gocui.ModNone, kb.diffExport); err != nil {
+		return err
+	}
+	if err := g.SetKeybinding(DiffView, 'q', gocui.ModNone, kb.diffClose); err != nil {
+		return err
+	}
 
 	// Folder manager keybindings
 	if err := g.SetKeybinding(FolderListView, gocui.KeyArrowUp, gocui.ModNone, kb.folderManagerUp); err != nil {
@@ -233,6 +324,26 @@ func (kb *Keybindings) cycleSort(g *gocui.Gui, v *gocui.View) error {
 	return kb.ui.navigation.cycleSort(g, v)
 }
 
+func (kb *Keybindings) toggleRisky(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.navigation.toggleRisky(g, v)
+}
+
+func (kb *Keybindings) cycleHost(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.navigation.cycleHost(g, v)
+}
+
+func (kb *Keybindings) toggleSelection(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.navigation.toggleSelection(g, v)
+}
+
+func (kb *Keybindings) acceptViolation(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.navigation.acceptViolation(g, v)
+}
+
+func (kb *Keybindings) clearSelection(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.navigation.clearSelection(g, v)
+}
+
 // Navigation wrapper functions
 func (kb *Keybindings) navigationMoveUp(g *gocui.Gui, v *gocui.View) error {
 	return kb.ui.navigation.moveUp(g, v)
@@ -298,14 +409,27 @@ func (kb *Keybindings) hideEventDetails(g *gocui.Gui, v *gocui.View) error {
 
 // Export/Import handlers
 func (kb *Keybindings) exportEventsHandler(g *gocui.Gui, v *gocui.View) error {
-	// Ouvre le dialogue d'export (mode Save, filtre .db)
-	kb.ui.showFileDialog(ModeSave, "*.db")
+	// Ouvre le dialogue d'export (mode Save, filtre des formats d'export)
+	kb.ui.showFileDialog(ModeSave, exportFileFilter)
 	return nil
 }
 
 func (kb *Keybindings) importEventsHandler(g *gocui.Gui, v *gocui.View) error {
-	// Ouvre le dialogue d'import (mode Open, filtre .db)
-	kb.ui.showFileDialog(ModeOpen, "*.db")
+	// Ouvre le dialogue d'import (mode Open, filtre des formats d'export)
+	kb.ui.showFileDialog(ModeOpen, importFileFilter)
+	return nil
+}
+
+func (kb *Keybindings) replayEventsHandler(g *gocui.Gui, v *gocui.View) error {
+	// Opens the replay dialog (Open mode with the import filter)
+	kb.ui.showFileDialog(ModeReplay, importFileFilter)
+	return nil
+}
+
+func (kb *Keybindings) diffEventsHandler(g *gocui.Gui, v *gocui.View) error {
+	// Opens the dialog picking the two captures to compare
+	kb.ui.state.Diff.FirstFile = ""
+	kb.ui.showFileDialog(ModeDiff, importFileFilter)
 	return nil
 }
 
@@ -332,10 +456,75 @@ func (kb *Keybindings) fileDialogEditFilename(g *gocui.Gui, v *gocui.View) error
 	return kb.ui.fileDialog.EditFilename(g, v)
 }
 
+func (kb *Keybindings) fileDialogCycleScope(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.fileDialog.CycleScope(g, v)
+}
+
 func (kb *Keybindings) fileDialogCancel(g *gocui.Gui, v *gocui.View) error {
 	return kb.ui.fileDialog.Cancel(g, v)
 }
 
+// Command handlers
+func (kb *Keybindings) commandRerun(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.command.rerun(g, v)
+}
+
+func (kb *Keybindings) profileNext(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.profileSwitch.next(g, v)
+}
+
+// Replay handlers
+func (kb *Keybindings) replayTogglePause(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.replay.togglePause(g, v)
+}
+
+func (kb *Keybindings) replayStep(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.replay.step(g, v)
+}
+
+func (kb *Keybindings) replayFaster(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.replay.faster(g, v)
+}
+
+func (kb *Keybindings) replaySlower(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.replay.slower(g, v)
+}
+
+func (kb *Keybindings) replayStop(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.replay.stop(g, v)
+}
+
+// Session diff functions
+func (kb *Keybindings) diffUp(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.diff.Up(g, v)
+}
+
+func (kb *Keybindings) diffDown(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.diff.Down(g, v)
+}
+
+func (kb *Keybindings) diffExport(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.diff.Export(g, v)
+}
+
+func (kb *Keybindings) diffClose(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.diff.Close(g, v)
+}
+
+// Detection popup functions
+func (kb *Keybindings) detectionDismiss(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.detection.Hide(g, v)
+}
+
+// Confirmation prompt functions
+func (kb *Keybindings) confirmAccept(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.confirm.Accept(g, v)
+}
+
+func (kb *Keybindings) confirmReject(g *gocui.Gui, v *gocui.View) error {
+	return kb.ui.confirm.Reject(g, v)
+}
+
 // Folder manager keybindings
 func (kb *Keybindings) folderManagerUp(g *gocui.Gui, v *gocui.View) error {
 	return kb.ui.folderManager.Up(g, v)
@@ -398,10 +587,16 @@ func (kb *Keybindings) quit(g *gocui.Gui, v *gocui.View) error {
 
 func (kb *Keybindings) debugEscape(g *gocui.Gui, v *gocui.View) error {
 	// Handle escape key based on current focus
-	if kb.ui.state.ShowDetails {
+	if kb.ui.state.ShowDetection {
+		return kb.detectionDismiss(g, v)
+	} else if kb.ui.state.ShowConfirm {
+		return kb.confirmReject(g, v)
+	} else if kb.ui.state.ShowDetails {
 		return kb.hideEventDetails(g, v)
 	} else if kb.ui.state.ShowFileDialog {
 		return kb.fileDialogCancel(g, v)
+	} else if kb.ui.state.ShowDiff {
+		return kb.diffClose(g, v)
 	} else if kb.ui.state.ShowFolderManager {
 		return kb.folderManagerCancel(g, v)
 	}
@@ -69,8 +69,59 @@ func (l *Layout) layoutMainViews(g *gocui.Gui, maxX, maxY int) error {
 		l.ui.views.UpdateFilterView(v)
 	}
 
+	// Command pane (below the events, `watch-fs exec` only)
+	eventsY1 := maxY - 4
+	if l.ui.runner != nil && l.ui.goTests == nil {
+		commandHeight := max((maxY-10)/3, 4)
+		eventsY1 -= commandHeight
+		if v, err := g.SetView(CommandView, 0, eventsY1+1, maxX-1, maxY-4, 0); err != nil {
+			if !isUnknownViewError(err) {
+				return err
+			}
+			v.Title = " Command "
+			v.Frame = true
+			v.Wrap = true
+			l.ui.command.UpdateView(v)
+		} else {
+			l.ui.command.UpdateView(v)
+		}
+	}
+
+	// Rules pane (below the events, when rules are configured)
+	if l.ui.rules.Visible() {
+		rulesY1 := eventsY1
+		eventsY1 -= max((maxY-10)/4, 4)
+		if v, err := g.SetView(RulesView, 0, eventsY1+1, maxX-1, rulesY1, 0); err != nil {
+			if !isUnknownViewError(err) {
+				return err
+			}
+			v.Title = " Rules "
+			v.Frame = true
+			l.ui.rules.UpdateView(v)
+		} else {
+			l.ui.rules.UpdateView(v)
+		}
+	}
+
+	// Packages panel (right of the events, `watch-fs gotest` only)
+	eventsX1 := maxX - 1
+	if l.ui.goTests != nil {
+		eventsX1 = maxX*3/5 - 1
+		if v, err := g.SetView(PackagesView, eventsX1+1, 6, maxX-1, eventsY1, 0); err != nil {
+			if !isUnknownViewError(err) {
+				return err
+			}
+			v.Title = " Packages "
+			v.Frame = true
+			v.Wrap = true
+			l.ui.packages.UpdateView(v)
+		} else {
+			l.ui.packages.UpdateView(v)
+		}
+	}
+
 	// Events view (main area)
-	if v, err := g.SetView(EventsView, 0, 6, maxX-1, maxY-4, 0); err != nil {
+	if v, err := g.SetView(EventsView, 0, 6, eventsX1, eventsY1, 0); err != nil {
 		if !isUnknownViewError(err) {
 			return err
 		}
@@ -116,6 +167,21 @@ func (l *Layout) layoutOverlayViews(g *gocui.Gui, maxX, maxY int) error {
 		return err
 	}
 
+	// Layout session diff view
+	if err := l.layoutDiffPopup(g, maxX, maxY); err != nil {
+		return err
+	}
+
+	// Layout confirmation prompt
+	if err := l.layoutConfirmPopup(g, maxX, maxY); err != nil {
+		return err
+	}
+
+	// Layout ransomware-like detection (on top of everything else)
+	if err := l.layoutDetectionPopup(g, maxX, maxY); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -149,6 +215,107 @@ func (l *Layout) layoutDetailsPopup(g *gocui.Gui, maxX, maxY int) error {
 	return nil
 }
 
+// layoutDiffPopup creates the session diff overlay
+func (l *Layout) layoutDiffPopup(g *gocui.Gui, maxX, maxY int) error {
+	if l.ui.state.ShowDiff {
+		// Nearly full screen, diffs are wide and long
+		x0, y0 := 2, 1
+		x1, y1 := maxX-3, maxY-4
+
+		if v, err := g.SetView(DiffView, x0, y0, x1, y1, 0); err != nil {
+			if !isUnknownViewError(err) {
+				return err
+			}
+			v.Title = " Session Diff "
+			v.Frame = true
+			v.BgColor = gocui.ColorBlack
+			v.FgColor = gocui.ColorWhite
+			l.ui.diff.UpdateView(v)
+		} else {
+			l.ui.diff.UpdateView(v)
+		}
+	} else {
+		// Remove diff view if not needed
+		if err := g.DeleteView(DiffView); err != nil {
+			logger.Error(err, "Failed to delete diff view during layout cleanup")
+		}
+	}
+	return nil
+}
+
+// layoutConfirmPopup creates the yes/no confirmation overlay
+func (l *Layout) layoutConfirmPopup(g *gocui.Gui, maxX, maxY int) error {
+	if l.ui.state.ShowConfirm {
+		popupWidth := 70
+		popupHeight := 6
+		x0 := (maxX - popupWidth) / 2
+		y0 := (maxY - popupHeight) / 2
+		x1 := x0 + popupWidth
+		y1 := y0 + popupHeight
+
+		if v, err := g.SetView(ConfirmView, x0, y0, x1, y1, 0); err != nil {
+			if !isUnknownViewError(err) {
+				return err
+			}
+			v.Title = " Confirm "
+			v.Frame = true
+			v.Wrap = true
+			v.BgColor = gocui.ColorBlack
+			v.FgColor = gocui.ColorWhite
+			v.FrameColor = gocui.ColorYellow
+			l.ui.confirm.UpdateView(v)
+		} else {
+			l.ui.confirm.UpdateView(v)
+		}
+		if _, err := g.SetViewOnTop(ConfirmView); err != nil {
+			return err
+		}
+	} else {
+		// Remove confirmation view if not needed
+		if err := g.DeleteView(ConfirmView); err != nil {
+			logger.Error(err, "Failed to delete confirm view during layout cleanup")
+		}
+	}
+	return nil
+}
+
+// layoutDetectionPopup creates the ransomware-like detection overlay
+func (l *Layout) layoutDetectionPopup(g *gocui.Gui, maxX, maxY int) error {
+	if l.ui.state.ShowDetection {
+		popupWidth := min(100, maxX-4)
+		popupHeight := min(24, maxY-4)
+		x0 := (maxX - popupWidth) / 2
+		y0 := (maxY - popupHeight) / 2
+		x1 := x0 + popupWidth
+		y1 := y0 + popupHeight
+
+		if v, err := g.SetView(DetectionView, x0, y0, x1, y1, 0); err != nil {
+			if !isUnknownViewError(err) {
+				return err
+			}
+			v.Title = " Ransomware Detection "
+			v.Frame = true
+			v.Wrap = true
+			v.BgColor = gocui.ColorBlack
+			v.FgColor = gocui.ColorWhite
+			v.FrameColor = gocui.ColorRed
+			v.TitleColor = gocui.ColorRed
+			l.ui.detection.UpdateView(v)
+		} else {
+			l.ui.detection.UpdateView(v)
+		}
+		if _, err := g.SetViewOnTop(DetectionView); err != nil {
+			return err
+		}
+	} else {
+		// Remove detection view if not needed
+		if err := g.DeleteView(DetectionView); err != nil {
+			logger.Error(err, "Failed to delete detection view during layout cleanup")
+		}
+	}
+	return nil
+}
+
 // layoutFileDialog creates the file dialog overlay
 func (l *Layout) layoutFileDialog(g *gocui.Gui, maxX, maxY int) error {
 	if l.ui.state.ShowFileDialog {
@@ -166,8 +333,13 @@ func (l *Layout) layoutFileDialog(g *gocui.Gui, maxX, maxY int) error {
 				return err
 			}
 			title := " Save File "
-			if l.ui.state.FileDialog.Mode == ModeOpen {
+			switch l.ui.state.FileDialog.Mode {
+			case ModeOpen:
 				title = " Open File "
+			case ModeReplay:
+				title = " Replay File "
+			case ModeDiff:
+				title = " Compare Files "
 			}
 			v.Title = title
 			v.Frame = true
@@ -195,8 +367,8 @@ func (l *Layout) layoutFileDialog(g *gocui.Gui, maxX, maxY int) error {
 		// File list (main area) - smaller in save mode to make room for filename input
 		listY0 := y0 + pathHeight + 1
 		listY1 := y1 - 5 // Leave more space for filename input in save mode
-		if l.ui.state.FileDialog.Mode == ModeOpen {
-			listY1 = y1 - 3 // Full height for open mode
+		if l.ui.state.FileDialog.Mode != ModeSave {
+			listY1 = y1 - 3 // Full height for open and replay modes
 		}
 
 		if v, err := g.SetView(FileListView, x0+1, listY0, x1-1, listY1, 0); err != nil {
@@ -395,8 +567,19 @@ func (l *Layout) layoutFolderManager(g *gocui.Gui, maxX, maxY int) error {
 
 // setFocus sets the current focus based on the UI state
 func (l *Layout) setFocus(g *gocui.Gui) error {
+	// A detection, then the confirmation prompt, take precedence over every
+	// other view
+	if l.ui.state.ShowDetection {
+		_, err := g.SetCurrentView(DetectionView)
+		return err
+	}
+	if l.ui.state.ShowConfirm {
+		_, err := g.SetCurrentView(ConfirmView)
+		return err
+	}
+
 	// Set EventsView as the default active view (unless dialogs are shown)
-	if !l.ui.state.ShowDetails && !l.ui.state.ShowFileDialog && !l.ui.state.ShowFolderManager {
+	if !l.ui.state.ShowDetails && !l.ui.state.ShowFileDialog && !l.ui.state.ShowFolderManager && !l.ui.state.ShowDiff {
 		if _, err := g.SetCurrentView(EventsView); err != nil {
 			return err
 		}
@@ -414,6 +597,10 @@ func (l *Layout) setFocus(g *gocui.Gui) error {
 				return err
 			}
 		}
+	} else if l.ui.state.ShowDiff {
+		if _, err := g.SetCurrentView(DiffView); err != nil {
+			return err
+		}
 	} else if l.ui.state.ShowFolderManager {
 		// Set focus based on the active panel in folder manager
 		switch l.ui.state.FolderManager.ActivePanel {
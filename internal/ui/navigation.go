@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/jesseduffield/gocui"
@@ -156,6 +157,10 @@ func (nav *Navigation) deaggregateEvents() {
 					Timestamp: event.Timestamp,
 					IsDir:     event.IsDir,
 					Count:     1,
+					Host:      event.Host,
+					Highlight: event.Highlight,
+					Violation: event.Violation,
+					Attrs:     event.Attrs,
 				}
 				newEvents = append(newEvents, newEvent)
 			}
@@ -166,6 +171,7 @@ func (nav *Navigation) deaggregateEvents() {
 	}
 
 	nav.ui.state.Events = newEvents
+	nav.ui.events.clearSelection()
 }
 
 // reaggregateEvents combines similar events that occurred within 1 second
@@ -175,10 +181,10 @@ func (nav *Navigation) reaggregateEvents() {
 	}
 
 	var newEvents []*FileEvent
-	eventMap := make(map[string]*FileEvent) // key: path+operation
+	eventMap := make(map[string]*FileEvent) // key: host+path+operation
 
 	for _, event := range nav.ui.state.Events {
-		key := event.Path + "|" + event.Operation.String()
+		key := event.Host + "|" + event.Path + "|" + event.Operation.String()
 
 		if existingEvent, exists := eventMap[key]; exists {
 			// Check if events are within 1 second of each other
@@ -200,11 +206,12 @@ func (nav *Navigation) reaggregateEvents() {
 	}
 
 	nav.ui.state.Events = newEvents
+	nav.ui.events.clearSelection()
 }
 
 // cycleSort cycles through sort options
 func (nav *Navigation) cycleSort(g *gocui.Gui, _ *gocui.View) error {
-	nav.ui.state.SortOption = (nav.ui.state.SortOption + 1) % 4
+	nav.ui.state.SortOption = nextSortOption(nav.ui.state.SortOption)
 	nav.ui.state.ScrollOffset = 0
 
 	if v, err := g.View(FilterView); err == nil {
@@ -216,6 +223,91 @@ func (nav *Navigation) cycleSort(g *gocui.Gui, _ *gocui.View) error {
 	return nil
 }
 
+// toggleRisky toggles showing only the risky attribute changes
+func (nav *Navigation) toggleRisky(g *gocui.Gui, _ *gocui.View) error {
+	nav.ToggleRisky()
+	if v, err := g.View(FilterView); err == nil {
+		nav.ui.views.UpdateFilterView(v)
+	}
+	if v, err := g.View(EventsView); err == nil {
+		nav.ui.views.UpdateEventsView(v)
+		v.SetCursor(0, 0)
+	}
+	return nil
+}
+
+// cycleHost cycles the host filter through the hosts of a collector
+func (nav *Navigation) cycleHost(g *gocui.Gui, _ *gocui.View) error {
+	nav.CycleHost()
+	if v, err := g.View(FilterView); err == nil {
+		nav.ui.views.UpdateFilterView(v)
+	}
+	if v, err := g.View(EventsView); err == nil {
+		nav.ui.views.UpdateEventsView(v)
+		v.SetCursor(0, 0)
+	}
+	return nil
+}
+
+// toggleSelection marks or unmarks the event under the cursor for export
+func (nav *Navigation) toggleSelection(g *gocui.Gui, v *gocui.View) error {
+	_, cy := v.Cursor()
+	filteredEvents := nav.ui.getFilteredEvents()
+	if cy < 0 || cy >= len(filteredEvents) {
+		return nil
+	}
+	nav.ui.events.toggleSelection(filteredEvents[cy])
+
+	// Advance so that consecutive events can be selected quickly
+	if err := nav.moveDown(g, v); err != nil {
+		return err
+	}
+	nav.refreshEventViews(g)
+	return nil
+}
+
+// acceptViolation asks to accept the current state of the path of the event
+// under the cursor into the integrity baseline
+func (nav *Navigation) acceptViolation(g *gocui.Gui, v *gocui.View) error {
+	_, cy := v.Cursor()
+	filteredEvents := nav.ui.getFilteredEvents()
+	if cy < 0 || cy >= len(filteredEvents) {
+		return nil
+	}
+	event := filteredEvents[cy]
+	if event.Violation == "" {
+		nav.ui.setStatusMessage("The selected event does not break the baseline")
+		return nil
+	}
+	path := event.Path
+	nav.ui.confirm.Show(fmt.Sprintf("Accept the current state of %s into the baseline? It was %s.", path, event.Violation), func() error {
+		if err := nav.ui.AcceptViolation(path); err != nil {
+			return err
+		}
+		nav.ui.setStatusMessage("Accepted " + path + " into the baseline")
+		nav.refreshEventViews(g)
+		return nil
+	})
+	return nav.ui.layout.Layout(g)
+}
+
+// clearSelection unmarks every selected event
+func (nav *Navigation) clearSelection(g *gocui.Gui, _ *gocui.View) error {
+	nav.ui.events.clearSelection()
+	nav.refreshEventViews(g)
+	return nil
+}
+
+// refreshEventViews redraws the views that show the event list
+func (nav *Navigation) refreshEventViews(g *gocui.Gui) {
+	if v, err := g.View(StatusView); err == nil {
+		nav.ui.views.UpdateStatusView(v)
+	}
+	if v, err := g.View(EventsView); err == nil {
+		nav.ui.views.UpdateEventsView(v)
+	}
+}
+
 // Public methods for testing and external access
 
 // MoveUp moves the selection up (public version for testing)
@@ -305,5 +397,23 @@ func (nav *Navigation) ToggleDirs() {
 
 // CycleSort cycles through sort options (public version)
 func (nav *Navigation) CycleSort() {
-	nav.ui.state.SortOption = (nav.ui.state.SortOption + 1) % 4
+	nav.ui.state.SortOption = nextSortOption(nav.ui.state.SortOption)
+}
+
+// ToggleRisky shows only the risky attribute changes, or every event
+// again (public version)
+func (nav *Navigation) ToggleRisky() {
+	if nav.ui.state.Filter.RiskFilter == "" {
+		nav.ui.state.Filter.RiskFilter = RiskAny
+	} else {
+		nav.ui.state.Filter.RiskFilter = ""
+	}
+	nav.ui.state.ScrollOffset = 0
+}
+
+// CycleHost shows the events of the next host, or of every host after the
+// last one (public version)
+func (nav *Navigation) CycleHost() {
+	nav.ui.state.Filter.HostFilter = nav.ui.events.nextHostFilter()
+	nav.ui.state.ScrollOffset = 0
 }
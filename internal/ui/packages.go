@@ -0,0 +1,100 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jesseduffield/gocui"
+	"github.com/pbouamriou/watch-fs/internal/gotest"
+	"github.com/pbouamriou/watch-fs/internal/runner"
+)
+
+// Packages shows the per-package test results of `watch-fs gotest` next
+// to the events
+type Packages struct {
+	ui *UI
+}
+
+// NewPackages creates a new Packages instance
+func NewPackages(ui *UI) *Packages {
+	return &Packages{ui: ui}
+}
+
+// Attach shows the panel for a test session and the runner running it
+func (p *Packages) Attach(session *gotest.Session, r *runner.Runner) {
+	p.ui.goTests = session
+	p.ui.runner = r
+	session.OnChange(p.refresh)
+	r.OnChange(p.refresh)
+}
+
+// UpdateView renders the runner state, then one line per package with the
+// output of failed packages below
+func (p *Packages) UpdateView(v *gocui.View) {
+	v.Clear()
+	if p.ui.goTests == nil {
+		return
+	}
+	yellow := color.New(color.FgYellow).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	status := p.ui.runner.Status()
+	switch status.State {
+	case runner.StateRunning:
+		_, _ = fmt.Fprintf(v, "Run %d: %s\n", status.Runs, yellow("testing "+time.Since(status.Started).Round(time.Second).String()))
+	case runner.StateIdle:
+		_, _ = fmt.Fprintln(v, "Waiting for changes")
+	default:
+		_, _ = fmt.Fprintf(v, "Run %d: %s in %s\n", status.Runs, runner.StateName(status.State), status.Duration.Round(time.Millisecond))
+	}
+	if status.Err != nil {
+		_, _ = fmt.Fprintln(v, red(status.Err))
+	}
+
+	results := p.ui.goTests.Results()
+	var failures []gotest.Result
+	for _, result := range results {
+		state := gotest.StateName(result.State)
+		switch result.State {
+		case gotest.StatePassed:
+			state = green(fmt.Sprintf("%-4s %5.2fs", state, result.Elapsed.Seconds()))
+		case gotest.StateFailed:
+			state = red(fmt.Sprintf("%-4s %5.2fs", state, result.Elapsed.Seconds()))
+			failures = append(failures, result)
+		case gotest.StateRunning:
+			state = yellow(state)
+		}
+		_, _ = fmt.Fprintf(v, "%s %s\n", state, result.Package)
+	}
+
+	// Errors of the go command itself, such as a broken go.mod
+	if status.State == runner.StateFailed && len(failures) == 0 {
+		for _, line := range status.Output {
+			if !strings.HasPrefix(line, "{") {
+				_, _ = fmt.Fprintln(v, line)
+			}
+		}
+	}
+
+	for _, result := range failures {
+		_, _ = fmt.Fprintf(v, "\n%s\n", red("--- "+result.Package))
+		for _, line := range result.Output {
+			_, _ = fmt.Fprintln(v, line)
+		}
+	}
+}
+
+// refresh redraws the packages panel
+func (p *Packages) refresh() {
+	if p.ui.gui != nil {
+		p.ui.gui.Update(func(g *gocui.Gui) error {
+			if v, err := g.View(PackagesView); err == nil {
+				p.UpdateView(v)
+			}
+			return nil
+		})
+	}
+}
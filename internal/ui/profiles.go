@@ -0,0 +1,128 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/jesseduffield/gocui"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// Settings are the view defaults a configuration profile sets; nil and zero
+// fields keep the current value
+type Settings struct {
+	Filter          *Filter
+	SortOption      *SortOption
+	AggregateEvents *bool
+	MaxEvents       int
+	MaxAge          time.Duration
+}
+
+// Profile is a named configuration the TUI can switch to
+type Profile struct {
+	Name     string
+	Roots    []string
+	Ignore   *utils.IgnoreRules
+	Settings Settings
+}
+
+// Profiles switches the watched roots and view settings between the
+// profiles of the configuration file
+type Profiles struct {
+	ui *UI
+}
+
+// NewProfiles creates a new Profiles instance
+func NewProfiles(ui *UI) *Profiles {
+	return &Profiles{ui: ui}
+}
+
+// Set lists the profiles the TUI can switch to; current is already applied
+func (p *Profiles) Set(profiles []Profile, current string) {
+	p.ui.profiles = profiles
+	p.ui.state.Profile = current
+}
+
+// Next switches to the profile after the current one
+func (p *Profiles) Next() error {
+	profiles := p.ui.profiles
+	if len(profiles) == 0 {
+		return fmt.Errorf("no profiles configured")
+	}
+	next := 0
+	for i, profile := range profiles {
+		if profile.Name == p.ui.state.Profile {
+			next = (i + 1) % len(profiles)
+		}
+	}
+	return p.Switch(profiles[next].Name)
+}
+
+// Switch applies a profile: roots missing from the watcher are added, roots
+// the profile does not list are removed, then its settings are applied
+func (p *Profiles) Switch(name string) error {
+	var profile *Profile
+	for i := range p.ui.profiles {
+		if p.ui.profiles[i].Name == name {
+			profile = &p.ui.profiles[i]
+		}
+	}
+	if profile == nil {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	if len(profile.Roots) > 0 {
+		roots, ok := p.ui.watcher.(interface {
+			AddRoot(string) error
+			RemoveRoot(string) error
+		})
+		if !ok {
+			return fmt.Errorf("the watched directories cannot be changed")
+		}
+		if ignorer, ok := p.ui.watcher.(interface{ SetIgnore(*utils.IgnoreRules) }); ok {
+			ignorer.SetIgnore(profile.Ignore)
+		}
+
+		wanted := make(map[string]bool)
+		for _, root := range profile.Roots {
+			wanted[filepath.Clean(root)] = true
+			if err := roots.AddRoot(root); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", root, err)
+			}
+		}
+		for _, root := range p.ui.watcher.GetRoots() {
+			if !wanted[filepath.Clean(root)] {
+				if err := roots.RemoveRoot(root); err != nil {
+					return fmt.Errorf("failed to stop watching %s: %w", root, err)
+				}
+			}
+		}
+		p.ui.rootPaths = p.ui.watcher.GetRoots()
+	}
+
+	p.ui.ApplySettings(profile.Settings)
+	p.ui.state.Profile = profile.Name
+	return nil
+}
+
+// next is the keybinding handler switching to the next profile
+func (p *Profiles) next(g *gocui.Gui, v *gocui.View) error {
+	if len(p.ui.profiles) == 0 {
+		return nil
+	}
+	if err := p.Next(); err != nil {
+		p.ui.setStatusMessage(fmt.Sprintf("Profile switch failed: %v", err))
+		return nil
+	}
+	p.ui.state.ScrollOffset = 0
+	p.ui.setStatusMessage(fmt.Sprintf("Profile %s", p.ui.state.Profile))
+
+	if v, err := g.View(FilterView); err == nil {
+		p.ui.views.UpdateFilterView(v)
+	}
+	if v, err := g.View(EventsView); err == nil {
+		p.ui.views.UpdateEventsView(v)
+	}
+	return nil
+}
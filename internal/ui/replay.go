@@ -0,0 +1,261 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jesseduffield/gocui"
+)
+
+const (
+	// minReplaySpeed and maxReplaySpeed bound the speed keys of the TUI
+	minReplaySpeed = 1.0 / 64
+	maxReplaySpeed = 64.0
+)
+
+// replayCommandKind identifies a replay control command
+type replayCommandKind int
+
+const (
+	replayPause replayCommandKind = iota
+	replayResume
+	replayStep
+	replaySpeed
+	replayStop
+)
+
+// replayCommand controls a running replay
+type replayCommand struct {
+	kind  replayCommandKind
+	speed float64
+}
+
+// Replay feeds a recorded session back through the event pipeline, so that
+// aggregation, filters and listeners see it as if it happened live
+type Replay struct {
+	ui       *UI
+	commands chan replayCommand
+	done     chan struct{}
+}
+
+// NewReplay creates a new Replay instance
+func NewReplay(ui *UI) *Replay {
+	return &Replay{ui: ui}
+}
+
+// Start loads a capture and replays it at the given speed multiplier
+// (1 is real time, 0 is as fast as possible). A running replay is stopped
+// first; the current events are replaced by the replayed ones.
+func (r *Replay) Start(filename string, format ExportFormat, speed float64, paused bool) error {
+	c, err := r.ui.exportImport.loadCapture(filename, format, false)
+	if err != nil {
+		return err
+	}
+	r.Stop()
+
+	events := replayEvents(c.events)
+	r.ui.state.Events = make([]*FileEvent, 0)
+	r.ui.events.clearSelection()
+	r.ui.exportImport.applyMeta(c.meta)
+	r.ui.state.Replay = ReplayState{
+		Active: true,
+		Source: filename,
+		Roots:  c.meta.Roots,
+		Total:  len(events),
+		Speed:  speed,
+		Paused: paused,
+	}
+
+	r.commands = make(chan replayCommand)
+	r.done = make(chan struct{})
+	go r.run(events, speed, paused, r.commands, r.done)
+	return nil
+}
+
+// replayEvents orders a capture by time and expands aggregated events, so
+// that aggregation rebuilds their counts while they are replayed
+func replayEvents(captured []*FileEvent) []*FileEvent {
+	events := make([]*FileEvent, 0, len(captured))
+	for _, event := range captured {
+		for i := 0; i < event.Count || i == 0; i++ {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events
+}
+
+// run replays events until the end of the capture or a stop command
+func (r *Replay) run(events []*FileEvent, speed float64, paused bool, commands <-chan replayCommand, done chan<- struct{}) {
+	defer close(done)
+
+	next := 0
+	emit := func() {
+		// Each replayed event is a copy, with every recorded field, that
+		// aggregation counts again; ignored paths are skipped as live ones
+		replayed := *events[next]
+		replayed.Count = 1
+		if ignorer, ok := r.ui.watcher.(interface{ Ignored(string) bool }); !ok || !ignorer.Ignored(replayed.Path) {
+			r.ui.events.record(&replayed)
+		}
+		next++
+		r.ui.state.Replay.Position = next
+	}
+
+	for next < len(events) {
+		// The gap between recorded events, scaled by the speed
+		var wait <-chan time.Time
+		var timer *time.Timer
+		if !paused {
+			var delay time.Duration
+			if next > 0 && speed > 0 {
+				delay = time.Duration(float64(events[next].Timestamp.Sub(events[next-1].Timestamp)) / speed)
+			}
+			timer = time.NewTimer(delay)
+			wait = timer.C
+		}
+
+		select {
+		case <-wait:
+			emit()
+		case cmd := <-commands:
+			if timer != nil {
+				timer.Stop()
+			}
+			switch cmd.kind {
+			case replayPause:
+				paused = true
+			case replayResume:
+				paused = false
+			case replayStep:
+				paused = true
+				emit()
+			case replaySpeed:
+				speed = cmd.speed
+			case replayStop:
+				r.ui.state.Replay.Active = false
+				return
+			}
+			r.ui.state.Replay.Paused = paused
+			r.ui.state.Replay.Speed = speed
+		}
+		r.refresh()
+	}
+
+	r.ui.state.Replay.Active = false
+	r.ui.setStatusMessage(fmt.Sprintf("Replay of %s finished", filepath.Base(r.ui.state.Replay.Source)))
+}
+
+// send delivers a command to the running replay; false if none is running
+func (r *Replay) send(cmd replayCommand) bool {
+	if r.commands == nil {
+		return false
+	}
+	select {
+	case r.commands <- cmd:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// Pause suspends the replay until Resume or Step
+func (r *Replay) Pause() {
+	r.send(replayCommand{kind: replayPause})
+}
+
+// Resume continues a paused replay
+func (r *Replay) Resume() {
+	r.send(replayCommand{kind: replayResume})
+}
+
+// TogglePause pauses a running replay or resumes a paused one
+func (r *Replay) TogglePause() {
+	if r.ui.state.Replay.Paused {
+		r.Resume()
+	} else {
+		r.Pause()
+	}
+}
+
+// Step replays the next event and leaves the replay paused
+func (r *Replay) Step() {
+	r.send(replayCommand{kind: replayStep})
+}
+
+// SetSpeed changes the speed multiplier of the replay
+func (r *Replay) SetSpeed(speed float64) {
+	r.send(replayCommand{kind: replaySpeed, speed: speed})
+}
+
+// Stop ends the replay and waits for it to finish
+func (r *Replay) Stop() {
+	if r.send(replayCommand{kind: replayStop}) {
+		<-r.done
+	}
+}
+
+// Done returns a channel closed when the replay ends
+func (r *Replay) Done() <-chan struct{} {
+	if r.done == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return r.done
+}
+
+// refresh redraws the status bar with the replay progress
+func (r *Replay) refresh() {
+	if r.ui.gui != nil {
+		r.ui.gui.Update(func(g *gocui.Gui) error {
+			if v, err := g.View(StatusView); err == nil {
+				r.ui.views.UpdateStatusView(v)
+			}
+			return nil
+		})
+	}
+}
+
+// Keybinding handlers
+
+// togglePause pauses or resumes the replay
+func (r *Replay) togglePause(g *gocui.Gui, v *gocui.View) error {
+	r.TogglePause()
+	return nil
+}
+
+// step replays the next event
+func (r *Replay) step(g *gocui.Gui, v *gocui.View) error {
+	r.Step()
+	return nil
+}
+
+// faster doubles the replay speed
+func (r *Replay) faster(g *gocui.Gui, v *gocui.View) error {
+	if speed := r.ui.state.Replay.Speed; speed > 0 && speed < maxReplaySpeed {
+		r.SetSpeed(speed * 2)
+	}
+	return nil
+}
+
+// slower halves the replay speed
+func (r *Replay) slower(g *gocui.Gui, v *gocui.View) error {
+	if speed := r.ui.state.Replay.Speed; speed > minReplaySpeed {
+		r.SetSpeed(speed / 2)
+	}
+	return nil
+}
+
+// stop ends the replay
+func (r *Replay) stop(g *gocui.Gui, v *gocui.View) error {
+	if r.ui.state.Replay.Active {
+		r.Stop()
+		r.ui.setStatusMessage("Replay stopped")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package ui
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/jesseduffield/gocui"
+)
+
+// maxRuleFirings is the number of rule firings kept for display
+const maxRuleFirings = 100
+
+// highlightColors are the colors rules can highlight events with
+var highlightColors = map[string]color.Attribute{
+	"red":     color.BgRed,
+	"green":   color.BgGreen,
+	"yellow":  color.BgYellow,
+	"blue":    color.BgBlue,
+	"magenta": color.BgMagenta,
+	"cyan":    color.BgCyan,
+	"white":   color.BgWhite,
+}
+
+// IsHighlightColor reports whether rules can highlight events with a color
+func IsHighlightColor(name string) bool {
+	_, ok := highlightColors[name]
+	return ok
+}
+
+// highlight colors text the way a rule highlights its event
+func highlight(name, text string) string {
+	attribute, ok := highlightColors[name]
+	if !ok {
+		return text
+	}
+	return color.New(attribute, color.FgBlack).Sprint(text)
+}
+
+// Rules shows the recent firings and failures of the configured rules
+// below the events, and the firing alerts in the status bar
+type Rules struct {
+	ui *UI
+
+	mu      sync.Mutex
+	visible bool
+	firings []RuleFiring // Oldest first
+	fired   int
+	failed  int
+	alerts  []Alert // In the order they first changed
+}
+
+// NewRules creates a new Rules instance
+func NewRules(ui *UI) *Rules {
+	return &Rules{ui: ui}
+}
+
+// Show shows the pane, or hides it when no rule is configured
+func (r *Rules) Show(count int) {
+	r.mu.Lock()
+	r.visible = count > 0
+	r.mu.Unlock()
+}
+
+// Visible reports whether the pane is shown
+func (r *Rules) Visible() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.visible
+}
+
+// Record adds a firing to the pane. Rules call it from their own
+// goroutines.
+func (r *Rules) Record(firing RuleFiring) {
+	r.mu.Lock()
+	r.firings = append(r.firings, firing)
+	if extra := len(r.firings) - maxRuleFirings; extra > 0 {
+		r.firings = r.firings[extra:]
+	}
+	if firing.Err != nil {
+		r.failed++
+	} else {
+		r.fired++
+	}
+	r.mu.Unlock()
+	r.refresh()
+}
+
+// SetAlert records the new state of an alert. Alerts call it from their
+// own goroutines.
+func (r *Rules) SetAlert(alert Alert) {
+	r.mu.Lock()
+	replaced := false
+	for i := range r.alerts {
+		if r.alerts[i].Name == alert.Name {
+			r.alerts[i] = alert
+			replaced = true
+		}
+	}
+	if !replaced {
+		r.alerts = append(r.alerts, alert)
+	}
+	r.mu.Unlock()
+	r.refresh()
+}
+
+// Alerts returns the alerts that changed state, firing or not
+func (r *Rules) Alerts() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Alert(nil), r.alerts...)
+}
+
+// Firing returns the alerts currently firing
+func (r *Rules) Firing() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var firing []Alert
+	for _, alert := range r.alerts {
+		if alert.Firing {
+			firing = append(firing, alert)
+		}
+	}
+	return firing
+}
+
+// Firings returns the kept firings, oldest first
+func (r *Rules) Firings() []RuleFiring {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RuleFiring(nil), r.firings...)
+}
+
+// UpdateView renders the counts, then the most recent firings first
+func (r *Rules) UpdateView(v *gocui.View) {
+	v.Clear()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	cyan := color.New(color.FgCyan).SprintFunc()
+
+	firing := 0
+	for _, alert := range r.alerts {
+		if alert.Firing {
+			firing++
+		}
+	}
+	_, _ = fmt.Fprintf(v, "Fired: %s | Failed: %s | Alerts firing: %s\n", green(r.fired), red(r.failed), red(firing))
+	if len(r.firings) == 0 {
+		_, _ = fmt.Fprint(v, "No rule fired yet")
+		return
+	}
+	_, height := v.Size()
+	for i := len(r.firings) - 1; i >= 0 && len(r.firings)-i < height; i-- {
+		firing := r.firings[i]
+		outcome := green("ok")
+		if firing.Err != nil {
+			outcome = red(firing.Err)
+		}
+		_, _ = fmt.Fprintf(v, "[%s] %s %s %s: %s\n",
+			firing.Time.Format("15:04:05"), cyan(firing.Rule), firing.Action, firing.Path, outcome)
+	}
+}
+
+// refresh redraws the pane, the status bar showing the alerts, and the
+// events, which rules may highlight
+func (r *Rules) refresh() {
+	if r.ui.gui != nil {
+		r.ui.gui.Update(func(g *gocui.Gui) error {
+			if v, err := g.View(StatusView); err == nil {
+				r.ui.views.UpdateStatusView(v)
+			}
+			if v, err := g.View(RulesView); err == nil {
+				r.UpdateView(v)
+			}
+			if v, err := g.View(EventsView); err == nil {
+				r.ui.views.UpdateEventsView(v)
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,494 @@
+package ui
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+const (
+	// defaultInotifyFormat is the line format of inotifywait -m without --format
+	defaultInotifyFormat = "%w %e %f"
+	// defaultFswatchTimeFmt is the timestamp format of fswatch -t without -f
+	defaultFswatchTimeFmt = "%c"
+	// maxTextLineSize bounds the length of a single log line
+	maxTextLineSize = 1024 * 1024
+)
+
+// fswatchFlags lists every event flag printed by fswatch -x
+var fswatchFlags = map[string]bool{
+	"NoOp": true, "PlatformSpecific": true, "Created": true, "Updated": true,
+	"Removed": true, "Renamed": true, "OwnerModified": true, "AttributeModified": true,
+	"MovedFrom": true, "MovedTo": true, "IsFile": true, "IsDir": true,
+	"IsSymLink": true, "Link": true, "Overflow": true, "CloseWrite": true,
+}
+
+// strftimeLayouts maps strftime conversions to Go time layouts
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST", 'F': "2006-01-02", 'T': "15:04:05",
+	'D': "01/02/06", 'R': "15:04", 'c': "Mon Jan _2 15:04:05 2006", '%': "%",
+}
+
+// timeFormat parses timestamps written with a strftime format
+type timeFormat struct {
+	layout string
+	unix   bool // %s: seconds since the epoch
+}
+
+// newTimeFormat converts a strftime format to a Go time layout
+func newTimeFormat(format string) (timeFormat, error) {
+	if format == "%s" {
+		return timeFormat{unix: true}, nil
+	}
+
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i >= len(format) {
+			return timeFormat{}, fmt.Errorf("time format %q ends with %%", format)
+		}
+		converted, ok := strftimeLayouts[format[i]]
+		if !ok {
+			return timeFormat{}, fmt.Errorf("unsupported time conversion %%%c in %q", format[i], format)
+		}
+		layout.WriteString(converted)
+	}
+	return timeFormat{layout: layout.String()}, nil
+}
+
+// parse parses a timestamp, interpreting zone-less layouts in local time
+func (tf timeFormat) parse(value string) (time.Time, error) {
+	if tf.unix {
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.ParseInLocation(tf.layout, strings.Join(strings.Fields(value), " "), time.Local)
+}
+
+// fieldCount returns the number of space-separated fields in a formatted timestamp
+func (tf timeFormat) fieldCount() int {
+	if tf.unix {
+		return 1
+	}
+	return len(strings.Fields(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC).Format(tf.layout)))
+}
+
+// inotifyParser parses lines written by inotifywait -m --format
+type inotifyParser struct {
+	re        *regexp.Regexp
+	eventSep  string
+	timestamp timeFormat
+	hasTime   bool
+}
+
+// newInotifyParser builds a parser for an inotifywait --format string.
+// Supported conversions are %w, %f, %e, %Xe (events separated by X), %T
+// and %%.
+func newInotifyParser(format, timefmt string) (*inotifyParser, error) {
+	if format == "" {
+		format = defaultInotifyFormat
+	}
+	p := &inotifyParser{eventSep: ","}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			pattern.WriteString(regexp.QuoteMeta(string(format[i])))
+			continue
+		}
+		i++
+		if i >= len(format) {
+			return nil, fmt.Errorf("inotifywait format %q ends with %%", format)
+		}
+		switch format[i] {
+		case 'w':
+			pattern.WriteString(`(?P<w>.*?)`)
+		case 'f':
+			pattern.WriteString(`(?P<f>.*?)`)
+		case 'e':
+			pattern.WriteString(`(?P<e>[A-Z_]+(?:,[A-Z_]+)*)`)
+		case 'T':
+			if !p.hasTime {
+				if timefmt == "" {
+					return nil, fmt.Errorf("inotifywait format %q uses %%T but no time format was given", format)
+				}
+				var err error
+				if p.timestamp, err = newTimeFormat(timefmt); err != nil {
+					return nil, err
+				}
+				p.hasTime = true
+			}
+			// Match as many words as the time format produces
+			pattern.WriteString(fmt.Sprintf(`(?P<T>\S+(?:\s+\S+){%d})`, p.timestamp.fieldCount()-1))
+		case '%':
+			pattern.WriteString("%")
+		default:
+			// %Xe: events separated by the character X
+			if i+1 < len(format) && format[i+1] == 'e' {
+				p.eventSep = string(format[i])
+				sep := regexp.QuoteMeta(p.eventSep)
+				pattern.WriteString(`(?P<e>[A-Z_]+(?:` + sep + `[A-Z_]+)*)`)
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("unsupported inotifywait conversion %%%c in %q", format[i], format)
+		}
+	}
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid inotifywait format %q: %w", format, err)
+	}
+	if re.SubexpIndex("e") < 0 {
+		return nil, fmt.Errorf("inotifywait format %q has no %%e conversion", format)
+	}
+	p.re = re
+	return p, nil
+}
+
+// parseLine decodes one inotifywait line; ok is false when it does not match
+func (p *inotifyParser) parseLine(line string) (*FileEvent, bool) {
+	match := p.re.FindStringSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+	group := func(name string) string {
+		if i := p.re.SubexpIndex(name); i >= 0 {
+			return match[i]
+		}
+		return ""
+	}
+
+	// %w is the watched directory (with a trailing slash) and %f the file in it
+	path := group("w") + group("f")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+
+	var timestamp time.Time
+	if p.hasTime {
+		var err error
+		if timestamp, err = p.timestamp.parse(group("T")); err != nil {
+			return nil, false
+		}
+	}
+
+	events := strings.ReplaceAll(group("e"), p.eventSep, ",")
+	return decodeEvent(path, events, timestamp, false, 1)
+}
+
+// fswatchParser parses lines written by fswatch -x, optionally with -t
+type fswatchParser struct {
+	timestamp timeFormat
+}
+
+// newFswatchParser builds a parser for fswatch -x output with the given -f time format
+func newFswatchParser(timefmt string) (*fswatchParser, error) {
+	if timefmt == "" {
+		timefmt = defaultFswatchTimeFmt
+	}
+	tf, err := newTimeFormat(timefmt)
+	if err != nil {
+		return nil, err
+	}
+	return &fswatchParser{timestamp: tf}, nil
+}
+
+// parseLine decodes one fswatch line; ok is false when it does not match
+func (p *fswatchParser) parseLine(line string) (*FileEvent, bool) {
+	// Event flags are the trailing words of the line
+	rest := strings.TrimRight(line, " \t")
+	var flags []string
+	for {
+		i := strings.LastIndexAny(rest, " \t")
+		if i < 0 || !fswatchFlags[rest[i+1:]] {
+			break
+		}
+		flags = append(flags, rest[i+1:])
+		rest = strings.TrimRight(rest[:i], " \t")
+	}
+	if len(flags) == 0 || rest == "" {
+		return nil, false
+	}
+
+	// With -t, the path is preceded by a timestamp
+	var timestamp time.Time
+	if head, tail, ok := splitFields(rest, p.timestamp.fieldCount()); ok {
+		if t, err := p.timestamp.parse(head); err == nil {
+			timestamp = t
+			rest = tail
+		}
+	}
+
+	return decodeEvent(rest, strings.Join(flags, " "), timestamp, false, 1)
+}
+
+// splitFields splits s after its first n space-separated fields
+func splitFields(s string, n int) (head, tail string, ok bool) {
+	i := 0
+	for field := 0; field < n; field++ {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i >= len(s) {
+			return "", "", false
+		}
+		for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+			i++
+		}
+	}
+	tail = strings.TrimLeft(s[i:], " \t")
+	if tail == "" {
+		return "", "", false
+	}
+	return s[:i], tail, true
+}
+
+// watchmanFile is an entry of the files array of a watchman subscription or query result
+type watchmanFile struct {
+	Name    string  `json:"name"`
+	Exists  *bool   `json:"exists"`
+	New     bool    `json:"new"`
+	Type    string  `json:"type"`
+	MtimeMs float64 `json:"mtime_ms"`
+}
+
+// watchmanResult is a watchman subscription or query result
+type watchmanResult struct {
+	Root  string            `json:"root"`
+	Files []json.RawMessage `json:"files"`
+}
+
+// decodeWatchmanJSON decodes a stream of watchman JSON results
+func decodeWatchmanJSON(r io.Reader, c *capture) error {
+	dec := json.NewDecoder(r)
+	for {
+		var result watchmanResult
+		if err := dec.Decode(&result); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		for _, raw := range result.Files {
+			file := watchmanFile{}
+			// Files are objects, or plain names when only "name" was requested
+			if err := json.Unmarshal(raw, &file); err != nil {
+				if err := json.Unmarshal(raw, &file.Name); err != nil {
+					return err
+				}
+			}
+			c.records++
+
+			operation := "WRITE"
+			if file.Exists != nil && !*file.Exists {
+				operation = "REMOVE"
+			} else if file.New {
+				operation = "CREATE"
+			}
+
+			var timestamp time.Time
+			if file.MtimeMs > 0 {
+				timestamp = time.UnixMilli(int64(file.MtimeMs))
+			}
+
+			path := file.Name
+			if result.Root != "" {
+				path = filepath.Join(result.Root, file.Name)
+			}
+			if event, ok := decodeEvent(path, operation, timestamp, file.Type == "d", 1); ok {
+				c.events = append(c.events, event)
+			}
+		}
+	}
+}
+
+// readText reads events from an inotifywait, fswatch or watchman log
+func (ei *ExportImport) readText(filename string, format ExportFormat, compression Compression) (*capture, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
+
+	reader, err := newDecompressedReader(file, compression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptExport, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
+
+	c := &capture{}
+	buffered := bufio.NewReader(reader)
+	options := ei.ui.state.TextImport
+
+	var parseLine func(string) (*FileEvent, bool)
+	switch format {
+	case FormatInotifywait:
+		p, err := newInotifyParser(options.InotifyFormat, options.InotifyTimeFmt)
+		if err != nil {
+			return nil, err
+		}
+		parseLine = p.parseLine
+	case FormatFswatch:
+		p, err := newFswatchParser(options.FswatchTimeFmt)
+		if err != nil {
+			return nil, err
+		}
+		parseLine = p.parseLine
+	case FormatWatchman:
+		// watchman -j output is JSON, watchman-wait prints one name per line
+		if first, err := peekNonSpace(buffered); err == nil && (first == '{' || first == '[') {
+			if err := decodeWatchmanJSON(buffered, c); err != nil {
+				return c, fmt.Errorf("%w: failed to decode watchman JSON: %v", ErrCorruptExport, err)
+			}
+			fillMissingTimestamps(c.events, file)
+			return c, nil
+		}
+		parseLine = func(line string) (*FileEvent, bool) {
+			return decodeEvent(line, "WRITE", time.Time{}, false, 1)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported text format")
+	}
+
+	scanner := bufio.NewScanner(buffered)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxTextLineSize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		c.records++
+		if event, ok := parseLine(line); ok {
+			c.events = append(c.events, event)
+		}
+	}
+	fillMissingTimestamps(c.events, file)
+	if err := scanner.Err(); err != nil {
+		return c, fmt.Errorf("%w: %v", ErrCorruptExport, err)
+	}
+	if c.records > 0 && len(c.events) == 0 {
+		return c, fmt.Errorf("no line of %s matches the %s format", filepath.Base(filename), textFormatName(format))
+	}
+	return c, nil
+}
+
+// fillMissingTimestamps gives events logged without a time a stable order
+// ending at the modification time of the log
+func fillMissingTimestamps(events []*FileEvent, file *os.File) {
+	end := time.Now()
+	if info, err := file.Stat(); err == nil {
+		end = info.ModTime()
+	}
+	for i, event := range events {
+		if event.Timestamp.IsZero() {
+			event.Timestamp = end.Add(-time.Duration(len(events)-1-i) * time.Millisecond)
+		}
+	}
+}
+
+// peekNonSpace returns the first non-whitespace byte of a reader without consuming it
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		data, err := r.Peek(n)
+		if len(data) == n {
+			if b := data[n-1]; b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+				return b, nil
+			}
+			continue
+		}
+		return 0, err
+	}
+}
+
+// textFormatName returns the name of a text log format
+func textFormatName(format ExportFormat) string {
+	switch format {
+	case FormatInotifywait:
+		return "inotifywait"
+	case FormatFswatch:
+		return "fswatch"
+	default:
+		return "watchman"
+	}
+}
+
+// SniffFormat guesses the format of a capture from its content, for files
+// whose extension does not tell
+func SniffFormat(filename string) (ExportFormat, bool) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return FormatSQLite, false
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Error(err, "close error")
+		}
+	}()
+
+	head := make([]byte, 64*1024)
+	n, _ := io.ReadFull(file, head)
+	head = head[:n]
+
+	if bytes.HasPrefix(head, []byte("SQLite format 3\x00")) {
+		return FormatSQLite, true
+	}
+	trimmed := bytes.TrimSpace(head)
+	if bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")) {
+		if bytes.Contains(trimmed, []byte(`"events"`)) {
+			return FormatJSON, true
+		}
+		return FormatWatchman, true
+	}
+
+	inotify, _ := newInotifyParser(defaultInotifyFormat, "")
+	fswatch, _ := newFswatchParser(defaultFswatchTimeFmt)
+	lines := strings.Split(string(head), "\n")
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if _, ok := fswatch.parseLine(line); ok {
+			return FormatFswatch, true
+		}
+		if _, ok := inotify.parseLine(line); ok {
+			return FormatInotifywait, true
+		}
+	}
+	if len(trimmed) > 0 {
+		// watchman-wait prints bare file names
+		return FormatWatchman, true
+	}
+	return FormatSQLite, false
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
 )
 
 // FileEvent represents a file system event with additional metadata
@@ -12,15 +13,48 @@ type FileEvent struct {
 	Operation fsnotify.Op
 	Timestamp time.Time
 	IsDir     bool
-	Count     int // Number of events for this path in recent time
+	Count     int           // Number of events for this path in recent time
+	Host      string        `json:",omitempty"` // Host the event comes from, set by a collector
+	Highlight string        `json:"-"`          // Color a rule highlights the event with
+	Violation string        `json:",omitempty"` // How the path breaks the integrity baseline, if it does
+	Attrs     *attrs.Change `json:",omitempty"` // Mode and owner before and after a CHMOD event, when they changed
+}
+
+// RuleFiring is the outcome of an action a rule ran on an event
+type RuleFiring struct {
+	Rule   string
+	Action string // command, webhook, append or highlight
+	Path   string
+	Time   time.Time
+	Err    error // Why the action failed, nil when it succeeded
+}
+
+// Detection is the evidence of a ransomware-like mass change, taken from
+// the events alone, whatever the process behind them
+type Detection struct {
+	Time        time.Time
+	Signals     []string // Thresholds crossed, e.g. "25 renames to unfamiliar extensions in 10s"
+	Paths       []string // Sample of the paths involved, most recent first
+	Extensions  []string // Unfamiliar extensions files were renamed to
+	Containment string   // Containment command started, if any
+}
+
+// Alert is the state of a configured rate alert
+type Alert struct {
+	Name   string
+	Firing bool
+	Reason string    // Why it fired or resolved
+	Since  time.Time // Last change of state
 }
 
 // Filter represents filtering options for events
 type Filter struct {
-	PathFilter      string
-	OperationFilter fsnotify.Op
-	ShowDirs        bool
-	ShowFiles       bool
+	PathFilter      string      `json:"path_filter"`
+	OperationFilter fsnotify.Op `json:"operation_filter"`
+	ShowDirs        bool        `json:"show_dirs"`
+	ShowFiles       bool        `json:"show_files"`
+	HostFilter      string      `json:"host_filter,omitempty"`
+	RiskFilter      string      `json:"risk_filter,omitempty"` // Risky attribute changes only: any, or text of the risk
 }
 
 // SortOption represents sorting options
@@ -31,6 +65,7 @@ const (
 	SortByPath
 	SortByOperation
 	SortByCount
+	SortByHost
 )
 
 // ExportFormat represents the format for import/export
@@ -39,14 +74,55 @@ type ExportFormat int
 const (
 	FormatSQLite ExportFormat = iota
 	FormatJSON
+	FormatInotifywait // inotifywait -m text log (import only)
+	FormatFswatch     // fswatch -x text log (import only)
+	FormatWatchman    // watchman-wait or watchman JSON log (import only)
+)
+
+// TextImportOptions describes how text logs from other watchers were written
+type TextImportOptions struct {
+	InotifyFormat  string // inotifywait --format string (default "%w %e %f")
+	InotifyTimeFmt string // inotifywait --timefmt strftime string used by %T
+	FswatchTimeFmt string // fswatch -f strftime string used by -t (default "%c")
+}
+
+// Compression represents the compression applied to an export file
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
 )
 
+// ExportScope selects which events are written by an export
+type ExportScope int
+
+const (
+	ScopeAll      ExportScope = iota // Every event in the state
+	ScopeFiltered                    // The filtered and sorted view
+	ScopeSelected                    // Only the multi-selected events
+)
+
+// ExportMeta describes how an export was produced, so that the view it
+// was taken from can be rebuilt after import
+type ExportMeta struct {
+	ExportTime time.Time `json:"export_time"`
+	TotalCount int       `json:"total_count"`
+	Scope      string    `json:"scope,omitempty"`
+	Filter     *Filter   `json:"filter,omitempty"`
+	SortOption string    `json:"sort,omitempty"`
+	Roots      []string  `json:"roots,omitempty"`
+}
+
 // FileDialogMode represents the mode of the file dialog
 type FileDialogMode int
 
 const (
 	ModeSave FileDialogMode = iota
 	ModeOpen
+	ModeReplay // Open a capture and replay it
+	ModeDiff   // Open two captures and compare them
 )
 
 // FileEntry represents a file or directory in the file dialog
@@ -85,6 +161,12 @@ const (
 	PathView          = "path"
 	FolderManagerView = "foldermanager"
 	FolderListView    = "folderlist"
+	ConfirmView       = "confirm"
+	DiffView          = "diff"
+	CommandView       = "command"
+	PackagesView      = "packages"
+	RulesView         = "rules"
+	DetectionView     = "detection"
 )
 
 // FocusMode represents the current focus mode of the UI
@@ -97,6 +179,9 @@ const (
 	FocusFolderManager
 	FocusWatchedFolders // Focus on "Currently Watching" panel
 	FocusFolderBrowser  // Focus on "Available Folders" panel
+	FocusConfirm        // Focus on a yes/no confirmation prompt
+	FocusDiff           // Focus on the session diff view
+	FocusDetection      // Focus on a ransomware-like detection
 )
 
 // FolderManagerState represents the state of the folder manager
@@ -108,6 +193,69 @@ type FolderManagerState struct {
 	ActivePanel  FocusMode // Which panel is currently focused (FocusWatchedFolders or FocusFolderBrowser)
 }
 
+// ConfirmState represents a pending yes/no confirmation prompt
+type ConfirmState struct {
+	Message   string
+	OnConfirm func() error // Called when the user answers yes
+	Previous  FocusMode    // Focus to restore once answered
+}
+
+// DetectionState is the ransomware-like detection shown until dismissed
+type DetectionState struct {
+	Detection Detection
+	Previous  FocusMode // Focus to restore once dismissed
+}
+
+// DiffKind tells how a path differs between two captures
+type DiffKind int
+
+const (
+	DiffOnlyA   DiffKind = iota // Only seen in the first capture
+	DiffOnlyB                   // Only seen in the second capture
+	DiffChanged                 // Seen in both with other counts or operations
+)
+
+// DiffSide summarises the events of one capture for a path
+type DiffSide struct {
+	Operations fsnotify.Op `json:"-"`
+	Count      int         `json:"count"`
+	IsDir      bool        `json:"is_dir"`
+}
+
+// PathDiff is a path that differs between two captures
+type PathDiff struct {
+	Path string    `json:"path"` // Relative to the capture root
+	Kind DiffKind  `json:"kind"`
+	A    *DiffSide `json:"a,omitempty"`
+	B    *DiffSide `json:"b,omitempty"`
+}
+
+// SessionDiff compares two captures path by path
+type SessionDiff struct {
+	A         string     `json:"a"`
+	B         string     `json:"b"`
+	Entries   []PathDiff `json:"entries"`
+	Unchanged int        `json:"unchanged"` // Paths with the same counts and operations
+}
+
+// DiffState represents the state of the session diff view
+type DiffState struct {
+	Result       *SessionDiff
+	FirstFile    string // Capture picked first while choosing the pair
+	ScrollOffset int
+}
+
+// ReplayState represents the progress of a replayed session
+type ReplayState struct {
+	Active   bool     // Whether a replay is running
+	Source   string   // Replayed capture file
+	Roots    []string // Roots recorded in the capture
+	Position int      // Number of events replayed so far
+	Total    int      // Number of events in the capture
+	Speed    float64  // Speed multiplier, 0 replays without delays
+	Paused   bool     // Whether the replay waits for step or resume
+}
+
 // UIState represents the current state of the UI
 type UIState struct {
 	Events            []*FileEvent
@@ -116,14 +264,27 @@ type UIState struct {
 	SelectedPath      string
 	ScrollOffset      int
 	MaxEvents         int
-	AggregateEvents   bool               // Toggle for event aggregation
-	ShowDetails       bool               // Toggle for details popup
-	SelectedEvent     *FileEvent         // Currently selected event for details
-	ExportFilename    string             // Current export filename
-	ImportFilename    string             // Current import filename
-	ShowFileDialog    bool               // Toggle for file dialog
-	ShowFolderManager bool               // Toggle for folder manager
-	FileDialog        FileDialogState    // File dialog state
-	FolderManager     FolderManagerState // Folder manager state
-	CurrentFocus      FocusMode          // Current focus mode
+	MaxAge            time.Duration       // Events older than this are dropped, never when zero
+	AggregateEvents   bool                // Toggle for event aggregation
+	ShowDetails       bool                // Toggle for details popup
+	SelectedEvent     *FileEvent          // Currently selected event for details
+	ExportFilename    string              // Current export filename
+	ImportFilename    string              // Current import filename
+	ExportScope       ExportScope         // Which events the next export writes
+	TextImport        TextImportOptions   // How text logs from other watchers are parsed
+	SelectedEvents    map[*FileEvent]bool // Events marked for a selection export
+	ShowFileDialog    bool                // Toggle for file dialog
+	ShowFolderManager bool                // Toggle for folder manager
+	FileDialog        FileDialogState     // File dialog state
+	FolderManager     FolderManagerState  // Folder manager state
+	CurrentFocus      FocusMode           // Current focus mode
+	ShowConfirm       bool                // Toggle for confirmation prompt
+	Confirm           ConfirmState        // Confirmation prompt state
+	StatusMessage     string              // Result of the last user operation
+	Replay            ReplayState         // Replayed session progress
+	ShowDiff          bool                // Toggle for session diff view
+	Diff              DiffState           // Session diff state
+	Profile           string              // Active configuration profile, if any
+	ShowDetection     bool                // Toggle for ransomware-like detection popup
+	Detection         DetectionState      // Ransomware-like detection popup state
 }
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +10,8 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/jesseduffield/gocui"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/pbouamriou/watch-fs/internal/gotest"
+	"github.com/pbouamriou/watch-fs/internal/runner"
 )
 
 // getAbsolutePath converts a relative path to absolute path, with fallback
@@ -32,6 +35,21 @@ type UI struct {
 	layout        *Layout
 	events        *Events
 	folderManager *FolderManager
+	confirm       *Confirm
+	detection     *DetectionPopup
+	replay        *Replay
+	diff          *Diff
+	command       *Command
+	packages      *Packages
+	profileSwitch *Profiles
+	rules         *Rules
+
+	runner  *runner.Runner  // Command run by `watch-fs exec`, if any
+	goTests *gotest.Session // Tests run by `watch-fs gotest`, if any
+
+	eventListeners []func(*FileEvent)      // Called for every recorded event
+	acceptBaseline func(path string) error // Accepts a path into the integrity baseline, if any
+	profiles       []Profile               // Configuration profiles the TUI can switch to
 
 	watcher interface {
 		Events() <-chan fsnotify.Event
@@ -66,11 +84,13 @@ func NewUI(watcher interface {
 			Filter:            Filter{ShowDirs: true, ShowFiles: true},
 			SortOption:        SortByTime,
 			MaxEvents:         1000,
-			AggregateEvents:   true,      // Enable aggregation by default
-			ShowDetails:       false,     // Details popup hidden by default
-			SelectedEvent:     nil,       // No event selected by default
-			ExportFilename:    "",        // No export filename by default
-			ImportFilename:    "",        // No import filename by default
+			AggregateEvents:   true,     // Enable aggregation by default
+			ShowDetails:       false,    // Details popup hidden by default
+			SelectedEvent:     nil,      // No event selected by default
+			ExportFilename:    "",       // No export filename by default
+			ImportFilename:    "",       // No import filename by default
+			ExportScope:       ScopeAll, // Export every event by default
+			SelectedEvents:    make(map[*FileEvent]bool),
 			ShowFileDialog:    false,     // File dialog hidden by default
 			ShowFolderManager: false,     // Folder manager hidden by default
 			CurrentFocus:      FocusMain, // Start with main focus
@@ -101,6 +121,20 @@ func NewUI(watcher interface {
 	ui.layout = NewLayout(ui)
 	ui.events = NewEvents(ui)
 	ui.folderManager = NewFolderManager(ui)
+	ui.confirm = NewConfirm(ui)
+	ui.detection = NewDetectionPopup(ui)
+	ui.replay = NewReplay(ui)
+	ui.diff = NewDiff(ui)
+	ui.command = NewCommand(ui)
+	ui.packages = NewPackages(ui)
+	ui.profileSwitch = NewProfiles(ui)
+	ui.rules = NewRules(ui)
+
+	// Remote watchers, such as a daemon client, bring the events received
+	// before the UI started
+	if source, ok := watcher.(interface{ History() []*FileEvent }); ok {
+		ui.state.Events = append(ui.state.Events, source.History()...)
+	}
 
 	return ui
 }
@@ -178,6 +212,11 @@ func (ui *UI) GetRootPaths() []string {
 	return ui.rootPaths
 }
 
+// RefreshRoots rereads the watched roots after they changed outside the TUI
+func (ui *UI) RefreshRoots() {
+	ui.rootPaths = ui.watcher.GetRoots()
+}
+
 // GetRootPathsDisplay returns a formatted string of all root paths for display
 func (ui *UI) GetRootPathsDisplay() string {
 	if len(ui.rootPaths) == 1 {
@@ -198,6 +237,12 @@ func (ui *UI) AddEvent(path string, operation fsnotify.Op, isDir bool) {
 	ui.addEvent(path, operation, isDir)
 }
 
+// RecordEvent adds a complete event, with the fields set by the watcher
+// and the hooks, to the state
+func (ui *UI) RecordEvent(event *FileEvent) {
+	ui.events.record(event)
+}
+
 // ToggleAggregate toggles aggregation (public version for testing)
 func (ui *UI) ToggleAggregate() {
 	ui.navigation.ToggleAggregate()
@@ -218,6 +263,17 @@ func (ui *UI) CycleSort() {
 	ui.navigation.CycleSort()
 }
 
+// ToggleRisky toggles showing only the risky attribute changes (public
+// version for testing)
+func (ui *UI) ToggleRisky() {
+	ui.navigation.ToggleRisky()
+}
+
+// CycleHost cycles the host filter (public version for testing)
+func (ui *UI) CycleHost() {
+	ui.navigation.CycleHost()
+}
+
 // GetFilteredEvents returns filtered events (public version for testing)
 func (ui *UI) GetFilteredEvents() []*FileEvent {
 	return ui.getFilteredEvents()
@@ -268,11 +324,256 @@ func (ui *UI) ExportEvents(filename string, format ExportFormat) error {
 	return ui.exportImport.ExportEvents(filename, format)
 }
 
+// Snapshot exports every event to a file, whatever the export scope
+func (ui *UI) Snapshot(filename string, format ExportFormat) error {
+	return ui.exportImport.Snapshot(filename, format)
+}
+
+// ExportView exports the given events, such as the result of a query, as
+// the filtered view
+func (ui *UI) ExportView(filename string, format ExportFormat, events []*FileEvent) error {
+	return ui.exportImport.ExportView(filename, format, events)
+}
+
 // ImportEvents imports events from a file
 func (ui *UI) ImportEvents(filename string, format ExportFormat) error {
 	return ui.exportImport.ImportEvents(filename, format)
 }
 
+// ApplySettings applies the view defaults of a configuration profile
+func (ui *UI) ApplySettings(settings Settings) {
+	if settings.Filter != nil {
+		ui.state.Filter = *settings.Filter
+	}
+	if settings.SortOption != nil {
+		ui.state.SortOption = *settings.SortOption
+	}
+	if settings.AggregateEvents != nil {
+		ui.state.AggregateEvents = *settings.AggregateEvents
+	}
+	if settings.MaxEvents > 0 {
+		ui.state.MaxEvents = settings.MaxEvents
+	}
+	if settings.MaxAge > 0 {
+		ui.state.MaxAge = settings.MaxAge
+	}
+}
+
+// SetProfiles lists the configuration profiles the TUI can switch to with
+// 'P'; current is the one already applied
+func (ui *UI) SetProfiles(profiles []Profile, current string) {
+	ui.profileSwitch.Set(profiles, current)
+}
+
+// SwitchProfile applies a configuration profile (public version for testing)
+func (ui *UI) SwitchProfile(name string) error {
+	return ui.profileSwitch.Switch(name)
+}
+
+// LoadCapture reads the events and metadata of a capture without importing it
+func (ui *UI) LoadCapture(filename string, format ExportFormat) ([]*FileEvent, ExportMeta, error) {
+	return ui.exportImport.LoadCapture(filename, format)
+}
+
+// ImportEventsPartial imports whatever can be recovered from a damaged file
+func (ui *UI) ImportEventsPartial(filename string, format ExportFormat) error {
+	return ui.exportImport.ImportEventsPartial(filename, format)
+}
+
+// OnEvent registers a function called for every event added to the state,
+// live or replayed
+func (ui *UI) OnEvent(listener func(*FileEvent)) {
+	ui.eventListeners = append(ui.eventListeners, listener)
+}
+
+// StartReplay replays a recorded session at the given speed multiplier
+// (1 is real time, 0 is as fast as possible), optionally starting paused
+func (ui *UI) StartReplay(filename string, format ExportFormat, speed float64, paused bool) error {
+	return ui.replay.Start(filename, format, speed, paused)
+}
+
+// PauseReplay suspends the running replay
+func (ui *UI) PauseReplay() {
+	ui.replay.Pause()
+}
+
+// ResumeReplay continues a paused replay
+func (ui *UI) ResumeReplay() {
+	ui.replay.Resume()
+}
+
+// StepReplay replays the next event and leaves the replay paused
+func (ui *UI) StepReplay() {
+	ui.replay.Step()
+}
+
+// SetReplaySpeed changes the speed multiplier of the running replay
+func (ui *UI) SetReplaySpeed(speed float64) {
+	ui.replay.SetSpeed(speed)
+}
+
+// StopReplay ends the running replay
+func (ui *UI) StopReplay() {
+	ui.replay.Stop()
+}
+
+// ReplayDone returns a channel closed when the replay ends
+func (ui *UI) ReplayDone() <-chan struct{} {
+	return ui.replay.Done()
+}
+
+// CompareCaptures loads two captures and compares them by root-relative path
+func (ui *UI) CompareCaptures(fileA string, formatA ExportFormat, fileB string, formatB ExportFormat) (*SessionDiff, error) {
+	return ui.diff.Compare(fileA, formatA, fileB, formatB)
+}
+
+// ShowDiff opens the session diff view with a result
+func (ui *UI) ShowDiff(result *SessionDiff) {
+	ui.diff.Show(result)
+}
+
+// HideDiff closes the session diff view
+func (ui *UI) HideDiff() {
+	ui.diff.Hide()
+}
+
+// SetBaselineAccept lets 'B' accept the current state of the path of the
+// selected event into the integrity baseline
+func (ui *UI) SetBaselineAccept(accept func(path string) error) {
+	ui.acceptBaseline = accept
+}
+
+// AcceptViolation accepts the current state of a path into the integrity
+// baseline and clears the violation of its events
+func (ui *UI) AcceptViolation(path string) error {
+	if ui.acceptBaseline == nil {
+		return errors.New("no integrity baseline is configured")
+	}
+	if err := ui.acceptBaseline(path); err != nil {
+		return err
+	}
+	for _, event := range ui.state.Events {
+		if event.Path == path && event.Host == "" {
+			event.Violation = ""
+		}
+	}
+	return nil
+}
+
+// SetCommandRunner shows the status and output of a command below the events
+func (ui *UI) SetCommandRunner(r *runner.Runner) {
+	ui.command.Attach(r)
+}
+
+// SetTestSession shows the per-package results of a test session next to
+// the events; the runner runs its tests
+func (ui *UI) SetTestSession(session *gotest.Session, r *runner.Runner) {
+	ui.packages.Attach(session, r)
+}
+
+// ShowRules shows the pane of rule firings below the events when rules are
+// configured
+func (ui *UI) ShowRules(count int) {
+	ui.rules.Show(count)
+}
+
+// RecordRuleFiring adds the outcome of a rule action to the rules pane
+func (ui *UI) RecordRuleFiring(firing RuleFiring) {
+	ui.rules.Record(firing)
+}
+
+// SetAlert shows an alert that fired or resolved; firing alerts stay in
+// the status bar
+func (ui *UI) SetAlert(alert Alert) {
+	ui.rules.SetAlert(alert)
+}
+
+// GetAlerts returns the alerts that changed state, firing or not
+func (ui *UI) GetAlerts() []Alert {
+	return ui.rules.Alerts()
+}
+
+// ShowDetection opens the popup with the evidence of a ransomware-like
+// detection
+func (ui *UI) ShowDetection(detection Detection) {
+	ui.detection.Show(detection)
+}
+
+// GetRuleFirings returns the recent rule firings, oldest first
+func (ui *UI) GetRuleFirings() []RuleFiring {
+	return ui.rules.Firings()
+}
+
+// SetTextImportOptions sets how inotifywait and fswatch logs are parsed
+func (ui *UI) SetTextImportOptions(options TextImportOptions) {
+	ui.state.TextImport = options
+}
+
+// Quit stops a running TUI as if the user had quit
+func (ui *UI) Quit() {
+	if ui.gui != nil {
+		ui.gui.Update(func(g *gocui.Gui) error {
+			return gocui.ErrQuit
+		})
+	}
+}
+
+// Update runs f on the TUI goroutine, which owns the state, or right away
+// when the TUI is not running
+func (ui *UI) Update(f func()) {
+	if ui.gui == nil {
+		f()
+		return
+	}
+	ui.gui.Update(func(*gocui.Gui) error {
+		f()
+		return nil
+	})
+}
+
+// SetStatusMessage shows a message in the status bar
+func (ui *UI) SetStatusMessage(message string) {
+	ui.setStatusMessage(message)
+}
+
+// setStatusMessage shows the result of a user operation in the status bar
+func (ui *UI) setStatusMessage(message string) {
+	ui.state.StatusMessage = message
+	if ui.gui != nil {
+		ui.gui.Update(func(g *gocui.Gui) error {
+			if v, err := g.View(StatusView); err == nil {
+				ui.views.UpdateStatusView(v)
+			}
+			return nil
+		})
+	}
+}
+
+// SetExportScope selects which events the next export writes
+func (ui *UI) SetExportScope(scope ExportScope) {
+	ui.state.ExportScope = scope
+}
+
+// CycleExportScope cycles through the export scopes (All → Filtered → Selected)
+func (ui *UI) CycleExportScope() {
+	ui.state.ExportScope = (ui.state.ExportScope + 1) % 3
+}
+
+// GetScopedEvents returns the events the current export scope covers
+func (ui *UI) GetScopedEvents() []*FileEvent {
+	return ui.events.getScopedEvents(ui.state.ExportScope)
+}
+
+// ToggleEventSelection marks or unmarks an event for a selection export
+func (ui *UI) ToggleEventSelection(event *FileEvent) {
+	ui.events.toggleSelection(event)
+}
+
+// ClearSelection unmarks every selected event
+func (ui *UI) ClearSelection() {
+	ui.events.clearSelection()
+}
+
 // ShowFolderManager shows the folder manager interface
 func (ui *UI) ShowFolderManager() {
 	ui.folderManager.Show()
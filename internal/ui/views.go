@@ -2,7 +2,9 @@ package ui
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/fsnotify/fsnotify"
@@ -43,11 +45,59 @@ func (v *Views) UpdateStatusView(view *gocui.View) {
 		watchingInfo = fmt.Sprintf("%s (%d dirs)", watchingInfo, len(v.ui.rootPaths))
 	}
 
-	_, _ = fmt.Fprintf(view, "Watching: %s | Events: %s | Sort: %s%s\n",
+	// Display the multi-selection used by selection exports
+	var selectionInfo string
+	if len(v.ui.state.SelectedEvents) > 0 {
+		selectionInfo = fmt.Sprintf(" | Selected: %s", yellow(len(v.ui.state.SelectedEvents)))
+	}
+
+	// Display the replay progress
+	var replayInfo string
+	if replay := v.ui.state.Replay; replay.Active {
+		progress := fmt.Sprintf("%d/%d", replay.Position, replay.Total)
+		speed := "max"
+		if replay.Speed > 0 {
+			speed = fmt.Sprintf("x%g", replay.Speed)
+		}
+		if replay.Paused {
+			speed += ", paused"
+		}
+		replayInfo = fmt.Sprintf(" | Replay: %s (%s)", yellow(progress), cyan(speed))
+	}
+
+	// Display the active configuration profile
+	var profileInfo string
+	if v.ui.state.Profile != "" {
+		profileInfo = fmt.Sprintf(" | Profile: %s", cyan(v.ui.state.Profile))
+	}
+
+	// Display the firing alerts
+	var alertInfo string
+	if firing := v.ui.rules.Firing(); len(firing) > 0 {
+		red := color.New(color.FgRed, color.Bold).SprintFunc()
+		names := make([]string, len(firing))
+		for i, alert := range firing {
+			names[i] = fmt.Sprintf("%s (%s)", alert.Name, alert.Reason)
+		}
+		alertInfo = fmt.Sprintf(" | %s %s", red("ALERT"), red(strings.Join(names, ", ")))
+	}
+
+	// Display the result of the last user operation
+	var messageInfo string
+	if v.ui.state.StatusMessage != "" {
+		messageInfo = fmt.Sprintf(" | %s", yellow(v.ui.state.StatusMessage))
+	}
+
+	_, _ = fmt.Fprintf(view, "Watching: %s | Events: %s | Sort: %s%s%s%s%s%s%s\n",
 		cyan(watchingInfo),
 		yellow(len(v.ui.state.Events)),
 		cyan(v.ui.getSortOptionName()),
-		exportInfo)
+		alertInfo,
+		profileInfo,
+		replayInfo,
+		selectionInfo,
+		exportInfo,
+		messageInfo)
 }
 
 // UpdateFilterView updates the filter view
@@ -73,6 +123,17 @@ func (v *Views) UpdateFilterView(view *gocui.View) {
 
 	_, _ = fmt.Fprintf(view, "Dirs: %s | Files: %s | Aggregate: %s | Path Filter: %s",
 		dirsStatus, filesStatus, aggregateStatus, v.ui.state.Filter.PathFilter)
+
+	if v.ui.state.Filter.RiskFilter != "" {
+		_, _ = fmt.Fprintf(view, " | Risk: %s", red(v.ui.state.Filter.RiskFilter))
+	}
+
+	// Events from a collector can be narrowed to one host
+	if v.ui.state.Filter.HostFilter != "" {
+		_, _ = fmt.Fprintf(view, " | Host: %s", v.ui.state.Filter.HostFilter)
+	} else if len(v.ui.events.hosts()) > 0 {
+		_, _ = fmt.Fprint(view, " | Host: all")
+	}
 }
 
 // UpdateEventsView updates the events view
@@ -109,18 +170,46 @@ func (v *Views) UpdateHelpView(view *gocui.View) {
 
 	switch v.ui.state.CurrentFocus {
 	case FocusMain:
-		helpText = "q: Quit | f: Toggle files | d: Toggle dirs | a: Toggle aggregate | s: Sort | ↑↓←→/hjkl: Navigate | PgUp/PgDn: Page | Home/End/g/G: Top/Bottom | Enter: Details | Ctrl+E: Export | Ctrl+I: Import | Ctrl+F: Folder Manager"
+		helpText = "q: Quit | f: Toggle files | d: Toggle dirs | a: Toggle aggregate | s: Sort | R: Risky changes | ↑↓←→/hjkl: Navigate | PgUp/PgDn: Page | Home/End/g/G: Top/Bottom | Enter: Details | Space: Select | c: Clear selection | Ctrl+E: Export | Ctrl+I: Import | Ctrl+R: Replay | Ctrl+D: Diff | Ctrl+F: Folder Manager"
+		if v.ui.state.Replay.Active {
+			helpText = "p: Pause/Resume replay | n: Step | +/-: Speed | x: Stop replay | " + helpText
+		}
+		if v.ui.runner != nil {
+			helpText = "r: Re-run command | " + helpText
+		}
+		if len(v.ui.profiles) > 0 {
+			helpText = "P: Next profile | " + helpText
+		}
+		if len(v.ui.events.hosts()) > 0 {
+			helpText = "H: Next host | " + helpText
+		}
+		if v.ui.acceptBaseline != nil {
+			helpText = "B: Accept into baseline | " + helpText
+		}
 
 	case FocusDetails:
 		helpText = "ESC/q: Close details | Enter: Close details"
 
 	case FocusFileDialog:
 		if v.ui.state.FileDialog.Mode == ModeSave {
-			helpText = "↑↓/kj: Navigate | Enter: Select file | e: Edit filename | ESC/q: Cancel | Save mode"
+			helpText = "↑↓/kj: Navigate | Enter: Select file | e: Edit filename | v: Export scope | ESC/q: Cancel | Save mode"
+		} else if v.ui.state.FileDialog.Mode == ModeDiff {
+			helpText = "↑↓/kj: Navigate | Enter: Pick capture A, then B | ESC/q: Cancel | Diff mode"
+		} else if v.ui.state.FileDialog.Mode == ModeReplay {
+			helpText = "↑↓/kj: Navigate | Enter: Replay file | ESC/q: Cancel | Replay mode"
 		} else {
 			helpText = "↑↓/kj: Navigate | Enter: Select file | ESC/q: Cancel | Open mode"
 		}
 
+	case FocusDiff:
+		helpText = "↑↓/kj: Scroll | e: Export diff | ESC/q: Close | Session Diff"
+
+	case FocusConfirm:
+		helpText = "y/Enter: Yes | n/ESC/q: No"
+
+	case FocusDetection:
+		helpText = "Enter/ESC/q: Dismiss | Ransomware Detection"
+
 	case FocusFolderManager:
 		helpText = "↑↓/kj: Navigate | Enter: Open folder | a: Add folder | d: Remove folder | ESC/q: Close | Folder Manager"
 
@@ -162,8 +251,12 @@ func (v *Views) UpdateDetailsView(view *gocui.View) {
 		operationStr = "UNKNOWN"
 	}
 
-	// Get file info if possible
-	fileInfo, err := v.ui.getFileInfo(event.Path)
+	// Get file info if possible; the files of other hosts are not here
+	var fileInfo os.FileInfo
+	err := os.ErrNotExist
+	if event.Host == "" {
+		fileInfo, err = v.ui.getFileInfo(event.Path)
+	}
 	fileSize := "N/A"
 	fileMode := "N/A"
 	if err == nil && fileInfo != nil {
@@ -175,6 +268,9 @@ func (v *Views) UpdateDetailsView(view *gocui.View) {
 	_, _ = fmt.Fprintf(view, "%sEvent Details%s\n", cyan("="), cyan("="))
 	_, _ = fmt.Fprintf(view, "\n")
 	_, _ = fmt.Fprintf(view, "%s: %s\n", cyan("Operation"), operationStr)
+	if event.Host != "" {
+		_, _ = fmt.Fprintf(view, "%s: %s\n", cyan("Host"), event.Host)
+	}
 	_, _ = fmt.Fprintf(view, "%s: %s\n", cyan("Path"), event.Path)
 	_, _ = fmt.Fprintf(view, "%s: %s\n", cyan("Type"), yellow(func() string {
 		if event.IsDir {
@@ -184,6 +280,18 @@ func (v *Views) UpdateDetailsView(view *gocui.View) {
 	}()))
 	_, _ = fmt.Fprintf(view, "%s: %s\n", cyan("Timestamp"), event.Timestamp.Format("2006-01-02 15:04:05.000"))
 	_, _ = fmt.Fprintf(view, "%s: %d\n", cyan("Count"), event.Count)
+	if event.Violation != "" {
+		_, _ = fmt.Fprintf(view, "%s: %s\n", cyan("Baseline"), red(event.Violation))
+	}
+	// Mode and owner as the event left them, not as they are now
+	if change := event.Attrs; change != nil {
+		_, _ = fmt.Fprintf(view, "%s: %s -> %s\n", cyan("Mode"), change.Before.Mode, change.After.Mode)
+		_, _ = fmt.Fprintf(view, "%s: %d:%d -> %d:%d\n", cyan("Owner"),
+			change.Before.UID, change.Before.GID, change.After.UID, change.After.GID)
+		if len(change.Risks) > 0 {
+			_, _ = fmt.Fprintf(view, "%s: %s\n", cyan("Risks"), red(strings.Join(change.Risks, ", ")))
+		}
+	}
 
 	if err == nil && fileInfo != nil {
 		_, _ = fmt.Fprintf(view, "%s: %s\n", cyan("Size"), fileSize)
@@ -197,31 +305,11 @@ func (v *Views) UpdateDetailsView(view *gocui.View) {
 
 // renderEvent renders a single event with colors
 func (v *Views) renderEvent(view *gocui.View, event *FileEvent) {
-	red := color.New(color.FgRed).SprintFunc()
-	green := color.New(color.FgGreen).SprintFunc()
-	yellow := color.New(color.FgYellow).SprintFunc()
-	blue := color.New(color.FgBlue).SprintFunc()
-	magenta := color.New(color.FgMagenta).SprintFunc()
-
 	// Format timestamp
 	timestamp := event.Timestamp.Format("15:04:05")
 
 	// Format operation with color
-	var operationStr string
-	// Handle combined operations by checking each bit
-	if event.Operation.Has(fsnotify.Create) {
-		operationStr = green("CREATE")
-	} else if event.Operation.Has(fsnotify.Write) {
-		operationStr = yellow("WRITE")
-	} else if event.Operation.Has(fsnotify.Remove) {
-		operationStr = red("REMOVE")
-	} else if event.Operation.Has(fsnotify.Rename) {
-		operationStr = magenta("RENAME")
-	} else if event.Operation.Has(fsnotify.Chmod) {
-		operationStr = blue("CHMOD")
-	} else {
-		operationStr = "UNKNOWN"
-	}
+	operationStr := OperationLabel(event.Operation)
 
 	// Format type indicator
 	typeIndicator := "F"
@@ -241,7 +329,51 @@ func (v *Views) renderEvent(view *gocui.View, event *FileEvent) {
 		pathStr = "..." + pathStr[len(pathStr)-47:]
 	}
 
+	// Prefix the events of a collector with their host
+	hostStr := ""
+	if event.Host != "" {
+		hostStr = color.New(color.FgCyan).Sprint(event.Host) + " "
+	}
+
+	// Rules may highlight the events they fired on; events breaking the
+	// integrity baseline or with risky attribute changes are red otherwise
+	risky := event.Attrs != nil && len(event.Attrs.Risks) > 0
+	if event.Highlight != "" {
+		pathStr = highlight(event.Highlight, pathStr)
+	} else if event.Violation != "" || risky {
+		pathStr = color.New(color.FgRed, color.Bold).Sprint(pathStr)
+	}
+	if risky {
+		countStr += color.New(color.FgRed).Sprintf(" [%s]", strings.Join(event.Attrs.Risks, ", "))
+	}
+
+	// Mark events selected for a selection export
+	selectedStr := ""
+	if v.ui.state.SelectedEvents[event] {
+		selectedStr = "* "
+	}
+
 	// Render the event line
-	line := fmt.Sprintf("[%s] %s %s %s%s", timestamp, operationStr, typeIndicator, pathStr, countStr)
+	line := fmt.Sprintf("%s[%s] %s%s %s %s%s", selectedStr, timestamp, hostStr, operationStr, typeIndicator, pathStr, countStr)
 	_, _ = fmt.Fprintln(view, line)
 }
+
+// OperationLabel returns the colored name of the main operation of an
+// event. Combined operations are shown by their first bit, in the order
+// CREATE, WRITE, REMOVE, RENAME, CHMOD.
+func OperationLabel(operation fsnotify.Op) string {
+	switch {
+	case operation.Has(fsnotify.Create):
+		return color.New(color.FgGreen).Sprint("CREATE")
+	case operation.Has(fsnotify.Write):
+		return color.New(color.FgYellow).Sprint("WRITE")
+	case operation.Has(fsnotify.Remove):
+		return color.New(color.FgRed).Sprint("REMOVE")
+	case operation.Has(fsnotify.Rename):
+		return color.New(color.FgMagenta).Sprint("RENAME")
+	case operation.Has(fsnotify.Chmod):
+		return color.New(color.FgBlue).Sprint("CHMOD")
+	default:
+		return "UNKNOWN"
+	}
+}
@@ -0,0 +1,146 @@
+// Package wait blocks until the file system reaches a state scripts care
+// about: a matching event, or a quiet period without events
+package wait
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// ErrTimeout is returned when the timeout expires first
+var ErrTimeout = errors.New("timed out")
+
+// Source is the part of the file watcher the waits need
+type Source interface {
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	AddDirectory(path string) error
+}
+
+// Matcher selects the events a wait considers
+type Matcher struct {
+	Roots      []string               // Watched roots; patterns match root-relative paths
+	Pattern    *utils.Glob            // Any path when nil
+	Operations fsnotify.Op            // Any operation when zero
+	Ignore     func(path string) bool // Ignored paths, hidden and ShouldIgnore paths when nil
+}
+
+// Matches reports whether an event is considered; ignored paths, by default
+// hidden ones and names such as .git, never are
+func (m Matcher) Matches(event fsnotify.Event) bool {
+	if m.Operations != 0 && event.Op&m.Operations == 0 {
+		return false
+	}
+	if m.Ignore != nil {
+		if m.Ignore(event.Name) {
+			return false
+		}
+	} else if m.ignoredByDefault(event.Name) {
+		return false
+	}
+	if m.Pattern == nil {
+		return true
+	}
+	if len(m.Roots) == 0 {
+		return m.Pattern.Match(event.Name)
+	}
+	for _, root := range m.Roots {
+		rel, err := filepath.Rel(root, event.Name)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && m.Pattern.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredByDefault reports whether a path is hidden or has an ignored name
+// under one of the roots
+func (m Matcher) ignoredByDefault(path string) bool {
+	for _, root := range m.Roots {
+		if utils.ShouldIgnorePath(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForEvent waits for an event the matcher considers and returns it. A zero
+// timeout waits forever.
+func ForEvent(source Source, matcher Matcher, timeout time.Duration) (fsnotify.Event, error) {
+	expired := deadline(timeout)
+	for {
+		select {
+		case event, ok := <-source.Events():
+			if !ok {
+				return fsnotify.Event{}, errors.New("watcher closed")
+			}
+			watchNewDirectory(source, event)
+			if matcher.Matches(event) {
+				return event, nil
+			}
+		case err, ok := <-source.Errors():
+			if ok {
+				return fsnotify.Event{}, fmt.Errorf("watcher error: %w", err)
+			}
+		case <-expired:
+			return fsnotify.Event{}, ErrTimeout
+		}
+	}
+}
+
+// ForQuiet waits until no considered event happened for the quiet period
+// and returns how many were seen. A zero timeout waits forever.
+func ForQuiet(source Source, matcher Matcher, quiet, timeout time.Duration) (int, error) {
+	expired := deadline(timeout)
+	settled := time.NewTimer(quiet)
+	defer settled.Stop()
+
+	count := 0
+	for {
+		select {
+		case event, ok := <-source.Events():
+			if !ok {
+				return count, errors.New("watcher closed")
+			}
+			watchNewDirectory(source, event)
+			if matcher.Matches(event) {
+				count++
+				settled.Reset(quiet)
+			}
+		case err, ok := <-source.Errors():
+			if ok {
+				return count, fmt.Errorf("watcher error: %w", err)
+			}
+		case <-settled.C:
+			return count, nil
+		case <-expired:
+			return count, ErrTimeout
+		}
+	}
+}
+
+// deadline returns a channel receiving when the timeout expires, or nil to
+// wait forever
+func deadline(timeout time.Duration) <-chan time.Time {
+	if timeout <= 0 {
+		return nil
+	}
+	return time.After(timeout)
+}
+
+// watchNewDirectory watches directories created while waiting
+func watchNewDirectory(source Source, event fsnotify.Event) {
+	if event.Op&fsnotify.Create == 0 {
+		return
+	}
+	if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+		_ = source.AddDirectory(event.Name)
+	}
+}
@@ -6,17 +6,47 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+	"github.com/pbouamriou/watch-fs/internal/metrics"
 	"github.com/pbouamriou/watch-fs/pkg/logger"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
 )
 
 // Watcher wraps fsnotify.Watcher with additional functionality
 type Watcher struct {
 	watcher *fsnotify.Watcher
-	roots   []string        // Root directories being watched
-	watched map[string]bool // Track all watched directories for removal
-	mu      sync.RWMutex    // Protect concurrent access to roots and watched
+	roots   []string           // Root directories being watched
+	watched map[string]bool    // Track all watched directories for removal
+	mu      sync.RWMutex       // Protect concurrent access to roots and watched
+	ignore  *utils.IgnoreRules // Paths neither watched nor reported, none when nil
+	attrs   *attrs.Tracker     // Last known mode and owner of the paths under the roots
+
+	// Events and errors are relayed from the current fsnotify watcher, which
+	// RemoveRoot replaces, so that consumers keep the same channels
+	events    chan fsnotify.Event
+	errors    chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+	relays    sync.WaitGroup
+}
+
+// newWatcher creates a watcher relaying the events of an fsnotify watcher
+func newWatcher(fsWatcher *fsnotify.Watcher, roots []string, ignore *utils.IgnoreRules) *Watcher {
+	w := &Watcher{
+		watcher: fsWatcher,
+		roots:   roots,
+		watched: make(map[string]bool),
+		ignore:  ignore,
+		attrs:   attrs.NewTracker(),
+		events:  make(chan fsnotify.Event),
+		errors:  make(chan error),
+		closed:  make(chan struct{}),
+	}
+	w.relay(fsWatcher)
+	return w
 }
 
 // New creates a new file system watcher
@@ -26,37 +56,136 @@ func New(root string) (*Watcher, error) {
 		return nil, err
 	}
 
-	return &Watcher{
-		watcher: watcher,
-		roots:   []string{root},
-		watched: make(map[string]bool),
-	}, nil
+	return newWatcher(watcher, []string{root}, nil), nil
 }
 
 // NewMultiRoot creates a new file system watcher with multiple root directories
 func NewMultiRoot(roots []string) (*Watcher, error) {
+	return NewWithIgnore(roots, nil)
+}
+
+// NewWithIgnore creates a watcher over multiple roots that does not watch
+// ignored directories; consumers skip ignored events with Ignored
+func NewWithIgnore(roots []string, ignore *utils.IgnoreRules) (*Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
 
-	w := &Watcher{
-		watcher: watcher,
-		roots:   roots,
-		watched: make(map[string]bool),
-	}
-
-	// Add all roots recursively
+	w := newWatcher(watcher, roots, ignore)
 	if err := w.AddAllRootsRecursive(); err != nil {
+		_ = w.Close()
 		return nil, err
 	}
-
 	return w, nil
 }
 
-// Close closes the watcher
+// relay forwards the events and errors of an fsnotify watcher until it is
+// closed, recording every event in the audit stream and the metrics, and
+// the mode and owner it leaves its path with
+func (w *Watcher) relay(fsWatcher *fsnotify.Watcher) {
+	w.relays.Add(1)
+	go func() {
+		defer w.relays.Done()
+		for {
+			select {
+			case event, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+				received := time.Now()
+				root, ignored := w.classify(event.Name)
+				logger.Event(event.Name, event.Op.String(), root, ignored)
+				if !ignored {
+					w.attrs.Observe(event)
+				}
+				select {
+				case w.events <- event:
+					metrics.ObserveEvent(root, event.Op, ignored, time.Since(received))
+				case <-w.closed:
+					return
+				}
+			case err, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				metrics.ObserveError(err)
+				select {
+				case w.errors <- err:
+				case <-w.closed:
+					return
+				}
+			}
+		}
+	}()
+}
+
+// classify returns the root of a path and whether it is ignored
+func (w *Watcher) classify(path string) (string, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return utils.RootOf(path, w.roots), w.ignoredUnsafe(path)
+}
+
+// SetIgnore replaces the ignore rules. Directories already watched stay
+// watched, but their events are ignored by consumers checking Ignored.
+func (w *Watcher) SetIgnore(ignore *utils.IgnoreRules) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ignore = ignore
+}
+
+// ReloadIgnore replaces the ignore rules and watches the directories they
+// no longer ignore. Directories they now ignore stay watched; their events
+// are left out through Ignored.
+func (w *Watcher) ReloadIgnore(ignore *utils.IgnoreRules) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ignore = ignore
+	for _, root := range w.roots {
+		if err := w.addRecursiveUnsafe(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ignored reports whether a path is ignored under one of the roots
+func (w *Watcher) Ignored(path string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.ignoredUnsafe(path)
+}
+
+// ignoredUnsafe is Ignored for callers holding the mutex
+func (w *Watcher) ignoredUnsafe(path string) bool {
+	if w.ignore == nil {
+		return false
+	}
+	for _, root := range w.roots {
+		if w.ignore.Match(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes the watcher; the event and error channels are closed once
+// the pending events are dropped
 func (w *Watcher) Close() error {
-	return w.watcher.Close()
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.closed)
+		w.mu.RLock()
+		err = w.watcher.Close()
+		w.mu.RUnlock()
+		go func() {
+			w.relays.Wait()
+			close(w.events)
+			close(w.errors)
+		}()
+	})
+	return err
 }
 
 // addRecursiveUnsafe adds a directory and all its subdirectories to the watcher
@@ -66,6 +195,15 @@ func (w *Watcher) addRecursiveUnsafe(root string) error {
 		if err != nil {
 			return err
 		}
+		if path != root && w.ignoredUnsafe(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			w.attrs.Record(path, info)
+		}
 		if d.IsDir() {
 			err = w.watcher.Add(path)
 			if err != nil {
@@ -98,25 +236,57 @@ func (w *Watcher) AddAllRootsRecursive() error {
 
 // Events returns the events channel
 func (w *Watcher) Events() <-chan fsnotify.Event {
-	return w.watcher.Events
+	return w.events
 }
 
 // Errors returns the errors channel
 func (w *Watcher) Errors() <-chan error {
-	return w.watcher.Errors
+	return w.errors
 }
 
 // AddDirectory adds a new directory to the watcher (for newly created directories)
 func (w *Watcher) AddDirectory(path string) error {
+	if w.Ignored(path) {
+		return nil
+	}
 	err := w.watcher.Add(path)
 	if err == nil {
 		w.mu.Lock()
 		w.watched[path] = true
 		w.mu.Unlock()
+		w.recordEntries(path)
 	}
 	return err
 }
 
+// recordEntries records the attributes of the entries of a new directory,
+// which may have been filled before it was watched
+func (w *Watcher) recordEntries(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if w.Ignored(path) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			w.attrs.Record(path, info)
+		}
+	}
+}
+
+// AttrChange returns how a CHMOD event changed the mode or owner of its
+// path, nil for other events. Consumers call it once for every event they
+// receive, in order, so that each gets its own change.
+func (w *Watcher) AttrChange(event fsnotify.Event) *attrs.Change {
+	if !event.Has(fsnotify.Chmod) {
+		return nil
+	}
+	return w.attrs.Take(event.Name)
+}
+
 // GetRoots returns all root directories being watched
 func (w *Watcher) GetRoots() []string {
 	w.mu.RLock()
@@ -205,6 +375,7 @@ func (w *Watcher) recreateWatcherWithoutRoot(rootToRemove string) error {
 
 	w.watcher = newWatcher
 	w.watched = make(map[string]bool)
+	w.relay(newWatcher)
 
 	// Re-add all roots except the one to remove (using unsafe version since caller holds the lock)
 	for _, root := range w.roots {
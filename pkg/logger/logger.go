@@ -1,19 +1,49 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-var Logger zerolog.Logger
+const (
+	// DefaultMaxSize is the size beyond which log files are rotated
+	DefaultMaxSize = 10 << 20
+	// DefaultMaxBackups is the number of rotated log files kept
+	DefaultMaxBackups = 3
+)
 
-// Init initializes the logger with file output
-func Init() error {
-	// Determine log file path based on OS
+var (
+	// Logger is the diagnostic log
+	Logger = zerolog.Nop()
+	// Audit records every file system event received, apart from the
+	// diagnostic log; disabled until SetupAudit is called
+	Audit = zerolog.Nop()
+
+	mu          sync.Mutex
+	logOutput   io.Closer // Closed when the diagnostic log is set up again
+	auditOutput io.Closer // Closed when the audit stream is set up again
+)
+
+// Options configures the diagnostic log
+type Options struct {
+	Level      string // trace, debug, info, warn, error or disabled; info when empty
+	File       string // Log file, "-" for stderr or "off"; DefaultPath when empty
+	MaxSize    int64  // Rotate the file beyond this many bytes, never when negative, DefaultMaxSize when zero
+	MaxBackups int    // Rotated files kept, none when negative, DefaultMaxBackups when zero
+}
+
+// DefaultPath returns the default log file: watch-fs.log in the user cache
+// directory, or in the temporary directory when there is no home
+func DefaultPath() string {
 	var logDir string
 	switch runtime.GOOS {
 	case "windows":
@@ -23,38 +53,133 @@ func Init() error {
 		}
 		logDir = filepath.Join(localAppData, "watch-fs")
 	case "linux", "darwin":
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return err
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			logDir = filepath.Join(homeDir, ".cache", "watch-fs")
+		} else {
+			logDir = filepath.Join(os.TempDir(), "watch-fs")
 		}
-		logDir = filepath.Join(homeDir, ".cache", "watch-fs")
 	default:
 		// Fallback to current directory
 		logDir = "."
 	}
+	return filepath.Join(logDir, "watch-fs.log")
+}
 
-	// Create log directory if it doesn't exist
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+// Init initializes the logger with the default file output
+func Init() error {
+	return Setup(Options{})
+}
+
+// Setup configures the diagnostic log. On error the log is disabled, so
+// that callers can report it and go on.
+func Setup(options Options) error {
+	level, err := ParseLevel(options.Level)
+	if err != nil {
 		return err
 	}
+	if options.File == "" {
+		options.File = DefaultPath()
+	}
+	output, err := openOutput(options.File, options.MaxSize, options.MaxBackups)
 
-	// Create log file
-	logFile := filepath.Join(logDir, "watch-fs.log")
-	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	mu.Lock()
+	defer mu.Unlock()
+	closeOutput(logOutput)
+	logOutput = nil
 	if err != nil {
+		Logger = zerolog.Nop()
+		log.Logger = Logger
 		return err
 	}
 
 	// Configure zerolog
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	Logger = zerolog.New(file).With().Timestamp().Logger()
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	if output == nil {
+		Logger = zerolog.Nop()
+	} else {
+		Logger = zerolog.New(output).Level(level).With().Timestamp().Logger()
+		logOutput = output
+	}
 
 	// Set global logger
 	log.Logger = Logger
+	return nil
+}
+
+// SetupAudit starts writing every received file system event to file, "-"
+// for stderr, with the rotation of Options; an empty file or "off" stops
+// the audit stream
+func SetupAudit(file string, maxSize int64, maxBackups int) error {
+	var output io.WriteCloser
+	if file != "" {
+		var err error
+		if output, err = openOutput(file, maxSize, maxBackups); err != nil {
+			return err
+		}
+	}
 
+	mu.Lock()
+	defer mu.Unlock()
+	closeOutput(auditOutput)
+	auditOutput = nil
+	Audit = zerolog.Nop()
+	if output != nil {
+		zerolog.TimeFieldFormat = time.RFC3339Nano
+		Audit = zerolog.New(output).With().Timestamp().Logger()
+		auditOutput = output
+	}
 	return nil
 }
 
+// ParseLevel parses a log level name
+func ParseLevel(name string) (zerolog.Level, error) {
+	if name == "" {
+		return zerolog.InfoLevel, nil
+	}
+	level, err := zerolog.ParseLevel(strings.ToLower(name))
+	if err != nil || level == zerolog.NoLevel {
+		return zerolog.InfoLevel, fmt.Errorf("unknown log level %q (want trace, debug, info, warn, error or disabled)", name)
+	}
+	return level, nil
+}
+
+// openOutput opens a log destination: nil for "off", stderr for "-", a
+// rotated file otherwise
+func openOutput(file string, maxSize int64, maxBackups int) (io.WriteCloser, error) {
+	switch file {
+	case "off":
+		return nil, nil
+	case "-":
+		return nopCloser{os.Stderr}, nil
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxBackups == 0 {
+		maxBackups = DefaultMaxBackups
+	}
+
+	// Create log directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return openRotating(file, maxSize, maxBackups)
+}
+
+// closeOutput closes a previous log destination
+func closeOutput(output io.Closer) {
+	if output != nil {
+		_ = output.Close()
+	}
+}
+
+// nopCloser keeps stderr open when the log is set up again
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
 // Error logs an error message
 func Error(err error, msg string) {
 	Logger.Error().Err(err).Msg(msg)
@@ -74,3 +199,8 @@ func Debug(msg string) {
 func Warn(msg string) {
 	Logger.Warn().Msg(msg)
 }
+
+// Event records a received file system event in the audit stream
+func Event(path, op, root string, ignored bool) {
+	Audit.Log().Str("path", path).Str("op", op).Str("root", root).Bool("ignored", ignored).Send()
+}
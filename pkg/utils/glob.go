@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Glob is a shell pattern over slash-separated paths: * and ? stay within
+// a path segment, ** spans segments. A pattern without a slash matches the
+// base name at any depth.
+type Glob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// NewGlob compiles a pattern
+func NewGlob(pattern string) (*Glob, error) {
+	source := strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+	if !strings.Contains(source, "/") {
+		source = "**/" + source
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(source); i++ {
+		switch c := source[i]; c {
+		case '*':
+			if strings.HasPrefix(source[i:], "**/") {
+				expr.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(source[i:], "**") {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(source[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %q: unclosed [", pattern)
+			}
+			class := source[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return &Glob{pattern: pattern, re: re}, nil
+}
+
+// Match reports whether a path matches the pattern
+func (g *Glob) Match(path string) bool {
+	return g.re.MatchString(filepath.ToSlash(path))
+}
+
+// String returns the pattern
+func (g *Glob) String() string {
+	return g.pattern
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreRules decide which paths under a watched root are ignored
+type IgnoreRules struct {
+	Patterns []*Glob // Root-relative patterns; a match on a directory ignores its content
+	Defaults bool    // Also ignore hidden paths and the ShouldIgnore names
+}
+
+// NewIgnoreRules compiles ignore patterns
+func NewIgnoreRules(patterns []string, defaults bool) (*IgnoreRules, error) {
+	rules := &IgnoreRules{Defaults: defaults}
+	for _, pattern := range patterns {
+		glob, err := NewGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		rules.Patterns = append(rules.Patterns, glob)
+	}
+	return rules, nil
+}
+
+// Match reports whether a path under root is ignored
+func (r *IgnoreRules) Match(root, path string) bool {
+	if r == nil {
+		return false
+	}
+	// Only paths below the root are ignored, never the root itself
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	if r.Defaults && ShouldIgnorePath(root, path) {
+		return true
+	}
+
+	// Check the path and every directory leading to it
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, pattern := range r.Patterns {
+			if pattern.Match(prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
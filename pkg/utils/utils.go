@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 )
 
 // ValidateDirectory checks if a path is a valid directory
@@ -54,3 +55,44 @@ func ShouldIgnore(path string) bool {
 
 	return slices.Contains(ignoreDirs, base)
 }
+
+// ShouldIgnorePath checks if a path, or any directory between root and it,
+// should be ignored
+func ShouldIgnorePath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return ShouldIgnore(path)
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part != ".." && ShouldIgnore(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// RootOf returns the deepest root containing a path, or "" if none does
+func RootOf(path string, roots []string) string {
+	best := ""
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(root) > len(best) {
+			best = root
+		}
+	}
+	return best
+}
+
+// RelativePath returns a path relative to the deepest root containing it,
+// slash-separated, or the path itself outside the roots
+func RelativePath(path string, roots []string) string {
+	if root := RootOf(path, roots); root != "" {
+		if rel, err := filepath.Rel(root, path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
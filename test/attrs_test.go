@@ -0,0 +1,193 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+	"github.com/pbouamriou/watch-fs/internal/query"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+)
+
+// TestAttrsRisks tests the risky transitions of mode and owner changes
+func TestAttrsRisks(t *testing.T) {
+	file := attrs.Attributes{Mode: 0o644, UID: 1000, GID: 1000}
+	for _, tc := range []struct {
+		name  string
+		after attrs.Attributes
+		risks string
+	}{
+		{"narrowed", attrs.Attributes{Mode: 0o600, UID: 1000, GID: 1000}, ""},
+		{"world-writable", attrs.Attributes{Mode: 0o666, UID: 1000, GID: 1000}, attrs.RiskWorldWritable},
+		{"setuid and setgid", attrs.Attributes{Mode: 0o755 | os.ModeSetuid | os.ModeSetgid, UID: 1000, GID: 1000},
+			attrs.RiskSetuid + ", " + attrs.RiskSetgid},
+		{"to root", attrs.Attributes{Mode: 0o644, UID: 0, GID: 0}, attrs.RiskOwnerToRoot + ", " + attrs.RiskGroupToRoot},
+		{"unknown owner", attrs.Attributes{Mode: 0o644, UID: -1, GID: -1}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			change := attrs.Compare(file, tc.after)
+			if change == nil || strings.Join(change.Risks, ", ") != tc.risks {
+				t.Errorf("Expected risks %q, got %+v", tc.risks, change)
+			}
+		})
+	}
+
+	if attrs.Compare(file, file) != nil {
+		t.Error("Expected no change between equal attributes")
+	}
+	fromRoot := attrs.Compare(attrs.Attributes{Mode: 0o644}, file)
+	if !fromRoot.HasRisk(attrs.RiskOwnerFromRoot) || fromRoot.String() != "owner 0:0 -> 1000:1000" {
+		t.Errorf("Expected an owner change from root, got %v %v", fromRoot, fromRoot.Risks)
+	}
+
+	// A risky mode reverted within an aggregated event is still reported
+	opened := attrs.Compare(file, attrs.Attributes{Mode: 0o666, UID: 1000, GID: 1000})
+	reverted := opened.Then(attrs.Compare(opened.After, file))
+	if reverted.String() != "reverted" || !reverted.HasRisk(attrs.RiskWorldWritable) {
+		t.Errorf("Expected the reverted change to keep its risk, got %v %v", reverted, reverted.Risks)
+	}
+}
+
+// TestAttrsWatcher tests that the watcher attaches the mode before and
+// after to CHMOD events
+func TestAttrsWatcher(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses POSIX permissions")
+	}
+	root := t.TempDir()
+	path := filepath.Join(root, "script.sh")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	fileWatcher, err := watcher.NewWithIgnore([]string{root}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer func() { _ = fileWatcher.Close() }()
+
+	if err := os.Chmod(path, 0o777); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-fileWatcher.Events():
+			change := fileWatcher.AttrChange(event)
+			if !event.Has(fsnotify.Chmod) {
+				if change != nil {
+					t.Errorf("Expected no change for %v, got %v", event, change)
+				}
+				continue
+			}
+			if change == nil || change.Before.Mode != 0o644 || change.After.Mode != 0o777 {
+				t.Fatalf("Expected a mode change from 0644 to 0777, got %+v", change)
+			}
+			if !change.HasRisk(attrs.RiskWorldWritable) {
+				t.Errorf("Expected a world-writable risk, got %v", change.Risks)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timed out waiting for the CHMOD event")
+		}
+	}
+}
+
+// TestAttrsPendingBound tests that changes stop being queued for new paths
+// when nobody takes them
+func TestAttrsPendingBound(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses POSIX permissions")
+	}
+	path := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if err := os.Chmod(path, 0o755); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+
+	tracker := attrs.NewTracker()
+	tracker.Record(path, info)
+	for i := range 10000 {
+		tracker.Observe(fsnotify.Event{Name: filepath.Join("/missing", strconv.Itoa(i)), Op: fsnotify.Chmod})
+	}
+	tracker.Observe(fsnotify.Event{Name: path, Op: fsnotify.Chmod})
+	if change := tracker.Take(path); change != nil {
+		t.Errorf("Expected no change queued past the bound, got %v", change)
+	}
+
+	// Taken changes make room again
+	for i := range 10000 {
+		tracker.Take(filepath.Join("/missing", strconv.Itoa(i)))
+	}
+	if err := os.Chmod(path, 0o700); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+	tracker.Observe(fsnotify.Event{Name: path, Op: fsnotify.Chmod})
+	if change := tracker.Take(path); change == nil || change.After.Mode != 0o700 {
+		t.Errorf("Expected the mode change to 0700, got %v", change)
+	}
+}
+
+// TestAttrsFilterAndExport tests that risky changes are filtered, queried
+// and kept by exports
+func TestAttrsFilterAndExport(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+	source := ui.NewUI(mockWatcher, "/test")
+	source.AddEvent("/test/plain.txt", fsnotify.Chmod, false)
+	source.RecordEvent(&ui.FileEvent{
+		Path:      "/test/tool",
+		Operation: fsnotify.Chmod,
+		Timestamp: time.Now(),
+		Count:     1,
+		Attrs:     attrs.Compare(attrs.Attributes{Mode: 0o755}, attrs.Attributes{Mode: 0o755 | os.ModeSetuid}),
+	})
+
+	source.ToggleRisky()
+	if events := source.GetFilteredEvents(); len(events) != 1 || events[0].Path != "/test/tool" {
+		t.Fatalf("Expected only the risky event, got %d events", len(events))
+	}
+	source.ToggleRisky()
+	q := query.New()
+	if err := q.Where("risk~root"); err != nil {
+		t.Fatalf("Failed to parse condition: %v", err)
+	}
+	if events := q.Run(source); len(events) != 0 {
+		t.Errorf("Expected no change to or from root, got %d events", len(events))
+	}
+
+	for _, format := range []ui.ExportFormat{ui.FormatSQLite, ui.FormatJSON} {
+		filename := filepath.Join(t.TempDir(), "events")
+		if err := source.ExportEvents(filename, format); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+		events, _, err := source.LoadCapture(filename, format)
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		var risky *ui.FileEvent
+		for _, event := range events {
+			if event.Path == "/test/tool" {
+				risky = event
+			} else if event.Attrs != nil {
+				t.Errorf("Expected no attributes for %s, got %v", event.Path, event.Attrs)
+			}
+		}
+		if risky == nil || risky.Attrs == nil || risky.Attrs.After.Mode != 0o755|os.ModeSetuid ||
+			!risky.Attrs.HasRisk(attrs.RiskSetuid) {
+			t.Errorf("Expected the setuid change to be kept by format %d, got %+v", format, risky)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/stats"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// TestIgnoreRules tests the -ignore and -default-ignores rules
+func TestIgnoreRules(t *testing.T) {
+	root := filepath.Join("/tmp", "project")
+	rules, err := utils.NewIgnoreRules([]string{"build", "docs/*.md", "**/*.log"}, false)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	cases := []struct {
+		path    string
+		ignored bool
+	}{
+		{root, false},
+		{filepath.Join(root, "build"), true},
+		{filepath.Join(root, "build", "out", "app"), true},
+		{filepath.Join(root, "src", "build", "app"), true},
+		{filepath.Join(root, "docs", "index.md"), true},
+		{filepath.Join(root, "src", "docs", "index.md"), false},
+		{filepath.Join(root, "src", "debug.log"), true},
+		{filepath.Join(root, "src", "main.go"), false},
+		{filepath.Join(root, ".git", "HEAD"), false},
+		{filepath.Join("/tmp", "other", "build"), false},
+	}
+	for _, c := range cases {
+		if got := rules.Match(root, c.path); got != c.ignored {
+			t.Errorf("%s: expected ignored=%v, got %v", c.path, c.ignored, got)
+		}
+	}
+
+	rules.Defaults = true
+	if !rules.Match(root, filepath.Join(root, ".git", "HEAD")) {
+		t.Error("Expected the default rules to ignore .git")
+	}
+	if rules.Match(root, root) {
+		t.Error("Expected the root itself never to be ignored")
+	}
+
+	var none *utils.IgnoreRules
+	if none.Match(root, filepath.Join(root, "build")) {
+		t.Error("Expected no rules to ignore nothing")
+	}
+}
+
+// TestWatcherIgnore tests that a watcher does not watch ignored directories
+func TestWatcherIgnore(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"src", "node_modules/pkg", "build"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	rules, err := utils.NewIgnoreRules([]string{"build"}, true)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	fileWatcher, err := watcher.NewWithIgnore([]string{root}, rules)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer func() { _ = fileWatcher.Close() }()
+
+	if fileWatcher.Ignored(filepath.Join(root, "src", "main.go")) {
+		t.Error("Expected src to be watched")
+	}
+	for _, path := range []string{"node_modules/pkg/index.js", "build/app"} {
+		if !fileWatcher.Ignored(filepath.Join(root, path)) {
+			t.Errorf("Expected %s to be ignored", path)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "build", "app"), nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "src", "main.go"), nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	select {
+	case event := <-fileWatcher.Events():
+		if event.Name != filepath.Join(root, "src", "main.go") {
+			t.Errorf("Expected the first event on src/main.go, got %s", event.Name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected an event on src/main.go")
+	}
+}
+
+// TestWatcherReloadIgnore tests that directories no longer ignored after a
+// reload are watched
+func TestWatcherReloadIgnore(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "build"), 0o755); err != nil {
+		t.Fatalf("Failed to create build: %v", err)
+	}
+	rules, err := utils.NewIgnoreRules([]string{"build"}, false)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	fileWatcher, err := watcher.NewWithIgnore([]string{root}, rules)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer func() { _ = fileWatcher.Close() }()
+
+	if err := fileWatcher.ReloadIgnore(nil); err != nil {
+		t.Fatalf("Failed to reload ignore rules: %v", err)
+	}
+	path := filepath.Join(root, "build", "app")
+	if fileWatcher.Ignored(path) {
+		t.Error("Expected build to be watched after the reload")
+	}
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	select {
+	case event := <-fileWatcher.Events():
+		if event.Name != path {
+			t.Errorf("Expected an event on build/app, got %s", event.Name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected an event on build/app")
+	}
+}
+
+// TestStatsSummarize tests the summary of the stats command
+func TestStatsSummarize(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	events := []*ui.FileEvent{
+		{Path: "/a/x.go", Operation: fsnotify.Create | fsnotify.Write, Timestamp: start, Count: 3},
+		{Path: "/a/y.go", Operation: fsnotify.Write, Timestamp: start.Add(time.Minute), Count: 1},
+		{Path: "/b/z.txt", Operation: fsnotify.Remove, Timestamp: start.Add(30 * time.Second), Count: 1},
+		{Path: "/c/w", Operation: fsnotify.Chmod, Timestamp: start.Add(10 * time.Second)},
+	}
+	summary := stats.Summarize(events, []string{"/a", "/b"}, 2)
+
+	if summary.Records != 4 || summary.Events != 6 || summary.Paths != 4 {
+		t.Errorf("Expected 4 records, 6 events and 4 paths, got %d, %d and %d", summary.Records, summary.Events, summary.Paths)
+	}
+	if !summary.First.Equal(start) || !summary.Last.Equal(start.Add(time.Minute)) {
+		t.Errorf("Unexpected time span %s to %s", summary.First, summary.Last)
+	}
+	operations := map[string]int{}
+	for _, count := range summary.Operations {
+		operations[count.Key] = count.Events
+	}
+	if operations["CREATE"] != 3 || operations["WRITE"] != 4 || operations["REMOVE"] != 1 || operations["CHMOD"] != 1 {
+		t.Errorf("Unexpected operation counts %v", operations)
+	}
+	if len(summary.Roots) != 3 || summary.Roots[0].Key != "/a" || summary.Roots[0].Events != 4 {
+		t.Errorf("Unexpected root counts %v", summary.Roots)
+	}
+	if len(summary.TopPaths) != 2 || summary.TopPaths[0].Key != "/a/x.go" {
+		t.Errorf("Unexpected top paths %v", summary.TopPaths)
+	}
+}
@@ -0,0 +1,231 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/collector"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// startCollector serves a collector on address, a free localhost port
+// when empty
+func startCollector(t *testing.T, address string, tlsConfig *tls.Config) (*collector.Collector, string) {
+	t.Helper()
+	if address == "" {
+		address = "127.0.0.1:0"
+	}
+	c := collector.New("secret", tlsConfig)
+	listener, err := c.Listen(address)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = c.Serve(listener) }()
+	t.Cleanup(func() { _ = c.Close() })
+	return c, listener.Addr().String()
+}
+
+// startForwarder runs a forwarder to a collector
+func startForwarder(t *testing.T, options collector.ForwarderOptions) (*collector.Forwarder, <-chan error) {
+	t.Helper()
+	if options.Token == "" {
+		options.Token = "secret"
+	}
+	f := collector.NewForwarder(options)
+	done := make(chan error, 1)
+	go func() { done <- f.Run() }()
+	t.Cleanup(f.Close)
+	return f, done
+}
+
+// receiveEvents reads n events from a collector
+func receiveEvents(t *testing.T, c *collector.Collector, n int) []*ui.FileEvent {
+	t.Helper()
+	var events []*ui.FileEvent
+	for len(events) < n {
+		select {
+		case event := <-c.FileEvents():
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d events, got %d", n, len(events))
+		}
+	}
+	return events
+}
+
+// fileEvent returns a write of a file
+func fileEvent(path string) *ui.FileEvent {
+	return &ui.FileEvent{Path: path, Operation: fsnotify.Write, Timestamp: time.Now(), Count: 1}
+}
+
+// TestCollectorHosts tests that the events of several forwarders reach the
+// collector with their host, and that the TUI filters and sorts by host
+func TestCollectorHosts(t *testing.T) {
+	c, address := startCollector(t, "", nil)
+	alpha, _ := startForwarder(t, collector.ForwarderOptions{Address: address, Host: "alpha", Roots: []string{"/srv"}})
+	beta, _ := startForwarder(t, collector.ForwarderOptions{Address: address, Host: "beta", Roots: []string{"/home"}})
+	alpha.Send(fileEvent("/srv/a.txt"))
+	beta.Send(fileEvent("/home/b.txt"))
+	alpha.Send(fileEvent("/srv/c.txt"))
+
+	session := ui.NewUI(c, "")
+	session.GetState().AggregateEvents = false
+	for _, event := range receiveEvents(t, c, 3) {
+		wantHost := "alpha"
+		if filepath.Base(event.Path) == "b.txt" {
+			wantHost = "beta"
+		}
+		if event.Host != wantHost || event.Operation != fsnotify.Write {
+			t.Errorf("Expected a write from %s, got %+v", wantHost, event)
+		}
+		session.GetState().Events = append(session.GetState().Events, event)
+	}
+	if roots := c.GetRoots(); len(roots) != 2 || roots[0] != "alpha:/srv" || roots[1] != "beta:/home" {
+		t.Errorf("Expected the roots of both hosts, got %v", roots)
+	}
+
+	session.CycleHost()
+	if filter := session.GetState().Filter.HostFilter; filter != "alpha" {
+		t.Fatalf("Expected the first host filter to be alpha, got %q", filter)
+	}
+	if events := session.GetFilteredEvents(); len(events) != 2 {
+		t.Errorf("Expected the 2 events of alpha, got %d", len(events))
+	}
+	session.CycleHost()
+	session.CycleHost()
+	if filter := session.GetState().Filter.HostFilter; filter != "" {
+		t.Errorf("Expected every host after the last one, got %q", filter)
+	}
+
+	option, ok := ui.ParseSortOption("host")
+	if !ok {
+		t.Fatal("Expected host to be a sort option")
+	}
+	session.GetState().SortOption = option
+	events := session.GetFilteredEvents()
+	if events[0].Host != "alpha" || events[2].Host != "beta" {
+		t.Errorf("Expected the events sorted by host, got %s, %s, %s", events[0].Host, events[1].Host, events[2].Host)
+	}
+}
+
+// TestForwarderReconnect tests that events sent while the collector is
+// down are buffered and delivered once it is back, and that a wrong token
+// is refused
+func TestForwarderReconnect(t *testing.T) {
+	first, address := startCollector(t, "", nil)
+	f, _ := startForwarder(t, collector.ForwarderOptions{Address: address, Host: "alpha"})
+	f.Send(fileEvent("/srv/before.txt"))
+	receiveEvents(t, first, 1)
+	_ = first.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for f.Connected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	f.Send(fileEvent("/srv/down1.txt"))
+	f.Send(fileEvent("/srv/down2.txt"))
+	if pending := f.Pending(); pending != 2 {
+		t.Errorf("Expected 2 buffered events, got %d", pending)
+	}
+
+	second, _ := startCollector(t, address, nil)
+	events := receiveEvents(t, second, 2)
+	if filepath.Base(events[0].Path) != "down1.txt" || filepath.Base(events[1].Path) != "down2.txt" {
+		t.Errorf("Expected the buffered events in order, got %s and %s", events[0].Path, events[1].Path)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for f.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending := f.Pending(); pending != 0 {
+		t.Errorf("Expected the events to be acknowledged, %d pending", pending)
+	}
+
+	_, refused := startForwarder(t, collector.ForwarderOptions{Address: address, Host: "mallory", Token: "wrong"})
+	select {
+	case err := <-refused:
+		if err == nil {
+			t.Error("Expected a wrong token to be refused")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the forwarder with a wrong token to stop")
+	}
+}
+
+// TestCollectorTLS tests forwarding over TLS with a certificate the
+// forwarder trusts
+func TestCollectorTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "collector"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	c, address := startCollector(t, "", serverTLS)
+	f, _ := startForwarder(t, collector.ForwarderOptions{
+		Address: address,
+		Host:    "alpha",
+		TLS:     &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+	})
+	f.Send(fileEvent("/srv/secure.txt"))
+	if event := receiveEvents(t, c, 1)[0]; event.Host != "alpha" || filepath.Base(event.Path) != "secure.txt" {
+		t.Errorf("Expected the event over TLS, got %+v", event)
+	}
+	if hosts := c.Hosts(); len(hosts) != 1 || !hosts[0].Connected || hosts[0].Events != 1 {
+		t.Errorf("Expected alpha to be connected with 1 event, got %+v", hosts)
+	}
+}
+
+// TestCaptureKeepsHosts tests that captures keep the host of events
+func TestCaptureKeepsHosts(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	session := ui.NewUI(mockWatcher, "/test/path")
+	event := fileEvent("/srv/a.txt")
+	event.Host = "alpha"
+	session.GetState().Events = append(session.GetState().Events, event)
+	for _, name := range []string{"hosts.db", "hosts.json"} {
+		filename := filepath.Join(t.TempDir(), name)
+		format, _, _ := ui.DetectFormat(filename)
+		if err := session.Snapshot(filename, format); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		events, _, err := session.LoadCapture(filename, format)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if len(events) != 1 || events[0].Host != "alpha" {
+			t.Errorf("Expected %s to keep the host, got %+v", name, events)
+		}
+	}
+}
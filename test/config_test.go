@@ -0,0 +1,148 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+)
+
+const testConfig = `
+profile: backend
+ignore: ["*.tmp"]
+sort: path
+profiles:
+  backend:
+    roots: [/srv/api]
+    filter: {op: write, dirs: false}
+    retention: {max_events: 500, max_age: 10m}
+  docs:
+    roots: [/srv/docs]
+    ignore: []
+    aggregate: false
+`
+
+// TestConfigResolve tests how the file, profiles and environment merge
+func TestConfigResolve(t *testing.T) {
+	file, err := config.Parse([]byte(testConfig), "config.yml")
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if names := file.ProfileNames(); len(names) != 2 || names[0] != "backend" || names[1] != "docs" {
+		t.Errorf("Expected profiles backend and docs, got %v", names)
+	}
+
+	settings, err := file.Resolve("")
+	if err != nil {
+		t.Fatalf("Failed to resolve the default profile: %v", err)
+	}
+	if len(settings.Roots) != 1 || settings.Roots[0] != "/srv/api" {
+		t.Errorf("Expected the backend roots, got %v", settings.Roots)
+	}
+	if len(settings.Ignore) != 1 || settings.Sort != "path" {
+		t.Errorf("Expected the top-level ignore and sort, got %v and %q", settings.Ignore, settings.Sort)
+	}
+
+	docs, err := file.Resolve("docs")
+	if err != nil {
+		t.Fatalf("Failed to resolve docs: %v", err)
+	}
+	if docs.Ignore == nil || len(docs.Ignore) != 0 {
+		t.Errorf("Expected an empty list to replace the top-level ignore, got %v", docs.Ignore)
+	}
+	if _, err := file.Resolve("missing"); err == nil {
+		t.Error("Expected an unknown profile to fail")
+	}
+
+	env, err := config.FromEnv(func(name string) string {
+		switch name {
+		case config.EnvPaths:
+			return "/srv/one" + string(os.PathListSeparator) + "/srv/two"
+		case config.EnvSort:
+			return "count"
+		case config.EnvMaxEvents:
+			return "2000"
+		}
+		return ""
+	})
+	if err != nil {
+		t.Fatalf("Failed to read the environment: %v", err)
+	}
+	merged := settings.Merge(env)
+	if len(merged.Roots) != 2 || merged.Sort != "count" || merged.Filter == nil {
+		t.Errorf("Expected the environment to override roots and sort, got %+v", merged)
+	}
+	if merged.Retention == nil || merged.Retention.MaxEvents != 2000 || merged.Retention.MaxAge != 10*time.Minute {
+		t.Errorf("Expected the environment max events with the profile max age, got %+v", merged.Retention)
+	}
+
+	view, err := settings.View()
+	if err != nil {
+		t.Fatalf("Failed to build the view settings: %v", err)
+	}
+	if view.Filter == nil || view.Filter.OperationFilter != fsnotify.Write || view.Filter.ShowDirs || !view.Filter.ShowFiles {
+		t.Errorf("Unexpected filter %+v", view.Filter)
+	}
+	if view.SortOption == nil || *view.SortOption != ui.SortByPath {
+		t.Error("Expected the path sort")
+	}
+	if view.MaxEvents != 500 || view.MaxAge != 10*time.Minute {
+		t.Errorf("Unexpected retention %d, %v", view.MaxEvents, view.MaxAge)
+	}
+}
+
+// TestConfigInvalid tests that mistakes in the file are reported
+func TestConfigInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown field":   "rootz: [/srv]",
+		"missing default": "profile: nope",
+		"bad duration":    "retention: {max_age: soon}",
+	} {
+		if _, err := config.Parse([]byte(data), "config.yml"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := config.Parse(nil, "config.yml"); err != nil {
+		t.Errorf("Expected an empty file to be valid, got %v", err)
+	}
+	if _, err := config.Load(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+		t.Error("Expected an explicit missing file to fail")
+	}
+}
+
+// TestSwitchProfile tests switching the watched roots from the TUI
+func TestSwitchProfile(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	fileWatcher, err := watcher.New(first)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer func() { _ = fileWatcher.Close() }()
+
+	sortOption := ui.SortByCount
+	session := ui.NewUI(fileWatcher, first)
+	session.SetProfiles([]ui.Profile{
+		{Name: "first", Roots: []string{first}},
+		{Name: "second", Roots: []string{second}, Settings: ui.Settings{SortOption: &sortOption}},
+	}, "first")
+
+	if err := session.SwitchProfile("second"); err != nil {
+		t.Fatalf("Failed to switch profile: %v", err)
+	}
+	roots := session.GetRootPaths()
+	if len(roots) != 1 || filepath.Clean(roots[0]) != filepath.Clean(second) {
+		t.Errorf("Expected only %s to be watched, got %v", second, roots)
+	}
+	state := session.GetState()
+	if state.Profile != "second" || state.SortOption != ui.SortByCount {
+		t.Errorf("Expected the second profile and its sort, got %q and %v", state.Profile, state.SortOption)
+	}
+	if err := session.SwitchProfile("third"); err == nil {
+		t.Error("Expected an unknown profile to fail")
+	}
+}
@@ -0,0 +1,127 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/console"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// consoleEvents returns a few events under the /watched root
+func consoleEvents() []*ui.FileEvent {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	return []*ui.FileEvent{
+		{Path: "/watched/src/main.go", Operation: fsnotify.Write, Timestamp: at, Count: 1},
+		{Path: "/watched/build", Operation: fsnotify.Create, Timestamp: at, IsDir: true, Count: 1},
+		{Path: "/watched/src/old.go", Operation: fsnotify.Remove, Timestamp: at, Count: 1},
+	}
+}
+
+// printAll prints events with a printer and returns the output
+func printAll(t *testing.T, options console.Options) string {
+	t.Helper()
+	var out bytes.Buffer
+	printer, err := console.NewPrinter(&out, options)
+	if err != nil {
+		t.Fatalf("Failed to create printer: %v", err)
+	}
+	for _, event := range consoleEvents() {
+		if err := printer.Print(event); err != nil {
+			t.Fatalf("Print failed: %v", err)
+		}
+	}
+	if err := printer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return out.String()
+}
+
+// TestConsoleFormats tests the structured console output formats
+func TestConsoleFormats(t *testing.T) {
+	allEvents := ui.Filter{ShowDirs: true, ShowFiles: true}
+	roots := []string{"/watched"}
+
+	// NDJSON: one object per line with root-relative paths
+	output := printAll(t, console.Options{Format: console.FormatNDJSON, Roots: roots, Filter: allEvents})
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 NDJSON lines, got %d: %s", len(lines), output)
+	}
+	var record console.Record
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("Invalid NDJSON line: %v", err)
+	}
+	if record.RelativePath != "build" || record.Root != "/watched" || record.Op != "CREATE" || !record.IsDir {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+
+	// JSON: a single array
+	output = printAll(t, console.Options{Format: console.FormatJSON, Roots: roots, Filter: allEvents})
+	var records []console.Record
+	if err := json.Unmarshal([]byte(output), &records); err != nil {
+		t.Fatalf("Invalid JSON array: %v\n%s", err, output)
+	}
+	if len(records) != 3 || !records[0].Time.Equal(consoleEvents()[0].Timestamp) {
+		t.Errorf("Unexpected JSON records: %+v", records)
+	}
+
+	// Template over the event fields
+	output = printAll(t, console.Options{
+		Format:   console.FormatTemplate,
+		Template: `{{.Op}} {{.RelativePath}} {{if .IsDir}}dir{{else}}file{{end}}`,
+		Roots:    roots,
+		Filter:   allEvents,
+	})
+	if output != "WRITE src/main.go file\nCREATE build dir\nREMOVE src/old.go file\n" {
+		t.Errorf("Unexpected template output: %q", output)
+	}
+
+	// Invalid templates are rejected up front
+	if _, err := console.NewPrinter(&bytes.Buffer{}, console.Options{Format: console.FormatTemplate, Template: "{{.Op"}); err == nil {
+		t.Error("Expected an invalid template to be rejected")
+	}
+	if _, err := console.ParseFormat("xml"); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+// TestConsoleFilters tests that console output honours the TUI filters
+func TestConsoleFilters(t *testing.T) {
+	template := "{{.RelativePath}}"
+	roots := []string{"/watched"}
+
+	// Path filter and no directories
+	output := printAll(t, console.Options{
+		Format:   console.FormatTemplate,
+		Template: template,
+		Roots:    roots,
+		Filter:   ui.Filter{PathFilter: "SRC", ShowFiles: true},
+	})
+	if output != "src/main.go\nsrc/old.go\n" {
+		t.Errorf("Unexpected filtered output: %q", output)
+	}
+
+	// Operation filter
+	operations, err := console.ParseOperations("create, remove")
+	if err != nil {
+		t.Fatalf("Failed to parse operations: %v", err)
+	}
+	output = printAll(t, console.Options{
+		Format:     console.FormatTemplate,
+		Template:   template,
+		Roots:      roots,
+		Filter:     ui.Filter{ShowDirs: true, ShowFiles: true},
+		Operations: operations,
+	})
+	if output != "build\nsrc/old.go\n" {
+		t.Errorf("Unexpected operation-filtered output: %q", output)
+	}
+	if _, err := console.ParseOperations("create,delete"); err == nil {
+		t.Error("Expected an unknown operation to be rejected")
+	}
+}
@@ -0,0 +1,127 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/daemon"
+	"github.com/pbouamriou/watch-fs/internal/server"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+)
+
+// startDaemon runs a daemon watching root on a socket in a temporary
+// directory and returns the socket
+func startDaemon(t *testing.T, root string) (*daemon.Daemon, string) {
+	t.Helper()
+	fileWatcher, err := watcher.NewWithIgnore([]string{root}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	srv := server.New(fileWatcher, ui.Settings{})
+	d := daemon.New(srv, fileWatcher)
+	socket := filepath.Join(t.TempDir(), "d.sock")
+	listener, err := daemon.Listen(socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Watch()
+	go func() { _ = d.Serve(listener) }()
+	t.Cleanup(func() {
+		d.Stop()
+		srv.Close()
+		_ = fileWatcher.Close()
+	})
+	return d, socket
+}
+
+// TestDaemonAttach tests that clients attach with the history, receive
+// the following events and keep the history across reattaches
+func TestDaemonAttach(t *testing.T) {
+	root, other := t.TempDir(), t.TempDir()
+	d, socket := startDaemon(t, root)
+	if _, err := daemon.Listen(socket); err == nil {
+		t.Error("Expected a second daemon on the socket to be refused")
+	}
+	if info, err := os.Stat(socket); err != nil {
+		t.Fatalf("Failed to stat socket: %v", err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the socket to be private, got mode %v", info.Mode().Perm())
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "before.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	client, err := daemon.Dial(socket)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if status, err := client.Status(); err == nil && status.Events > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := client.Attach(); err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+	session := ui.NewUI(client, client.GetRoot())
+	if events := session.GetState().Events; len(events) == 0 || filepath.Base(events[0].Path) != "before.txt" {
+		t.Fatalf("Expected the UI to start with the daemon's history, got %d events", len(events))
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "after.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	select {
+	case event := <-client.Events():
+		if filepath.Base(event.Name) != "after.txt" {
+			t.Errorf("Expected the creation of after.txt, got %v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the attached client to receive the new event")
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Failed to detach: %v", err)
+	}
+	select {
+	case <-client.Disconnected():
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the client to stop relaying once detached")
+	}
+
+	again, err := daemon.Dial(socket)
+	if err != nil {
+		t.Fatalf("Failed to reattach: %v", err)
+	}
+	defer func() { _ = again.Close() }()
+	if err := again.Attach(); err != nil {
+		t.Fatalf("Failed to reattach: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, event := range again.History() {
+		seen[filepath.Base(event.Path)] = true
+	}
+	if !seen["before.txt"] || !seen["after.txt"] {
+		t.Errorf("Expected the history to survive a detach, got %v", seen)
+	}
+
+	if err := again.AddRoot(other); err != nil {
+		t.Fatalf("Failed to add root: %v", err)
+	}
+	if roots := again.GetRoots(); len(roots) != 2 || !again.IsWatching(other) {
+		t.Errorf("Expected the daemon to watch %s too, got %v", other, roots)
+	}
+	if err := again.Stop(); err != nil {
+		t.Fatalf("Failed to stop daemon: %v", err)
+	}
+	select {
+	case <-d.Stopped():
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the daemon to stop")
+	}
+}
@@ -0,0 +1,136 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// TestSessionDiff tests comparing two builds recorded in different roots
+func TestSessionDiff(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Run A writes main.go twice and a temporary file
+	watcherA := NewMockWatcherWithRoots([]string{"/build/a"})
+	defer watcherA.Close()
+	runA := ui.NewUI(watcherA, "/build/a")
+	runA.AddEvent("/build/a/main.go", fsnotify.Write, false)
+	runA.AddEvent("/build/a/main.go", fsnotify.Write, false)
+	runA.AddEvent("/build/a/go.sum", fsnotify.Write, false)
+	runA.AddEvent("/build/a/tmp.o", fsnotify.Create, false)
+	fileA := filepath.Join(tempDir, "a.db")
+	if err := runA.ExportEvents(fileA, ui.FormatSQLite); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	// Run B writes main.go once and a new binary
+	watcherB := NewMockWatcherWithRoots([]string{"/build/b"})
+	defer watcherB.Close()
+	runB := ui.NewUI(watcherB, "/build/b")
+	runB.AddEvent("/build/b/main.go", fsnotify.Write, false)
+	runB.AddEvent("/build/b/go.sum", fsnotify.Write, false)
+	runB.AddEvent("/build/b/app", fsnotify.Create, false)
+	fileB := filepath.Join(tempDir, "b.json")
+	if err := runB.ExportEvents(fileB, ui.FormatJSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	result, err := runA.CompareCaptures(fileA, ui.FormatSQLite, fileB, ui.FormatJSON)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+
+	expected := map[string]ui.DiffKind{
+		"tmp.o":   ui.DiffOnlyA,
+		"app":     ui.DiffOnlyB,
+		"main.go": ui.DiffChanged,
+	}
+	if len(result.Entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %+v", len(expected), result.Entries)
+	}
+	for _, entry := range result.Entries {
+		kind, ok := expected[entry.Path]
+		if !ok || kind != entry.Kind {
+			t.Errorf("Unexpected entry %s (kind %v)", entry.Path, entry.Kind)
+		}
+		if entry.Path == "main.go" && (entry.A.Count != 2 || entry.B.Count != 1) {
+			t.Errorf("Expected main.go counts 2 and 1, got %d and %d", entry.A.Count, entry.B.Count)
+		}
+	}
+	if result.Unchanged != 1 {
+		t.Errorf("Expected go.sum to be unchanged, got %d unchanged paths", result.Unchanged)
+	}
+
+	// The current events are left alone
+	if got := len(runA.GetState().Events); got != 3 {
+		t.Errorf("Expected the 3 live events to be kept, got %d", got)
+	}
+
+	// The result can be exported
+	output := filepath.Join(tempDir, "diff.json")
+	if err := result.WriteFile(output); err != nil {
+		t.Fatalf("Diff export failed: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read diff export: %v", err)
+	}
+	var exported struct {
+		Entries []struct {
+			Path string `json:"path"`
+			Kind string `json:"kind"`
+			A    *struct {
+				Operations string `json:"operations"`
+				Count      int    `json:"count"`
+			} `json:"a"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Failed to parse diff export: %v", err)
+	}
+	if len(exported.Entries) != 3 || exported.Entries[0].Kind != "only_a" || exported.Entries[0].A.Operations != "CREATE" {
+		t.Errorf("Unexpected diff export: %s", data)
+	}
+}
+
+// TestSessionDiffUnderSlash tests comparing captures without roots whose
+// only common directory is /
+func TestSessionDiffUnderSlash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses Unix paths")
+	}
+	tempDir := t.TempDir()
+	capture := func(name string, paths ...string) string {
+		watcher := NewMockWatcherWithRoots(nil)
+		defer watcher.Close()
+		session := ui.NewUI(watcher, "")
+		for _, path := range paths {
+			session.AddEvent(path, fsnotify.Write, false)
+		}
+		filename := filepath.Join(tempDir, name)
+		if err := session.ExportEvents(filename, ui.FormatJSON); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+		return filename
+	}
+	fileA := capture("a.json", "/etc/hosts", "/var/log/syslog")
+	fileB := capture("b.json", "/etc/hosts", "/usr/bin/app")
+
+	session := ui.NewUI(NewMockWatcherWithRoots(nil), "")
+	result, err := session.CompareCaptures(fileA, ui.FormatJSON, fileB, ui.FormatJSON)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	paths := make(map[string]ui.DiffKind)
+	for _, entry := range result.Entries {
+		paths[entry.Path] = entry.Kind
+	}
+	if len(paths) != 2 || paths["var/log/syslog"] != ui.DiffOnlyA || paths["usr/bin/app"] != ui.DiffOnlyB || result.Unchanged != 1 {
+		t.Errorf("Expected paths relative to /, got %+v with %d unchanged", result.Entries, result.Unchanged)
+	}
+}
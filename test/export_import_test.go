@@ -0,0 +1,315 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// TestExportScopes tests that exports honour the selected scope
+func TestExportScopes(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+	uiInstance.AddEvent("/test/a.txt", fsnotify.Write, false)
+	uiInstance.AddEvent("/test/b.txt", fsnotify.Create, false)
+	uiInstance.AddEvent("/test/dir", fsnotify.Create, true)
+
+	// All scope covers every event
+	if got := len(uiInstance.GetScopedEvents()); got != 3 {
+		t.Errorf("Expected 3 events in the all scope, got %d", got)
+	}
+
+	// Filtered scope follows the current filter
+	uiInstance.ToggleDirs()
+	uiInstance.SetExportScope(ui.ScopeFiltered)
+	if got := len(uiInstance.GetScopedEvents()); got != 2 {
+		t.Errorf("Expected 2 events in the filtered scope, got %d", got)
+	}
+
+	// Selected scope only covers marked events
+	uiInstance.SetExportScope(ui.ScopeSelected)
+	if got := len(uiInstance.GetScopedEvents()); got != 0 {
+		t.Errorf("Expected no events in the selected scope, got %d", got)
+	}
+	uiInstance.ToggleEventSelection(uiInstance.GetState().Events[0])
+	uiInstance.ToggleEventSelection(uiInstance.GetState().Events[2])
+	if got := len(uiInstance.GetScopedEvents()); got != 2 {
+		t.Errorf("Expected 2 events in the selected scope, got %d", got)
+	}
+	uiInstance.ToggleEventSelection(uiInstance.GetState().Events[2])
+	if got := len(uiInstance.GetScopedEvents()); got != 1 {
+		t.Errorf("Expected 1 event after unselecting, got %d", got)
+	}
+
+	uiInstance.ClearSelection()
+	if got := len(uiInstance.GetScopedEvents()); got != 0 {
+		t.Errorf("Expected no events after clearing the selection, got %d", got)
+	}
+}
+
+// TestSnapshotIgnoresScope tests that a snapshot writes every event
+func TestSnapshotIgnoresScope(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+	uiInstance.AddEvent("/test/a.txt", fsnotify.Write, false)
+	uiInstance.AddEvent("/test/b.txt", fsnotify.Create, false)
+	uiInstance.SetExportScope(ui.ScopeSelected)
+
+	filename := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := uiInstance.Snapshot(filename, ui.FormatSQLite); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	events, meta, err := uiInstance.LoadCapture(filename, ui.FormatSQLite)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	if len(events) != 2 || meta.Scope != "all" {
+		t.Errorf("Expected 2 events in the all scope, got %d in %q", len(events), meta.Scope)
+	}
+}
+
+// TestSnapshotWhileRecording tests that snapshots taken while events
+// arrive and old ones are pruned hold whole events
+func TestSnapshotWhileRecording(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+	uiInstance.GetState().MaxAge = 50 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		for i := range 5000 {
+			uiInstance.RecordEvent(&ui.FileEvent{
+				Path:      fmt.Sprintf("/test/path/%d.txt", i),
+				Operation: fsnotify.Write,
+				Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+				Count:     1,
+			})
+		}
+	}()
+
+	dir := t.TempDir()
+	for i := 0; ; i++ {
+		filename := filepath.Join(dir, fmt.Sprintf("snapshot-%d.json", i))
+		if err := uiInstance.Snapshot(filename, ui.FormatJSON); err != nil {
+			t.Fatalf("Failed to write snapshot: %v", err)
+		}
+		events, _, err := uiInstance.LoadCapture(filename, ui.FormatJSON)
+		if err != nil {
+			t.Fatalf("Failed to read snapshot: %v", err)
+		}
+		for _, event := range events {
+			if event.Path == "" || event.Count != 1 {
+				t.Fatalf("Expected whole events in the snapshot, got %+v", event)
+			}
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+// TestExportFilteredViewRoundTrip tests that a filtered export records its
+// filter and that importing it rebuilds the same view
+func TestExportFilteredViewRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for _, tc := range []struct {
+		name     string
+		filename string
+		format   ui.ExportFormat
+	}{
+		{"json", "events.json", ui.FormatJSON},
+		{"sqlite", "events.db", ui.FormatSQLite},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockWatcher := NewMockWatcher()
+			defer mockWatcher.Close()
+
+			source := ui.NewUI(mockWatcher, "/test/path")
+			source.AddEvent("/test/keep.txt", fsnotify.Write, false)
+			source.AddEvent("/test/other.txt", fsnotify.Write, false)
+			source.AddEvent("/test/dir", fsnotify.Create, true)
+			source.GetState().Filter.PathFilter = "keep"
+			source.CycleSort() // Sort by path
+			source.SetExportScope(ui.ScopeFiltered)
+
+			filename := filepath.Join(tempDir, tc.filename)
+			if err := source.ExportEvents(filename, tc.format); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+
+			target := ui.NewUI(mockWatcher, "/test/path")
+			if err := target.ImportEvents(filename, tc.format); err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+
+			events := target.GetState().Events
+			if len(events) != 1 || events[0].Path != "/test/keep.txt" {
+				t.Fatalf("Expected only /test/keep.txt to be imported, got %d events", len(events))
+			}
+			if target.GetState().Filter.PathFilter != "keep" {
+				t.Errorf("Expected path filter 'keep' to be restored, got '%s'", target.GetState().Filter.PathFilter)
+			}
+			if target.GetState().SortOption != ui.SortByPath {
+				t.Errorf("Expected sort by path to be restored, got %v", target.GetState().SortOption)
+			}
+		})
+	}
+}
+
+// TestExportJSONMetadata tests the metadata written alongside JSON exports
+func TestExportJSONMetadata(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+	uiInstance.AddEvent("/test/a.txt", fsnotify.Write, false)
+	uiInstance.AddEvent("/test/b.txt", fsnotify.Write, false)
+	uiInstance.ToggleEventSelection(uiInstance.GetState().Events[1])
+	uiInstance.SetExportScope(ui.ScopeSelected)
+
+	filename := filepath.Join(t.TempDir(), "selected.json")
+	if err := uiInstance.ExportEvents(filename, ui.FormatJSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	var exported struct {
+		Events []*ui.FileEvent `json:"events"`
+		Meta   ui.ExportMeta   `json:"meta"`
+	}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+
+	if len(exported.Events) != 1 || exported.Events[0].Path != "/test/b.txt" {
+		t.Errorf("Expected only the selected event to be exported, got %d events", len(exported.Events))
+	}
+	if exported.Meta.Scope != "selected" {
+		t.Errorf("Expected scope 'selected', got '%s'", exported.Meta.Scope)
+	}
+	if exported.Meta.TotalCount != 1 {
+		t.Errorf("Expected total count 1, got %d", exported.Meta.TotalCount)
+	}
+	if exported.Meta.Filter == nil || !exported.Meta.Filter.ShowFiles {
+		t.Error("Expected the filter to be recorded in the metadata")
+	}
+}
+
+// TestCompressedExportRoundTrip tests every format and compression picked by extension
+func TestCompressedExportRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for _, filename := range []string{"events.db", "events.db.gz", "events.db.zst", "events.json", "events.json.gz", "events.json.zst"} {
+		t.Run(filename, func(t *testing.T) {
+			mockWatcher := NewMockWatcher()
+			defer mockWatcher.Close()
+
+			format, _, ok := ui.DetectFormat(filename)
+			if !ok {
+				t.Fatalf("Format of %s was not detected", filename)
+			}
+
+			source := ui.NewUI(mockWatcher, "/test/path")
+			for i := 0; i < 20; i++ {
+				source.AddEvent(fmt.Sprintf("/test/file%d.txt", i), fsnotify.Write, false)
+			}
+
+			path := filepath.Join(tempDir, filename)
+			if err := source.ExportEvents(path, format); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+
+			manifestData, err := os.ReadFile(path + ".manifest.json")
+			if err != nil {
+				t.Fatalf("Manifest was not written: %v", err)
+			}
+			var manifest ui.Manifest
+			if err := json.Unmarshal(manifestData, &manifest); err != nil {
+				t.Fatalf("Failed to parse manifest: %v", err)
+			}
+			if manifest.EventCount != 20 || len(manifest.SHA256) != 64 {
+				t.Errorf("Unexpected manifest: %+v", manifest)
+			}
+
+			target := ui.NewUI(mockWatcher, "/test/path")
+			if err := target.ImportEvents(path, format); err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			if got := len(target.GetState().Events); got != 20 {
+				t.Errorf("Expected 20 imported events, got %d", got)
+			}
+		})
+	}
+}
+
+// TestTruncatedExportDetection tests that damaged exports are rejected and
+// can still be partially imported
+func TestTruncatedExportDetection(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	source := ui.NewUI(mockWatcher, "/test/path")
+	for i := 0; i < 500; i++ {
+		source.AddEvent(fmt.Sprintf("/test/some/longer/path/file%d.txt", i), fsnotify.Write, false)
+	}
+
+	path := filepath.Join(t.TempDir(), "events.json.gz")
+	if err := source.ExportEvents(path, ui.FormatJSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	// Simulate a transfer that stopped half way
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("Failed to truncate export: %v", err)
+	}
+
+	target := ui.NewUI(mockWatcher, "/test/path")
+	err = target.ImportEvents(path, ui.FormatJSON)
+	if !errors.Is(err, ui.ErrManifestMismatch) {
+		t.Fatalf("Expected a manifest mismatch, got %v", err)
+	}
+	if len(target.GetState().Events) != 0 {
+		t.Error("Events should not be replaced when the manifest does not match")
+	}
+
+	if err := target.ImportEventsPartial(path, ui.FormatJSON); err != nil {
+		t.Fatalf("Partial import failed: %v", err)
+	}
+	got := len(target.GetState().Events)
+	if got == 0 || got >= 500 {
+		t.Errorf("Expected a partial set of events, got %d", got)
+	}
+
+	// Without a manifest, the damage is still reported as a corrupt export
+	if err := os.Remove(path + ".manifest.json"); err != nil {
+		t.Fatalf("Failed to remove manifest: %v", err)
+	}
+	err = target.ImportEvents(path, ui.FormatJSON)
+	if !errors.Is(err, ui.ErrCorruptExport) {
+		t.Errorf("Expected a corrupt export error, got %v", err)
+	}
+}
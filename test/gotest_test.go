@@ -0,0 +1,127 @@
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pbouamriou/watch-fs/internal/gotest"
+)
+
+// writeModule writes a small module: b imports a, c's tests import b, d
+// stands alone
+func writeModule(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.21\n",
+		"a/a.go":      "package a\n\nfunc A() int { return 1 }\n",
+		"b/b.go":      "package b\n\nimport \"example.com/m/a\"\n\nfunc B() int { return a.A() }\n",
+		"c/c.go":      "package c\n",
+		"c/c_test.go": "package c\n\nimport (\n\t\"testing\"\n\n\t\"example.com/m/b\"\n)\n\nfunc TestC(t *testing.T) { _ = b.B() }\n",
+		"d/d.go":      "package d\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestGoTestAffected tests mapping changed files to packages and their
+// reverse dependencies
+func TestGoTestAffected(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("The go command is not available")
+	}
+	dir := writeModule(t)
+
+	moduleDir, err := gotest.FindModule(filepath.Join(dir, "b"))
+	if err != nil || moduleDir != dir {
+		t.Fatalf("Expected module %s, got %s (%v)", dir, moduleDir, err)
+	}
+	graph, err := gotest.LoadGraph(dir)
+	if err != nil {
+		t.Fatalf("Failed to load graph: %v", err)
+	}
+
+	// a is imported by b, whose importers include c's tests
+	packages, stale := graph.Affected([]string{filepath.Join(dir, "a", "a.go"), filepath.Join(dir, "README.md")})
+	expected := []string{"example.com/m/a", "example.com/m/b", "example.com/m/c"}
+	if stale || !reflect.DeepEqual(packages, expected) {
+		t.Errorf("Expected %v, got %v (stale %v)", expected, packages, stale)
+	}
+
+	// go.mod affects everything
+	packages, _ = graph.Affected([]string{filepath.Join(dir, "go.mod")})
+	if len(packages) != 4 {
+		t.Errorf("Expected every package for go.mod, got %v", packages)
+	}
+
+	// A new file makes the graph stale
+	newFile := filepath.Join(dir, "d", "e.go")
+	if err := os.WriteFile(newFile, []byte("package d\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if packages, stale = graph.Affected([]string{newFile}); !stale || !reflect.DeepEqual(packages, []string{"example.com/m/d"}) {
+		t.Errorf("Expected d and a stale graph, got %v (stale %v)", packages, stale)
+	}
+}
+
+// TestGoTestResults tests following `go test -json` output per package
+func TestGoTestResults(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("The go command is not available")
+	}
+	session, err := gotest.NewSession(writeModule(t), []string{"-count=1"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	var finished []string
+	session.OnResult(func(result gotest.Result) {
+		finished = append(finished, result.Package)
+	})
+
+	argv := session.TestCommand([]string{"example.com/m/b", "example.com/m/c"})
+	if strings.Join(argv, " ") != "go test -json -count=1 example.com/m/b example.com/m/c" {
+		t.Errorf("Unexpected command line: %q", argv)
+	}
+
+	// Output arrives in arbitrary chunks
+	output := `{"Action":"start","Package":"example.com/m/c"}
+{"Action":"run","Package":"example.com/m/c","Test":"TestC"}
+{"Action":"output","Package":"example.com/m/c","Test":"TestC","Output":"    c_test.go:9: boom\n"}
+{"Action":"fail","Package":"example.com/m/c","Test":"TestC","Elapsed":0}
+{"Action":"fail","Package":"example.com/m/c","Elapsed":0.25}
+{"Action":"skip","Package":"example.com/m/b","Elapsed":0}
+`
+	for len(output) > 0 {
+		n := min(7, len(output))
+		if _, err := session.Write([]byte(output[:n])); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		output = output[n:]
+	}
+
+	if !reflect.DeepEqual(finished, []string{"example.com/m/c", "example.com/m/b"}) {
+		t.Errorf("Unexpected finished packages: %v", finished)
+	}
+	results := session.Results()
+	if len(results) != 2 || results[0].Package != "example.com/m/c" || results[0].State != gotest.StateFailed {
+		t.Fatalf("Expected the failure first, got %+v", results)
+	}
+	if results[0].Output[0] != "    c_test.go:9: boom" || results[0].Elapsed.Seconds() != 0.25 {
+		t.Errorf("Unexpected failure details: %+v", results[0])
+	}
+	if results[1].State != gotest.StateSkipped {
+		t.Errorf("Expected b without tests to be skipped, got %+v", results[1])
+	}
+}
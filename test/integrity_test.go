@@ -0,0 +1,218 @@
+package test
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/integrity"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// newBaseline records a baseline of a tree with a kept, a changed and a
+// removed file, and an ignored directory
+func newBaseline(t *testing.T) (root, filename, keyPath string) {
+	t.Helper()
+	root = t.TempDir()
+	for name, content := range map[string]string{
+		"kept.txt":       "kept",
+		"changed.txt":    "before",
+		"removed.txt":    "removed",
+		"cache/data.bin": "cache",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	dir := t.TempDir()
+	filename = filepath.Join(dir, "watch-fs.baseline")
+	keyPath = filepath.Join(dir, "keys", "baseline.key")
+	key, err := integrity.LoadKey(keyPath, true)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("Expected a key readable only by its owner, got %v, %v", info.Mode(), err)
+		}
+	}
+	baseline, err := integrity.Create(filename, []string{root}, ignoreCache(root), key)
+	if err != nil {
+		t.Fatalf("Failed to create baseline: %v", err)
+	}
+	if baseline.Len() != 4 {
+		t.Errorf("Expected the root and its files but the ignored directory, got %d", baseline.Len())
+	}
+	if err := baseline.Close(); err != nil {
+		t.Fatalf("Failed to close baseline: %v", err)
+	}
+	return root, filename, keyPath
+}
+
+// ignoreCache ignores the cache directory of a root
+func ignoreCache(root string) func(string) bool {
+	return func(path string) bool {
+		return strings.HasPrefix(path, filepath.Join(root, "cache"))
+	}
+}
+
+// openBaseline opens a baseline with the key of keyPath
+func openBaseline(t *testing.T, root, filename, keyPath string) *integrity.Baseline {
+	t.Helper()
+	key, err := integrity.LoadKey(keyPath, false)
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+	baseline, err := integrity.Open(filename, ignoreCache(root), key)
+	if err != nil {
+		t.Fatalf("Failed to open baseline: %v", err)
+	}
+	t.Cleanup(func() { _ = baseline.Close() })
+	return baseline
+}
+
+// TestIntegrityVerify tests the checks of single events, a full
+// verification, and accepting changes
+func TestIntegrityVerify(t *testing.T) {
+	root, filename, keyPath := newBaseline(t)
+	changed := filepath.Join(root, "changed.txt")
+	if err := os.WriteFile(changed, []byte("after!"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(root, "removed.txt")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "added.txt"), nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "cache", "data.bin"), []byte("ignored"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	baseline := openBaseline(t, root, filename, keyPath)
+	if violation := baseline.Check(filepath.Join(root, "kept.txt")); violation != nil {
+		t.Errorf("Expected an unchanged file to match, got %v", violation)
+	}
+	if violation := baseline.Check(filepath.Join(root, "cache", "data.bin")); violation != nil {
+		t.Errorf("Expected an ignored file not to be checked, got %v", violation)
+	}
+	violation := baseline.Check(changed)
+	if violation == nil || violation.String() != "changed: content" {
+		t.Errorf("Expected a content change, got %v", violation)
+	}
+
+	violations, err := baseline.Verify()
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	var got []string
+	for _, v := range violations {
+		got = append(got, filepath.Base(v.Path)+" "+v.Kind.String())
+	}
+	if strings.Join(got, ", ") != "added.txt added, changed.txt changed, removed.txt removed" {
+		t.Errorf("Expected the added, changed and removed files, got %v", got)
+	}
+
+	if err := baseline.Accept(violations[0].Path, violations[1].Path, violations[2].Path); err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	if violations, err := baseline.Verify(); err != nil || len(violations) != 0 {
+		t.Errorf("Expected no violation once accepted, got %v, %v", violations, err)
+	}
+	// The accepted changes are signed again
+	if reopened := openBaseline(t, root, filename, keyPath); reopened.Len() != 4 {
+		t.Errorf("Expected the accepted baseline to open, got %d paths", reopened.Len())
+	}
+}
+
+// TestIntegrityMode tests the mode changes of events
+func TestIntegrityMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses POSIX permissions")
+	}
+	root, filename, keyPath := newBaseline(t)
+	kept := filepath.Join(root, "kept.txt")
+	if err := os.Chmod(kept, 0o666); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+	baseline := openBaseline(t, root, filename, keyPath)
+	violation := baseline.Check(kept)
+	if violation == nil || violation.String() != "changed: mode -rw-r--r-- -> -rw-rw-rw-" {
+		t.Errorf("Expected a mode change, got %v", violation)
+	}
+}
+
+// TestIntegrityTampering tests that edited baselines and other keys are
+// refused
+func TestIntegrityTampering(t *testing.T) {
+	root, filename, keyPath := newBaseline(t)
+
+	other, err := integrity.LoadKey(filepath.Join(t.TempDir(), "other.key"), true)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if _, err := integrity.Open(filename, nil, other); err == nil || !strings.Contains(err.Error(), "another key") {
+		t.Errorf("Expected another key to be refused, got %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE entries SET hash = 'forged' WHERE path = ?`, filepath.Join(root, "kept.txt")); err != nil {
+		t.Fatalf("Failed to tamper: %v", err)
+	}
+	_ = db.Close()
+
+	key, err := integrity.LoadKey(keyPath, false)
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+	if _, err := integrity.Open(filename, nil, key); !errors.Is(err, integrity.ErrTampered) {
+		t.Errorf("Expected tampering to be detected, got %v", err)
+	}
+}
+
+// TestIntegrityAcceptFromTUI tests accepting a violation from the TUI
+func TestIntegrityAcceptFromTUI(t *testing.T) {
+	root, filename, keyPath := newBaseline(t)
+	baseline := openBaseline(t, root, filename, keyPath)
+	changed := filepath.Join(root, "changed.txt")
+	if err := os.WriteFile(changed, []byte("after"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+	tui := ui.NewUI(mockWatcher, root)
+	if err := tui.AcceptViolation(changed); err == nil {
+		t.Error("Expected accepting without a baseline to fail")
+	}
+	tui.SetBaselineAccept(func(path string) error { return baseline.Accept(path) })
+	tui.OnEvent(func(event *ui.FileEvent) {
+		if violation := baseline.Check(event.Path); violation != nil {
+			event.Violation = violation.String()
+		}
+	})
+	tui.AddEvent(changed, fsnotify.Write, false)
+	events := tui.GetState().Events
+	if len(events) != 1 || events[0].Violation == "" {
+		t.Fatalf("Expected the event to break the baseline, got %+v", events)
+	}
+
+	if err := tui.AcceptViolation(changed); err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	if events[0].Violation != "" || baseline.Check(changed) != nil {
+		t.Errorf("Expected the violation to be cleared and accepted, got %q", events[0].Violation)
+	}
+}
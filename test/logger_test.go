@@ -0,0 +1,97 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+	"github.com/pbouamriou/watch-fs/pkg/logger"
+)
+
+// TestLoggerRotation tests that log files are rotated by size
+func TestLoggerRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "watch-fs.log")
+	if err := logger.Setup(logger.Options{Level: "debug", File: path, MaxSize: 300, MaxBackups: 2}); err != nil {
+		t.Fatalf("Failed to set up logger: %v", err)
+	}
+	defer func() { _ = logger.Setup(logger.Options{File: "off"}) }()
+
+	for i := 0; i < 50; i++ {
+		logger.Debug(strings.Repeat("x", 40))
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("Expected %s to stay under 300 bytes, got %d", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected at most 2 backups")
+	}
+
+	if err := logger.Setup(logger.Options{Level: "loud"}); err == nil {
+		t.Error("Expected an unknown level to fail")
+	}
+}
+
+// TestAuditStream tests that every received event is recorded, ignored
+// ones included, and that events keep flowing after a root is removed
+func TestAuditStream(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	if err := logger.SetupAudit(auditPath, 0, 0); err != nil {
+		t.Fatalf("Failed to set up audit stream: %v", err)
+	}
+	defer func() { _ = logger.SetupAudit("", 0, 0) }()
+
+	fileWatcher, err := watcher.NewMultiRoot([]string{first, second})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer func() { _ = fileWatcher.Close() }()
+	if err := fileWatcher.RemoveRoot(first); err != nil {
+		t.Fatalf("Failed to remove root: %v", err)
+	}
+
+	path := filepath.Join(second, "a.txt")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	select {
+	case event := <-fileWatcher.Events():
+		if event.Name != path {
+			t.Errorf("Expected an event on %s, got %s", path, event.Name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected events after removing a root")
+	}
+
+	file, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("Expected an audit record")
+	}
+	var record struct {
+		Path string `json:"path"`
+		Op   string `json:"op"`
+		Root string `json:"root"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+		t.Fatalf("Invalid audit record: %v", err)
+	}
+	if record.Path != path || record.Op != "CREATE" || record.Root != second {
+		t.Errorf("Unexpected audit record %+v", record)
+	}
+}
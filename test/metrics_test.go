@@ -0,0 +1,83 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/metrics"
+)
+
+// scrape returns the metrics served at url, asking for OpenMetrics or not
+func scrape(t *testing.T, url string, openMetrics bool) (string, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	if openMetrics {
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	return string(body), resp.Header.Get("Content-Type")
+}
+
+// TestMetricsEndpoint tests the counters and gauges served on /metrics
+func TestMetricsEndpoint(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	_, httpServer := startServer(t, root)
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	metrics.Dropped("test", 2)
+
+	created := fmt.Sprintf(`watch_fs_events_total{root=%q,op="create"} `, root)
+	var body, contentType string
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		body, contentType = scrape(t, httpServer.URL+"/metrics", false)
+		if strings.Contains(body, created) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the Prometheus text format, got %q", contentType)
+	}
+	for _, want := range []string{
+		created,
+		"# TYPE watch_fs_events_total counter",
+		fmt.Sprintf(`watch_fs_watched_directories{root=%q} 2`, root),
+		`watch_fs_dropped_events_total{reason="test"} 2`,
+		`watch_fs_event_processing_seconds_bucket{le="+Inf"}`,
+		"watch_fs_errors_total 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	body, contentType = scrape(t, httpServer.URL+"/metrics", true)
+	if !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("Expected OpenMetrics, got %q", contentType)
+	}
+	if !strings.Contains(body, "# TYPE watch_fs_events counter") || !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("Expected OpenMetrics family names and # EOF, got:\n%s", body)
+	}
+}
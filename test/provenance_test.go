@@ -0,0 +1,118 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/provenance"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+)
+
+// TestProvenanceNet tests netting recorded events into per-path changes
+func TestProvenanceNet(t *testing.T) {
+	root := t.TempDir()
+	path := func(name string) string { return filepath.Join(root, name) }
+
+	// The tree once the command is done
+	for _, name := range []string{"app", "lib.so", "main.go", "config.yml"} {
+		if err := os.WriteFile(path(name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The tree before the command ran; lib.so is replaced, not created
+	existedBefore := map[string]bool{
+		path("lib.so"): true, path("main.go"): true, path("config.yml"): true, path("old.o"): true,
+	}
+
+	at := time.Now()
+	events := []*ui.FileEvent{
+		{Path: path("app.tmp"), Operation: fsnotify.Create, Timestamp: at, Count: 1},
+		{Path: path("app.tmp"), Operation: fsnotify.Write, Timestamp: at, Count: 3},
+		{Path: path("app.tmp"), Operation: fsnotify.Rename, Timestamp: at, Count: 1},
+		{Path: path("app"), Operation: fsnotify.Create, Timestamp: at, Count: 1},
+		{Path: path("lib.so"), Operation: fsnotify.Create, Timestamp: at, Count: 1},
+		{Path: path("main.go"), Operation: fsnotify.Write, Timestamp: at, Count: 2},
+		{Path: path("config.yml"), Operation: fsnotify.Chmod, Timestamp: at, Count: 1},
+		{Path: path("old.o"), Operation: fsnotify.Remove, Timestamp: at, Count: 1},
+	}
+	changes, temporary := provenance.Net(events, []string{root}, existedBefore, os.Lstat)
+
+	if temporary != 1 {
+		t.Errorf("Expected app.tmp to be temporary, got %d temporary paths", temporary)
+	}
+	expected := map[string]provenance.Kind{
+		"app":     provenance.Created,
+		"lib.so":  provenance.Modified,
+		"main.go": provenance.Modified,
+		"old.o":   provenance.Deleted,
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %+v", len(expected), changes)
+	}
+	for _, change := range changes {
+		if kind, ok := expected[change.RelativePath]; !ok || kind != change.Kind {
+			t.Errorf("Unexpected change %s (kind %v)", change.RelativePath, change.Kind)
+		}
+	}
+
+	// The JSON report names kinds and operations
+	report := &provenance.Report{Command: []string{"make"}, Changes: changes, Temporary: temporary}
+	var out bytes.Buffer
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded struct {
+		Changes []struct {
+			RelativePath string `json:"relative_path"`
+			Kind         string `json:"kind"`
+			Operations   string `json:"operations"`
+			Events       int    `json:"events"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON report: %v", err)
+	}
+	if len(decoded.Changes) != 4 || decoded.Changes[2].Kind != "modified" || decoded.Changes[2].Events != 2 || decoded.Changes[2].Operations != "WRITE" {
+		t.Errorf("Unexpected JSON report: %s", out.String())
+	}
+
+	// Exports get one event per change
+	exported := report.Events()
+	if len(exported) != 4 || exported[0].Operation != fsnotify.Create || exported[1].Operation != fsnotify.Write ||
+		exported[3].Operation != fsnotify.Remove {
+		t.Errorf("Unexpected export events: %+v", exported)
+	}
+}
+
+// TestProvenanceRunSignal tests the exit code reported for a command killed
+// by a signal
+func TestProvenanceRunSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses POSIX signals")
+	}
+	root := t.TempDir()
+	fileWatcher, err := watcher.NewWithIgnore([]string{root}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer func() { _ = fileWatcher.Close() }()
+	session := ui.NewUI(fileWatcher, root)
+
+	report, err := provenance.Run(fileWatcher, session, exec.Command("sh", "-c", "kill -KILL $$"),
+		provenance.Options{Quiet: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.ExitCode != 128+9 || report.Signal != "killed" {
+		t.Errorf("Expected exit code 137 for SIGKILL, got %d (%q)", report.ExitCode, report.Signal)
+	}
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/query"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// TestQueryWhere tests parsing query conditions
+func TestQueryWhere(t *testing.T) {
+	q := query.New()
+	if err := q.Where("path~internal/ and op=write, count>10"); err != nil {
+		t.Fatalf("Failed to parse conditions: %v", err)
+	}
+	if err := q.Where("count <= 20 and type=file"); err != nil {
+		t.Fatalf("Failed to parse conditions: %v", err)
+	}
+	if q.Filter.PathFilter != "internal/" || q.Filter.OperationFilter != fsnotify.Write {
+		t.Errorf("Unexpected filter %+v", q.Filter)
+	}
+	if q.Filter.ShowDirs || !q.Filter.ShowFiles {
+		t.Errorf("Expected files only, got %+v", q.Filter)
+	}
+	if q.MinCount != 11 || q.MaxCount != 20 {
+		t.Errorf("Expected counts 11 to 20, got %d to %d", q.MinCount, q.MaxCount)
+	}
+
+	for _, invalid := range []string{"size>3", "count>many", "op=touch", "type=link", "path"} {
+		if err := query.New().Where(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// TestQueryRun tests selecting and grouping the events of a capture
+func TestQueryRun(t *testing.T) {
+	root := filepath.Join("/", "project")
+	mockWatcher := NewMockWatcherWithRoots([]string{root})
+	defer mockWatcher.Close()
+	session := ui.NewUI(mockWatcher, root)
+
+	for range 12 {
+		session.AddEvent(filepath.Join(root, "internal", "ui", "ui.go"), fsnotify.Write, false)
+	}
+	for range 3 {
+		session.AddEvent(filepath.Join(root, "internal", "ui", "types.go"), fsnotify.Write, false)
+	}
+	session.AddEvent(filepath.Join(root, "internal", "ui"), fsnotify.Write, true)
+	session.AddEvent(filepath.Join(root, "cmd", "main.go"), fsnotify.Write, false)
+	session.AddEvent(filepath.Join(root, "README.md"), fsnotify.Create, false)
+
+	session.GetState().SortOption = ui.SortByCount
+
+	q := query.New()
+	if err := q.Where("path~INTERNAL/ and op=write and count>10"); err != nil {
+		t.Fatalf("Failed to parse conditions: %v", err)
+	}
+	matching := q.Run(session)
+	if len(matching) != 1 || matching[0].Path != filepath.Join(root, "internal", "ui", "ui.go") || matching[0].Count != 12 {
+		t.Fatalf("Expected ui.go written 12 times, got %v", matching)
+	}
+
+	q = query.New()
+	q.Until = time.Now().Add(-time.Hour)
+	if matching := q.Run(session); len(matching) != 0 {
+		t.Errorf("Expected no event before an hour ago, got %d", len(matching))
+	}
+
+	all := query.New().Run(session)
+	groups := query.GroupEvents(all, query.GroupDir, []string{root})
+	if len(groups) != 4 || groups[0].Key != "internal/ui" || groups[0].Events != 15 || groups[0].Paths != 2 {
+		t.Errorf("Unexpected directory groups %+v", groups)
+	}
+	groups = query.GroupEvents(all, query.GroupExt, []string{root})
+	expected := map[string]int{".go": 16, ".md": 1, "(dir)": 1}
+	if len(groups) != len(expected) {
+		t.Fatalf("Unexpected extension groups %+v", groups)
+	}
+	for _, group := range groups {
+		if expected[group.Key] != group.Events {
+			t.Errorf("Expected %d events for %s, got %d", expected[group.Key], group.Key, group.Events)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package test
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/rules"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// newDetector compiles a ransomware configuration and waits for it to learn
+// the roots
+func newDetector(t *testing.T, data string, roots []string) (*rules.Engine, <-chan ui.Detection, <-chan ui.RuleFiring) {
+	t.Helper()
+	engine, firings := newRules(t, data, roots)
+	detections := make(chan ui.Detection, 10)
+	engine.OnDetection(func(detection ui.Detection) { detections <- detection })
+	engine.Learned()
+	return engine, detections, firings
+}
+
+// detected returns the detection an event just raised; detections happen
+// before Handle returns
+func detected(t *testing.T, detections <-chan ui.Detection) ui.Detection {
+	t.Helper()
+	select {
+	case detection := <-detections:
+		return detection
+	default:
+		t.Fatal("Expected a detection")
+		return ui.Detection{}
+	}
+}
+
+// TestRansomwareRenames tests renames to unfamiliar extensions, the
+// evidence of a detection and the cooldown
+func TestRansomwareRenames(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "report.docx"), []byte("report"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	engine, detections, _ := newDetector(t, `
+ransomware:
+  renames: 3
+  writes: -1
+  removes: -1
+`, []string{root})
+	if engine.Len() != 1 {
+		t.Errorf("Expected the detector to count as a rule, got %d", engine.Len())
+	}
+
+	now := time.Now()
+	rename := func(from, to string) {
+		engine.Handle(&ui.FileEvent{Path: filepath.Join(root, from), Operation: fsnotify.Rename, Timestamp: now})
+		engine.Handle(&ui.FileEvent{Path: filepath.Join(root, to), Operation: fsnotify.Create, Timestamp: now})
+		now = now.Add(100 * time.Millisecond)
+	}
+	// Known extensions, learned from the roots or from creates, do not count
+	rename("a.tmp", "a.docx")
+	engine.Handle(&ui.FileEvent{Path: filepath.Join(root, "new.txt"), Operation: fsnotify.Create, Timestamp: now.Add(5 * time.Second)})
+	now = now.Add(6 * time.Second)
+	rename("b.tmp", "b.txt")
+	for i := range 3 {
+		rename(fmt.Sprintf("%d.docx", i), fmt.Sprintf("%d.docx.locked", i))
+	}
+
+	detection := detected(t, detections)
+	if len(detection.Signals) != 1 || !strings.Contains(detection.Signals[0], "3 renames to unfamiliar extensions") {
+		t.Errorf("Expected the rename signal, got %v", detection.Signals)
+	}
+	if len(detection.Extensions) != 1 || detection.Extensions[0] != ".locked" {
+		t.Errorf("Expected the unfamiliar extension, got %v", detection.Extensions)
+	}
+	if len(detection.Paths) != 3 || detection.Paths[0] != filepath.Join(root, "2.docx.locked") {
+		t.Errorf("Expected the renamed paths, most recent first, got %v", detection.Paths)
+	}
+
+	rename("3.docx", "3.docx.locked")
+	select {
+	case detection := <-detections:
+		t.Errorf("Expected no detection during the cooldown, got %v", detection.Signals)
+	default:
+	}
+}
+
+// TestRansomwareRemovesAndWrites tests the removal and written files
+// signals, and the containment command
+func TestRansomwareRemovesAndWrites(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses a POSIX shell")
+	}
+	root := t.TempDir()
+	out := filepath.Join(t.TempDir(), "contained.txt")
+	engine, detections, firings := newDetector(t, `
+ransomware:
+  sensitivity: high
+  window: 1s
+  renames: -1
+  removes: 5
+  cooldown: 1ms
+  containment: [sh, -c, 'echo "{{.Reason}}" > `+out+`']
+`, []string{root})
+
+	now := time.Now()
+	// Spread over more than the window, removals never add up
+	for i := range 8 {
+		engine.Handle(&ui.FileEvent{Path: filepath.Join(root, fmt.Sprint(i)), Operation: fsnotify.Remove, Timestamp: now})
+		now = now.Add(300 * time.Millisecond)
+	}
+	// Writing the same file does not count as many files
+	for range 200 {
+		engine.Handle(&ui.FileEvent{Path: filepath.Join(root, "same"), Operation: fsnotify.Write, Timestamp: now})
+	}
+	select {
+	case detection := <-detections:
+		t.Fatalf("Expected no detection, got %v", detection.Signals)
+	default:
+	}
+
+	now = now.Add(time.Second)
+	for i := range 100 {
+		engine.Handle(&ui.FileEvent{Path: filepath.Join(root, fmt.Sprint(i)), Operation: fsnotify.Write, Timestamp: now})
+	}
+	detection := detected(t, detections)
+	if len(detection.Signals) != 1 || !strings.Contains(detection.Signals[0], "100 files written in 1s") {
+		t.Errorf("Expected the written files signal of high sensitivity, got %v", detection.Signals)
+	}
+	if !strings.HasPrefix(detection.Containment, "sh -c") {
+		t.Errorf("Expected the containment command, got %q", detection.Containment)
+	}
+	firing := nextFiring(t, firings)
+	if firing.Rule != rules.RansomwareRule || firing.Err != nil {
+		t.Errorf("Expected the containment to run, got %+v", firing)
+	}
+	if data, err := os.ReadFile(out); err != nil || !strings.Contains(string(data), "100 files written") {
+		t.Errorf("Expected the containment to get the reason, got %q, %v", data, err)
+	}
+
+	// Once the writes are out of the window
+	now = now.Add(2 * time.Second)
+	for i := range 5 {
+		engine.Handle(&ui.FileEvent{Path: filepath.Join(root, fmt.Sprint(i)), Operation: fsnotify.Remove, Timestamp: now})
+		now = now.Add(2 * time.Millisecond)
+	}
+	if detection := detected(t, detections); len(detection.Signals) != 1 || !strings.Contains(detection.Signals[0], "5 removals in 1s") {
+		t.Errorf("Expected the removal signal, got %v", detection.Signals)
+	}
+}
+
+// TestRansomwareEntropy tests the jump in entropy of rewritten files
+func TestRansomwareEntropy(t *testing.T) {
+	root := t.TempDir()
+	plain := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 200))
+	for i := range 3 {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("%d.txt", i)), plain, 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	engine, detections, _ := newDetector(t, `
+ransomware:
+  entropy: true
+  entropy_files: 2
+  writes: -1
+`, []string{root})
+
+	now := time.Now()
+	random := make([]byte, 8192)
+	for i := range 3 {
+		path := filepath.Join(root, fmt.Sprintf("%d.txt", i))
+		// A plain rewrite is no jump
+		if i == 0 {
+			engine.Handle(&ui.FileEvent{Path: path, Operation: fsnotify.Write, Timestamp: now})
+		}
+		_, _ = rand.Read(random)
+		if err := os.WriteFile(path, random, 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		engine.Handle(&ui.FileEvent{Path: path, Operation: fsnotify.Write, Timestamp: now})
+		if i == 0 {
+			select {
+			case detection := <-detections:
+				t.Fatalf("Expected no detection after one jump, got %v", detection.Signals)
+			default:
+			}
+		}
+	}
+	if detection := detected(t, detections); len(detection.Signals) != 1 || !strings.Contains(detection.Signals[0], "2 files rewritten with high entropy") {
+		t.Errorf("Expected the entropy signal, got %v", detection.Signals)
+	}
+}
+
+// TestRansomwareDetectionPopup tests the TUI popup showing a detection
+func TestRansomwareDetectionPopup(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+	tui := ui.NewUI(mockWatcher, "/test/path")
+
+	tui.ShowDetection(ui.Detection{Time: time.Now(), Signals: []string{"25 removals in 10s"}, Paths: []string{"/test/path/a"}})
+	state := tui.GetState()
+	if !state.ShowDetection || state.CurrentFocus != ui.FocusDetection {
+		t.Fatal("Expected the detection popup to open and take the focus")
+	}
+	if state.Detection.Detection.Signals[0] != "25 removals in 10s" || !strings.Contains(state.StatusMessage, "Ransomware-like") {
+		t.Errorf("Expected the evidence and a status message, got %+v, %q", state.Detection, state.StatusMessage)
+	}
+}
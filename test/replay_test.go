@@ -0,0 +1,188 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// recordSession exports a few events spread over time and returns the file
+func recordSession(t *testing.T) string {
+	t.Helper()
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	source := ui.NewUI(mockWatcher, "/test/path")
+	source.AddEvent("/test/a.txt", fsnotify.Create, false)
+	source.AddEvent("/test/a.txt", fsnotify.Write, false)
+	source.AddEvent("/test/a.txt", fsnotify.Write, false) // Aggregated with the previous one
+	source.AddEvent("/test/b.txt", fsnotify.Remove, false)
+
+	// Spread the events so that the replay has gaps to wait for
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i, event := range source.GetState().Events {
+		event.Timestamp = base.Add(time.Duration(i) * 10 * time.Millisecond)
+	}
+
+	filename := filepath.Join(t.TempDir(), "session.json")
+	if err := source.ExportEvents(filename, ui.FormatJSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	return filename
+}
+
+// TestReplayRebuildsSession tests that a replay feeds every recorded event
+// through the pipeline with its original time
+func TestReplayRebuildsSession(t *testing.T) {
+	filename := recordSession(t)
+
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+	uiInstance.AddEvent("/test/live.txt", fsnotify.Write, false)
+
+	var seen int
+	uiInstance.OnEvent(func(event *ui.FileEvent) { seen++ })
+
+	if err := uiInstance.StartReplay(filename, ui.FormatJSON, 0, false); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	select {
+	case <-uiInstance.ReplayDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replay did not finish")
+	}
+
+	if seen != 4 {
+		t.Errorf("Expected 4 replayed events, got %d", seen)
+	}
+	events := uiInstance.GetState().Events
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events after aggregation, got %d", len(events))
+	}
+	if events[1].Path != "/test/a.txt" || events[1].Operation != fsnotify.Write || events[1].Count != 2 {
+		t.Errorf("Expected the aggregated write to be rebuilt, got %s %v x%d", events[1].Path, events[1].Operation, events[1].Count)
+	}
+	if !events[0].Timestamp.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the recorded timestamp, got %v", events[0].Timestamp)
+	}
+
+	state := uiInstance.GetState().Replay
+	if state.Active || state.Position != 4 || state.Total != 4 {
+		t.Errorf("Unexpected replay state: %+v", state)
+	}
+}
+
+// TestReplayPauseAndStep tests stepping through a paused replay
+func TestReplayPauseAndStep(t *testing.T) {
+	filename := recordSession(t)
+
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+
+	if err := uiInstance.StartReplay(filename, ui.FormatJSON, 1, true); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	defer uiInstance.StopReplay()
+
+	if got := len(uiInstance.GetState().Events); got != 0 {
+		t.Fatalf("A paused replay should not emit events, got %d", got)
+	}
+
+	uiInstance.StepReplay()
+	uiInstance.StepReplay()
+	uiInstance.PauseReplay() // Synchronises with the replay goroutine
+	if got := uiInstance.GetState().Replay.Position; got != 2 {
+		t.Errorf("Expected 2 events after two steps, got %d", got)
+	}
+
+	// Resume at a high speed and let the replay finish
+	uiInstance.SetReplaySpeed(100)
+	uiInstance.ResumeReplay()
+	select {
+	case <-uiInstance.ReplayDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replay did not finish")
+	}
+	if got := uiInstance.GetState().Replay.Position; got != 4 {
+		t.Errorf("Expected every event to be replayed, got %d", got)
+	}
+}
+
+// TestReplayStop tests that stopping a replay leaves the remaining events out
+func TestReplayStop(t *testing.T) {
+	filename := recordSession(t)
+
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+
+	if err := uiInstance.StartReplay(filename, ui.FormatJSON, 1, true); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	uiInstance.StepReplay()
+	uiInstance.StopReplay()
+
+	if uiInstance.GetState().Replay.Active {
+		t.Error("Replay should be inactive once stopped")
+	}
+	if got := len(uiInstance.GetState().Events); got != 1 {
+		t.Errorf("Expected 1 replayed event, got %d", got)
+	}
+}
+
+// ignoringWatcher is a mock watcher ignoring the paths containing a text
+type ignoringWatcher struct {
+	*MockWatcher
+	text string
+}
+
+func (w ignoringWatcher) Ignored(path string) bool {
+	return strings.Contains(path, w.text)
+}
+
+// TestReplayKeepsEventsAndIgnores tests that replayed events keep every
+// recorded field and that ignored paths are left out
+func TestReplayKeepsEventsAndIgnores(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+	source := ui.NewUI(mockWatcher, "/test/path")
+	source.RecordEvent(&ui.FileEvent{
+		Path:      "/test/tool",
+		Operation: fsnotify.Chmod,
+		Timestamp: time.Now(),
+		Count:     1,
+		Host:      "web-1",
+		Attrs:     attrs.Compare(attrs.Attributes{Mode: 0o755}, attrs.Attributes{Mode: 0o755 | os.ModeSetuid}),
+	})
+	source.AddEvent("/test/cache/data.bin", fsnotify.Write, false)
+	filename := filepath.Join(t.TempDir(), "session.json")
+	if err := source.ExportEvents(filename, ui.FormatJSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	replay := ui.NewUI(ignoringWatcher{MockWatcher: mockWatcher, text: "/cache/"}, "/test/path")
+	if err := replay.StartReplay(filename, ui.FormatJSON, 0, false); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	select {
+	case <-replay.ReplayDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replay did not finish")
+	}
+
+	events := replay.GetState().Events
+	if len(events) != 1 {
+		t.Fatalf("Expected the ignored event to be left out, got %d events", len(events))
+	}
+	if events[0].Host != "web-1" || !events[0].Attrs.HasRisk(attrs.RiskSetuid) {
+		t.Errorf("Expected the host and attribute change to be replayed, got %+v", events[0])
+	}
+}
@@ -0,0 +1,321 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/config"
+	"github.com/pbouamriou/watch-fs/internal/rules"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// newRules compiles the rules of a configuration file
+func newRules(t *testing.T, data string, roots []string) (*rules.Engine, <-chan ui.RuleFiring) {
+	t.Helper()
+	file, err := config.Parse([]byte(data), "config.yml")
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	engine, err := rules.New(file.Settings, roots, func(string) bool { return false }, nil)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	t.Cleanup(engine.Close)
+	firings := make(chan ui.RuleFiring, 100)
+	engine.OnFiring(func(firing ui.RuleFiring) { firings <- firing })
+	return engine, firings
+}
+
+// nextFiring waits for a rule action to run
+func nextFiring(t *testing.T, firings <-chan ui.RuleFiring) ui.RuleFiring {
+	t.Helper()
+	select {
+	case firing := <-firings:
+		return firing
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a rule to fire")
+		return ui.RuleFiring{}
+	}
+}
+
+// TestRulesConditions tests the conditions of a rule, its rate, and the
+// append and highlight actions
+func TestRulesConditions(t *testing.T) {
+	root := t.TempDir()
+	big := filepath.Join(root, "logs", "big.log")
+	if err := os.MkdirAll(filepath.Dir(big), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(big, make([]byte, 2048), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "logs", "small.log"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	journal := filepath.Join(t.TempDir(), "rules.log")
+	engine, firings := newRules(t, `
+rules:
+  - name: big-logs
+    match: "**/*.log"
+    op: write
+    type: file
+    min_size: 1k
+    actions:
+      - append: {path: `+journal+`, template: "{{.Rule}} {{.RelativePath}} {{.Size}}"}
+      - highlight: red
+  - name: burst
+    regex: '\.tmp$'
+    rate: {count: 3, window: 1m}
+    actions:
+      - highlight: yellow
+`, []string{root})
+	if engine.Len() != 2 {
+		t.Fatalf("Expected 2 rules, got %d", engine.Len())
+	}
+
+	write := func(path string) *ui.FileEvent {
+		event := &ui.FileEvent{Path: path, Operation: fsnotify.Write, Timestamp: time.Now(), Count: 1}
+		engine.Handle(event)
+		return event
+	}
+	if event := write(filepath.Join(root, "logs", "small.log")); event.Highlight != "" {
+		t.Errorf("Expected a file below min_size not to match, got %q", event.Highlight)
+	}
+	if event := write(big); event.Highlight != "red" {
+		t.Errorf("Expected the big log to be highlighted, got %q", event.Highlight)
+	}
+	created := &ui.FileEvent{Path: big, Operation: fsnotify.Create, Timestamp: time.Now(), Count: 1}
+	if engine.Handle(created); created.Highlight != "" {
+		t.Error("Expected a create not to match the write rule")
+	}
+	for i := 0; i < 2; i++ {
+		if event := write(filepath.Join(root, "a.tmp")); event.Highlight != "" {
+			t.Errorf("Expected event %d to stay under the rate", i+1)
+		}
+	}
+	if event := write(filepath.Join(root, "a.tmp")); event.Highlight != "yellow" {
+		t.Errorf("Expected the third event to reach the rate, got %q", event.Highlight)
+	}
+
+	seen := map[string]bool{}
+	for len(seen) < 3 {
+		firing := nextFiring(t, firings)
+		if firing.Err != nil {
+			t.Fatalf("Expected %s to succeed, got %v", firing.Action, firing.Err)
+		}
+		seen[firing.Rule+" "+firing.Action] = true
+	}
+	for _, want := range []string{"big-logs highlight", "big-logs append", "burst highlight"} {
+		if !seen[want] {
+			t.Errorf("Expected a %s firing, got %v", want, seen)
+		}
+	}
+	data, err := os.ReadFile(journal)
+	if err != nil {
+		t.Fatalf("Failed to read appended file: %v", err)
+	}
+	if got := string(data); got != "big-logs logs/big.log 2048\n" {
+		t.Errorf("Unexpected appended line %q", got)
+	}
+}
+
+// TestRulesWebhook tests that webhooks are signed and retried
+func TestRulesWebhook(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if got, want := r.Header.Get(rules.SignatureHeader), rules.Sign("s3cret", body); got != want {
+			t.Errorf("Expected signature %s, got %s", want, got)
+		}
+		received <- body
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	engine, firings := newRules(t, `
+rules:
+  - name: notify
+    actions:
+      - webhook: {url: `+server.URL+`, secret: s3cret, retries: 2, backoff: 10ms}
+`, []string{root})
+	engine.Handle(&ui.FileEvent{Path: filepath.Join(root, "a.txt"), Operation: fsnotify.Remove, Timestamp: time.Now(), Count: 1})
+
+	if firing := nextFiring(t, firings); firing.Err != nil || firing.Action != "webhook" {
+		t.Fatalf("Expected the webhook to succeed after a retry, got %+v", firing)
+	}
+	var payload struct {
+		Rule string `json:"rule"`
+		Path string `json:"path"`
+		Op   string `json:"op"`
+		Size int64  `json:"size"`
+	}
+	if err := json.Unmarshal(<-received, &payload); err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	if payload.Rule != "notify" || filepath.Base(payload.Path) != "a.txt" || payload.Op != "REMOVE" || payload.Size != -1 {
+		t.Errorf("Unexpected payload %+v", payload)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("Expected 2 attempts, got %d", n)
+	}
+}
+
+// TestRulesAlerts tests that alerts fire and resolve on event rates,
+// silences and spikes, and run their actions both times
+func TestRulesAlerts(t *testing.T) {
+	root := t.TempDir()
+	journal := filepath.Join(t.TempDir(), "alerts.log")
+	engine, _ := newRules(t, `
+alerts:
+  - name: churn
+    path: `+root+`/config
+    above: {count: 3, window: 1m}
+    actions:
+      - append: {path: `+journal+`, template: "{{.Rule}} {{.State}}: {{.Reason}}"}
+  - name: heartbeat
+    match: "logs/**"
+    silence: 1m
+  - name: spike
+    op: remove
+    spike: {factor: 3, window: 10s, learn: 1m, min: 5}
+`, []string{root})
+	changes := make(chan ui.Alert, 10)
+	engine.OnAlert(func(alert ui.Alert) { changes <- alert })
+	expect := func(name string, firing bool) {
+		t.Helper()
+		select {
+		case alert := <-changes:
+			if alert.Name != name || alert.Firing != firing {
+				t.Errorf("Expected %s firing=%v, got %+v", name, firing, alert)
+			}
+		default:
+			t.Errorf("Expected %s firing=%v, got no change", name, firing)
+		}
+	}
+	event := func(name string, op fsnotify.Op, at time.Time) {
+		engine.Handle(&ui.FileEvent{Path: filepath.Join(root, name), Operation: op, Timestamp: at, Count: 1})
+	}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		event("config/app.yml", fsnotify.Write, start.Add(time.Duration(i)*time.Second))
+	}
+	expect("churn", true)
+	engine.Evaluate(start.Add(30 * time.Second))
+	if len(changes) != 0 {
+		t.Errorf("Expected no change within the silence period, got %+v", <-changes)
+	}
+	engine.Evaluate(start.Add(2 * time.Minute))
+	expect("churn", false)
+	expect("heartbeat", true)
+	event("logs/app.log", fsnotify.Write, start.Add(2*time.Minute))
+	expect("heartbeat", false)
+
+	// No removal while learning: any burst is a spike
+	for i := 0; i < 6; i++ {
+		event("tmp/"+string(rune('a'+i)), fsnotify.Remove, start.Add(2*time.Minute+time.Duration(i)*time.Second))
+	}
+	expect("spike", true)
+	engine.Evaluate(start.Add(3 * time.Minute))
+	expect("heartbeat", true)
+	expect("spike", false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	var data []byte
+	for time.Now().Before(deadline) {
+		if data, _ = os.ReadFile(journal); strings.Count(string(data), "\n") == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := "churn firing: 4 events in 1m0s, above 3\nchurn resolved: 0 events in 1m0s\n"
+	if string(data) != want {
+		t.Errorf("Expected the alert actions to run on both changes, got %q", data)
+	}
+}
+
+// TestRulesInvalid tests that mistakes in rules are reported
+func TestRulesInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"no action":       "rules: [{name: a}]",
+		"two kinds":       "rules: [{actions: [{command: [true], highlight: red}]}]",
+		"bad color":       "rules: [{actions: [{highlight: pink}]}]",
+		"bad type":        "rules: [{type: socket, actions: [{highlight: red}]}]",
+		"bad regex":       "rules: [{regex: '(', actions: [{highlight: red}]}]",
+		"bad rate":        "rules: [{rate: {count: 0}, actions: [{highlight: red}]}]",
+		"webhook url":     "rules: [{actions: [{webhook: {url: ftp://host}}]}]",
+		"bad command arg": "rules: [{actions: [{command: [echo, '{{.Path']}]}]",
+		"two conditions":  "alerts: [{above: {count: 1, window: 1m}, silence: 1m}]",
+		"no condition":    "alerts: [{name: a}]",
+		"alert highlight": "alerts: [{silence: 1m, actions: [{highlight: red}]}]",
+		"sensitivity":     "ransomware: {sensitivity: paranoid}",
+		"threshold":       "ransomware: {renames: -2}",
+		"window":          "ransomware: {window: -1s}",
+		"containment":     "ransomware: {containment: ['{{']}",
+	} {
+		file, err := config.Parse([]byte(data), "config.yml")
+		if err != nil {
+			t.Fatalf("%s: failed to parse config: %v", name, err)
+		}
+		if _, err := rules.New(file.Settings, nil, nil, nil); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := config.Parse([]byte("rules: [{min_size: lots}]"), "config.yml"); err == nil {
+		t.Error("Expected an invalid size to fail")
+	}
+	for value, want := range map[string]config.Size{"512": 512, "64k": 64 << 10, "10MB": 10 << 20, "1.5GiB": 3 << 29} {
+		if got, err := config.ParseSize(value); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", value, got, err, want)
+		}
+	}
+}
+
+// TestRulesCommand tests that command arguments are templates over the
+// event and that failures carry the command output
+func TestRulesCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses a POSIX shell")
+	}
+	root := t.TempDir()
+	out := filepath.Join(t.TempDir(), "out.txt")
+	engine, firings := newRules(t, `
+rules:
+  - name: copy
+    match: "*.txt"
+    actions:
+      - command: [sh, -c, 'echo "$0 {{.Op}}" > `+out+`', "{{.RelativePath}}"]
+  - name: broken
+    match: "*.bad"
+    actions:
+      - command: [sh, -c, "echo nope; exit 2"]
+`, []string{root})
+
+	engine.Handle(&ui.FileEvent{Path: filepath.Join(root, "a.txt"), Operation: fsnotify.Create, Timestamp: time.Now()})
+	if firing := nextFiring(t, firings); firing.Err != nil {
+		t.Fatalf("Expected the command to succeed, got %v", firing.Err)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "a.txt CREATE\n" {
+		t.Errorf("Expected the expanded arguments, got %q, %v", data, err)
+	}
+
+	engine.Handle(&ui.FileEvent{Path: filepath.Join(root, "a.bad"), Operation: fsnotify.Write, Timestamp: time.Now()})
+	if firing := nextFiring(t, firings); firing.Err == nil || !strings.Contains(firing.Err.Error(), "nope") {
+		t.Errorf("Expected the failure to carry the output, got %v", firing.Err)
+	}
+}
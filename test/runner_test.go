@@ -0,0 +1,182 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbouamriou/watch-fs/internal/runner"
+)
+
+// TestRunnerPlaceholders tests how changed paths reach the command
+func TestRunnerPlaceholders(t *testing.T) {
+	changed := []string{"a.go", "b.go"}
+
+	args := runner.ExpandArgs([]string{"vet", "{paths}", "--file={path}", "all: {paths}"}, changed)
+	expected := []string{"vet", "a.go", "b.go", "--file=a.go", "all: a.go b.go"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+
+	env := runner.ChangeEnv(changed)
+	if env[0] != "WATCH_FS_CHANGED_PATH=a.go" || env[1] != "WATCH_FS_CHANGED_PATHS=a.go\nb.go" {
+		t.Errorf("Unexpected environment: %q", env)
+	}
+
+	if _, err := runner.ParsePolicy("sometimes"); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
+
+// waitForRuns waits until the runner is idle after at least n runs
+func waitForRuns(t *testing.T, r *runner.Runner, n int) runner.Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status := r.Status()
+		if status.Runs >= n && status.State != runner.StateRunning && status.Pending == 0 {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d runs, got %+v", n, r.Status())
+	return runner.Status{}
+}
+
+// TestRunnerPolicies tests debouncing and what happens to changes during a run
+func TestRunnerPolicies(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses a POSIX shell")
+	}
+	log := filepath.Join(t.TempDir(), "runs.log")
+	script := `echo "$WATCH_FS_CHANGED_PATHS" | tr '\n' ' ' >> ` + log + `; echo >> ` + log + `; sleep 0.3`
+
+	newRunner := func(policy runner.Policy) *runner.Runner {
+		t.Helper()
+		if err := os.Remove(log); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		r, err := runner.New(runner.Config{
+			Command:  []string{"sh", "-c", script},
+			Debounce: 50 * time.Millisecond,
+			Policy:   policy,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create runner: %v", err)
+		}
+		t.Cleanup(r.Close)
+		return r
+	}
+	runs := func() []string {
+		data, _ := os.ReadFile(log)
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	// A burst of changes runs the command once
+	r := newRunner(runner.PolicyQueue)
+	r.Notify("b")
+	r.Notify("a")
+	r.Notify("b")
+	status := waitForRuns(t, r, 1)
+	if status.Runs != 1 || status.State != runner.StateSucceeded || !reflect.DeepEqual(status.Changed, []string{"a", "b"}) {
+		t.Errorf("Expected one run for a and b, got %+v", status)
+	}
+
+	// Queue: changes during a run trigger one more run afterwards
+	r.Notify("c")
+	time.Sleep(150 * time.Millisecond)
+	r.Notify("d")
+	time.Sleep(100 * time.Millisecond)
+	r.Notify("e")
+	if status := waitForRuns(t, r, 3); status.Runs != 3 {
+		t.Errorf("Expected the queued changes to run once more, got %d runs", status.Runs)
+	}
+	if got := runs(); len(got) != 3 || strings.TrimSpace(got[2]) != "d e" {
+		t.Errorf("Unexpected runs: %q", got)
+	}
+
+	// Ignore: changes during a run are dropped
+	r = newRunner(runner.PolicyIgnore)
+	r.Trigger()
+	time.Sleep(100 * time.Millisecond)
+	r.Notify("lost")
+	if status := waitForRuns(t, r, 1); status.Runs != 1 {
+		t.Errorf("Expected changes during the run to be ignored, got %d runs", status.Runs)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if status := r.Status(); status.Runs != 1 {
+		t.Errorf("Expected no run after the ignored change, got %d runs", status.Runs)
+	}
+
+	// Restart: a change kills the running command and starts it again
+	r = newRunner(runner.PolicyRestart)
+	r.Trigger()
+	time.Sleep(100 * time.Millisecond)
+	r.Notify("f")
+	status = waitForRuns(t, r, 2)
+	if status.Runs != 2 || status.State != runner.StateSucceeded || !reflect.DeepEqual(status.Changed, []string{"f"}) {
+		t.Errorf("Expected a restarted run for f, got %+v", status)
+	}
+}
+
+// TestRunnerOutput tests that the last output lines and exit code are kept
+func TestRunnerOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses a POSIX shell")
+	}
+	r, err := runner.New(runner.Config{
+		Command:     []string{"sh", "-c", "for i in 1 2 3 4; do echo line $i; done; exit 3"},
+		OutputLines: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+	defer r.Close()
+
+	r.Trigger()
+	status := waitForRuns(t, r, 1)
+	if status.State != runner.StateFailed || status.ExitCode != 3 {
+		t.Errorf("Expected a failed run with exit code 3, got %+v", status)
+	}
+	if !reflect.DeepEqual(status.Output, []string{"line 3", "line 4"}) {
+		t.Errorf("Expected the last two lines, got %q", status.Output)
+	}
+}
+
+// TestRunnerSlowBuild tests that changes are still recorded while the
+// command line of a run is being built
+func TestRunnerSlowBuild(t *testing.T) {
+	building := make(chan struct{})
+	release := make(chan struct{})
+	r, err := runner.New(runner.Config{
+		Debounce: 10 * time.Millisecond,
+		Build: func(changed []string) ([]string, error) {
+			building <- struct{}{}
+			<-release
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+	defer r.Close()
+
+	r.Notify("a.go")
+	<-building
+	notified := make(chan struct{})
+	go func() {
+		r.Notify("b.go")
+		close(notified)
+	}()
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Error("Expected Notify not to wait for the build")
+	}
+	close(release)
+	<-building
+}
@@ -0,0 +1,392 @@
+package test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/attrs"
+	"github.com/pbouamriou/watch-fs/internal/server"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+	"github.com/pbouamriou/watch-fs/internal/watcher"
+)
+
+// startServer serves a watcher over root with an httptest server
+func startServer(t *testing.T, root string) (*server.Server, *httptest.Server) {
+	t.Helper()
+	fileWatcher, err := watcher.NewWithIgnore([]string{root}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	srv := server.New(fileWatcher, ui.Settings{})
+	go srv.Watch()
+	httpServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		httpServer.Close()
+		_ = fileWatcher.Close()
+	})
+	return srv, httpServer
+}
+
+// getJSON decodes the response of a GET request
+func getJSON(t *testing.T, url string, value any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		t.Fatalf("GET %s: invalid JSON: %v", url, err)
+	}
+	return resp.StatusCode
+}
+
+// TestServerEventsAndRoots tests the REST API
+func TestServerEventsAndRoots(t *testing.T) {
+	root, other := t.TempDir(), t.TempDir()
+	_, httpServer := startServer(t, root)
+
+	for _, name := range []string{"a.txt", "b.go"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	var page server.EventPage
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		getJSON(t, httpServer.URL+"/api/events?op=create&sort=path", &page)
+		if page.Total == 2 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if page.Total != 2 || page.Events[0].RelativePath != "a.txt" || page.Events[1].RelativePath != "b.go" {
+		t.Fatalf("Expected the creations of a.txt and b.go sorted by path, got %+v", page)
+	}
+	getJSON(t, httpServer.URL+"/api/events?op=create&sort=path&offset=1&limit=1", &page)
+	if page.Total != 2 || len(page.Events) != 1 || page.Events[0].RelativePath != "b.go" {
+		t.Errorf("Expected the second page to hold b.go, got %+v", page)
+	}
+	var apiError map[string]string
+	if status := getJSON(t, httpServer.URL+"/api/events?where=size>1", &apiError); status != http.StatusBadRequest || apiError["error"] == "" {
+		t.Errorf("Expected an invalid condition to fail with 400, got %d %v", status, apiError)
+	}
+
+	resp, err := http.Post(httpServer.URL+"/api/roots", "application/json", strings.NewReader(fmt.Sprintf(`{"path": %q}`, other)))
+	if err != nil {
+		t.Fatalf("Failed to add root: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected 201 adding a root, got %d", resp.StatusCode)
+	}
+	request, _ := http.NewRequest(http.MethodDelete, httpServer.URL+"/api/roots?path="+root, nil)
+	request.Header.Set("Content-Type", "application/json")
+	if resp, err = http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Failed to remove root: %v", err)
+	}
+	_ = resp.Body.Close()
+	var roots []server.Root
+	getJSON(t, httpServer.URL+"/api/roots", &roots)
+	if len(roots) != 1 || roots[0].Path != other {
+		t.Errorf("Expected only %s to be watched, got %+v", other, roots)
+	}
+}
+
+// TestServerKeepsAttrs tests that the mode changes attached by the watcher
+// reach the history and the API of the server
+func TestServerKeepsAttrs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Uses POSIX permissions")
+	}
+	root := t.TempDir()
+	path := filepath.Join(root, "tool")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	srv, httpServer := startServer(t, root)
+
+	if err := os.Chmod(path, 0o777); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+	var change *attrs.Change
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && change == nil {
+		for _, event := range srv.History() {
+			if event.Path == path && event.Attrs != nil {
+				change = event.Attrs
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if change == nil {
+		t.Fatal("Expected the mode change in the history")
+	}
+	if change.After.Mode != 0o777 || !change.HasRisk(attrs.RiskWorldWritable) {
+		t.Errorf("Expected a world-writable mode change, got %+v", change)
+	}
+
+	// The API serves the change and filters the risky events
+	var page server.EventPage
+	getJSON(t, httpServer.URL+"/api/events?risk=writable", &page)
+	if page.Total != 1 || page.Events[0].Attrs == nil || page.Events[0].Attrs.Before.Mode != 0o644 {
+		t.Errorf("Expected the risky change over the API, got %+v", page)
+	}
+	getJSON(t, httpServer.URL+"/api/events?risk=setuid", &page)
+	if page.Total != 0 {
+		t.Errorf("Expected no setuid change, got %+v", page)
+	}
+}
+
+// TestServerHosts tests that API events name the host of a collector and
+// can be filtered by it
+func TestServerHosts(t *testing.T) {
+	remote := &ui.FileEvent{Path: "/srv/app/main.go", Operation: fsnotify.Write, Count: 1, Host: "build-1"}
+	local := &ui.FileEvent{Path: "/srv/app/go.mod", Operation: fsnotify.Write, Count: 1}
+
+	data, err := json.Marshal(server.NewEvent(remote, []string{"/srv/app"}))
+	if err != nil || !strings.Contains(string(data), `"host":"build-1"`) {
+		t.Errorf("Expected the host in the event, got %s", data)
+	}
+	if data, _ := json.Marshal(server.NewEvent(local, []string{"/srv/app"})); strings.Contains(string(data), `"host"`) {
+		t.Errorf("Expected no host for a local event, got %s", data)
+	}
+
+	q, err := server.ParseQuery(url.Values{"host": {"build-1"}})
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	if !q.Matches(remote) || q.Matches(local) {
+		t.Error("Expected the host filter to keep the events of build-1 only")
+	}
+}
+
+// TestServerStreams tests the filters of the SSE and WebSocket streams
+func TestServerStreams(t *testing.T) {
+	root := t.TempDir()
+	_, httpServer := startServer(t, root)
+
+	resp, err := http.Get(httpServer.URL + "/api/stream?path=.go&op=create")
+	if err != nil {
+		t.Fatalf("Failed to open SSE stream: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", got)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(httpServer.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET /api/ws?path=.go HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", key)
+	reader := bufio.NewReader(conn)
+	handshake, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	if handshake.StatusCode != http.StatusSwitchingProtocols || handshake.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected handshake %d %v", handshake.StatusCode, handshake.Header)
+	}
+
+	// Give both streams time to subscribe
+	time.Sleep(100 * time.Millisecond)
+	for _, name := range []string{"notes.txt", "main.go"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	sse := bufio.NewScanner(resp.Body)
+	var event server.Event
+	for sse.Scan() {
+		if data, ok := strings.CutPrefix(sse.Text(), "data: "); ok {
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatalf("Invalid SSE event: %v", err)
+			}
+			break
+		}
+	}
+	if event.RelativePath != "main.go" || event.Op != "CREATE" {
+		t.Errorf("Expected the creation of main.go over SSE, got %+v", event)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatalf("Failed to read WebSocket frame: %v", err)
+	}
+	if header[0] != 0x81 || header[1] > 126 {
+		t.Fatalf("Expected an unmasked final text frame, got %x", header)
+	}
+	length := int(header[1])
+	if length == 126 {
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			t.Fatalf("Failed to read WebSocket frame length: %v", err)
+		}
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("Failed to read WebSocket payload: %v", err)
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.RelativePath != "main.go" {
+		t.Errorf("Expected main.go over WebSocket, got %s", payload)
+	}
+}
+
+// TestServerWebUI tests the embedded web UI and the endpoints it uses
+func TestServerWebUI(t *testing.T) {
+	root := t.TempDir()
+	_, httpServer := startServer(t, root)
+
+	resp, err := http.Get(httpServer.URL + "/")
+	if err != nil {
+		t.Fatalf("Failed to get the web UI: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "app.js") {
+		t.Errorf("Expected the web UI page, got %d", resp.StatusCode)
+	}
+
+	for _, name := range []string{"a.txt", "b.go"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	var events server.EventPage
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && events.Total < 2 {
+		getJSON(t, httpServer.URL+"/api/events?op=create", &events)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var capture struct {
+		Events []ui.FileEvent `json:"events"`
+		Meta   ui.ExportMeta  `json:"meta"`
+	}
+	resp, err = http.Get(httpServer.URL + "/api/export?format=json&scope=view&path=.go&op=create")
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&capture); err != nil {
+		t.Fatalf("Invalid export: %v", err)
+	}
+	_ = resp.Body.Close()
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), ".json") {
+		t.Errorf("Expected a JSON attachment, got %q", resp.Header.Get("Content-Disposition"))
+	}
+	if len(capture.Events) != 1 || filepath.Base(capture.Events[0].Path) != "b.go" || capture.Meta.Scope != "filtered" {
+		t.Errorf("Expected the view export to hold the creation of b.go, got %+v", capture)
+	}
+
+	var apiError map[string]string
+	if status := getJSON(t, httpServer.URL+"/api/export?format=csv", &apiError); status != http.StatusBadRequest {
+		t.Errorf("Expected an unknown format to fail with 400, got %d", status)
+	}
+	if status := getJSON(t, httpServer.URL+"/api/info?path="+filepath.Dir(root), &apiError); status != http.StatusNotFound {
+		t.Errorf("Expected details outside the roots to fail with 404, got %d", status)
+	}
+	var info server.FileInfo
+	getJSON(t, httpServer.URL+"/api/info?path="+filepath.Join(root, "b.go"), &info)
+	if !info.Exists || info.Size != 1 {
+		t.Errorf("Expected the details of b.go, got %+v", info)
+	}
+}
+
+// TestServerSameSite tests that requests other websites could send from a
+// browser are refused
+func TestServerSameSite(t *testing.T) {
+	root, other := t.TempDir(), t.TempDir()
+	_, httpServer := startServer(t, root)
+
+	send := func(method, path, contentType, origin string) int {
+		t.Helper()
+		request, _ := http.NewRequest(method, httpServer.URL+path, strings.NewReader(""))
+		request.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			request.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := send(http.MethodPost, "/api/roots?path="+other, "application/json", "http://attacker.example"); status != http.StatusForbidden {
+		t.Errorf("Expected a cross-origin request to fail with 403, got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/roots?path="+other, "application/x-www-form-urlencoded", httpServer.URL); status != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected a form to fail with 415, got %d", status)
+	}
+	if status := send(http.MethodPost, "/api/roots?path="+other, "application/json", httpServer.URL); status != http.StatusCreated {
+		t.Errorf("Expected a same-origin JSON request to succeed, got %d", status)
+	}
+
+	var directory server.Directory
+	if status := getJSON(t, httpServer.URL+"/api/browse?path="+root, &directory); status != http.StatusOK || directory.Parent != "" {
+		t.Errorf("Expected a root to be listed without its parent, got %d %+v", status, directory)
+	}
+	var apiError map[string]string
+	if status := getJSON(t, httpServer.URL+"/api/browse?path="+filepath.Dir(root), &apiError); status != http.StatusForbidden {
+		t.Errorf("Expected browsing outside the roots to fail with 403, got %d", status)
+	}
+
+	// WebSocket upgrades from other websites are refused, and unmasked
+	// frames close the connection with a protocol error
+	handshake := func(origin string) (net.Conn, *bufio.Reader, int) {
+		t.Helper()
+		conn, err := net.Dial("tcp", strings.TrimPrefix(httpServer.URL, "http://"))
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		fmt.Fprintf(conn, "GET /api/ws HTTP/1.1\r\nHost: %s\r\nOrigin: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n",
+			strings.TrimPrefix(httpServer.URL, "http://"), origin)
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read handshake: %v", err)
+		}
+		return conn, reader, resp.StatusCode
+	}
+	if _, _, status := handshake("http://attacker.example"); status != http.StatusForbidden {
+		t.Errorf("Expected a cross-origin upgrade to fail with 403, got %d", status)
+	}
+	conn, reader, status := handshake(httpServer.URL)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected a same-origin upgrade, got %d", status)
+	}
+	if _, err := conn.Write([]byte{0x89, 0x00}); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame := make([]byte, 4)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		t.Fatalf("Failed to read close frame: %v", err)
+	}
+	if frame[0] != 0x88 || frame[1] != 2 || binary.BigEndian.Uint16(frame[2:]) != 1002 {
+		t.Errorf("Expected a close frame with status 1002, got %x", frame)
+	}
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/ui"
+)
+
+// writeLog writes a watcher log in a temporary directory
+func writeLog(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	return path
+}
+
+// TestImportInotifywait tests importing inotifywait logs in the default and a custom format
+func TestImportInotifywait(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+	path := writeLog(t, "events.inotify", "/src/ CREATE a.txt\n/src/ MODIFY a.txt\n/src/ CREATE,ISDIR sub\n/src/ MOVED_FROM a.txt\nnot an event\n")
+	if err := uiInstance.ImportEvents(path, ui.FormatInotifywait); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	events := uiInstance.GetState().Events
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+	if events[0].Path != "/src/a.txt" || events[0].Operation != fsnotify.Create {
+		t.Errorf("Unexpected first event: %s %v", events[0].Path, events[0].Operation)
+	}
+	if events[1].Operation != fsnotify.Write || events[3].Operation != fsnotify.Rename {
+		t.Errorf("Unexpected operations: %v, %v", events[1].Operation, events[3].Operation)
+	}
+	if !events[2].IsDir || events[2].Path != "/src/sub" {
+		t.Errorf("Expected /src/sub to be a directory, got %s (dir=%v)", events[2].Path, events[2].IsDir)
+	}
+	if !events[0].Timestamp.Before(events[3].Timestamp) {
+		t.Error("Events without timestamps should keep the log order")
+	}
+
+	// Custom --format and --timefmt
+	uiInstance.SetTextImportOptions(ui.TextImportOptions{
+		InotifyFormat:  "%T %w%f %|e",
+		InotifyTimeFmt: "%Y-%m-%d %H:%M:%S",
+	})
+	path = writeLog(t, "custom.log", "2024-05-01 10:00:00 /src/a b.txt CLOSE_WRITE|CLOSE\n2024-05-01 10:00:01 /src/a b.txt DELETE\n")
+	if err := uiInstance.ImportEvents(path, ui.FormatInotifywait); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	events = uiInstance.GetState().Events
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	if events[0].Path != "/src/a b.txt" || !events[0].Timestamp.Equal(want) {
+		t.Errorf("Unexpected first event: %s at %v", events[0].Path, events[0].Timestamp)
+	}
+	if events[1].Operation != fsnotify.Remove {
+		t.Errorf("Expected a remove, got %v", events[1].Operation)
+	}
+}
+
+// TestImportFswatch tests importing fswatch -x logs with and without timestamps
+func TestImportFswatch(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+	path := writeLog(t, "events.fswatch",
+		"Wed May  1 10:00:00 2024 /src/my file.txt Created IsFile\n"+
+			"/src/dir Removed IsDir\n"+
+			"/src/b.txt Updated OwnerModified IsFile\n")
+	if err := uiInstance.ImportEvents(path, ui.FormatFswatch); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	events := uiInstance.GetState().Events
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	if events[0].Path != "/src/my file.txt" || !events[0].Timestamp.Equal(want) || events[0].Operation != fsnotify.Create {
+		t.Errorf("Unexpected first event: %s %v at %v", events[0].Path, events[0].Operation, events[0].Timestamp)
+	}
+	if events[1].Path != "/src/dir" || !events[1].IsDir || events[1].Operation != fsnotify.Remove {
+		t.Errorf("Unexpected second event: %s %v", events[1].Path, events[1].Operation)
+	}
+	if events[2].Operation != fsnotify.Write|fsnotify.Chmod {
+		t.Errorf("Expected combined write and chmod, got %v", events[2].Operation)
+	}
+}
+
+// TestImportWatchman tests importing watchman JSON results and watchman-wait output
+func TestImportWatchman(t *testing.T) {
+	mockWatcher := NewMockWatcher()
+	defer mockWatcher.Close()
+
+	uiInstance := ui.NewUI(mockWatcher, "/test/path")
+	path := writeLog(t, "events.watchman", `{
+  "root": "/src",
+  "files": [
+    {"name": "new.txt", "exists": true, "new": true, "type": "f"},
+    {"name": "gone.txt", "exists": false, "type": "f"},
+    {"name": "dir", "exists": true, "type": "d"}
+  ]
+}
+{"root": "/src", "files": ["plain.txt"]}
+`)
+	if err := uiInstance.ImportEvents(path, ui.FormatWatchman); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	events := uiInstance.GetState().Events
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+	expected := []struct {
+		path string
+		op   fsnotify.Op
+	}{
+		{"/src/new.txt", fsnotify.Create},
+		{"/src/gone.txt", fsnotify.Remove},
+		{"/src/dir", fsnotify.Write},
+		{"/src/plain.txt", fsnotify.Write},
+	}
+	for i, want := range expected {
+		if events[i].Path != filepath.FromSlash(want.path) || events[i].Operation != want.op {
+			t.Errorf("Event %d: expected %s %v, got %s %v", i, want.path, want.op, events[i].Path, events[i].Operation)
+		}
+	}
+	if !events[2].IsDir {
+		t.Error("Expected the type d entry to be a directory")
+	}
+
+	// watchman-wait prints one name per line
+	path = writeLog(t, "wait.watchman", "a.txt\nsub/b.txt\n")
+	if err := uiInstance.ImportEvents(path, ui.FormatWatchman); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if got := len(uiInstance.GetState().Events); got != 2 {
+		t.Errorf("Expected 2 events, got %d", got)
+	}
+}
+
+// TestSniffFormat tests format detection from the content of a log
+func TestSniffFormat(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		format  ui.ExportFormat
+	}{
+		{"/src/ CREATE a.txt\n", ui.FormatInotifywait},
+		{"/src/a.txt Created IsFile\n", ui.FormatFswatch},
+		{`{"root": "/src", "files": []}`, ui.FormatWatchman},
+		{`{"events": [], "meta": {}}`, ui.FormatJSON},
+		{"a.txt\n", ui.FormatWatchman},
+	} {
+		path := writeLog(t, "events.log", tc.content)
+		format, ok := ui.SniffFormat(path)
+		if !ok || format != tc.format {
+			t.Errorf("Content %q: expected format %v, got %v (ok=%v)", tc.content, tc.format, format, ok)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pbouamriou/watch-fs/internal/wait"
+	"github.com/pbouamriou/watch-fs/pkg/utils"
+)
+
+// TestWaitGlob tests the path patterns of wait and settle
+func TestWaitGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"dist/app.js", "dist/app.js", true},
+		{"./dist/app.js", "dist/app.js", true},
+		{"dist/*.js", "dist/sub/app.js", false},
+		{"dist/**/*.js", "dist/sub/app.js", true},
+		{"dist/**/*.js", "dist/app.js", true},
+		{"*.go", "internal/ui/ui.go", true},
+		{"*.go", "main.gox", false},
+		{"file?.[ch]", "src/file1.c", true},
+		{"file?.[!ch]", "src/file1.c", false},
+	}
+	for _, c := range cases {
+		glob, err := utils.NewGlob(c.pattern)
+		if err != nil {
+			t.Fatalf("Failed to compile %q: %v", c.pattern, err)
+		}
+		if got := glob.Match(c.path); got != c.match {
+			t.Errorf("%q on %q: expected %v, got %v", c.pattern, c.path, c.match, got)
+		}
+	}
+	if _, err := utils.NewGlob("file[.go"); err == nil {
+		t.Error("Expected an unclosed class to be rejected")
+	}
+}
+
+// TestWaitForEvent tests waiting for a matching event
+func TestWaitForEvent(t *testing.T) {
+	watcher := NewMockWatcherWithRoots([]string{"/project"})
+	defer watcher.Close()
+	glob, err := utils.NewGlob("dist/*.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := wait.Matcher{Roots: watcher.GetRoots(), Pattern: glob, Operations: fsnotify.Create | fsnotify.Write}
+
+	watcher.events <- fsnotify.Event{Name: "/project/dist/app.css", Op: fsnotify.Write}
+	watcher.events <- fsnotify.Event{Name: "/project/dist/app.js", Op: fsnotify.Chmod}
+	watcher.events <- fsnotify.Event{Name: "/project/.cache/dist/app.js", Op: fsnotify.Write}
+	watcher.events <- fsnotify.Event{Name: "/project/dist/app.js", Op: fsnotify.Write}
+	event, err := wait.ForEvent(watcher, matcher, time.Second)
+	if err != nil || event.Name != "/project/dist/app.js" || event.Op != fsnotify.Write {
+		t.Errorf("Expected the write of dist/app.js, got %v (%v)", event, err)
+	}
+
+	// Nothing else matches
+	if _, err := wait.ForEvent(watcher, matcher, 50*time.Millisecond); !errors.Is(err, wait.ErrTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
+
+// TestWaitForQuiet tests waiting for the end of a burst of events
+func TestWaitForQuiet(t *testing.T) {
+	watcher := NewMockWatcherWithRoots([]string{"/project"})
+	defer watcher.Close()
+	matcher := wait.Matcher{Roots: watcher.GetRoots()}
+
+	// A burst shorter than the timeout settles
+	go func() {
+		for i := 0; i < 5; i++ {
+			watcher.events <- fsnotify.Event{Name: "/project/out.log", Op: fsnotify.Write}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	count, err := wait.ForQuiet(watcher, matcher, 100*time.Millisecond, 2*time.Second)
+	if err != nil || count != 5 {
+		t.Errorf("Expected to settle after 5 events, got %d (%v)", count, err)
+	}
+
+	// Events more frequent than the quiet period until the timeout
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case watcher.events <- fsnotify.Event{Name: "/project/out.log", Op: fsnotify.Write}:
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+	}()
+	if _, err := wait.ForQuiet(watcher, matcher, 100*time.Millisecond, 300*time.Millisecond); !errors.Is(err, wait.ErrTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	close(stop)
+	<-stopped
+}